@@ -22,6 +22,7 @@ import (
 	"gritcms/apps/api/internal/jobs"
 	"gritcms/apps/api/internal/mail"
 	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/payments"
 	"gritcms/apps/api/internal/routes"
 	"gritcms/apps/api/internal/storage"
 )
@@ -68,6 +69,7 @@ func main() {
 		} else {
 			storageService = s
 			log.Println("File storage connected")
+			go moveToPrivateStorage(s)
 		}
 	}
 
@@ -142,6 +144,13 @@ func main() {
 			Cache:   cacheService,
 			Jobs:    jobClient,
 			AppURL:  cfg.AppURL,
+			WebURL:  cfg.WebURL,
+			AppName: cfg.AppName,
+
+			Payments: payments.NewRegistry(db, cfg),
+
+			FFmpegPath:  cfg.FFmpegPath,
+			FFprobePath: cfg.FFprobePath,
 		})
 		if err != nil {
 			log.Printf("Warning: Background worker failed to start: %v", err)
@@ -228,3 +237,21 @@ func main() {
 
 	log.Println("Server exited")
 }
+
+// privatePrefixes are stored in the private bucket; files an older release
+// left in the public bucket are moved across on startup.
+var privatePrefixes = []string{"certificates/", "invoices/", "media/hls/", "scorm/"}
+
+func moveToPrivateStorage(s *storage.Storage) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	for _, prefix := range privatePrefixes {
+		moved, err := s.MovePrivate(ctx, prefix)
+		if err != nil {
+			log.Printf("Warning: moving %s to private storage: %v", prefix, err)
+		}
+		if moved > 0 {
+			log.Printf("Moved %d files under %s to private storage", moved, prefix)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package config
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"strings"
@@ -18,6 +21,10 @@ type StorageConfig struct {
 	Region    string
 	UseSSL    bool
 	PublicURL string // Public base URL for serving files (e.g. R2 dev URL)
+
+	// PrivateBucket holds files served only through signed URLs or the API
+	// (certificates, submissions, paid video); never given a public policy.
+	PrivateBucket string
 }
 
 // Config holds all application configuration.
@@ -33,12 +40,22 @@ type Config struct {
 	JWTAccessExpiry  time.Duration
 	JWTRefreshExpiry time.Duration
 
+	// ScormSecret signs the content and xAPI tokens in SCORM launch URLs,
+	// which end up in the SCO's own pages and logs
+	ScormSecret string
+	// VideoSecret signs HLS playback tokens, which end up in playlist URLs
+	VideoSecret string
+
 	RedisURL string
 
 	// Storage
 	StorageDriver string        // "minio", "r2", or "b2"
 	Storage       StorageConfig // Resolved config for the active driver
 
+	// Video transcoding
+	FFmpegPath  string
+	FFprobePath string
+
 	ResendAPIKey string
 	MailFrom     string
 
@@ -87,11 +104,23 @@ type Config struct {
 	MPesaConsumerSecret string
 	MPesaShortcode      string
 	MPesaPasskey        string
+	// Reversals (refunds) use the B2C initiator credentials
+	MPesaInitiatorName      string
+	MPesaSecurityCredential string
 
 	// PayPal — Payment processing
 	PayPalClientID     string	
     PayPalSecret   string
     PayPalMode     string // "sandbox" or "live"
+
+	// Flutterwave — Payment processing
+	FlutterwaveSecretKey   string
+	FlutterwavePublicKey   string
+	FlutterwaveWebhookHash string // "verif-hash" header value set in the dashboard
+
+	// Paystack — Payment processing
+	PaystackSecretKey string
+	PaystackPublicKey string
 }
 
 // Load reads configuration from environment variables.
@@ -110,11 +139,16 @@ func Load() (*Config, error) {
 		WebURL:      getEnv("WEB_URL", getEnv("OAUTH_FRONTEND_URL", "http://localhost:3001")),
 		DatabaseURL: getEnv("DATABASE_URL", ""),
 		JWTSecret:   getEnv("JWT_SECRET", ""),
+		ScormSecret: getEnv("SCORM_SECRET", ""),
+		VideoSecret: getEnv("VIDEO_SECRET", ""),
 		RedisURL:    getEnv("REDIS_URL", "redis://localhost:6379"),
 
 		StorageDriver: storageDriver,
 		Storage:       resolveStorage(storageDriver),
 
+		FFmpegPath:  getEnv("FFMPEG_PATH", "ffmpeg"),
+		FFprobePath: getEnv("FFPROBE_PATH", "ffprobe"),
+
 		ResendAPIKey: getEnv("RESEND_API_KEY", ""),
 		MailFrom:     getEnv("MAIL_FROM", "noreply@localhost"),
 
@@ -156,10 +190,19 @@ func Load() (*Config, error) {
 		MPesaConsumerSecret: getEnv("MPESA_CONSUMER_SECRET", ""),
 		MPesaShortcode:      getEnv("MPESA_SHORTCODE", ""),
 		MPesaPasskey:        getEnv("MPESA_PASSKEY", ""),
+		MPesaInitiatorName:      getEnv("MPESA_INITIATOR_NAME", ""),
+		MPesaSecurityCredential: getEnv("MPESA_SECURITY_CREDENTIAL", ""),
 
 		PayPalClientID: getEnv("PAYPAL_CLIENT_ID", ""),
         PayPalSecret:   getEnv("PAYPAL_SECRET", ""),
         PayPalMode:     getEnv("PAYPAL_MODE", "sandbox"),
+
+		FlutterwaveSecretKey:   getEnv("FLUTTERWAVE_SECRET_KEY", ""),
+		FlutterwavePublicKey:   getEnv("FLUTTERWAVE_PUBLIC_KEY", ""),
+		FlutterwaveWebhookHash: getEnv("FLUTTERWAVE_WEBHOOK_HASH", ""),
+
+		PaystackSecretKey: getEnv("PAYSTACK_SECRET_KEY", ""),
+		PaystackPublicKey: getEnv("PAYSTACK_PUBLIC_KEY", ""),
 	}
 
 	if cfg.DatabaseURL == "" {
@@ -169,6 +212,12 @@ func Load() (*Config, error) {
 	if cfg.JWTSecret == "" {
 		return nil, fmt.Errorf("JWT_SECRET is required")
 	}
+	if cfg.ScormSecret == "" {
+		cfg.ScormSecret = deriveSecret(cfg.JWTSecret, "scorm")
+	}
+	if cfg.VideoSecret == "" {
+		cfg.VideoSecret = deriveSecret(cfg.JWTSecret, "video")
+	}
 
 	// Parse durations
 	accessExpiry, err := time.ParseDuration(getEnv("JWT_ACCESS_EXPIRY", "15m"))
@@ -186,6 +235,14 @@ func Load() (*Config, error) {
 	return cfg, nil
 }
 
+// deriveSecret derives a purpose-specific key from secret, so a token
+// signed with it can't pass for one signed with secret itself.
+func deriveSecret(secret, purpose string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 // IsDevelopment returns true if the app is running in development mode.
 func (c *Config) IsDevelopment() bool {
 	return c.AppEnv == "development"
@@ -196,31 +253,34 @@ func resolveStorage(driver string) StorageConfig {
 	switch driver {
 	case "r2":
 		return StorageConfig{
-			Endpoint:  getEnv("R2_ENDPOINT", ""),
-			AccessKey: getEnv("R2_ACCESS_KEY", ""),
-			SecretKey: getEnv("R2_SECRET_KEY", ""),
-			Bucket:    getEnv("R2_BUCKET", "uploads"),
-			Region:    getEnv("R2_REGION", "auto"),
-			UseSSL:    true,
-			PublicURL: getEnv("R2_PUBLIC_URL", ""),
+			Endpoint:      getEnv("R2_ENDPOINT", ""),
+			AccessKey:     getEnv("R2_ACCESS_KEY", ""),
+			SecretKey:     getEnv("R2_SECRET_KEY", ""),
+			Bucket:        getEnv("R2_BUCKET", "uploads"),
+			PrivateBucket: getEnv("R2_PRIVATE_BUCKET", getEnv("R2_BUCKET", "uploads")+"-private"),
+			Region:        getEnv("R2_REGION", "auto"),
+			UseSSL:        true,
+			PublicURL:     getEnv("R2_PUBLIC_URL", ""),
 		}
 	case "b2":
 		return StorageConfig{
-			Endpoint:  getEnv("B2_ENDPOINT", ""),
-			AccessKey: getEnv("B2_ACCESS_KEY", ""),
-			SecretKey: getEnv("B2_SECRET_KEY", ""),
-			Bucket:    getEnv("B2_BUCKET", "uploads"),
-			Region:    getEnv("B2_REGION", "us-west-004"),
-			UseSSL:    true,
+			Endpoint:      getEnv("B2_ENDPOINT", ""),
+			AccessKey:     getEnv("B2_ACCESS_KEY", ""),
+			SecretKey:     getEnv("B2_SECRET_KEY", ""),
+			Bucket:        getEnv("B2_BUCKET", "uploads"),
+			PrivateBucket: getEnv("B2_PRIVATE_BUCKET", getEnv("B2_BUCKET", "uploads")+"-private"),
+			Region:        getEnv("B2_REGION", "us-west-004"),
+			UseSSL:        true,
 		}
 	default: // minio
 		return StorageConfig{
-			Endpoint:  getEnv("MINIO_ENDPOINT", "http://localhost:9000"),
-			AccessKey: getEnv("MINIO_ACCESS_KEY", "minioadmin"),
-			SecretKey: getEnv("MINIO_SECRET_KEY", "minioadmin"),
-			Bucket:    getEnv("MINIO_BUCKET", "uploads"),
-			Region:    getEnv("MINIO_REGION", "us-east-1"),
-			UseSSL:    getEnv("MINIO_USE_SSL", "false") == "true",
+			Endpoint:      getEnv("MINIO_ENDPOINT", "http://localhost:9000"),
+			AccessKey:     getEnv("MINIO_ACCESS_KEY", "minioadmin"),
+			SecretKey:     getEnv("MINIO_SECRET_KEY", "minioadmin"),
+			Bucket:        getEnv("MINIO_BUCKET", "uploads"),
+			PrivateBucket: getEnv("MINIO_PRIVATE_BUCKET", getEnv("MINIO_BUCKET", "uploads")+"-private"),
+			Region:        getEnv("MINIO_REGION", "us-east-1"),
+			UseSSL:        getEnv("MINIO_USE_SSL", "false") == "true",
 		}
 	}
 }
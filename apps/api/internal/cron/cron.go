@@ -56,6 +56,72 @@ func New(redisURL string) (*Scheduler, error) {
 		Type:     "campaign:check-scheduled",
 	})
 
+	// Abandoned checkout recovery — every 15 minutes
+	_, err = scheduler.Register("*/15 * * * *", asynq.NewTask("checkout:recover", nil))
+	if err != nil {
+		return nil, fmt.Errorf("registering checkout recovery: %w", err)
+	}
+	RegisteredTasks = append(RegisteredTasks, Task{
+		Name:     "Recover abandoned checkouts",
+		Schedule: "*/15 * * * *",
+		Type:     "checkout:recover",
+	})
+
+	// SaaS metric snapshots — daily at 00:15
+	_, err = scheduler.Register("15 0 * * *", asynq.NewTask("metrics:snapshot", nil))
+	if err != nil {
+		return nil, fmt.Errorf("registering metrics snapshot: %w", err)
+	}
+	RegisteredTasks = append(RegisteredTasks, Task{
+		Name:     "Snapshot SaaS metrics",
+		Schedule: "15 0 * * *",
+		Type:     "metrics:snapshot",
+	})
+
+	// Affiliate commission holds — hourly
+	_, err = scheduler.Register("0 * * * *", asynq.NewTask("affiliates:release-commissions", nil))
+	if err != nil {
+		return nil, fmt.Errorf("registering affiliate release: %w", err)
+	}
+	RegisteredTasks = append(RegisteredTasks, Task{
+		Name:     "Release held affiliate commissions",
+		Schedule: "0 * * * *",
+		Type:     "affiliates:release-commissions",
+	})
+
+	// Course drip unlock emails — hourly
+	_, err = scheduler.Register("30 * * * *", asynq.NewTask("courses:drip-notify", nil))
+	if err != nil {
+		return nil, fmt.Errorf("registering course drip notify: %w", err)
+	}
+	RegisteredTasks = append(RegisteredTasks, Task{
+		Name:     "Email students about unlocked lessons",
+		Schedule: "30 * * * *",
+		Type:     "courses:drip-notify",
+	})
+
+	// Membership expiry — hourly
+	_, err = scheduler.Register("45 * * * *", asynq.NewTask("memberships:expire", nil))
+	if err != nil {
+		return nil, fmt.Errorf("registering membership expiry: %w", err)
+	}
+	RegisteredTasks = append(RegisteredTasks, Task{
+		Name:     "Expire lapsed memberships",
+		Schedule: "45 * * * *",
+		Type:     "memberships:expire",
+	})
+
+	// Subscription renewals — hourly
+	_, err = scheduler.Register("5 * * * *", asynq.NewTask("subscriptions:renew", nil))
+	if err != nil {
+		return nil, fmt.Errorf("registering subscription renewals: %w", err)
+	}
+	RegisteredTasks = append(RegisteredTasks, Task{
+		Name:     "Renew subscriptions",
+		Schedule: "5 * * * *",
+		Type:     "subscriptions:renew",
+	})
+
 	// grit:cron-tasks
 
 	return &Scheduler{scheduler: scheduler}, nil
@@ -29,12 +29,16 @@ const (
 	CourseEnrolled       = "course.enrolled"
 	CourseLessonCompleted = "course.lesson.completed"
 	CourseCompleted      = "course.completed"
+	CourseAssignmentSubmitted = "course.assignment.submitted"
+	CourseAssignmentGraded    = "course.assignment.graded"
+	LearningPathCompleted     = "learning_path.completed"
 )
 
 // Purchase / Commerce events
 const (
 	PurchaseCompleted = "purchase.completed"
 	PurchaseRefunded  = "purchase.refunded"
+	CheckoutAbandoned = "checkout.abandoned"
 	SubscriptionCreated  = "subscription.created"
 	SubscriptionRenewed  = "subscription.renewed"
 	SubscriptionCancelled = "subscription.cancelled"
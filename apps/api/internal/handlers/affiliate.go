@@ -3,24 +3,33 @@ package handlers
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 
+	"gritcms/apps/api/internal/config"
 	"gritcms/apps/api/internal/events"
 	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/services"
 )
 
 type AffiliateHandler struct {
-	DB *gorm.DB
+	DB  *gorm.DB
+	Cfg *config.Config
 }
 
-func NewAffiliateHandler(db *gorm.DB) *AffiliateHandler {
-	return &AffiliateHandler{DB: db}
+func NewAffiliateHandler(db *gorm.DB, cfg *config.Config) *AffiliateHandler {
+	return &AffiliateHandler{DB: db, Cfg: cfg}
 }
 
 // ---------- Programs ----------
@@ -57,19 +66,82 @@ func (h *AffiliateHandler) ListPrograms(c *gin.Context) {
 func (h *AffiliateHandler) GetProgram(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
 	var program models.AffiliateProgram
-	if err := h.DB.First(&program, id).Error; err != nil {
+	if err := h.DB.Preload("ProductOverrides.Product").First(&program, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Program not found"})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"data": program})
 }
 
+// SetProductCommission creates or updates a program's commission override
+// for one product.
+func (h *AffiliateHandler) SetProductCommission(c *gin.Context) {
+	programID, _ := strconv.Atoi(c.Param("id"))
+	productID, _ := strconv.Atoi(c.Param("productId"))
+	var body struct {
+		CommissionType   string `json:"commission_type"`
+		CommissionAmount int64  `json:"commission_amount"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if body.CommissionType == "" {
+		body.CommissionType = "percentage"
+	}
+	if body.CommissionType != "percentage" && body.CommissionType != "fixed" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "commission_type must be 'percentage' or 'fixed'"})
+		return
+	}
+
+	var program models.AffiliateProgram
+	if err := h.DB.First(&program, programID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Program not found"})
+		return
+	}
+	var product models.Product
+	if err := h.DB.First(&product, productID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	override := models.AffiliateProductCommission{TenantID: 1, ProgramID: program.ID, ProductID: product.ID}
+	h.DB.Where("program_id = ? AND product_id = ?", program.ID, product.ID).FirstOrInit(&override)
+	override.CommissionType = body.CommissionType
+	override.CommissionAmount = body.CommissionAmount
+	if err := h.DB.Save(&override).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save product commission"})
+		return
+	}
+	override.Product = &product
+	c.JSON(http.StatusOK, gin.H{"data": override})
+}
+
+// DeleteProductCommission removes a product override so the program rate applies.
+func (h *AffiliateHandler) DeleteProductCommission(c *gin.Context) {
+	res := h.DB.Where("program_id = ? AND product_id = ?", c.Param("id"), c.Param("productId")).
+		Delete(&models.AffiliateProductCommission{})
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product commission not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Product commission removed"})
+}
+
 func (h *AffiliateHandler) CreateProgram(c *gin.Context) {
 	var body models.AffiliateProgram
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if len(body.Tiers) > 0 && json.Unmarshal(body.Tiers, &[]models.CommissionTier{}) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tiers must be a list of {min_revenue, commission_type, commission_amount}"})
+		return
+	}
+	if len(body.LinkDomains) > 0 && json.Unmarshal(body.LinkDomains, &[]string{}) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "link_domains must be a list of host names"})
+		return
+	}
 	body.TenantID = 1
 	if err := h.DB.Create(&body).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create program"})
@@ -91,6 +163,22 @@ func (h *AffiliateHandler) UpdateProgram(c *gin.Context) {
 		return
 	}
 	sanitizeUpdates(body)
+	if tiers, ok := body["tiers"]; ok && tiers != nil {
+		b, err := json.Marshal(tiers)
+		if err != nil || json.Unmarshal(b, &[]models.CommissionTier{}) != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tiers must be a list of {min_revenue, commission_type, commission_amount}"})
+			return
+		}
+		body["tiers"] = datatypes.JSON(b)
+	}
+	if domains, ok := body["link_domains"]; ok && domains != nil {
+		b, err := json.Marshal(domains)
+		if err != nil || json.Unmarshal(b, &[]string{}) != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "link_domains must be a list of host names"})
+			return
+		}
+		body["link_domains"] = datatypes.JSON(b)
+	}
 	h.DB.Model(&program).Updates(body)
 	h.DB.First(&program, id)
 	c.JSON(http.StatusOK, gin.H{"data": program})
@@ -157,8 +245,9 @@ func (h *AffiliateHandler) GetAccount(c *gin.Context) {
 
 func (h *AffiliateHandler) CreateAccount(c *gin.Context) {
 	var body struct {
-		ContactID uint `json:"contact_id" binding:"required"`
-		ProgramID uint `json:"program_id" binding:"required"`
+		ContactID     uint  `json:"contact_id" binding:"required"`
+		ProgramID     uint  `json:"program_id" binding:"required"`
+		RecruitedByID *uint `json:"recruited_by_id"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -178,17 +267,19 @@ func (h *AffiliateHandler) CreateAccount(c *gin.Context) {
 	}
 
 	account := models.AffiliateAccount{
-		TenantID:     1,
-		ContactID:    body.ContactID,
-		ProgramID:    body.ProgramID,
-		Status:       status,
-		ReferralCode: generateReferralCode(),
+		TenantID:      1,
+		ContactID:     body.ContactID,
+		ProgramID:     body.ProgramID,
+		Status:        status,
+		ReferralCode:  generateReferralCode(),
+		RecruitedByID: body.RecruitedByID,
 	}
 	if err := h.DB.Create(&account).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create affiliate account"})
 		return
 	}
 
+	services.NewAffiliateService(h.DB).GrantAffiliateRole(&account)
 	h.DB.Preload("Contact").Preload("Program").First(&account, account.ID)
 	c.JSON(http.StatusCreated, gin.H{"data": account})
 }
@@ -208,29 +299,86 @@ func (h *AffiliateHandler) UpdateAccountStatus(c *gin.Context) {
 		return
 	}
 	h.DB.Model(&account).Update("status", body.Status)
+	services.NewAffiliateService(h.DB).GrantAffiliateRole(&account)
 	c.JSON(http.StatusOK, gin.H{"data": account})
 }
 
 // ---------- Links ----------
 
+// linkInput is the body for creating an affiliate link: either a plain URL
+// or a deep link to a product, course or funnel step.
+type linkInput struct {
+	URL         string `json:"url"`
+	TargetType  string `json:"target_type"` // url (default), product, course, funnel_step
+	TargetID    *uint  `json:"target_id"`
+	Slug        string `json:"slug"`
+	UTMSource   string `json:"utm_source"`
+	UTMMedium   string `json:"utm_medium"`
+	UTMCampaign string `json:"utm_campaign"`
+	UTMContent  string `json:"utm_content"`
+}
+
+// createLink resolves the link's destination, picks a unique slug and saves
+// it, writing the error response itself when it fails.
+func (h *AffiliateHandler) createLink(c *gin.Context, account *models.AffiliateAccount, body linkInput) (*models.AffiliateLink, bool) {
+	svc := services.NewAffiliateService(h.DB)
+	var program models.AffiliateProgram
+	h.DB.First(&program, account.ProgramID)
+	target, err := svc.LinkURL(h.Cfg.WebURL, &program, body.TargetType, body.TargetID, body.URL)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if body.TargetType == "" {
+		body.TargetType = models.LinkTargetURL
+		body.TargetID = nil
+	}
+
+	slug := generateSlug(body.Slug)
+	if slug == "" {
+		slug = strings.ToLower(generateReferralCode())
+	}
+
+	link := models.AffiliateLink{
+		TenantID:    1,
+		AccountID:   account.ID,
+		URL:         target,
+		TargetType:  body.TargetType,
+		TargetID:    body.TargetID,
+		UTMSource:   body.UTMSource,
+		UTMMedium:   body.UTMMedium,
+		UTMCampaign: body.UTMCampaign,
+		UTMContent:  body.UTMContent,
+	}
+	if err := svc.CreateLink(&link, slug); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create link"})
+		return nil, false
+	}
+	return &link, true
+}
+
+// trackingURL is the public redirect URL of a link.
+func (h *AffiliateHandler) trackingURL(link models.AffiliateLink, code string) string {
+	if link.Slug == "" {
+		return referralURL(link.URL, code)
+	}
+	return fmt.Sprintf("%s/api/l/%s", strings.TrimRight(h.Cfg.AppURL, "/"), link.Slug)
+}
+
 func (h *AffiliateHandler) CreateLink(c *gin.Context) {
 	accountID, _ := strconv.Atoi(c.Param("accountId"))
-	var body struct {
-		URL  string `json:"url" binding:"required"`
-		Slug string `json:"slug"`
-	}
+	var body linkInput
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	link := models.AffiliateLink{
-		TenantID:  1,
-		AccountID: uint(accountID),
-		URL:       body.URL,
-		Slug:      body.Slug,
+	var account models.AffiliateAccount
+	if err := h.DB.First(&account, accountID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
 	}
-	if err := h.DB.Create(&link).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create link"})
+	link, ok := h.createLink(c, &account, body)
+	if !ok {
 		return
 	}
 	c.JSON(http.StatusCreated, gin.H{"data": link})
@@ -263,6 +411,9 @@ func (h *AffiliateHandler) ListCommissions(c *gin.Context) {
 	if aid := c.Query("account_id"); aid != "" {
 		q = q.Where("account_id = ?", aid)
 	}
+	if c.Query("flagged") == "true" {
+		q = q.Where("flagged_at IS NOT NULL")
+	}
 
 	q.Count(&total)
 
@@ -289,15 +440,20 @@ func (h *AffiliateHandler) ApproveCommission(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Commission not found"})
 		return
 	}
-	now := time.Now()
-	h.DB.Model(&comm).Updates(map[string]interface{}{
-		"status":      models.CommissionApproved,
-		"approved_at": now,
-	})
+	if comm.Status != models.CommissionPending {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only pending commissions can be approved"})
+		return
+	}
 
-	// Add to account balance
-	h.DB.Model(&models.AffiliateAccount{}).Where("id = ?", comm.AccountID).
-		UpdateColumn("balance", gorm.Expr("balance + ?", comm.Amount))
+	// Credits the account balance through the ledger
+	if err := services.NewAffiliateService(h.DB).ApproveCommission(&comm); err != nil {
+		if errors.Is(err, services.ErrCommissionOnHold) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "hold_until": comm.HoldUntil})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve commission"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"data": comm})
 }
@@ -309,10 +465,109 @@ func (h *AffiliateHandler) RejectCommission(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Commission not found"})
 		return
 	}
-	h.DB.Model(&comm).Update("status", models.CommissionRejected)
+	updates := map[string]interface{}{"status": models.CommissionRejected}
+	if comm.FlaggedAt != nil && comm.ReviewedAt == nil {
+		updates["reviewed_at"] = time.Now()
+	}
+	h.DB.Model(&comm).Updates(updates)
 	c.JSON(http.StatusOK, gin.H{"data": comm})
 }
 
+// ---------- Fraud Review ----------
+
+// ReviewQueue lists pending commissions flagged for fraud that nobody has
+// reviewed yet, with each account's recent click pattern.
+func (h *AffiliateHandler) ReviewQueue(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize := 20
+	if page < 1 {
+		page = 1
+	}
+
+	var total int64
+	q := h.DB.Model(&models.Commission{}).
+		Where("flagged_at IS NOT NULL AND reviewed_at IS NULL AND status = ?", models.CommissionPending)
+	q.Count(&total)
+
+	var commissions []models.Commission
+	q.Preload("Account.Contact").
+		Order("flagged_at ASC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&commissions)
+
+	type clickStats struct {
+		AccountID  uint  `json:"account_id"`
+		Clicks     int64 `json:"clicks"`
+		Duplicates int64 `json:"duplicates"`
+		UniqueIPs  int64 `json:"unique_ips"`
+	}
+	ids := []uint{}
+	for _, cm := range commissions {
+		ids = append(ids, cm.AccountID)
+	}
+	var stats []clickStats
+	if len(ids) > 0 {
+		h.DB.Model(&models.AffiliateClick{}).
+			Select("account_id, COUNT(*) FILTER (WHERE NOT duplicate) AS clicks, COUNT(*) FILTER (WHERE duplicate) AS duplicates, COUNT(DISTINCT ip_address) AS unique_ips").
+			Where("account_id IN ? AND created_at > ?", ids, time.Now().AddDate(0, 0, -7)).
+			Group("account_id").Scan(&stats)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": commissions,
+		"meta": gin.H{
+			"total": total, "page": page, "page_size": pageSize,
+			"pages":       int(math.Ceil(float64(total) / float64(pageSize))),
+			"click_stats": stats, // last 7 days per account
+		},
+	})
+}
+
+// ClearCommission accepts a flagged commission after review.
+func (h *AffiliateHandler) ClearCommission(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("commissionId"))
+	var comm models.Commission
+	if err := h.DB.First(&comm, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Commission not found"})
+		return
+	}
+	if err := services.NewAffiliateService(h.DB).ClearCommission(&comm); err != nil {
+		if errors.Is(err, services.ErrCommissionNotFlagged) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear commission"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": comm})
+}
+
+// AccountClicks lists an account's recent clicks, duplicates included.
+func (h *AffiliateHandler) AccountClicks(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("accountId"))
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize := 50
+	if page < 1 {
+		page = 1
+	}
+
+	var total int64
+	q := h.DB.Model(&models.AffiliateClick{}).Where("account_id = ?", id)
+	q.Count(&total)
+
+	var clicks []models.AffiliateClick
+	q.Order("created_at DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&clicks)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": clicks,
+		"meta": gin.H{
+			"total": total, "page": page, "page_size": pageSize,
+			"pages": int(math.Ceil(float64(total) / float64(pageSize))),
+		},
+	})
+}
+
 // ---------- Payouts ----------
 
 func (h *AffiliateHandler) ListPayouts(c *gin.Context) {
@@ -358,25 +613,19 @@ func (h *AffiliateHandler) CreatePayout(c *gin.Context) {
 		return
 	}
 
-	// Check balance
 	var account models.AffiliateAccount
 	if err := h.DB.First(&account, body.AccountID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
 		return
 	}
-	if account.Balance < body.Amount {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient balance"})
-		return
-	}
 
-	payout := models.Payout{
-		TenantID:  1,
-		AccountID: body.AccountID,
-		Amount:    body.Amount,
-		Method:    body.Method,
-		Status:    models.PayoutPending,
-	}
-	if err := h.DB.Create(&payout).Error; err != nil {
+	// Allocated against approved commissions not already claimed by a payout
+	payout, err := services.NewAffiliateService(h.DB).CreatePayout(account.ID, body.Amount, body.Method)
+	if err != nil {
+		if errors.Is(err, services.ErrInsufficientBalance) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payout"})
 		return
 	}
@@ -385,42 +634,201 @@ func (h *AffiliateHandler) CreatePayout(c *gin.Context) {
 
 func (h *AffiliateHandler) ProcessPayout(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("payoutId"))
-	var payout models.Payout
-	if err := h.DB.First(&payout, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Payout not found"})
+	var body struct {
+		TransactionID string `json:"transaction_id"`
+	}
+	c.ShouldBindJSON(&body)
+
+	payout, err := services.NewAffiliateService(h.DB).CompletePayout(uint(id), body.TransactionID)
+	if err != nil {
+		h.payoutError(c, err, "Failed to process payout")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": payout})
+}
+
+// FailPayout marks a payout as failed, returning its commissions to the
+// pool for the next payout.
+func (h *AffiliateHandler) FailPayout(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("payoutId"))
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	c.ShouldBindJSON(&body)
+
+	payout, err := services.NewAffiliateService(h.DB).FailPayout(uint(id), body.Reason)
+	if err != nil {
+		h.payoutError(c, err, "Failed to update payout")
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"data": payout})
+}
 
-	now := time.Now()
-	h.DB.Model(&payout).Updates(map[string]interface{}{
-		"status":       models.PayoutCompleted,
-		"processed_at": now,
+func (h *AffiliateHandler) payoutError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrPayoutNotFound), errors.Is(err, services.ErrBatchNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPayoutNotPending), errors.Is(err, services.ErrEmptyBatch),
+		errors.Is(err, services.ErrUnknownPayoutMethod):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// ---------- Ledger ----------
+
+// AccountLedger lists an account's balance movements, newest first.
+func (h *AffiliateHandler) AccountLedger(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("accountId"))
+	var account models.AffiliateAccount
+	if err := h.DB.First(&account, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	h.ledgerPage(c, &account)
+}
+
+func (h *AffiliateHandler) ledgerPage(c *gin.Context, account *models.AffiliateAccount) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize := 20
+	if page < 1 {
+		page = 1
+	}
+
+	entries, total := services.NewAffiliateService(h.DB).Ledger(account.ID, page, pageSize)
+	c.JSON(http.StatusOK, gin.H{
+		"data": entries,
+		"meta": gin.H{
+			"total": total, "page": page, "page_size": pageSize,
+			"pages":   int(math.Ceil(float64(total) / float64(pageSize))),
+			"balance": account.Balance,
+		},
 	})
+}
 
-	// Deduct from account balance, add to total_paid
-	h.DB.Model(&models.AffiliateAccount{}).Where("id = ?", payout.AccountID).
-		UpdateColumns(map[string]interface{}{
-			"balance":    gorm.Expr("balance - ?", payout.Amount),
-			"total_paid": gorm.Expr("total_paid + ?", payout.Amount),
-		})
+// AdjustBalance posts a manual credit (positive) or debit (negative) to an
+// account's ledger.
+func (h *AffiliateHandler) AdjustBalance(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("accountId"))
+	var body struct {
+		Amount      int64  `json:"amount" binding:"required"` // in cents, signed
+		Description string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	// Mark approved commissions as paid up to payout amount
-	h.DB.Model(&models.Commission{}).
-		Where("account_id = ? AND status = ?", payout.AccountID, models.CommissionApproved).
-		Updates(map[string]interface{}{"status": models.CommissionPaid, "paid_at": now})
+	var account models.AffiliateAccount
+	if err := h.DB.First(&account, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	if err := services.NewAffiliateService(h.DB).Adjust(account.ID, body.Amount, body.Description); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adjust balance"})
+		return
+	}
+	h.DB.First(&account, account.ID)
+	c.JSON(http.StatusOK, gin.H{"data": account})
+}
 
-	c.JSON(http.StatusOK, gin.H{"data": payout})
+// ---------- Payout Batches ----------
+
+func (h *AffiliateHandler) ListPayoutBatches(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize := 20
+	if page < 1 {
+		page = 1
+	}
+
+	var total int64
+	q := h.DB.Model(&models.PayoutBatch{})
+	if st := c.Query("status"); st != "" {
+		q = q.Where("status = ?", st)
+	}
+	q.Count(&total)
+
+	var batches []models.PayoutBatch
+	q.Order("created_at DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&batches)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": batches,
+		"meta": gin.H{
+			"total": total, "page": page, "page_size": pageSize,
+			"pages": int(math.Ceil(float64(total) / float64(pageSize))),
+		},
+	})
+}
+
+func (h *AffiliateHandler) GetPayoutBatch(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("batchId"))
+	var batch models.PayoutBatch
+	if err := h.DB.Preload("Payouts.Account.Contact").First(&batch, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payout batch not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": batch})
+}
+
+// CreatePayoutBatch gathers every affiliate owed a payout by one method.
+func (h *AffiliateHandler) CreatePayoutBatch(c *gin.Context) {
+	var body struct {
+		Method string `json:"method" binding:"required"` // paypal, bank_transfer
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	batch, err := services.NewAffiliateService(h.DB).CreatePayoutBatch(1, body.Method)
+	if err != nil {
+		h.payoutError(c, err, "Failed to create payout batch")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": batch})
+}
+
+// ExportPayoutBatch downloads the batch as a CSV for PayPal Payouts or the
+// bank's bulk-transfer upload.
+func (h *AffiliateHandler) ExportPayoutBatch(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("batchId"))
+	data, filename, err := services.NewAffiliateService(h.DB).ExportPayoutBatch(uint(id))
+	if err != nil {
+		h.payoutError(c, err, "Failed to export payout batch")
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, "text/csv", data)
+}
+
+// CompletePayoutBatch records the batch as paid out.
+func (h *AffiliateHandler) CompletePayoutBatch(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("batchId"))
+	var body struct {
+		Reference string `json:"reference"` // PayPal batch ID or bank reference
+	}
+	c.ShouldBindJSON(&body)
+
+	batch, err := services.NewAffiliateService(h.DB).CompletePayoutBatch(uint(id), body.Reference)
+	if err != nil {
+		h.payoutError(c, err, "Failed to complete payout batch")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": batch})
 }
 
 // ---------- Dashboard ----------
 
 func (h *AffiliateHandler) Dashboard(c *gin.Context) {
-	var totalAffiliates, activeAffiliates, pendingCommissions int64
+	var totalAffiliates, activeAffiliates, pendingCommissions, flaggedCommissions int64
 	var totalCommissionValue, totalPaidOut int64
 
 	h.DB.Model(&models.AffiliateAccount{}).Count(&totalAffiliates)
 	h.DB.Model(&models.AffiliateAccount{}).Where("status = ?", models.AffiliateStatusActive).Count(&activeAffiliates)
 	h.DB.Model(&models.Commission{}).Where("status = ?", models.CommissionPending).Count(&pendingCommissions)
+	h.DB.Model(&models.Commission{}).Where("flagged_at IS NOT NULL AND reviewed_at IS NULL AND status = ?", models.CommissionPending).
+		Count(&flaggedCommissions)
 	h.DB.Model(&models.Commission{}).Where("status IN ?", []string{models.CommissionApproved, models.CommissionPaid}).
 		Select("COALESCE(SUM(amount), 0)").Scan(&totalCommissionValue)
 	h.DB.Model(&models.Payout{}).Where("status = ?", models.PayoutCompleted).
@@ -451,6 +859,7 @@ func (h *AffiliateHandler) Dashboard(c *gin.Context) {
 			"total_affiliates":     totalAffiliates,
 			"active_affiliates":    activeAffiliates,
 			"pending_commissions":  pendingCommissions,
+			"flagged_commissions":  flaggedCommissions,
 			"total_commission":     totalCommissionValue,
 			"total_paid":           totalPaidOut,
 			"top_affiliates":       topAffiliates,
@@ -460,33 +869,620 @@ func (h *AffiliateHandler) Dashboard(c *gin.Context) {
 
 // ---------- Public: Referral Tracking ----------
 
+// TrackReferral records a click on a referral link and stores the code in a
+// first-party cookie for the program's CookieDays. Checkout reads the cookie
+// (or an explicit referral_code) and attributes the buyer to the affiliate.
 func (h *AffiliateHandler) TrackReferral(c *gin.Context) {
 	code := c.Param("code")
-	var account models.AffiliateAccount
-	if err := h.DB.Where("referral_code = ? AND status = ?", code, models.AffiliateStatusActive).
-		First(&account).Error; err != nil {
+	account, err := services.NewAffiliateService(h.DB).ResolveCode(code)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid referral code"})
 		return
 	}
 
-	// Increment click on first link
-	h.DB.Model(&models.AffiliateLink{}).Where("account_id = ?", account.ID).
-		UpdateColumn("clicks", gorm.Expr("clicks + 1"))
+	// Repeat clicks from the same visitor inside the program's window don't count.
+	// Code-only referrals belong to no link; /api/l/:slug credits its own link.
+	svc := services.NewAffiliateService(h.DB)
+	click, err := svc.RecordClick(account, nil, c.ClientIP(), c.Request.UserAgent(), c.Request.Referer(), time.Now())
 
-	events.Emit(events.AffiliateReferral, map[string]interface{}{
-		"account_id": account.ID, "referral_code": code,
-	})
+	cookieDays := account.Program.CookieDays
+	if cookieDays <= 0 {
+		cookieDays = 30
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(services.AffiliateCookie, account.ReferralCode, cookieDays*24*60*60, "/", "", c.Request.TLS != nil, true)
+
+	if err == nil && !click.Duplicate {
+		events.Emit(events.AffiliateReferral, map[string]interface{}{
+			"account_id": account.ID, "referral_code": account.ReferralCode,
+		})
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"data": gin.H{
 			"referral_code": account.ReferralCode,
 			"account_id":    account.ID,
+			"cookie_days":   cookieDays,
+		},
+	})
+}
+
+// FollowLink is the public redirect for an affiliate link (/api/l/:slug). It
+// records the click against the link, sets the referral cookies and sends
+// the visitor to the link's destination with the referral code and UTM tags.
+func (h *AffiliateHandler) FollowLink(c *gin.Context) {
+	svc := services.NewAffiliateService(h.DB)
+	link, account, err := svc.ResolveLink(c.Param("slug"))
+	if err != nil {
+		c.Redirect(http.StatusFound, strings.TrimRight(h.Cfg.WebURL, "/")+"/")
+		return
+	}
+
+	click, err := svc.RecordClick(account, &link.ID, c.ClientIP(), c.Request.UserAgent(), c.Request.Referer(), time.Now())
+	if err == nil && !click.Duplicate {
+		h.DB.Model(&models.AffiliateLink{}).Where("id = ?", link.ID).
+			UpdateColumn("clicks", gorm.Expr("clicks + 1"))
+		events.Emit(events.AffiliateReferral, map[string]interface{}{
+			"account_id": account.ID, "referral_code": account.ReferralCode, "link_id": link.ID,
+		})
+	}
+
+	cookieDays := account.Program.CookieDays
+	if cookieDays <= 0 {
+		cookieDays = 30
+	}
+	maxAge := cookieDays * 24 * 60 * 60
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(services.AffiliateCookie, account.ReferralCode, maxAge, "/", "", c.Request.TLS != nil, true)
+	c.SetCookie(services.AffiliateLinkCookie, strconv.Itoa(int(link.ID)), maxAge, "/", "", c.Request.TLS != nil, true)
+
+	// Links saved before their domain was allowed, or whose domain was since
+	// removed, land on the site instead of redirecting off it.
+	if !services.LinkHostAllowed(h.Cfg.WebURL, account.Program, link.URL) {
+		c.Redirect(http.StatusFound, strings.TrimRight(h.Cfg.WebURL, "/")+"/")
+		return
+	}
+	c.Redirect(http.StatusFound, services.LinkDestination(link, account.ReferralCode, c.Request.URL.Query()))
+}
+
+// ---------- Marketing Assets (admin) ----------
+
+func (h *AffiliateHandler) ListAssets(c *gin.Context) {
+	q := h.DB.Model(&models.AffiliateAsset{})
+	if pid := c.Query("program_id"); pid != "" {
+		q = q.Where("program_id = ?", pid)
+	}
+	var assets []models.AffiliateAsset
+	q.Order("sort_order ASC, created_at DESC").Find(&assets)
+	c.JSON(http.StatusOK, gin.H{"data": assets})
+}
+
+func (h *AffiliateHandler) CreateAsset(c *gin.Context) {
+	var body models.AffiliateAsset
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if body.Title == "" || (body.FileURL == "" && body.Content == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "title and either file_url or content are required"})
+		return
+	}
+	body.ID = 0
+	body.TenantID = 1
+	if err := h.DB.Create(&body).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create asset"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": body})
+}
+
+func (h *AffiliateHandler) UpdateAsset(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("assetId"))
+	var asset models.AffiliateAsset
+	if err := h.DB.First(&asset, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+	var body map[string]interface{}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sanitizeUpdates(body)
+	h.DB.Model(&asset).Updates(body)
+	h.DB.First(&asset, id)
+	c.JSON(http.StatusOK, gin.H{"data": asset})
+}
+
+func (h *AffiliateHandler) DeleteAsset(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("assetId"))
+	if err := h.DB.Delete(&models.AffiliateAsset{}, id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete asset"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Asset deleted"})
+}
+
+// ---------- Affiliate Portal (authenticated affiliate) ----------
+
+// portalContact resolves the authenticated user's contact.
+func (h *AffiliateHandler) portalContact(c *gin.Context) (*models.Contact, bool) {
+	user, _ := c.Get("user")
+	u := user.(models.User)
+	var contact models.Contact
+	if err := h.DB.Where("email = ? AND tenant_id = ?", u.Email, 1).First(&contact).Error; err != nil {
+		return nil, false
+	}
+	return &contact, true
+}
+
+// portalAccount loads one of the authenticated user's own affiliate accounts:
+// the one named by ?account_id=, or their oldest. Every portal query is
+// scoped to the account returned here.
+func (h *AffiliateHandler) portalAccount(c *gin.Context) (*models.AffiliateAccount, bool) {
+	contact, ok := h.portalContact(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Affiliate account not found"})
+		return nil, false
+	}
+	q := h.DB.Preload("Program").Preload("Links", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at ASC")
+	}).Where("contact_id = ?", contact.ID)
+	if aid := c.Query("account_id"); aid != "" {
+		q = q.Where("id = ?", aid)
+	}
+	var account models.AffiliateAccount
+	if err := q.Order("created_at ASC").First(&account).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Affiliate account not found"})
+		return nil, false
+	}
+	return &account, true
+}
+
+// activePortalAccount is portalAccount for actions that need an approved account.
+func (h *AffiliateHandler) activePortalAccount(c *gin.Context) (*models.AffiliateAccount, bool) {
+	account, ok := h.portalAccount(c)
+	if !ok {
+		return nil, false
+	}
+	if account.Status != models.AffiliateStatusActive {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your affiliate account is not active"})
+		return nil, false
+	}
+	return account, true
+}
+
+// PortalPrograms lists active programs open for applications, marking those
+// the user already belongs to.
+func (h *AffiliateHandler) PortalPrograms(c *gin.Context) {
+	var programs []models.AffiliateProgram
+	h.DB.Where("status = ?", "active").Order("created_at ASC").Find(&programs)
+
+	joined := map[uint]string{}
+	if contact, ok := h.portalContact(c); ok {
+		var accounts []models.AffiliateAccount
+		h.DB.Where("contact_id = ?", contact.ID).Find(&accounts)
+		for _, a := range accounts {
+			joined[a.ProgramID] = a.Status
+		}
+	}
+
+	out := make([]gin.H, len(programs))
+	for i, p := range programs {
+		out[i] = gin.H{
+			"id":                p.ID,
+			"name":              p.Name,
+			"description":       p.Description,
+			"commission_type":   p.CommissionType,
+			"commission_amount": p.CommissionAmount,
+			"cookie_days":       p.CookieDays,
+			"min_payout_amount": p.MinPayoutAmount,
+			"account_status":    joined[p.ID],
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": out})
+}
+
+// PortalApply creates an affiliate account for the user in a program. It is
+// active straight away when the program auto-approves, pending otherwise.
+func (h *AffiliateHandler) PortalApply(c *gin.Context) {
+	var body struct {
+		ProgramID    uint   `json:"program_id" binding:"required"`
+		CustomSlug   string `json:"custom_slug"`
+		ReferralCode string `json:"referral_code"` // recruiting affiliate; defaults to the referral cookie
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var program models.AffiliateProgram
+	if err := h.DB.Where("id = ? AND status = ?", body.ProgramID, "active").First(&program).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Program not found"})
+		return
+	}
+
+	user, _ := c.Get("user")
+	u := user.(models.User)
+	contact, ok := h.portalContact(c)
+	if !ok {
+		contact = &models.Contact{
+			TenantID:  1,
+			Email:     u.Email,
+			FirstName: u.FirstName,
+			LastName:  u.LastName,
+			Source:    "affiliate",
+			UserID:    &u.ID,
+		}
+		if err := h.DB.Create(contact).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create contact"})
+			return
+		}
+	}
+
+	var existing int64
+	h.DB.Model(&models.AffiliateAccount{}).Where("contact_id = ? AND program_id = ?", contact.ID, program.ID).Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "You have already applied to this program"})
+		return
+	}
+
+	slug := strings.TrimSpace(body.CustomSlug)
+	if slug != "" {
+		var taken int64
+		h.DB.Model(&models.AffiliateAccount{}).Where("custom_slug = ? OR referral_code = ?", slug, slug).Count(&taken)
+		if taken > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "That referral slug is taken"})
+			return
+		}
+	}
+
+	// The affiliate whose link brought the applicant in earns second-tier commissions
+	var recruitedBy *uint
+	code := body.ReferralCode
+	if code == "" {
+		code, _ = c.Cookie(services.AffiliateCookie)
+	}
+	if code != "" {
+		if recruiter, err := services.NewAffiliateService(h.DB).ResolveCode(code); err == nil && recruiter.ContactID != contact.ID {
+			recruitedBy = &recruiter.ID
+		}
+	}
+
+	status := models.AffiliateStatusPending
+	if program.AutoApprove {
+		status = models.AffiliateStatusActive
+	}
+	account := models.AffiliateAccount{
+		TenantID:      1,
+		ContactID:     contact.ID,
+		ProgramID:     program.ID,
+		Status:        status,
+		ReferralCode:  generateReferralCode(),
+		CustomSlug:    slug,
+		RecruitedByID: recruitedBy,
+		SignupIP:      c.ClientIP(),
+	}
+	if err := h.DB.Create(&account).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create affiliate account"})
+		return
+	}
+	services.NewAffiliateService(h.DB).GrantAffiliateRole(&account)
+
+	account.Program = &program
+	c.JSON(http.StatusCreated, gin.H{"data": account})
+}
+
+// PortalAccounts lists the user's own affiliate accounts.
+func (h *AffiliateHandler) PortalAccounts(c *gin.Context) {
+	contact, ok := h.portalContact(c)
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"data": []models.AffiliateAccount{}})
+		return
+	}
+	var accounts []models.AffiliateAccount
+	h.DB.Preload("Program").Where("contact_id = ?", contact.ID).Order("created_at ASC").Find(&accounts)
+	c.JSON(http.StatusOK, gin.H{"data": accounts})
+}
+
+// PortalDashboard returns the account with its click, conversion and
+// earnings totals.
+func (h *AffiliateHandler) PortalDashboard(c *gin.Context) {
+	account, ok := h.portalAccount(c)
+	if !ok {
+		return
+	}
+	stats := services.NewAffiliateService(h.DB).Stats(account)
+
+	var recent []models.Commission
+	h.DB.Where("account_id = ?", account.ID).Order("created_at DESC").Limit(5).Find(&recent)
+
+	var recruits int64
+	h.DB.Model(&models.AffiliateAccount{}).Where("recruited_by_id = ?", account.ID).Count(&recruits)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"account":            account,
+			"stats":              stats,
+			"recruits":           recruits,
+			"recent_commissions": recent,
 		},
 	})
 }
 
+// PortalLinks lists the account's links with their stats and tracking URLs.
+func (h *AffiliateHandler) PortalLinks(c *gin.Context) {
+	account, ok := h.portalAccount(c)
+	if !ok {
+		return
+	}
+	var links []models.AffiliateLink
+	h.DB.Where("account_id = ?", account.ID).Order("created_at DESC").Find(&links)
+
+	// Earnings per link, from the commissions credited to it
+	type linkEarnings struct {
+		LinkID uint
+		Total  int64
+	}
+	var earnings []linkEarnings
+	h.DB.Model(&models.Commission{}).Select("link_id, COALESCE(SUM(amount), 0) AS total").
+		Where("account_id = ? AND link_id IS NOT NULL AND status NOT IN ?", account.ID,
+			[]string{models.CommissionRejected, models.CommissionReversed}).
+		Group("link_id").Scan(&earnings)
+	earned := map[uint]int64{}
+	for _, e := range earnings {
+		earned[e.LinkID] = e.Total
+	}
+
+	type LinkWithURL struct {
+		models.AffiliateLink
+		TrackingURL    string  `json:"tracking_url"`
+		ConversionRate float64 `json:"conversion_rate"`
+		Earnings       int64   `json:"earnings"`
+	}
+	out := make([]LinkWithURL, len(links))
+	for i, l := range links {
+		out[i] = LinkWithURL{AffiliateLink: l, TrackingURL: h.trackingURL(l, account.ReferralCode), Earnings: earned[l.ID]}
+		if l.Clicks > 0 {
+			out[i].ConversionRate = math.Round(float64(l.Conversions)/float64(l.Clicks)*10000) / 100
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": out})
+}
+
+// PortalCreateLink adds a referral link to the user's account.
+func (h *AffiliateHandler) PortalCreateLink(c *gin.Context) {
+	account, ok := h.activePortalAccount(c)
+	if !ok {
+		return
+	}
+	var body linkInput
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	link, ok := h.createLink(c, account, body)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{"link": link, "tracking_url": h.trackingURL(*link, account.ReferralCode)}})
+}
+
+// PortalDeleteLink removes one of the user's own links.
+func (h *AffiliateHandler) PortalDeleteLink(c *gin.Context) {
+	account, ok := h.portalAccount(c)
+	if !ok {
+		return
+	}
+	res := h.DB.Where("id = ? AND account_id = ?", c.Param("linkId"), account.ID).Delete(&models.AffiliateLink{})
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Link deleted"})
+}
+
+// PortalCommissions lists the account's commissions, newest first.
+func (h *AffiliateHandler) PortalCommissions(c *gin.Context) {
+	account, ok := h.portalAccount(c)
+	if !ok {
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize := 20
+	if page < 1 {
+		page = 1
+	}
+
+	var total int64
+	q := h.DB.Model(&models.Commission{}).Where("account_id = ?", account.ID)
+	if st := c.Query("status"); st != "" {
+		q = q.Where("status = ?", st)
+	}
+	q.Count(&total)
+
+	var commissions []models.Commission
+	q.Order("created_at DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&commissions)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": commissions,
+		"meta": gin.H{
+			"total": total, "page": page, "page_size": pageSize,
+			"pages": int(math.Ceil(float64(total) / float64(pageSize))),
+		},
+	})
+}
+
+// PortalPayouts lists the account's payouts and current balance.
+func (h *AffiliateHandler) PortalPayouts(c *gin.Context) {
+	account, ok := h.portalAccount(c)
+	if !ok {
+		return
+	}
+	var payouts []models.Payout
+	h.DB.Where("account_id = ?", account.ID).Order("created_at DESC").Find(&payouts)
+	c.JSON(http.StatusOK, gin.H{
+		"data": payouts,
+		"meta": gin.H{"stats": services.NewAffiliateService(h.DB).Stats(account)},
+	})
+}
+
+// PortalRequestPayout files a payout request for the available balance (or
+// a given amount), subject to the program's minimum payout.
+func (h *AffiliateHandler) PortalRequestPayout(c *gin.Context) {
+	account, ok := h.activePortalAccount(c)
+	if !ok {
+		return
+	}
+	var body struct {
+		Amount int64  `json:"amount"` // in cents; 0 = whole balance
+		Method string `json:"method"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	payout, err := services.NewAffiliateService(h.DB).RequestPayout(account, body.Amount, body.Method)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrPayoutBelowMinimum):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "min_payout_amount": account.Program.MinPayoutAmount})
+		case errors.Is(err, services.ErrInsufficientBalance), errors.Is(err, services.ErrPayoutPending):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request payout"})
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": payout})
+}
+
+// PortalLedger lists the account's balance movements.
+func (h *AffiliateHandler) PortalLedger(c *gin.Context) {
+	account, ok := h.portalAccount(c)
+	if !ok {
+		return
+	}
+	h.ledgerPage(c, account)
+}
+
+// PortalUpdatePayoutDetails sets how the affiliate wants to be paid.
+func (h *AffiliateHandler) PortalUpdatePayoutDetails(c *gin.Context) {
+	account, ok := h.portalAccount(c)
+	if !ok {
+		return
+	}
+	var body struct {
+		PayoutMethod      string `json:"payout_method" binding:"required"` // paypal, bank_transfer
+		PayPalEmail       string `json:"paypal_email"`
+		BankAccountName   string `json:"bank_account_name"`
+		BankName          string `json:"bank_name"`
+		BankAccountNumber string `json:"bank_account_number"`
+		BankRoutingNumber string `json:"bank_routing_number"`
+		BankSwift         string `json:"bank_swift"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch body.PayoutMethod {
+	case models.PayoutMethodPayPal:
+		if body.PayPalEmail == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "paypal_email is required"})
+			return
+		}
+	case models.PayoutMethodBank:
+		if body.BankAccountName == "" || body.BankAccountNumber == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bank_account_name and bank_account_number are required"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrUnknownPayoutMethod.Error()})
+		return
+	}
+
+	h.DB.Model(account).Updates(map[string]interface{}{
+		"payout_method":       body.PayoutMethod,
+		"pay_pal_email":       body.PayPalEmail,
+		"bank_account_name":   body.BankAccountName,
+		"bank_name":           body.BankName,
+		"bank_account_number": body.BankAccountNumber,
+		"bank_routing_number": body.BankRoutingNumber,
+		"bank_swift":          body.BankSwift,
+	})
+	c.JSON(http.StatusOK, gin.H{"data": account})
+}
+
+// PortalAssets lists the marketing assets available to the account's program.
+func (h *AffiliateHandler) PortalAssets(c *gin.Context) {
+	account, ok := h.activePortalAccount(c)
+	if !ok {
+		return
+	}
+	var assets []models.AffiliateAsset
+	h.DB.Where("program_id IS NULL OR program_id = ?", account.ProgramID).
+		Order("sort_order ASC, created_at DESC").Find(&assets)
+	for i := range assets {
+		assets[i].Content = h.personalizeAsset(assets[i].Content, account)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": assets})
+}
+
+// PortalDownloadAsset downloads an asset: swipe copy as a text file with the
+// affiliate's link filled in, files by redirecting to their URL.
+func (h *AffiliateHandler) PortalDownloadAsset(c *gin.Context) {
+	account, ok := h.activePortalAccount(c)
+	if !ok {
+		return
+	}
+	var asset models.AffiliateAsset
+	if err := h.DB.Where("id = ? AND (program_id IS NULL OR program_id = ?)", c.Param("assetId"), account.ProgramID).
+		First(&asset).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+
+	if asset.FileURL != "" {
+		c.Redirect(http.StatusFound, asset.FileURL)
+		return
+	}
+	filename := fmt.Sprintf("%s.txt", generateSlug(asset.Title))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(h.personalizeAsset(asset.Content, account)))
+}
+
 // ---------- Helpers ----------
 
+// referralURL appends the affiliate's ref code to a landing page URL.
+func referralURL(raw, code string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	q := u.Query()
+	q.Set("ref", code)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// personalizeAsset fills the {link} and {code} placeholders in swipe copy.
+func (h *AffiliateHandler) personalizeAsset(content string, account *models.AffiliateAccount) string {
+	code := account.ReferralCode
+	if account.CustomSlug != "" {
+		code = account.CustomSlug
+	}
+	link := ""
+	if len(account.Links) > 0 {
+		link = h.trackingURL(account.Links[0], code)
+	}
+	return strings.NewReplacer("{code}", code, "{link}", link).Replace(content)
+}
+
 func generateReferralCode() string {
 	b := make([]byte, 6)
 	rand.Read(b)
@@ -12,6 +12,7 @@ import (
 	"gorm.io/gorm"
 
 	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/services"
 )
 
 // AnalyticsHandler handles analytics and CRM dashboard endpoints.
@@ -37,23 +38,19 @@ func (h *AnalyticsHandler) Dashboard(c *gin.Context) {
 	var totalSubscribers int64
 	h.db.Model(&models.EmailSubscription{}).Where("status = 'active'").Count(&totalSubscribers)
 
-	// --- Revenue metrics ---
+	// --- Revenue metrics (in the base currency) ---
+	fx := services.NewCurrencyService(h.db)
 	var totalRevenue float64
-	h.db.Model(&models.Order{}).Where("status = 'paid'").Select("COALESCE(SUM(total), 0)").Scan(&totalRevenue)
+	h.db.Model(&models.Order{}).Where("status = 'paid'").Select(services.BaseRevenueSQL).Scan(&totalRevenue)
 
 	startOfMonth := time.Now().UTC().Truncate(24 * time.Hour).AddDate(0, 0, -time.Now().Day()+1)
 	var monthlyRevenue float64
-	h.db.Model(&models.Order{}).Where("status = 'paid' AND paid_at >= ?", startOfMonth).Select("COALESCE(SUM(total), 0)").Scan(&monthlyRevenue)
+	h.db.Model(&models.Order{}).Where("status = 'paid' AND paid_at >= ?", startOfMonth).Select(services.BaseRevenueSQL).Scan(&monthlyRevenue)
 
 	var totalOrders int64
 	h.db.Model(&models.Order{}).Where("status = 'paid'").Count(&totalOrders)
 
-	var mrr float64
-	h.db.Model(&models.Subscription{}).
-		Where("subscriptions.status = 'active'").
-		Joins("JOIN prices ON prices.id = subscriptions.price_id").
-		Select("COALESCE(SUM(CASE WHEN prices.interval = 'year' THEN prices.amount / 12 ELSE prices.amount END), 0)").
-		Scan(&mrr)
+	mrr := fx.MRR(1)
 
 	// --- Course metrics ---
 	var activeStudents int64
@@ -81,9 +78,11 @@ func (h *AnalyticsHandler) Dashboard(c *gin.Context) {
 		"new_contacts_30d":  newContacts30d,
 		"total_subscribers":  totalSubscribers,
 		"total_revenue":      totalRevenue,
+		"currency":           fx.BaseCurrency(1),
 		"monthly_revenue":    monthlyRevenue,
 		"total_orders":       totalOrders,
 		"mrr":                mrr,
+		"unconverted_orders": fx.UnconvertedOrders(1),
 		"active_students":    activeStudents,
 		"completed_courses":  completedCourses,
 		"total_emails_sent":  totalEmailsSent,
@@ -117,7 +116,7 @@ func (h *AnalyticsHandler) ContactProfile(c *gin.Context) {
 
 	// Lifetime value
 	var lifetimeValue float64
-	h.db.Model(&models.Order{}).Where("contact_id = ? AND status = 'paid'", contactID).Select("COALESCE(SUM(total), 0)").Scan(&lifetimeValue)
+	h.db.Model(&models.Order{}).Where("contact_id = ? AND status = 'paid'", contactID).Select(services.BaseRevenueSQL).Scan(&lifetimeValue)
 
 	// Active subscriptions
 	var activeSubs []models.Subscription
@@ -178,7 +177,7 @@ func (h *AnalyticsHandler) ActivityTimeline(c *gin.Context) {
 	})
 }
 
-// RevenueChart returns revenue data points for charting.
+// RevenueChart returns revenue data points for charting, in the base currency.
 func (h *AnalyticsHandler) RevenueChart(c *gin.Context) {
 	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
 	if days < 7 {
@@ -201,7 +200,7 @@ func (h *AnalyticsHandler) RevenueChart(c *gin.Context) {
 
 		var revenue float64
 		h.db.Model(&models.Order{}).Where("status = 'paid' AND paid_at >= ? AND paid_at < ?", day, nextDay).
-			Select("COALESCE(SUM(total), 0)").Scan(&revenue)
+			Select(services.BaseRevenueSQL).Scan(&revenue)
 
 		var orders int64
 		h.db.Model(&models.Order{}).Where("status = 'paid' AND paid_at >= ? AND paid_at < ?", day, nextDay).Count(&orders)
@@ -213,7 +212,7 @@ func (h *AnalyticsHandler) RevenueChart(c *gin.Context) {
 		})
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": points})
+	c.JSON(http.StatusOK, gin.H{"data": points, "currency": services.NewCurrencyService(h.db).BaseCurrency(1)})
 }
 
 // SubscriberGrowth returns subscriber growth data for charting.
@@ -253,7 +252,9 @@ func (h *AnalyticsHandler) SubscriberGrowth(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": points})
 }
 
-// TopProducts returns the top-selling products.
+// TopProducts returns the top-selling products by base-currency revenue.
+// Like the other revenue figures it leaves out orders without a captured
+// exchange rate.
 func (h *AnalyticsHandler) TopProducts(c *gin.Context) {
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
 	if limit > 50 {
@@ -271,16 +272,77 @@ func (h *AnalyticsHandler) TopProducts(c *gin.Context) {
 	h.db.Raw(`
 		SELECT oi.product_id, p.name,
 			COUNT(DISTINCT oi.order_id) as sales,
-			COALESCE(SUM(oi.total), 0) as revenue
+			COALESCE(SUM(oi.total * o.exchange_rate), 0) as revenue
 		FROM order_items oi
-		JOIN orders o ON o.id = oi.order_id AND o.status = 'paid'
+		JOIN orders o ON o.id = oi.order_id AND o.status = 'paid' AND o.exchange_rate > 0
 		JOIN products p ON p.id = oi.product_id
 		GROUP BY oi.product_id, p.name
 		ORDER BY revenue DESC
 		LIMIT ?
 	`, limit).Scan(&stats)
 
-	c.JSON(http.StatusOK, gin.H{"data": stats})
+	fx := services.NewCurrencyService(h.db)
+	c.JSON(http.StatusOK, gin.H{"data": stats, "currency": fx.BaseCurrency(1), "unconverted_orders": fx.UnconvertedOrders(1)})
+}
+
+// SaaSMetrics returns subscription metrics from the nightly snapshots over
+// the last N days: MRR movements, churn, ARPU, trial conversion and LTV.
+func (h *AnalyticsHandler) SaaSMetrics(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if days < 7 {
+		days = 7
+	}
+	if days > 365 {
+		days = 365
+	}
+
+	to := time.Now().UTC().AddDate(0, 0, -1)
+	from := to.AddDate(0, 0, -days+1)
+	report := services.NewSaaSMetricsService(h.db).Report(1, from, to)
+
+	c.JSON(http.StatusOK, gin.H{"data": report})
+}
+
+// SaaSCohorts returns monthly cohort retention for the last N cohorts.
+func (h *AnalyticsHandler) SaaSCohorts(c *gin.Context) {
+	months, _ := strconv.Atoi(c.DefaultQuery("months", "12"))
+	if months < 1 {
+		months = 1
+	}
+	if months > 36 {
+		months = 36
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": services.NewSaaSMetricsService(h.db).CohortTable(1, months)})
+}
+
+// RebuildSaaSMetrics recomputes the daily snapshots for the last N days
+// (through yesterday) and the cohort table, e.g. after importing subscriptions.
+func (h *AnalyticsHandler) RebuildSaaSMetrics(c *gin.Context) {
+	var input struct {
+		Days int `json:"days"`
+	}
+	c.ShouldBindJSON(&input)
+	if input.Days <= 0 {
+		input.Days = 90
+	}
+	if input.Days > 365 {
+		input.Days = 365
+	}
+
+	svc := services.NewSaaSMetricsService(h.db)
+	to := time.Now().UTC().AddDate(0, 0, -1)
+	count, err := svc.Rebuild(1, to.AddDate(0, 0, -input.Days+1), to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild metrics"})
+		return
+	}
+	if err := svc.RebuildCohorts(1); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild cohorts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"days": count}, "message": "Metrics rebuilt"})
 }
 
 // ContactExport exports contacts as CSV or XLSX (?format=xlsx).
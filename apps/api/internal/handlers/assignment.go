@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/services"
+	"gritcms/apps/api/internal/storage"
+)
+
+// AssignmentHandler manages assignments, student submissions and instructor
+// grading.
+type AssignmentHandler struct {
+	db      *gorm.DB
+	storage *storage.Storage
+}
+
+// NewAssignmentHandler creates a new AssignmentHandler.
+func NewAssignmentHandler(db *gorm.DB, storage *storage.Storage) *AssignmentHandler {
+	return &AssignmentHandler{db: db, storage: storage}
+}
+
+// validRubric reports whether the rubric, if set, is a list of criteria with
+// names and positive points.
+func validRubric(assignment *models.Assignment) bool {
+	if len(assignment.Rubric) == 0 || string(assignment.Rubric) == "null" {
+		return true
+	}
+	var rubric []services.RubricCriterion
+	if err := json.Unmarshal(assignment.Rubric, &rubric); err != nil {
+		return false
+	}
+	for _, criterion := range rubric {
+		if criterion.Criterion == "" || criterion.Points <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ===== Assignments (admin) =====
+
+// CreateAssignment attaches an assignment to a lesson.
+func (h *AssignmentHandler) CreateAssignment(c *gin.Context) {
+	lessonID, _ := strconv.Atoi(c.Param("lessonId"))
+	var lesson models.Lesson
+	if err := h.db.First(&lesson, lessonID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
+		return
+	}
+	var existing int64
+	h.db.Model(&models.Assignment{}).Where("lesson_id = ?", lessonID).Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "This lesson already has an assignment"})
+		return
+	}
+
+	var body models.Assignment
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validRubric(&body) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Rubric criteria need a name and positive points"})
+		return
+	}
+	body.TenantID = 1
+	body.LessonID = uint(lessonID)
+	if body.Title == "" {
+		body.Title = lesson.Title
+	}
+	if err := h.db.Create(&body).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create assignment"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": body})
+}
+
+// UpdateAssignment updates an assignment's instructions, rubric and rules.
+func (h *AssignmentHandler) UpdateAssignment(c *gin.Context) {
+	assignmentID, _ := strconv.Atoi(c.Param("assignmentId"))
+	var assignment models.Assignment
+	if err := h.db.First(&assignment, assignmentID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Assignment not found"})
+		return
+	}
+	lessonID := assignment.LessonID
+	if err := c.ShouldBindJSON(&assignment); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validRubric(&assignment) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Rubric criteria need a name and positive points"})
+		return
+	}
+	assignment.ID = uint(assignmentID)
+	assignment.LessonID = lessonID
+	h.db.Save(&assignment)
+	c.JSON(http.StatusOK, gin.H{"data": assignment})
+}
+
+// DeleteAssignment removes an assignment.
+func (h *AssignmentHandler) DeleteAssignment(c *gin.Context) {
+	assignmentID, _ := strconv.Atoi(c.Param("assignmentId"))
+	h.db.Delete(&models.Assignment{}, assignmentID)
+	c.JSON(http.StatusOK, gin.H{"message": "Assignment deleted"})
+}
+
+// ===== Review (course instructor or admin) =====
+
+// ReviewQueue lists submissions on the user's courses, oldest first.
+// Filter with status (submitted, graded) and course_id.
+func (h *AssignmentHandler) ReviewQueue(c *gin.Context) {
+	user, _ := c.Get("user")
+	u := user.(models.User)
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	courseID, _ := strconv.Atoi(c.Query("course_id"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	svc := services.NewAssignmentService(h.db, h.storage)
+	submissions, total := svc.ReviewQueue(&u, c.Query("status"), uint(courseID), page, pageSize)
+	views := make([]services.SubmissionView, 0, len(submissions))
+	for i := range submissions {
+		views = append(views, svc.View(c.Request.Context(), &submissions[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": views,
+		"meta": gin.H{
+			"total":     total,
+			"page":      page,
+			"page_size": pageSize,
+			"pages":     int(math.Ceil(float64(total) / float64(pageSize))),
+		},
+	})
+}
+
+// reviewSubmission loads a submission the user may review, writing a 403 or
+// 404 otherwise.
+func (h *AssignmentHandler) reviewSubmission(c *gin.Context) (*models.AssignmentSubmission, *models.User, bool) {
+	user, _ := c.Get("user")
+	u := user.(models.User)
+	subID, _ := strconv.Atoi(c.Param("subId"))
+
+	submission, err := services.NewAssignmentService(h.db, h.storage).LoadForReview(&u, uint(subID))
+	if err != nil {
+		if errors.Is(err, services.ErrNotInstructor) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return nil, nil, false
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Submission not found"})
+		return nil, nil, false
+	}
+	return submission, &u, true
+}
+
+// GetSubmission returns a submission with its files, the assignment rubric
+// and the student's earlier submissions.
+func (h *AssignmentHandler) GetSubmission(c *gin.Context) {
+	submission, _, ok := h.reviewSubmission(c)
+	if !ok {
+		return
+	}
+	svc := services.NewAssignmentService(h.db, h.storage)
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"submission": svc.View(c.Request.Context(), submission),
+		"rubric":     services.ParseRubric(&submission.Assignment),
+		"history":    svc.Submissions(c.Request.Context(), submission.AssignmentID, submission.EnrollmentID),
+	}})
+}
+
+// GradeSubmission grades a submission against the rubric and leaves
+// feedback; the student is emailed the result.
+func (h *AssignmentHandler) GradeSubmission(c *gin.Context) {
+	submission, u, ok := h.reviewSubmission(c)
+	if !ok {
+		return
+	}
+	var body services.GradeInput
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	svc := services.NewAssignmentService(h.db, h.storage)
+	if err := svc.Grade(submission, u.ID, body, time.Now()); err != nil {
+		if errors.Is(err, services.ErrInvalidGrade) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to grade submission"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": svc.View(c.Request.Context(), submission)})
+}
+
+// ===== Student =====
+
+// studentAssignment loads an assignment of a course the student is enrolled
+// in, provided its lesson has unlocked.
+func (h *AssignmentHandler) studentAssignment(c *gin.Context) (*models.Assignment, *models.CourseEnrollment, bool) {
+	courseID, _ := strconv.Atoi(c.Param("id"))
+	user, _ := c.Get("user")
+	u := user.(models.User)
+
+	courses := services.NewCourseService(h.db)
+	enrollment, err := courses.StudentEnrollment(u.Email, uint(courseID))
+	if err != nil {
+		if errors.Is(err, services.ErrMembershipRequired) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return nil, nil, false
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not enrolled in this course"})
+		return nil, nil, false
+	}
+
+	var assignment models.Assignment
+	err = h.db.Joins("JOIN lessons ON lessons.id = assignments.lesson_id").
+		Joins("JOIN course_modules ON course_modules.id = lessons.module_id").
+		Where("assignments.id = ? AND course_modules.course_id = ?", c.Param("assignmentId"), enrollment.CourseID).
+		First(&assignment).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Assignment not found"})
+		return nil, nil, false
+	}
+	if err := courses.CheckUnlocked(enrollment, assignment.LessonID, time.Now()); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": services.ErrLessonLocked.Error()})
+		return nil, nil, false
+	}
+	return &assignment, enrollment, true
+}
+
+// StudentGetAssignment returns an assignment with its rubric and the
+// student's submissions, grades and feedback.
+func (h *AssignmentHandler) StudentGetAssignment(c *gin.Context) {
+	assignment, enrollment, ok := h.studentAssignment(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"assignment":  assignment,
+		"rubric":      services.ParseRubric(assignment),
+		"submissions": services.NewAssignmentService(h.db, h.storage).Submissions(c.Request.Context(), assignment.ID, enrollment.ID),
+	}})
+}
+
+// StudentSubmitAssignment submits text and/or files uploaded through
+// /uploads/presign and /uploads/complete. The instructor is notified.
+func (h *AssignmentHandler) StudentSubmitAssignment(c *gin.Context) {
+	assignment, enrollment, ok := h.studentAssignment(c)
+	if !ok {
+		return
+	}
+	var body services.SubmissionInput
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID, _ := c.Get("user_id")
+
+	svc := services.NewAssignmentService(h.db, h.storage)
+	submission, err := svc.Submit(c.Request.Context(), assignment, enrollment, userID.(uint), body, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrEmptySubmission), errors.Is(err, services.ErrSubmissionType),
+			errors.Is(err, services.ErrTooManyFiles), errors.Is(err, services.ErrInvalidUpload),
+			errors.Is(err, services.ErrMaxSubmissions):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit assignment"})
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": svc.View(c.Request.Context(), submission)})
+}
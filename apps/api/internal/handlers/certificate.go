@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/services"
+	"gritcms/apps/api/internal/storage"
+)
+
+// CertificateHandler manages certificate templates and serves certificate
+// PDFs to admins and students.
+type CertificateHandler struct {
+	db      *gorm.DB
+	storage *storage.Storage
+	webURL  string
+}
+
+// NewCertificateHandler creates a new CertificateHandler.
+func NewCertificateHandler(db *gorm.DB, storage *storage.Storage, webURL string) *CertificateHandler {
+	return &CertificateHandler{db: db, storage: storage, webURL: webURL}
+}
+
+func (h *CertificateHandler) service() *services.CertificateService {
+	return services.NewCertificateService(h.db, h.storage, h.webURL)
+}
+
+// ===== Templates =====
+
+func (h *CertificateHandler) ListTemplates(c *gin.Context) {
+	var templates []models.CertificateTemplate
+	h.db.Where("tenant_id = ?", 1).Order("name ASC").Find(&templates)
+	c.JSON(http.StatusOK, gin.H{"data": templates})
+}
+
+func (h *CertificateHandler) GetTemplate(c *gin.Context) {
+	var tmpl models.CertificateTemplate
+	if err := h.db.First(&tmpl, c.Param("templateId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": tmpl})
+}
+
+func (h *CertificateHandler) CreateTemplate(c *gin.Context) {
+	var body models.CertificateTemplate
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateCertificateFields(body.Fields); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	body.ID = 0
+	body.TenantID = 1
+	if err := h.db.Create(&body).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create template"})
+		return
+	}
+	h.keepSingleDefault(&body)
+	c.JSON(http.StatusCreated, gin.H{"data": body})
+}
+
+func (h *CertificateHandler) UpdateTemplate(c *gin.Context) {
+	var tmpl models.CertificateTemplate
+	if err := h.db.First(&tmpl, c.Param("templateId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	if err := c.ShouldBindJSON(&tmpl); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateCertificateFields(tmpl.Fields); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.db.Save(&tmpl)
+	h.keepSingleDefault(&tmpl)
+	c.JSON(http.StatusOK, gin.H{"data": tmpl})
+}
+
+func (h *CertificateHandler) DeleteTemplate(c *gin.Context) {
+	templateID, _ := strconv.Atoi(c.Param("templateId"))
+	h.db.Model(&models.Course{}).Where("certificate_template_id = ?", templateID).Update("certificate_template_id", nil)
+	h.db.Delete(&models.CertificateTemplate{}, templateID)
+	c.JSON(http.StatusOK, gin.H{"message": "Template deleted"})
+}
+
+// PreviewTemplate renders the template with sample data.
+func (h *CertificateHandler) PreviewTemplate(c *gin.Context) {
+	var tmpl models.CertificateTemplate
+	if err := h.db.First(&tmpl, c.Param("templateId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	pdf, err := h.service().Preview(c.Request.Context(), &tmpl)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", "inline; filename=certificate-preview.pdf")
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
+
+// keepSingleDefault clears IsDefault on the other templates when tmpl is
+// the default.
+func (h *CertificateHandler) keepSingleDefault(tmpl *models.CertificateTemplate) {
+	if !tmpl.IsDefault {
+		return
+	}
+	h.db.Model(&models.CertificateTemplate{}).
+		Where("tenant_id = ? AND id <> ?", tmpl.TenantID, tmpl.ID).
+		Update("is_default", false)
+}
+
+func validateCertificateFields(raw []byte) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	var fields []services.CertificateField
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return fmt.Errorf("fields must be a list of positioned fields")
+	}
+	for _, f := range fields {
+		switch f.Type {
+		case models.CertFieldText, models.CertFieldStudentName, models.CertFieldCourseTitle, models.CertFieldDate,
+			models.CertFieldInstructor, models.CertFieldSignature, models.CertFieldNumber, models.CertFieldQRCode:
+		default:
+			return fmt.Errorf("unknown field type %q", f.Type)
+		}
+	}
+	return nil
+}
+
+// ===== Certificates =====
+
+// DownloadCertificate returns a certificate PDF to an admin.
+func (h *CertificateHandler) DownloadCertificate(c *gin.Context) {
+	certID, err := strconv.ParseUint(c.Param("certId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid certificate ID"})
+		return
+	}
+	h.sendCertificate(c, uint(certID))
+}
+
+// ReissueCertificate renders a certificate again, e.g. after its template
+// was edited.
+func (h *CertificateHandler) ReissueCertificate(c *gin.Context) {
+	certID, _ := strconv.Atoi(c.Param("certId"))
+	cert, err := h.service().Reissue(c.Request.Context(), uint(certID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": cert})
+}
+
+// StudentCertificates lists the current user's certificates.
+func (h *CertificateHandler) StudentCertificates(c *gin.Context) {
+	user, _ := c.Get("user")
+	u := user.(models.User)
+
+	var contact models.Contact
+	if err := h.db.Where("email = ? AND tenant_id = ?", u.Email, 1).First(&contact).Error; err != nil {
+		c.JSON(http.StatusOK, gin.H{"data": []models.Certificate{}})
+		return
+	}
+
+	var certs []models.Certificate
+	h.db.Preload("Course").Preload("LearningPath").Where("contact_id = ?", contact.ID).Order("issued_at DESC").Find(&certs)
+	c.JSON(http.StatusOK, gin.H{"data": certs})
+}
+
+// StudentDownloadCertificate returns one of the current user's certificates.
+func (h *CertificateHandler) StudentDownloadCertificate(c *gin.Context) {
+	user, _ := c.Get("user")
+	u := user.(models.User)
+
+	var contact models.Contact
+	if err := h.db.Where("email = ? AND tenant_id = ?", u.Email, 1).First(&contact).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+		return
+	}
+
+	var cert models.Certificate
+	if err := h.db.Where("id = ? AND contact_id = ?", c.Param("certId"), contact.ID).First(&cert).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+		return
+	}
+
+	h.sendCertificate(c, cert.ID)
+}
+
+func (h *CertificateHandler) sendCertificate(c *gin.Context, certID uint) {
+	svc := h.service()
+	cert, err := svc.Issue(c.Request.Context(), certID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not available: " + err.Error()})
+		return
+	}
+
+	pdf, err := svc.PDF(c.Request.Context(), cert)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate certificate"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.pdf", cert.CertificateNumber))
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
@@ -15,19 +15,24 @@ import (
 	"gorm.io/gorm"
 
 	"gritcms/apps/api/internal/cache"
+	"gritcms/apps/api/internal/config"
 	"gritcms/apps/api/internal/events"
 	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/payments"
+	"gritcms/apps/api/internal/services"
 )
 
 // CommerceHandler handles all commerce-related endpoints.
 type CommerceHandler struct {
-	db    *gorm.DB
-	cache *cache.Cache
+	db        *gorm.DB
+	cache     *cache.Cache
+	cfg       *config.Config
+	providers *payments.Registry
 }
 
 // NewCommerceHandler creates a new CommerceHandler.
-func NewCommerceHandler(db *gorm.DB, cache *cache.Cache) *CommerceHandler {
-	return &CommerceHandler{db: db, cache: cache}
+func NewCommerceHandler(db *gorm.DB, cache *cache.Cache, cfg *config.Config, providers *payments.Registry) *CommerceHandler {
+	return &CommerceHandler{db: db, cache: cache, cfg: cfg, providers: providers}
 }
 
 // invalidateProductCache clears cached public product pages.
@@ -166,6 +171,142 @@ func (h *CommerceHandler) DeleteProduct(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Product deleted"})
 }
 
+// ===================== BUNDLES =====================
+
+// ListProductCourses returns every course a product grants: linked courses,
+// bundled courses and the courses of learning paths sold with it.
+func (h *CommerceHandler) ListProductCourses(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	courseIDs := services.ProductCourseIDs(h.db, uint(id))
+
+	var bundled []uint
+	h.db.Model(&models.ProductCourse{}).Where("product_id = ?", id).Pluck("course_id", &bundled)
+
+	courses := []models.Course{}
+	if len(courseIDs) > 0 {
+		h.db.Where("id IN ?", courseIDs).Order("title ASC").Find(&courses)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"courses": courses, "bundled_course_ids": bundled}})
+}
+
+// SetProductCourses replaces the courses bundled with a product. Buying the
+// product enrolls the customer in each of them.
+func (h *CommerceHandler) SetProductCourses(c *gin.Context) {
+	var product models.Product
+	if err := h.db.First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	var body struct {
+		CourseIDs []uint `json:"course_ids"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var found int64
+	if len(body.CourseIDs) > 0 {
+		h.db.Model(&models.Course{}).Where("id IN ?", body.CourseIDs).Count(&found)
+	}
+	links := make([]models.ProductCourse, 0, len(body.CourseIDs))
+	seen := make(map[uint]bool)
+	for _, courseID := range body.CourseIDs {
+		if !seen[courseID] {
+			seen[courseID] = true
+			links = append(links, models.ProductCourse{ProductID: product.ID, CourseID: courseID})
+		}
+	}
+	if int(found) != len(links) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Course not found"})
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("product_id = ?", product.ID).Delete(&models.ProductCourse{}).Error; err != nil {
+			return err
+		}
+		if len(links) == 0 {
+			return nil
+		}
+		return tx.Create(&links).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update bundle"})
+		return
+	}
+	h.invalidateProductCache(c)
+	c.JSON(http.StatusOK, gin.H{"data": links})
+}
+
+// ===================== MEMBERSHIP ENTITLEMENTS =====================
+
+// ListEntitlements returns what a membership product unlocks.
+func (h *CommerceHandler) ListEntitlements(c *gin.Context) {
+	var entitlements []models.MembershipEntitlement
+	h.db.Where("membership_product_id = ?", c.Param("id")).Order("resource_type ASC, resource_id ASC").Find(&entitlements)
+	c.JSON(http.StatusOK, gin.H{"data": entitlements})
+}
+
+// CreateEntitlement lets a membership product unlock a course, space, guide
+// or product.
+func (h *CommerceHandler) CreateEntitlement(c *gin.Context) {
+	var product models.Product
+	if err := h.db.First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if product.Type != models.ProductTypeMembership {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only membership products can grant entitlements"})
+		return
+	}
+	var input struct {
+		ResourceType string `json:"resource_type" binding:"required"`
+		ResourceID   uint   `json:"resource_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var resource interface{}
+	switch input.ResourceType {
+	case models.EntitlementCourse:
+		resource = &models.Course{}
+	case models.EntitlementSpace:
+		resource = &models.Space{}
+	case models.EntitlementGuide:
+		resource = &models.PremiumGuide{}
+	case models.EntitlementProduct:
+		resource = &models.Product{}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "resource_type must be course, space, guide or product"})
+		return
+	}
+	if err := h.db.First(resource, input.ResourceID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Resource not found"})
+		return
+	}
+
+	entitlement := models.MembershipEntitlement{
+		TenantID:            1,
+		MembershipProductID: product.ID,
+		ResourceType:        input.ResourceType,
+		ResourceID:          input.ResourceID,
+	}
+	if err := h.db.Where(entitlement).FirstOrCreate(&entitlement).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create entitlement"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": entitlement})
+}
+
+// DeleteEntitlement removes something from a membership.
+func (h *CommerceHandler) DeleteEntitlement(c *gin.Context) {
+	h.db.Where("id = ? AND membership_product_id = ?", c.Param("entitlementId"), c.Param("id")).
+		Delete(&models.MembershipEntitlement{})
+	c.JSON(http.StatusOK, gin.H{"message": "Entitlement deleted"})
+}
+
 // ===================== PRICES =====================
 
 // CreatePrice creates a price for a product.
@@ -319,7 +460,7 @@ func (h *CommerceHandler) ListOrders(c *gin.Context) {
 func (h *CommerceHandler) GetOrder(c *gin.Context) {
 	id := c.Param("orderId")
 	var order models.Order
-	if err := h.db.Preload("Contact").Preload("Items.Product").Preload("Coupon").First(&order, id).Error; err != nil {
+	if err := h.db.Preload("Contact").Preload("Items.Product").Preload("Coupon").Preload("Discounts").First(&order, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
 	}
@@ -336,8 +477,9 @@ func (h *CommerceHandler) CreateOrder(c *gin.Context) {
 			VariantID *uint `json:"variant_id"`
 			Quantity  int   `json:"quantity"`
 		} `json:"items" binding:"required"`
-		CouponCode string `json:"coupon_code"`
-		Currency   string `json:"currency"`
+		CouponCode  string   `json:"coupon_code"`
+		CouponCodes []string `json:"coupon_codes"`
+		Currency    string   `json:"currency"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -345,10 +487,12 @@ func (h *CommerceHandler) CreateOrder(c *gin.Context) {
 		return
 	}
 
+	fx := services.NewCurrencyService(h.db)
 	currency := input.Currency
 	if currency == "" {
-		currency = "USD"
+		currency = fx.BaseCurrency(1)
 	}
+	currency = strings.ToUpper(currency)
 
 	// Build order items
 	var orderItems []models.OrderItem
@@ -360,21 +504,26 @@ func (h *CommerceHandler) CreateOrder(c *gin.Context) {
 			qty = 1
 		}
 
-		// Get product price
+		// Get product price in the order currency
 		var unitPrice float64
+		priceCurrency := currency
 		if item.PriceID != nil {
 			var price models.Price
 			if err := h.db.First(&price, *item.PriceID).Error; err == nil {
-				unitPrice = price.Amount
+				unitPrice, priceCurrency = fx.PriceIn(1, price, currency)
 			}
 		}
 		if unitPrice == 0 {
 			// Fallback: get first price of product
 			var price models.Price
 			if err := h.db.Where("product_id = ?", item.ProductID).Order("sort_order ASC").First(&price).Error; err == nil {
-				unitPrice = price.Amount
+				unitPrice, priceCurrency = fx.PriceIn(1, price, currency)
 			}
 		}
+		if priceCurrency != currency {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Product %d has no price in %s and no exchange rate to convert it", item.ProductID, currency)})
+			return
+		}
 
 		total := unitPrice * float64(qty)
 		subtotal += total
@@ -391,35 +540,13 @@ func (h *CommerceHandler) CreateOrder(c *gin.Context) {
 		})
 	}
 
-	// Apply coupon
-	var discountAmount float64
-	var couponID *uint
-	if input.CouponCode != "" {
-		var coupon models.Coupon
-		if err := h.db.Where("code = ? AND status = 'active'", strings.ToUpper(input.CouponCode)).First(&coupon).Error; err == nil {
-			if coupon.MaxUses == 0 || coupon.UsedCount < coupon.MaxUses {
-				now := time.Now()
-				validTime := true
-				if coupon.ValidFrom != nil && now.Before(*coupon.ValidFrom) {
-					validTime = false
-				}
-				if coupon.ValidUntil != nil && now.After(*coupon.ValidUntil) {
-					validTime = false
-				}
-				if validTime && subtotal >= coupon.MinOrderAmount {
-					if coupon.Type == models.CouponTypePercentage {
-						discountAmount = subtotal * (coupon.Amount / 100)
-					} else {
-						discountAmount = coupon.Amount
-					}
-					if discountAmount > subtotal {
-						discountAmount = subtotal
-					}
-					couponID = &coupon.ID
-				}
-			}
-		}
+	// Apply coupons and automatic discounts
+	quote, err := services.NewCouponService(h.db).Quote(1, input.ContactID, currency, orderItems, couponCodes(input.CouponCode, input.CouponCodes))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
+	discountAmount := quote.Discount
 
 	totalAmount := subtotal - discountAmount
 
@@ -433,8 +560,9 @@ func (h *CommerceHandler) CreateOrder(c *gin.Context) {
 		TaxAmount:      0,
 		Total:          totalAmount,
 		Currency:       currency,
-		CouponID:       couponID,
+		CouponID:       quote.CouponID,
 		Items:          orderItems,
+		Discounts:      quote.Discounts,
 	}
 
 	if err := h.db.Create(&order).Error; err != nil {
@@ -442,10 +570,7 @@ func (h *CommerceHandler) CreateOrder(c *gin.Context) {
 		return
 	}
 
-	// Increment coupon usage
-	if couponID != nil {
-		h.db.Model(&models.Coupon{}).Where("id = ?", *couponID).UpdateColumn("used_count", gorm.Expr("used_count + 1"))
-	}
+	// Coupon usage is counted by fulfillment once the order is paid
 
 	h.db.Preload("Contact").Preload("Items.Product").First(&order, order.ID)
 	c.JSON(http.StatusCreated, gin.H{"data": order})
@@ -468,107 +593,83 @@ func (h *CommerceHandler) UpdateOrderStatus(c *gin.Context) {
 		return
 	}
 
-	oldStatus := order.Status
-	order.Status = input.Status
-
-	if input.Status == models.OrderStatusPaid && oldStatus != models.OrderStatusPaid {
-		now := time.Now()
-		order.PaidAt = &now
-		h.db.Save(&order)
-
-		// Fulfill: auto-enroll in linked courses
-		for _, item := range order.Items {
-			// Direct course purchase
-			if item.CourseID != nil {
-				enrollment := models.CourseEnrollment{
-					TenantID:  1,
-					ContactID: order.ContactID,
-					CourseID:  *item.CourseID,
-					Status:    "active",
-					Source:    "purchase",
-				}
-				h.db.FirstOrCreate(&enrollment, models.CourseEnrollment{
-					ContactID: order.ContactID,
-					CourseID:  *item.CourseID,
-				})
-				continue
-			}
-			// Legacy: product-type-course linkage
-			if item.ProductID != nil {
-				var product models.Product
-				if err := h.db.First(&product, *item.ProductID).Error; err == nil {
-					if product.Type == models.ProductTypeCourse {
-						var courses []models.Course
-						h.db.Where("product_id = ?", product.ID).Find(&courses)
-						for _, course := range courses {
-							enrollment := models.CourseEnrollment{
-								TenantID:  1,
-								ContactID: order.ContactID,
-								CourseID:  course.ID,
-								Status:    "active",
-								Source:    "purchase",
-							}
-							h.db.FirstOrCreate(&enrollment, models.CourseEnrollment{
-								ContactID: order.ContactID,
-								CourseID:  course.ID,
-							})
-						}
-					}
-				}
-			}
+	if input.Status == models.OrderStatusPaid && order.Status != models.OrderStatusPaid {
+		// Manual payment — same fulfillment path as the payment providers
+		provider := order.PaymentProvider
+		if provider == "" {
+			provider = "manual"
 		}
-
-		events.Emit(events.PurchaseCompleted, map[string]interface{}{
-			"order_id":   order.ID,
-			"contact_id": order.ContactID,
-			"total":      order.Total,
-		})
+		paid, _, err := services.NewFulfillmentService(h.db).FulfillPaidOrder(order.ID, services.PaymentConfirmation{Provider: provider})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		order = *paid
 	} else if input.Status == models.OrderStatusRefunded {
-		h.db.Save(&order)
-		events.Emit(events.PurchaseRefunded, map[string]interface{}{
-			"order_id":   order.ID,
-			"contact_id": order.ContactID,
-			"total":      order.Total,
-		})
+		// Refund whatever is left through the provider and the refund ledger
+		if _, err := services.NewRefundService(h.db, h.cfg, h.providers).RefundOrder(&order, services.RefundRequest{Reason: "Marked as refunded"}); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else if input.Status == models.OrderStatusPartiallyRefunded {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use the refund endpoint to refund part of an order"})
+		return
 	} else {
+		order.Status = input.Status
 		h.db.Save(&order)
 	}
 
 	c.JSON(http.StatusOK, gin.H{"data": order})
 }
 
-// RefundOrder processes a refund.
+// RefundOrder refunds all or part of an order through its payment provider.
+// Body: {"amount": 10.00} for a partial amount, {"items": [{"order_item_id": 1}]}
+// for specific items, or an empty body for a full refund.
 func (h *CommerceHandler) RefundOrder(c *gin.Context) {
 	orderID := c.Param("orderId")
 	var order models.Order
-	if err := h.db.First(&order, orderID).Error; err != nil {
+	if err := h.db.Preload("Items").First(&order, orderID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
 	}
 
-	if order.Status != models.OrderStatusPaid {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Order is not in paid status"})
-		return
+	var input services.RefundRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 	}
 
-	order.Status = models.OrderStatusRefunded
-	h.db.Save(&order)
+	refunds, err := services.NewRefundService(h.db, h.cfg, h.providers).RefundOrder(&order, input)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	events.Emit(events.PurchaseRefunded, map[string]interface{}{
-		"order_id":   order.ID,
-		"contact_id": order.ContactID,
-		"total":      order.Total,
-	})
+	h.db.Preload("Contact").Preload("Items.Product").Preload("Refunds").First(&order, order.ID)
+	c.JSON(http.StatusOK, gin.H{"data": order, "refunds": refunds})
+}
 
-	c.JSON(http.StatusOK, gin.H{"data": order})
+// ListOrderRefunds returns the refund ledger for an order.
+func (h *CommerceHandler) ListOrderRefunds(c *gin.Context) {
+	var refunds []models.Refund
+	h.db.Where("order_id = ?", c.Param("orderId")).Order("created_at DESC").Find(&refunds)
+	c.JSON(http.StatusOK, gin.H{"data": refunds})
 }
 
 // ===================== COUPONS =====================
 
-// ListCoupons lists all coupons.
+// ListCoupons lists all coupons. Codes bulk-generated from a coupon are
+// listed under it (see ListCouponCodes), not here.
 func (h *CommerceHandler) ListCoupons(c *gin.Context) {
+	q := h.db.Where("parent_id IS NULL")
+	if c.Query("automatic") != "" {
+		q = q.Where("automatic = ?", c.Query("automatic") == "true")
+	}
+
 	var coupons []models.Coupon
-	if err := h.db.Order("created_at DESC").Find(&coupons).Error; err != nil {
+	if err := q.Order("created_at DESC").Find(&coupons).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list coupons"})
 		return
 	}
@@ -586,7 +687,7 @@ func (h *CommerceHandler) GetCoupon(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": coupon})
 }
 
-// CreateCoupon creates a new coupon.
+// CreateCoupon creates a new coupon. Automatic discounts may omit the code.
 func (h *CommerceHandler) CreateCoupon(c *gin.Context) {
 	var coupon models.Coupon
 	if err := c.ShouldBindJSON(&coupon); err != nil {
@@ -595,7 +696,14 @@ func (h *CommerceHandler) CreateCoupon(c *gin.Context) {
 	}
 
 	coupon.TenantID = 1
-	coupon.Code = strings.ToUpper(coupon.Code)
+	coupon.Code = strings.ToUpper(strings.TrimSpace(coupon.Code))
+	if coupon.Code == "" && coupon.Automatic {
+		coupon.Code = services.GenerateAutomaticCode()
+	}
+	if msg := validateCouponRules(coupon); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
 
 	if err := h.db.Create(&coupon).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create coupon"})
@@ -605,6 +713,37 @@ func (h *CommerceHandler) CreateCoupon(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"data": coupon})
 }
 
+// validateCouponRules checks that a coupon's type-specific fields make sense.
+func validateCouponRules(coupon models.Coupon) string {
+	if coupon.Code == "" {
+		return "Code is required"
+	}
+	switch coupon.Type {
+	case "", models.CouponTypePercentage:
+		if coupon.Amount <= 0 || coupon.Amount > 100 {
+			return "Percentage must be between 0 and 100"
+		}
+	case models.CouponTypeFixed:
+		if coupon.Amount <= 0 {
+			return "Amount must be greater than zero"
+		}
+	case models.CouponTypeBuyXGetY:
+		if coupon.BuyQuantity < 1 || coupon.GetQuantity < 1 {
+			return "buy_quantity and get_quantity must be at least 1"
+		}
+		if coupon.Amount < 0 || coupon.Amount > 100 {
+			return "Percentage off must be between 0 and 100"
+		}
+	case models.CouponTypeFreeTrial:
+		if coupon.TrialDays < 1 {
+			return "trial_days must be at least 1"
+		}
+	default:
+		return "Unknown coupon type"
+	}
+	return ""
+}
+
 // UpdateCoupon updates a coupon.
 func (h *CommerceHandler) UpdateCoupon(c *gin.Context) {
 	id := c.Param("couponId")
@@ -620,6 +759,11 @@ func (h *CommerceHandler) UpdateCoupon(c *gin.Context) {
 		return
 	}
 	sanitizeUpdates(input)
+	delete(input, "used_count")
+	delete(input, "parent_id")
+	if code, ok := input["code"].(string); ok {
+		input["code"] = strings.ToUpper(strings.TrimSpace(code))
+	}
 
 	if err := h.db.Model(&coupon).Updates(input).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update coupon"})
@@ -640,7 +784,89 @@ func (h *CommerceHandler) DeleteCoupon(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Coupon deleted"})
 }
 
-// ValidateCoupon validates a coupon code (public).
+// GenerateCouponCodes creates unique single-use codes for a campaign, each a
+// copy of the coupon's rules.
+func (h *CommerceHandler) GenerateCouponCodes(c *gin.Context) {
+	var coupon models.Coupon
+	if err := h.db.First(&coupon, c.Param("couponId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found"})
+		return
+	}
+	if coupon.ParentID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Codes can only be generated from a parent coupon"})
+		return
+	}
+
+	var input struct {
+		Count  int    `json:"count" binding:"required"`
+		Prefix string `json:"prefix"`
+		Length int    `json:"length"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := services.NewCouponService(h.db).GenerateCodes(coupon, input.Count, input.Prefix, input.Length)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{"generated": len(codes)}})
+}
+
+// ListCouponCodes lists the codes generated from a coupon, paginated, or
+// exports them all as CSV with ?format=csv.
+func (h *CommerceHandler) ListCouponCodes(c *gin.Context) {
+	parentID := c.Param("couponId")
+	q := h.db.Model(&models.Coupon{}).Where("parent_id = ?", parentID)
+	if status := c.Query("status"); status == "used" {
+		q = q.Where("used_count > 0")
+	} else if status == "unused" {
+		q = q.Where("used_count = 0")
+	}
+
+	if c.Query("format") == "csv" {
+		var coupons []models.Coupon
+		q.Order("code ASC").Find(&coupons)
+
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=coupon-%s-codes.csv", parentID))
+		c.Writer.WriteString("code,used_count,status,created_at\n")
+		for _, cp := range coupons {
+			c.Writer.WriteString(fmt.Sprintf("%s,%d,%s,%s\n", cp.Code, cp.UsedCount, cp.Status, cp.CreatedAt.Format(time.RFC3339)))
+		}
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 500 {
+		pageSize = 50
+	}
+
+	var total int64
+	q.Count(&total)
+
+	var coupons []models.Coupon
+	if err := q.Order("code ASC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&coupons).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list coupon codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": coupons,
+		"meta": gin.H{"total": total, "page": page, "page_size": pageSize, "pages": int(math.Ceil(float64(total) / float64(pageSize)))},
+	})
+}
+
+// ValidateCoupon validates a coupon code (public). Pass ?subtotal= (minor
+// units, in ?currency=) to check the minimum order amount too; per-customer
+// rules are checked at checkout.
 func (h *CommerceHandler) ValidateCoupon(c *gin.Context) {
 	code := c.Query("code")
 	if code == "" {
@@ -654,21 +880,96 @@ func (h *CommerceHandler) ValidateCoupon(c *gin.Context) {
 		return
 	}
 
-	now := time.Now()
-	if coupon.ValidFrom != nil && now.Before(*coupon.ValidFrom) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Coupon is not yet valid"})
+	subtotal := coupon.MinOrderAmount
+	if v, err := strconv.ParseFloat(c.Query("subtotal"), 64); err == nil {
+		subtotal = v
+	}
+	currency := c.DefaultQuery("currency", services.NewCurrencyService(h.db).BaseCurrency(1))
+	if err := services.NewCouponService(h.db).Check(coupon, 0, currency, subtotal); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if coupon.ValidUntil != nil && now.After(*coupon.ValidUntil) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Coupon has expired"})
+
+	c.JSON(http.StatusOK, gin.H{"data": coupon})
+}
+
+// QuoteDiscounts prices a cart with the given codes and any automatic
+// discounts, so the checkout page can show the final amount before paying.
+func (h *CommerceHandler) QuoteDiscounts(c *gin.Context) {
+	var input struct {
+		Items []struct {
+			ProductID uint  `json:"product_id" binding:"required"`
+			PriceID   *uint `json:"price_id"`
+			Quantity  int   `json:"quantity"`
+		} `json:"items" binding:"required"`
+		CouponCodes []string `json:"coupon_codes"`
+		Currency    string   `json:"currency"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if coupon.MaxUses > 0 && coupon.UsedCount >= coupon.MaxUses {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Coupon usage limit reached"})
+
+	fx := services.NewCurrencyService(h.db)
+	currency := input.Currency
+	if currency == "" {
+		currency = displayCurrency(c, fx)
+	}
+
+	var contactID uint
+	if user, ok := c.Get("user"); ok {
+		var contact models.Contact
+		if h.db.Where("email = ? AND tenant_id = ?", user.(models.User).Email, 1).First(&contact).Error == nil {
+			contactID = contact.ID
+		}
+	}
+
+	var items []models.OrderItem
+	for _, in := range input.Items {
+		qty := in.Quantity
+		if qty < 1 {
+			qty = 1
+		}
+		var price models.Price
+		q := h.db.Where("product_id = ?", in.ProductID)
+		if in.PriceID != nil {
+			q = q.Where("id = ?", *in.PriceID)
+		}
+		if err := q.Order("sort_order ASC").First(&price).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("No price found for product %d", in.ProductID)})
+			return
+		}
+		// The cart is priced in the first item's resolved currency
+		unitPrice, cur := fx.PriceIn(1, price, currency)
+		if len(items) == 0 {
+			currency = cur
+		}
+		productID := in.ProductID
+		priceID := price.ID
+		items = append(items, models.OrderItem{
+			TenantID:  1,
+			ProductID: &productID,
+			PriceID:   &priceID,
+			Quantity:  qty,
+			UnitPrice: unitPrice,
+			Total:     unitPrice * float64(qty),
+		})
+	}
+
+	quote, err := services.NewCouponService(h.db).Quote(1, contactID, currency, items, input.CouponCodes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"data": quote, "currency": currency})
+}
 
-	c.JSON(http.StatusOK, gin.H{"data": coupon})
+// couponCodes merges the single coupon_code field with coupon_codes.
+func couponCodes(code string, codes []string) []string {
+	if code != "" {
+		codes = append([]string{code}, codes...)
+	}
+	return codes
 }
 
 // ===================== SUBSCRIPTIONS =====================
@@ -751,10 +1052,11 @@ func (h *CommerceHandler) CancelSubscription(c *gin.Context) {
 
 // ===================== REVENUE DASHBOARD =====================
 
-// RevenueDashboard returns commerce analytics.
+// RevenueDashboard returns commerce analytics in the base currency.
 func (h *CommerceHandler) RevenueDashboard(c *gin.Context) {
+	fx := services.NewCurrencyService(h.db)
 	var totalRevenue float64
-	h.db.Model(&models.Order{}).Where("status = 'paid'").Select("COALESCE(SUM(total), 0)").Scan(&totalRevenue)
+	h.db.Model(&models.Order{}).Where("status = 'paid'").Select(services.BaseRevenueSQL).Scan(&totalRevenue)
 
 	var totalOrders int64
 	h.db.Model(&models.Order{}).Where("status = 'paid'").Count(&totalOrders)
@@ -768,31 +1070,195 @@ func (h *CommerceHandler) RevenueDashboard(c *gin.Context) {
 	// Revenue this month
 	startOfMonth := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -time.Now().Day()+1)
 	var monthlyRevenue float64
-	h.db.Model(&models.Order{}).Where("status = 'paid' AND paid_at >= ?", startOfMonth).Select("COALESCE(SUM(total), 0)").Scan(&monthlyRevenue)
+	h.db.Model(&models.Order{}).Where("status = 'paid' AND paid_at >= ?", startOfMonth).Select(services.BaseRevenueSQL).Scan(&monthlyRevenue)
 
 	// Recent orders
 	var recentOrders []models.Order
 	h.db.Preload("Contact").Where("status = 'paid'").Order("paid_at DESC").Limit(5).Find(&recentOrders)
 
 	// MRR from active subscriptions
-	var mrr float64
-	h.db.Model(&models.Subscription{}).
-		Where("subscriptions.status = 'active'").
-		Joins("JOIN prices ON prices.id = subscriptions.price_id").
-		Select("COALESCE(SUM(CASE WHEN prices.interval = 'year' THEN prices.amount / 12 ELSE prices.amount END), 0)").
-		Scan(&mrr)
+	mrr := fx.MRR(1)
 
 	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"currency":             fx.BaseCurrency(1),
 		"total_revenue":        totalRevenue,
 		"total_orders":         totalOrders,
 		"total_products":       totalProducts,
 		"active_subscriptions": activeSubscriptions,
 		"monthly_revenue":      monthlyRevenue,
 		"mrr":                  mrr,
+		"unconverted_orders":   fx.UnconvertedOrders(1),
 		"recent_orders":        recentOrders,
 	}})
 }
 
+// ===================== ORDER BUMPS =====================
+
+// ListOrderBumps lists order bumps (?product_id= to filter) with how often
+// each was taken on paid orders and the revenue it added.
+func (h *CommerceHandler) ListOrderBumps(c *gin.Context) {
+	q := h.db.Where("tenant_id = ?", 1)
+	if productID := c.Query("product_id"); productID != "" {
+		q = q.Where("product_id = ?", productID)
+	}
+
+	var bumps []models.OrderBump
+	if err := q.Preload("OfferProduct").Preload("OfferPrice").Order("product_id ASC, sort_order ASC").Find(&bumps).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list order bumps"})
+		return
+	}
+
+	type bumpStat struct {
+		BumpID  uint
+		Taken   int64
+		Revenue float64
+	}
+	var stats []bumpStat
+	h.db.Raw(`
+		SELECT oi.bump_id, COUNT(*) AS taken,
+			COALESCE(SUM(oi.total * CASE WHEN o.exchange_rate > 0 THEN o.exchange_rate ELSE 1 END), 0) AS revenue
+		FROM order_items oi
+		JOIN orders o ON o.id = oi.order_id AND o.status = 'paid'
+		WHERE oi.bump_id IS NOT NULL
+		GROUP BY oi.bump_id
+	`).Scan(&stats)
+	byBump := make(map[uint]bumpStat, len(stats))
+	for _, st := range stats {
+		byBump[st.BumpID] = st
+	}
+
+	data := make([]gin.H, 0, len(bumps))
+	for _, b := range bumps {
+		data = append(data, gin.H{"bump": b, "taken": byBump[b.ID].Taken, "revenue": byBump[b.ID].Revenue})
+	}
+	c.JSON(http.StatusOK, gin.H{"data": data, "currency": services.NewCurrencyService(h.db).BaseCurrency(1)})
+}
+
+// CreateOrderBump creates an order bump for a product's checkout.
+func (h *CommerceHandler) CreateOrderBump(c *gin.Context) {
+	var bump models.OrderBump
+	if err := c.ShouldBindJSON(&bump); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	bump.TenantID = 1
+	if bump.ProductID == 0 || bump.OfferPriceID == 0 || bump.Headline == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "product_id, offer_price_id and headline are required"})
+		return
+	}
+	var price models.Price
+	if err := h.db.First(&price, bump.OfferPriceID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Offer price not found"})
+		return
+	}
+	bump.OfferProductID = price.ProductID
+	if bump.OfferProductID == bump.ProductID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A product cannot be its own order bump"})
+		return
+	}
+
+	if err := h.db.Create(&bump).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order bump"})
+		return
+	}
+	h.db.Preload("OfferProduct").Preload("OfferPrice").First(&bump, bump.ID)
+	c.JSON(http.StatusCreated, gin.H{"data": bump})
+}
+
+// UpdateOrderBump updates an order bump.
+func (h *CommerceHandler) UpdateOrderBump(c *gin.Context) {
+	id := c.Param("bumpId")
+	var bump models.OrderBump
+	if err := h.db.First(&bump, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order bump not found"})
+		return
+	}
+
+	var input map[string]interface{}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sanitizeUpdates(input)
+	if priceID, ok := input["offer_price_id"]; ok {
+		var price models.Price
+		if err := h.db.First(&price, priceID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Offer price not found"})
+			return
+		}
+		input["offer_product_id"] = price.ProductID
+	}
+
+	if err := h.db.Model(&bump).Updates(input).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order bump"})
+		return
+	}
+	h.db.Preload("OfferProduct").Preload("OfferPrice").First(&bump, id)
+	c.JSON(http.StatusOK, gin.H{"data": bump})
+}
+
+// DeleteOrderBump deletes an order bump.
+func (h *CommerceHandler) DeleteOrderBump(c *gin.Context) {
+	if err := h.db.Delete(&models.OrderBump{}, c.Param("bumpId")).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete order bump"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Order bump deleted"})
+}
+
+// ===================== ABANDONED CHECKOUTS =====================
+
+// ListAbandonedCheckouts lists abandoned checkouts with their recovery state.
+// Filter with ?status=open|recovered|expired.
+func (h *CommerceHandler) ListAbandonedCheckouts(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	offset := (page - 1) * pageSize
+
+	q := h.db.Model(&models.CheckoutRecovery{}).Where("tenant_id = ?", 1)
+	switch c.Query("status") {
+	case "open":
+		q = q.Where("recovered_at IS NULL AND expired_at IS NULL")
+	case "recovered":
+		q = q.Where("recovered_at IS NOT NULL")
+	case "expired":
+		q = q.Where("expired_at IS NOT NULL")
+	}
+
+	var total int64
+	q.Count(&total)
+
+	var recoveries []models.CheckoutRecovery
+	if err := q.Preload("Order").Preload("Contact").Order("abandoned_at DESC").
+		Offset(offset).Limit(pageSize).Find(&recoveries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list abandoned checkouts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": recoveries,
+		"meta": gin.H{"total": total, "page": page, "page_size": pageSize, "pages": int(math.Ceil(float64(total) / float64(pageSize)))},
+	})
+}
+
+// AbandonedCheckoutReport returns abandoned checkout and recovered revenue
+// metrics for the last ?days= days (default 30).
+func (h *CommerceHandler) AbandonedCheckoutReport(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if days < 1 {
+		days = 30
+	}
+	from := time.Now().AddDate(0, 0, -days)
+	report := services.NewCheckoutRecoveryService(h.db).Report(1, from)
+	c.JSON(http.StatusOK, gin.H{"data": report, "days": days})
+}
+
 // ===================== PUBLIC ENDPOINTS =====================
 
 // ListPublicProducts lists active products for the storefront.
@@ -818,9 +1284,17 @@ func (h *CommerceHandler) ListPublicProducts(c *gin.Context) {
 		return
 	}
 
+	fx := services.NewCurrencyService(h.db)
+	currency := displayCurrency(c, fx)
+	for i := range products {
+		setDisplayPrices(fx, products[i].Prices, currency)
+		hideDownloadURLs(&products[i])
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"data": products,
-		"meta": gin.H{"total": total, "page": page, "page_size": pageSize, "pages": int(math.Ceil(float64(total) / float64(pageSize)))},
+		"currency": currency,
+		"data":     products,
+		"meta":     gin.H{"total": total, "page": page, "page_size": pageSize, "pages": int(math.Ceil(float64(total) / float64(pageSize)))},
 	})
 }
 
@@ -834,7 +1308,29 @@ func (h *CommerceHandler) GetPublicProduct(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"data": product})
+	fx := services.NewCurrencyService(h.db)
+	currency := displayCurrency(c, fx)
+	setDisplayPrices(fx, product.Prices, currency)
+	hideDownloadURLs(&product)
+	c.JSON(http.StatusOK, gin.H{"data": product, "currency": currency})
+}
+
+// hideDownloadURLs keeps only the names of a product's files for the
+// storefront; the links are served to buyers and entitled members by
+// StudentGetProductDownloads.
+func hideDownloadURLs(product *models.Product) {
+	if len(product.DownloadableFiles) == 0 {
+		return
+	}
+	var files []map[string]interface{}
+	if json.Unmarshal(product.DownloadableFiles, &files) != nil {
+		product.DownloadableFiles = nil
+		return
+	}
+	for _, f := range files {
+		delete(f, "url")
+	}
+	product.DownloadableFiles, _ = json.Marshal(files)
 }
 
 // ===================== STUDENT PURCHASES =====================
@@ -877,6 +1373,43 @@ func (h *CommerceHandler) StudentGetPurchases(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": result})
 }
 
+// StudentGetMemberships returns the authenticated user's active memberships
+// and what each one unlocks.
+func (h *CommerceHandler) StudentGetMemberships(c *gin.Context) {
+	user, _ := c.Get("user")
+	u := user.(models.User)
+
+	var contact models.Contact
+	if err := h.db.Where("email = ? AND tenant_id = ?", u.Email, 1).First(&contact).Error; err != nil {
+		c.JSON(http.StatusOK, gin.H{"data": []interface{}{}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": services.NewAccessService(h.db).Memberships(contact.ID, time.Now())})
+}
+
+// StudentGetProductDownloads returns a product's files to a contact who
+// bought it or whose membership unlocks it.
+func (h *CommerceHandler) StudentGetProductDownloads(c *gin.Context) {
+	user, _ := c.Get("user")
+	u := user.(models.User)
+
+	var contact models.Contact
+	if err := h.db.Where("email = ? AND tenant_id = ?", u.Email, 1).First(&contact).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have access to this product"})
+		return
+	}
+	var product models.Product
+	if err := h.db.First(&product, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if !services.NewAccessService(h.db).CanAccessProduct(contact.ID, product.ID, time.Now()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have access to this product"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"product_id": product.ID, "name": product.Name, "files": product.DownloadableFiles}})
+}
+
 // StudentGetPurchase returns a single paid order for the authenticated user.
 func (h *CommerceHandler) StudentGetPurchase(c *gin.Context) {
 	orderID := c.Param("orderId")
@@ -11,6 +11,7 @@ import (
 	"gorm.io/gorm"
 
 	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/services"
 )
 
 // CommunityHandler handles all community endpoints.
@@ -191,6 +192,11 @@ func (h *CommunityHandler) ListThreads(c *gin.Context) {
 	}
 	offset := (page - 1) * pageSize
 
+	id, _ := strconv.Atoi(spaceID)
+	if !h.spaceAccess(c, uint(id)) {
+		return
+	}
+
 	q := h.db.Model(&models.Thread{}).Where("space_id = ?", spaceID)
 	if threadType != "" {
 		q = q.Where("type = ?", threadType)
@@ -228,6 +234,9 @@ func (h *CommunityHandler) GetThread(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Thread not found"})
 		return
 	}
+	if !h.spaceAccess(c, thread.SpaceID) {
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"data": thread})
 }
 
@@ -591,6 +600,32 @@ func (h *CommunityHandler) GetUserContact(c *gin.Context) (*models.Contact, bool
 	return &contact, true
 }
 
+// spaceAccess reports whether the current user may read and post in the
+// space, writing a 404 or 403 otherwise. Admins can use every space; others
+// need a public space, a live membership of the space, or an entitled
+// membership.
+func (h *CommunityHandler) spaceAccess(c *gin.Context, spaceID uint) bool {
+	var space models.Space
+	if err := h.db.First(&space, spaceID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Space not found"})
+		return false
+	}
+	if userObj, exists := c.Get("user"); exists {
+		if u, ok := userObj.(models.User); ok && models.IsAdminRole(u.Role) {
+			return true
+		}
+	}
+	if space.Type == models.SpaceTypePublic {
+		return true
+	}
+	contact, ok := h.GetUserContact(c)
+	if !ok || !services.NewAccessService(h.db).CanAccessSpace(contact.ID, &space, time.Now()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have access to this space"})
+		return false
+	}
+	return true
+}
+
 func (h *CommunityHandler) StudentCreateThread(c *gin.Context) {
 	spaceID, _ := strconv.Atoi(c.Param("id"))
 	contact, ok := h.GetUserContact(c)
@@ -598,6 +633,9 @@ func (h *CommunityHandler) StudentCreateThread(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
+	if !h.spaceAccess(c, uint(spaceID)) {
+		return
+	}
 
 	var thread models.Thread
 	if err := c.ShouldBindJSON(&thread); err != nil {
@@ -629,6 +667,14 @@ func (h *CommunityHandler) StudentCreateReply(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
+	var thread models.Thread
+	if err := h.db.Select("id", "space_id").First(&thread, threadID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Thread not found"})
+		return
+	}
+	if !h.spaceAccess(c, thread.SpaceID) {
+		return
+	}
 
 	var reply models.Reply
 	if err := c.ShouldBindJSON(&reply); err != nil {
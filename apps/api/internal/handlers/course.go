@@ -1,9 +1,9 @@
 package handlers
 
 import (
-	"crypto/rand"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"net/http"
 	"strconv"
@@ -12,9 +12,11 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"gritcms/apps/api/internal/events"
 	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/services"
 )
 
 type CourseHandler struct {
@@ -121,7 +123,7 @@ func (h *CourseHandler) DeleteCourse(c *gin.Context) {
 func (h *CourseHandler) DuplicateCourse(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
 	var original models.Course
-	if err := h.DB.Preload("Modules.Lessons").First(&original, id).Error; err != nil {
+	if err := h.DB.Preload("Modules.Lessons.Assignment").First(&original, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Course not found"})
 		return
 	}
@@ -159,12 +161,31 @@ func (h *CourseHandler) DuplicateCourse(c *gin.Context) {
 				Content:         lesson.Content,
 				Type:            lesson.Type,
 				VideoURL:        lesson.VideoURL,
+				MediaAssetID:    lesson.MediaAssetID,
 				DurationMinutes: lesson.DurationMinutes,
 				SortOrder:       lesson.SortOrder,
 				IsFreePreview:   lesson.IsFreePreview,
 				DripDelayDays:   lesson.DripDelayDays,
+				DripType:        lesson.DripType,
+				DripDate:        lesson.DripDate,
 			}
 			h.DB.Create(&newLesson)
+
+			if a := lesson.Assignment; a != nil {
+				h.DB.Create(&models.Assignment{
+					TenantID:       a.TenantID,
+					LessonID:       newLesson.ID,
+					Title:          a.Title,
+					Instructions:   a.Instructions,
+					SubmissionType: a.SubmissionType,
+					MaxFiles:       a.MaxFiles,
+					Rubric:         a.Rubric,
+					MaxPoints:      a.MaxPoints,
+					PassingScore:   a.PassingScore,
+					MaxSubmissions: a.MaxSubmissions,
+					Required:       a.Required,
+				})
+			}
 		}
 	}
 
@@ -262,7 +283,7 @@ func (h *CourseHandler) GetLesson(c *gin.Context) {
 	var lesson models.Lesson
 	if err := h.DB.Preload("Quizzes.Questions", func(db *gorm.DB) *gorm.DB {
 		return db.Order("sort_order ASC")
-	}).First(&lesson, lessonID).Error; err != nil {
+	}).Preload("Assignment").First(&lesson, lessonID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
 		return
 	}
@@ -314,6 +335,7 @@ func (h *CourseHandler) EnrollContact(c *gin.Context) {
 	var body struct {
 		ContactID uint   `json:"contact_id" binding:"required"`
 		Source    string `json:"source"`
+		CohortID  *uint  `json:"cohort_id"` // admins may place students in a full or closed cohort
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -338,7 +360,30 @@ func (h *CourseHandler) EnrollContact(c *gin.Context) {
 	if body.Source == "" {
 		enrollment.Source = "manual"
 	}
-	if err := h.DB.Create(&enrollment).Error; err != nil {
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var cohort *models.CourseCohort
+		if body.CohortID != nil {
+			cohort = &models.CourseCohort{}
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("id = ? AND course_id = ?", *body.CohortID, courseID).First(cohort).Error; err != nil {
+				return services.ErrCohortNotFound
+			}
+		} else {
+			var course models.Course
+			if err := tx.First(&course, courseID).Error; err == nil {
+				cohort, _ = services.SelectCohort(tx, &course, nil, time.Now())
+			}
+		}
+		if err := tx.Create(&enrollment).Error; err != nil {
+			return err
+		}
+		return services.JoinCohort(tx, &enrollment, cohort)
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrCohortNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enroll contact"})
 		return
 	}
@@ -371,114 +416,178 @@ func (h *CourseHandler) UnenrollContact(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Enrollment removed"})
 }
 
-// ===== Progress =====
+// ===== Cohorts =====
 
-// MarkLessonComplete marks a lesson as completed for a student.
-func (h *CourseHandler) MarkLessonComplete(c *gin.Context) {
+// ListCohorts returns a course's cohorts with seat usage and progress.
+func (h *CourseHandler) ListCohorts(c *gin.Context) {
+	courseID, _ := strconv.Atoi(c.Param("id"))
+	c.JSON(http.StatusOK, gin.H{"data": services.NewCourseService(h.DB).CohortStats(uint(courseID))})
+}
+
+// CreateCohort adds a cohort to a course. With create_space set, a private
+// community space is created for the cohort's students.
+func (h *CourseHandler) CreateCohort(c *gin.Context) {
+	courseID, _ := strconv.Atoi(c.Param("id"))
+	var course models.Course
+	if err := h.DB.First(&course, courseID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Course not found"})
+		return
+	}
 	var body struct {
-		EnrollmentID uint `json:"enrollment_id" binding:"required"`
-		LessonID     uint `json:"lesson_id" binding:"required"`
+		models.CourseCohort
+		CreateSpace bool `json:"create_space"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	now := time.Now()
-	var progress models.LessonProgress
-	result := h.DB.Where("enrollment_id = ? AND lesson_id = ?", body.EnrollmentID, body.LessonID).First(&progress)
-
-	if result.Error == gorm.ErrRecordNotFound {
-		progress = models.LessonProgress{
-			TenantID:     1,
-			EnrollmentID: body.EnrollmentID,
-			LessonID:     body.LessonID,
-			Status:       models.ProgressCompleted,
-			StartedAt:    &now,
-			CompletedAt:  &now,
+	cohort := body.CourseCohort
+	if cohort.Name == "" || cohort.StartsAt.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and starts_at are required"})
+		return
+	}
+	cohort.TenantID = 1
+	cohort.CourseID = course.ID
+
+	if body.CreateSpace && cohort.SpaceID == nil {
+		name := course.Title + " — " + cohort.Name
+		space := models.Space{
+			TenantID:    1,
+			Name:        name,
+			Slug:        fmt.Sprintf("%s-%d", generateSlug(name), time.Now().Unix()),
+			Description: "Community space for the " + cohort.Name + " cohort of " + course.Title,
+			Type:        models.SpaceTypePrivate,
 		}
-		h.DB.Create(&progress)
-	} else {
-		progress.Status = models.ProgressCompleted
-		progress.CompletedAt = &now
-		h.DB.Save(&progress)
+		if err := h.DB.Create(&space).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create cohort space"})
+			return
+		}
+		cohort.SpaceID = &space.ID
 	}
+	if err := h.DB.Create(&cohort).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create cohort"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": cohort})
+}
 
-	events.Emit(events.CourseLessonCompleted, progress)
-
-	// Recalculate course progress
-	h.recalculateProgress(body.EnrollmentID)
+// UpdateCohort updates a cohort's dates, seat limit or space.
+func (h *CourseHandler) UpdateCohort(c *gin.Context) {
+	courseID, _ := strconv.Atoi(c.Param("id"))
+	var cohort models.CourseCohort
+	if err := h.DB.Where("id = ? AND course_id = ?", c.Param("cohortId"), courseID).First(&cohort).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cohort not found"})
+		return
+	}
+	id := cohort.ID
+	if err := c.ShouldBindJSON(&cohort); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cohort.ID = id
+	cohort.CourseID = uint(courseID)
+	if err := h.DB.Save(&cohort).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update cohort"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": cohort})
+}
 
-	c.JSON(http.StatusOK, gin.H{"data": progress})
+// DeleteCohort removes a cohort that has no students.
+func (h *CourseHandler) DeleteCohort(c *gin.Context) {
+	courseID, _ := strconv.Atoi(c.Param("id"))
+	var cohort models.CourseCohort
+	if err := h.DB.Where("id = ? AND course_id = ?", c.Param("cohortId"), courseID).First(&cohort).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cohort not found"})
+		return
+	}
+	var enrolled int64
+	h.DB.Model(&models.CourseEnrollment{}).Where("cohort_id = ?", cohort.ID).Count(&enrolled)
+	if enrolled > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Move this cohort's students to another cohort first"})
+		return
+	}
+	h.DB.Delete(&cohort)
+	c.JSON(http.StatusOK, gin.H{"message": "Cohort deleted"})
 }
 
-// GetProgress returns progress data for an enrollment.
-func (h *CourseHandler) GetProgress(c *gin.Context) {
-	enrollID, _ := strconv.Atoi(c.Param("enrollId"))
-	var enrollment models.CourseEnrollment
-	if err := h.DB.Preload("LessonProgresses.Lesson").First(&enrollment, enrollID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Enrollment not found"})
+// CohortAnalytics returns a cohort's completion stats, lesson engagement
+// and funnel.
+func (h *CourseHandler) CohortAnalytics(c *gin.Context) {
+	courseID, _ := strconv.Atoi(c.Param("id"))
+	cohortID, _ := strconv.Atoi(c.Param("cohortId"))
+	stats, err := services.NewCourseService(h.DB).CohortAnalytics(uint(courseID), uint(cohortID))
+	if err != nil {
+		if errors.Is(err, services.ErrCohortNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load cohort analytics"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"data": enrollment})
+	c.JSON(http.StatusOK, gin.H{"data": stats})
 }
 
-func (h *CourseHandler) recalculateProgress(enrollmentID uint) {
+// MoveEnrollmentCohort moves a student to another cohort of the course.
+// Admins may move students into full or closed cohorts.
+func (h *CourseHandler) MoveEnrollmentCohort(c *gin.Context) {
+	courseID, _ := strconv.Atoi(c.Param("id"))
+	var body struct {
+		CohortID uint `json:"cohort_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	var enrollment models.CourseEnrollment
-	if err := h.DB.Preload("Course.Modules.Lessons").First(&enrollment, enrollmentID).Error; err != nil {
+	if err := h.DB.Where("id = ? AND course_id = ?", c.Param("enrollId"), courseID).First(&enrollment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Enrollment not found"})
 		return
 	}
-
-	totalLessons := 0
-	for _, mod := range enrollment.Course.Modules {
-		totalLessons += len(mod.Lessons)
+	var cohort models.CourseCohort
+	if err := h.DB.Where("id = ? AND course_id = ?", body.CohortID, courseID).First(&cohort).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrCohortNotFound.Error()})
+		return
 	}
-	if totalLessons == 0 {
+	if err := services.JoinCohort(h.DB, &enrollment, &cohort); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move enrollment"})
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"data": enrollment})
+}
 
-	var completedCount int64
-	h.DB.Model(&models.LessonProgress{}).Where("enrollment_id = ? AND status = ?", enrollmentID, models.ProgressCompleted).Count(&completedCount)
-
-	enrollment.ProgressPercentage = float64(completedCount) / float64(totalLessons) * 100
-
-	if int(completedCount) >= totalLessons {
-		enrollment.Status = models.EnrollStatusCompleted
-		now := time.Now()
-		enrollment.CompletedAt = &now
-		events.Emit(events.CourseCompleted, enrollment)
+// ===== Progress =====
 
-		// Auto-generate certificate
-		h.generateCertificate(enrollment)
+// MarkLessonComplete marks a lesson as completed for a student.
+func (h *CourseHandler) MarkLessonComplete(c *gin.Context) {
+	var body struct {
+		EnrollmentID uint `json:"enrollment_id" binding:"required"`
+		LessonID     uint `json:"lesson_id" binding:"required"`
 	}
-
-	h.DB.Save(&enrollment)
-}
-
-func (h *CourseHandler) generateCertificate(enrollment models.CourseEnrollment) {
-	// Check if certificate already exists
-	var existing models.Certificate
-	if err := h.DB.Where("enrollment_id = ?", enrollment.ID).First(&existing).Error; err == nil {
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	certNum := generateCertNumber()
-	cert := models.Certificate{
-		TenantID:          enrollment.TenantID,
-		CourseID:          enrollment.CourseID,
-		EnrollmentID:      enrollment.ID,
-		ContactID:         enrollment.ContactID,
-		CertificateNumber: certNum,
-		IssuedAt:          time.Now(),
-		Template:          "default",
+	// Admins can complete a lesson without its quiz being passed
+	progress, err := services.NewCourseService(h.DB).CompleteLesson(body.EnrollmentID, body.LessonID, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update progress"})
+		return
 	}
-	h.DB.Create(&cert)
+
+	c.JSON(http.StatusOK, gin.H{"data": progress})
 }
 
-func generateCertNumber() string {
-	b := make([]byte, 8)
-	rand.Read(b)
-	return fmt.Sprintf("CERT-%X", b)
+// GetProgress returns progress data for an enrollment.
+func (h *CourseHandler) GetProgress(c *gin.Context) {
+	enrollID, _ := strconv.Atoi(c.Param("enrollId"))
+	var enrollment models.CourseEnrollment
+	if err := h.DB.Preload("LessonProgresses.Lesson").First(&enrollment, enrollID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Enrollment not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": enrollment})
 }
 
 // ===== Quizzes =====
@@ -558,11 +667,8 @@ func (h *CourseHandler) DeleteQuestion(c *gin.Context) {
 func (h *CourseHandler) SubmitQuizAttempt(c *gin.Context) {
 	quizID, _ := strconv.Atoi(c.Param("quizId"))
 	var body struct {
-		EnrollmentID uint `json:"enrollment_id" binding:"required"`
-		Answers      []struct {
-			QuestionID uint   `json:"question_id"`
-			Answer     string `json:"answer"`
-		} `json:"answers"`
+		EnrollmentID uint                  `json:"enrollment_id" binding:"required"`
+		Answers      []services.QuizAnswer `json:"answers"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -576,55 +682,27 @@ func (h *CourseHandler) SubmitQuizAttempt(c *gin.Context) {
 		return
 	}
 
-	// Check max attempts
-	if quiz.MaxAttempts > 0 {
-		var attemptCount int64
-		h.DB.Model(&models.QuizAttempt{}).Where("quiz_id = ? AND enrollment_id = ?", quizID, body.EnrollmentID).Count(&attemptCount)
-		if int(attemptCount) >= quiz.MaxAttempts {
+	result, err := services.NewQuizService(h.DB).Record(&quiz, body.EnrollmentID, body.Answers, time.Now())
+	if err != nil {
+		if errors.Is(err, services.ErrMaxAttempts) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Maximum attempts reached"})
 			return
 		}
-	}
-
-	// Score the quiz
-	totalPoints := 0
-	earnedPoints := 0
-	for _, q := range quiz.Questions {
-		totalPoints += q.Points
-		for _, a := range body.Answers {
-			if a.QuestionID == q.ID && strings.EqualFold(strings.TrimSpace(a.Answer), strings.TrimSpace(q.CorrectAnswer)) {
-				earnedPoints += q.Points
-			}
+		if errors.Is(err, services.ErrExternalQuiz) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record attempt"})
+		return
 	}
 
-	score := float64(0)
-	if totalPoints > 0 {
-		score = float64(earnedPoints) / float64(totalPoints) * 100
-	}
-	passed := score >= float64(quiz.PassingScore)
-
-	answersJSON, _ := json.Marshal(body.Answers)
-	now := time.Now()
-	attempt := models.QuizAttempt{
-		TenantID:     1,
-		QuizID:       uint(quizID),
-		EnrollmentID: body.EnrollmentID,
-		Answers:      answersJSON,
-		Score:        score,
-		Passed:       passed,
-		StartedAt:    now,
-		CompletedAt:  &now,
-	}
-	h.DB.Create(&attempt)
-
 	c.JSON(http.StatusCreated, gin.H{
-		"data": attempt,
+		"data": result.Attempt,
 		"result": gin.H{
-			"score":         score,
-			"passed":        passed,
-			"total_points":  totalPoints,
-			"earned_points": earnedPoints,
+			"score":         result.Score,
+			"passed":        result.Passed,
+			"total_points":  result.TotalPoints,
+			"earned_points": result.EarnedPoints,
 		},
 	})
 }
@@ -648,14 +726,18 @@ func (h *CourseHandler) ListQuizAttempts(c *gin.Context) {
 func (h *CourseHandler) ListCertificates(c *gin.Context) {
 	courseID := c.Query("course_id")
 	contactID := c.Query("contact_id")
+	pathID := c.Query("learning_path_id")
 
-	q := h.DB.Preload("Course").Preload("Contact").Order("created_at DESC")
+	q := h.DB.Preload("Course").Preload("Contact").Preload("LearningPath").Order("created_at DESC")
 	if courseID != "" {
 		q = q.Where("course_id = ?", courseID)
 	}
 	if contactID != "" {
 		q = q.Where("contact_id = ?", contactID)
 	}
+	if pathID != "" {
+		q = q.Where("learning_path_id = ?", pathID)
+	}
 
 	var certs []models.Certificate
 	q.Find(&certs)
@@ -666,7 +748,7 @@ func (h *CourseHandler) ListCertificates(c *gin.Context) {
 func (h *CourseHandler) VerifyCertificate(c *gin.Context) {
 	number := c.Param("number")
 	var cert models.Certificate
-	if err := h.DB.Preload("Course").Preload("Contact").Where("certificate_number = ?", number).First(&cert).Error; err != nil {
+	if err := h.DB.Preload("Course").Preload("Contact").Preload("LearningPath").Where("certificate_number = ?", number).First(&cert).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
 		return
 	}
@@ -674,7 +756,8 @@ func (h *CourseHandler) VerifyCertificate(c *gin.Context) {
 		"data": gin.H{
 			"certificate_number": cert.CertificateNumber,
 			"issued_at":          cert.IssuedAt,
-			"course_title":       cert.Course.Title,
+			"course_title":       cert.Title(),
+			"learning_path":      cert.LearningPath != nil,
 			"student_name":       cert.Contact.FirstName + " " + cert.Contact.LastName,
 		},
 	})
@@ -717,6 +800,9 @@ func (h *CourseHandler) GetPublishedCourse(c *gin.Context) {
 			return db.Order("sort_order ASC")
 		}).
 		Preload("Instructor").
+		Preload("Cohorts", func(db *gorm.DB) *gorm.DB {
+			return db.Where("ends_at IS NULL OR ends_at > ?", time.Now()).Order("starts_at ASC")
+		}).
 		First(&course).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Course not found"})
 		return
@@ -725,6 +811,9 @@ func (h *CourseHandler) GetPublishedCourse(c *gin.Context) {
 	var count int64
 	h.DB.Model(&models.CourseEnrollment{}).Where("course_id = ?", course.ID).Count(&count)
 	course.EnrollmentCount = count
+	for i := range course.Cohorts {
+		course.Cohorts[i].SeatsTaken = services.CohortSeatsTaken(h.DB, course.Cohorts[i].ID)
+	}
 
 	c.JSON(http.StatusOK, gin.H{"data": course})
 }
@@ -743,12 +832,20 @@ func (h *CourseHandler) CourseAnalytics(c *gin.Context) {
 	var avgProgress float64
 	h.DB.Model(&models.CourseEnrollment{}).Where("course_id = ?", id).Select("COALESCE(AVG(progress_percentage), 0)").Row().Scan(&avgProgress)
 
+	engagement, err := services.NewCourseService(h.DB).Engagement(uint(id), nil)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Course not found"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"data": gin.H{
 			"total_enrollments":     totalEnrollments,
 			"completed_enrollments": completedEnrollments,
 			"completion_rate":       safePercent(completedEnrollments, totalEnrollments),
 			"avg_progress":          math.Round(avgProgress*100) / 100,
+			"lessons":               engagement.Lessons,
+			"funnel":                engagement.Funnel,
 		},
 	})
 }
@@ -779,9 +876,12 @@ func (h *CourseHandler) StudentEnroll(c *gin.Context) {
 		return
 	}
 
-	// Only allow free courses for now (paid courses need payment integration)
-	if course.AccessType == models.CourseAccessPaid {
-		c.JSON(http.StatusPaymentRequired, gin.H{"error": "This course requires payment"})
+	// Cohort courses enroll into the chosen, or next open, cohort
+	var body struct {
+		CohortID *uint `json:"cohort_id"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -809,16 +909,48 @@ func (h *CourseHandler) StudentEnroll(c *gin.Context) {
 		return
 	}
 
+	// Members enroll in courses their membership unlocks; otherwise only
+	// free courses allow self-enrollment (paid courses go through checkout)
+	source := "self-enroll"
+	switch {
+	case services.NewAccessService(h.DB).HasAccess(contact.ID, models.EntitlementCourse, course.ID, time.Now()):
+		source = "membership"
+	case course.AccessType == models.CourseAccessPaid:
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "This course requires payment"})
+		return
+	case course.AccessType == models.CourseAccessMembership:
+		c.JSON(http.StatusForbidden, gin.H{"error": services.ErrMembershipRequired.Error()})
+		return
+	}
+
 	enrollment := models.CourseEnrollment{
 		TenantID:   1,
 		ContactID:  contact.ID,
 		CourseID:   uint(courseID),
 		Status:     models.EnrollStatusActive,
 		EnrolledAt: time.Now(),
-		Source:     "self-enroll",
+		Source:     source,
 	}
-	if err := h.DB.Create(&enrollment).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enroll"})
+	// The cohort stays locked until the enrollment taking its seat commits
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		cohort, err := services.SelectCohort(tx, &course, body.CohortID, time.Now())
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(&enrollment).Error; err != nil {
+			return err
+		}
+		return services.JoinCohort(tx, &enrollment, cohort)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrCohortNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrCohortClosed), errors.Is(err, services.ErrCohortFull), errors.Is(err, services.ErrNoOpenCohort):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enroll"})
+		}
 		return
 	}
 	events.Emit(events.CourseEnrolled, enrollment)
@@ -874,6 +1006,8 @@ func (h *CourseHandler) StudentGetCourse(c *gin.Context) {
 		Preload("Modules.Lessons", func(db *gorm.DB) *gorm.DB {
 			return db.Order("sort_order ASC")
 		}).
+		Preload("Modules.Lessons.Quizzes"). // questions and answers are served by StudentGetQuiz
+		Preload("Modules.Lessons.Assignment").
 		Preload("Instructor").
 		First(&course).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Course not found"})
@@ -890,15 +1024,39 @@ func (h *CourseHandler) StudentGetCourse(c *gin.Context) {
 	var enrollment models.CourseEnrollment
 	if err := h.DB.Where("contact_id = ? AND course_id = ?", contact.ID, courseID).
 		Preload("LessonProgresses").
+		Preload("Cohort").
 		First(&enrollment).Error; err != nil {
 		c.JSON(http.StatusOK, gin.H{"data": gin.H{"course": course, "enrollment": nil, "lesson_progresses": []interface{}{}}})
 		return
 	}
 
+	// Lock lessons that haven't dripped yet, or the whole course until the
+	// learning path's prerequisites are completed
+	courses := services.NewCourseService(h.DB)
+	courses.ApplyDrip(&enrollment, &course, time.Now())
+	prerequisites := courses.MissingPrerequisites(contact.ID, course.ID)
+
+	// Membership enrollments lock again once the membership lapses
+	membershipRequired := services.NewAccessService(h.DB).CheckEnrollment(&enrollment, time.Now()) != nil
+	if membershipRequired {
+		services.LockCourse(&course)
+	}
+
+	// Hosted videos are only played through signed URLs
+	for i := range course.Modules {
+		for j := range course.Modules[i].Lessons {
+			if course.Modules[i].Lessons[j].MediaAssetID != nil {
+				course.Modules[i].Lessons[j].VideoURL = ""
+			}
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"data": gin.H{
-		"course":            course,
-		"enrollment":        enrollment,
-		"lesson_progresses": enrollment.LessonProgresses,
+		"course":                course,
+		"enrollment":            enrollment,
+		"lesson_progresses":     enrollment.LessonProgresses,
+		"missing_prerequisites": prerequisites,
+		"membership_required":   membershipRequired,
 	}})
 }
 
@@ -906,47 +1064,194 @@ func (h *CourseHandler) StudentGetCourse(c *gin.Context) {
 func (h *CourseHandler) StudentMarkLessonComplete(c *gin.Context) {
 	courseID, _ := strconv.Atoi(c.Param("id"))
 	lessonID, _ := strconv.Atoi(c.Param("lessonId"))
+	enrollment, ok := h.studentEnrollment(c, uint(courseID))
+	if !ok {
+		return
+	}
+
+	progress, err := services.NewCourseService(h.DB).CompleteLesson(enrollment.ID, uint(lessonID), true)
+	if err != nil {
+		if errors.Is(err, services.ErrLessonLocked) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrLessonNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrQuizNotPassed) || errors.Is(err, services.ErrAssignmentNotPassed) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update progress"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": progress})
+}
+
+// studentEnrollment loads the authenticated student's enrollment in the
+// course, writing a 403 when there is no usable one.
+func (h *CourseHandler) studentEnrollment(c *gin.Context, courseID uint) (*models.CourseEnrollment, bool) {
 	user, _ := c.Get("user")
 	u := user.(models.User)
 
-	// Find contact
-	var contact models.Contact
-	if err := h.DB.Where("email = ? AND tenant_id = ?", u.Email, 1).First(&contact).Error; err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not enrolled"})
+	enrollment, err := services.NewCourseService(h.DB).StudentEnrollment(u.Email, courseID)
+	if err != nil {
+		if errors.Is(err, services.ErrMembershipRequired) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not enrolled in this course"})
+		return nil, false
+	}
+	return enrollment, true
+}
+
+// StudentLessonHeartbeat records a periodic progress report from the lesson
+// player: time spent, video position and how much of the video was watched.
+func (h *CourseHandler) StudentLessonHeartbeat(c *gin.Context) {
+	courseID, _ := strconv.Atoi(c.Param("id"))
+	lessonID, _ := strconv.Atoi(c.Param("lessonId"))
+	enrollment, ok := h.studentEnrollment(c, uint(courseID))
+	if !ok {
+		return
+	}
+	var body services.Heartbeat
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Find enrollment
-	var enrollment models.CourseEnrollment
-	if err := h.DB.Where("contact_id = ? AND course_id = ?", contact.ID, courseID).First(&enrollment).Error; err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not enrolled in this course"})
+	progress, err := services.NewCourseService(h.DB).RecordHeartbeat(enrollment, uint(lessonID), body, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrLessonLocked):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrLessonNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update progress"})
+		}
 		return
 	}
 
-	now := time.Now()
-	var progress models.LessonProgress
-	result := h.DB.Where("enrollment_id = ? AND lesson_id = ?", enrollment.ID, lessonID).First(&progress)
-
-	if result.Error == gorm.ErrRecordNotFound {
-		progress = models.LessonProgress{
-			TenantID:     1,
-			EnrollmentID: enrollment.ID,
-			LessonID:     uint(lessonID),
-			Status:       models.ProgressCompleted,
-			StartedAt:    &now,
-			CompletedAt:  &now,
+	c.JSON(http.StatusOK, gin.H{"data": progress})
+}
+
+// studentQuiz loads a quiz of the enrolled course with its questions in
+// order, provided its lesson has unlocked.
+func (h *CourseHandler) studentQuiz(c *gin.Context, enrollment *models.CourseEnrollment) (*models.Quiz, bool) {
+	var quiz models.Quiz
+	err := h.DB.Joins("JOIN lessons ON lessons.id = quizzes.lesson_id").
+		Joins("JOIN course_modules ON course_modules.id = lessons.module_id").
+		Where("quizzes.id = ? AND course_modules.course_id = ?", c.Param("quizId"), enrollment.CourseID).
+		Preload("Questions", func(db *gorm.DB) *gorm.DB {
+			return db.Order("sort_order ASC, id ASC")
+		}).
+		First(&quiz).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Quiz not found"})
+		return nil, false
+	}
+	if err := services.NewCourseService(h.DB).CheckUnlocked(enrollment, quiz.LessonID, time.Now()); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": services.ErrLessonLocked.Error()})
+		return nil, false
+	}
+	return &quiz, true
+}
+
+// StudentGetQuiz serves a quiz without its answers, with the student's
+// attempt count, best score and any attempt in progress.
+func (h *CourseHandler) StudentGetQuiz(c *gin.Context) {
+	courseID, _ := strconv.Atoi(c.Param("id"))
+	enrollment, ok := h.studentEnrollment(c, uint(courseID))
+	if !ok {
+		return
+	}
+	quiz, ok := h.studentQuiz(c, enrollment)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": services.NewQuizService(h.DB).ForStudent(quiz, enrollment.ID)})
+}
+
+// StudentStartQuiz opens an attempt; timed quizzes must be started before
+// they are submitted.
+func (h *CourseHandler) StudentStartQuiz(c *gin.Context) {
+	courseID, _ := strconv.Atoi(c.Param("id"))
+	enrollment, ok := h.studentEnrollment(c, uint(courseID))
+	if !ok {
+		return
+	}
+	quiz, ok := h.studentQuiz(c, enrollment)
+	if !ok {
+		return
+	}
+
+	svc := services.NewQuizService(h.DB)
+	if _, err := svc.Start(quiz, enrollment.ID, time.Now()); err != nil {
+		if errors.Is(err, services.ErrMaxAttempts) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Maximum attempts reached"})
+			return
+		}
+		if errors.Is(err, services.ErrExternalQuiz) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
-		h.DB.Create(&progress)
-	} else {
-		progress.Status = models.ProgressCompleted
-		progress.CompletedAt = &now
-		h.DB.Save(&progress)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start quiz"})
+		return
 	}
+	c.JSON(http.StatusOK, gin.H{"data": svc.ForStudent(quiz, enrollment.ID)})
+}
 
-	events.Emit(events.CourseLessonCompleted, progress)
-	h.recalculateProgress(enrollment.ID)
+// StudentSubmitQuiz grades the student's answers server-side. Passing the
+// lesson's required quizzes completes the lesson.
+func (h *CourseHandler) StudentSubmitQuiz(c *gin.Context) {
+	courseID, _ := strconv.Atoi(c.Param("id"))
+	enrollment, ok := h.studentEnrollment(c, uint(courseID))
+	if !ok {
+		return
+	}
+	quiz, ok := h.studentQuiz(c, enrollment)
+	if !ok {
+		return
+	}
+	var body struct {
+		Answers []services.QuizAnswer `json:"answers"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"data": progress})
+	result, err := services.NewQuizService(h.DB).Submit(quiz, enrollment.ID, body.Answers, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrMaxAttempts):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Maximum attempts reached"})
+		case errors.Is(err, services.ErrAttemptExpired), errors.Is(err, services.ErrNoOpenAttempt), errors.Is(err, services.ErrExternalQuiz):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit quiz"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// StudentQuizAttempts lists the student's own attempts at a quiz.
+func (h *CourseHandler) StudentQuizAttempts(c *gin.Context) {
+	courseID, _ := strconv.Atoi(c.Param("id"))
+	enrollment, ok := h.studentEnrollment(c, uint(courseID))
+	if !ok {
+		return
+	}
+	quiz, ok := h.studentQuiz(c, enrollment)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": services.NewQuizService(h.DB).StudentAttempts(quiz, enrollment.ID)})
 }
 
 // CourseDashboard returns aggregate stats for all courses.
@@ -966,7 +1271,7 @@ func (h *CourseHandler) CourseDashboard(c *gin.Context) {
 		Where("status = 'paid' AND id IN (?)",
 			h.DB.Model(&models.OrderItem{}).Select("order_id").Where("course_id IS NOT NULL"),
 		).
-		Select("COALESCE(SUM(total), 0)").Scan(&courseRevenue)
+		Select(services.BaseRevenueSQL).Scan(&courseRevenue)
 
 	// Monthly course revenue
 	startOfMonth := time.Now().UTC().Truncate(24 * time.Hour).AddDate(0, 0, -time.Now().Day()+1)
@@ -976,7 +1281,7 @@ func (h *CourseHandler) CourseDashboard(c *gin.Context) {
 			startOfMonth,
 			h.DB.Model(&models.OrderItem{}).Select("order_id").Where("course_id IS NOT NULL"),
 		).
-		Select("COALESCE(SUM(total), 0)").Scan(&monthlyRevenue)
+		Select(services.BaseRevenueSQL).Scan(&monthlyRevenue)
 
 	c.JSON(http.StatusOK, gin.H{"data": gin.H{
 		"total_courses":     totalCourses,
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/services"
+)
+
+// CurrencyHandler manages the base currency, exchange rates and the
+// storefront display currency.
+type CurrencyHandler struct {
+	db *gorm.DB
+}
+
+// NewCurrencyHandler creates a new CurrencyHandler.
+func NewCurrencyHandler(db *gorm.DB) *CurrencyHandler {
+	return &CurrencyHandler{db: db}
+}
+
+// countryHeaders are set by common CDNs/edge proxies with the visitor's country.
+var countryHeaders = []string{"CF-IPCountry", "X-Vercel-IP-Country", "CloudFront-Viewer-Country", "X-Country-Code"}
+
+// displayCurrency picks the currency to show prices in: an explicit
+// ?currency= or X-Currency header, the "currency" cookie, the visitor's
+// country, then the base currency. Unsupported choices fall through.
+func displayCurrency(c *gin.Context, fx *services.CurrencyService) string {
+	candidates := []string{c.Query("currency"), c.GetHeader("X-Currency")}
+	if cookie, err := c.Cookie("currency"); err == nil {
+		candidates = append(candidates, cookie)
+	}
+	for _, h := range countryHeaders {
+		if country := c.GetHeader(h); country != "" {
+			candidates = append(candidates, services.CurrencyForCountry(country))
+			break
+		}
+	}
+
+	supported := fx.SupportedCurrencies(1)
+	for _, cur := range candidates {
+		cur = strings.ToUpper(strings.TrimSpace(cur))
+		for _, s := range supported {
+			if cur != "" && cur == s {
+				return cur
+			}
+		}
+	}
+	return supported[0]
+}
+
+// setDisplayPrices fills DisplayAmount/DisplayCurrency on prices for the storefront.
+func setDisplayPrices(fx *services.CurrencyService, prices []models.Price, currency string) {
+	for i := range prices {
+		prices[i].DisplayAmount, prices[i].DisplayCurrency = fx.PriceIn(1, prices[i], currency)
+	}
+}
+
+// PublicCurrencies returns the base currency, the currencies shoppers can
+// choose from and the one detected for this visitor.
+func (h *CurrencyHandler) PublicCurrencies(c *gin.Context) {
+	fx := services.NewCurrencyService(h.db)
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"base_currency": fx.BaseCurrency(1),
+		"currencies":    fx.SupportedCurrencies(1),
+		"detected":      displayCurrency(c, fx),
+	}})
+}
+
+// GetSettings returns the base currency, supported currencies and rates.
+func (h *CurrencyHandler) GetSettings(c *gin.Context) {
+	fx := services.NewCurrencyService(h.db)
+	var rates []models.ExchangeRate
+	h.db.Where("tenant_id = ?", 1).Order("currency ASC").Find(&rates)
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"base_currency": fx.BaseCurrency(1),
+		"currencies":    fx.SupportedCurrencies(1),
+		"rates":         rates,
+	}})
+}
+
+// UpdateSettings changes the base and supported currencies. The base
+// currency is locked once orders have been paid; orders still missing a rate
+// are captured with the current rates.
+func (h *CurrencyHandler) UpdateSettings(c *gin.Context) {
+	var input struct {
+		BaseCurrency        *string  `json:"base_currency"`
+		SupportedCurrencies []string `json:"supported_currencies"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	save := func(key, value string) error {
+		setting := models.Setting{TenantID: 1, Group: "payments", Key: key, Value: value, Type: "string"}
+		return h.db.Where("tenant_id = ? AND key = ?", 1, key).
+			Assign(map[string]interface{}{"value": value}).
+			FirstOrCreate(&setting).Error
+	}
+
+	fx := services.NewCurrencyService(h.db)
+	if input.BaseCurrency != nil {
+		if err := fx.SetBaseCurrency(1, *input.BaseCurrency); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if input.SupportedCurrencies != nil {
+		var codes []string
+		for _, cur := range input.SupportedCurrencies {
+			if cur = strings.ToUpper(strings.TrimSpace(cur)); len(cur) == 3 {
+				codes = append(codes, cur)
+			}
+		}
+		if err := save(services.SettingSupportedCurrencies, strings.Join(codes, ",")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save supported currencies"})
+			return
+		}
+	}
+
+	updated := fx.BackfillOrderRates(1)
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"base_currency":  fx.BaseCurrency(1),
+		"currencies":     fx.SupportedCurrencies(1),
+		"orders_updated": updated,
+	}})
+}
+
+// SetRate creates or updates an exchange rate.
+func (h *CurrencyHandler) SetRate(c *gin.Context) {
+	var input struct {
+		Currency string  `json:"currency" binding:"required"`
+		Rate     float64 `json:"rate" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fx := services.NewCurrencyService(h.db)
+	rate, err := fx.SetRate(1, input.Currency, input.Rate, "manual")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fx.BackfillOrderRates(1)
+
+	c.JSON(http.StatusOK, gin.H{"data": rate})
+}
+
+// DeleteRate removes an exchange rate. Orders keep the rate they captured.
+func (h *CurrencyHandler) DeleteRate(c *gin.Context) {
+	currency := strings.ToUpper(c.Param("currency"))
+	if err := h.db.Where("tenant_id = ? AND currency = ?", 1, currency).Delete(&models.ExchangeRate{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete exchange rate"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Exchange rate deleted"})
+}
+
+// ImportRates imports exchange rates from an uploaded CSV file with
+// "currency,rate" rows, where rate is the value of one unit in the base currency.
+func (h *CurrencyHandler) ImportRates(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+	defer f.Close()
+
+	fx := services.NewCurrencyService(h.db)
+	imported, err := fx.ImportRates(1, f)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "imported": imported})
+		return
+	}
+	updated := fx.BackfillOrderRates(1)
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"imported":       imported,
+		"orders_updated": updated,
+	}})
+}
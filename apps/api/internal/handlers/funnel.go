@@ -215,13 +215,19 @@ func (h *FunnelHandler) FunnelAnalytics(c *gin.Context) {
 		Visits      int64   `json:"visits"`
 		Conversions int64   `json:"conversions"`
 		Rate        float64 `json:"conversion_rate"`
+		Bumps       int64   `json:"bumps"`     // order bumps taken with this step's purchases
+		BumpRate    float64 `json:"bump_rate"` // percent of conversions that took a bump
+		Value       int64   `json:"value"`     // in cents, including bumps
 	}
 
 	var stats []StepStats
 	for _, step := range funnel.Steps {
-		var visits, conversions int64
+		var visits, conversions, bumps, value int64
 		h.DB.Model(&models.FunnelVisit{}).Where("step_id = ?", step.ID).Count(&visits)
-		h.DB.Model(&models.FunnelConversion{}).Where("step_id = ?", step.ID).Count(&conversions)
+		// Order bumps are tracked as their own conversions on the checkout step
+		h.DB.Model(&models.FunnelConversion{}).Where("step_id = ? AND type <> 'bump'", step.ID).Count(&conversions)
+		h.DB.Model(&models.FunnelConversion{}).Where("step_id = ? AND type = 'bump'", step.ID).Count(&bumps)
+		h.DB.Model(&models.FunnelConversion{}).Where("step_id = ?", step.ID).Select("COALESCE(SUM(value), 0)").Scan(&value)
 		rate := float64(0)
 		if visits > 0 {
 			rate = float64(conversions) / float64(visits) * 100
@@ -229,12 +235,13 @@ func (h *FunnelHandler) FunnelAnalytics(c *gin.Context) {
 		stats = append(stats, StepStats{
 			StepID: step.ID, StepName: step.Name, StepType: step.Type,
 			Visits: visits, Conversions: conversions, Rate: math.Round(rate*100) / 100,
+			Bumps: bumps, BumpRate: safeRate(bumps, conversions), Value: value,
 		})
 	}
 
 	var totalVisits, totalConversions int64
 	h.DB.Model(&models.FunnelVisit{}).Where("funnel_id = ?", funnelID).Count(&totalVisits)
-	h.DB.Model(&models.FunnelConversion{}).Where("funnel_id = ?", funnelID).Count(&totalConversions)
+	h.DB.Model(&models.FunnelConversion{}).Where("funnel_id = ? AND type <> 'bump'", funnelID).Count(&totalConversions)
 
 	var totalValue int64
 	h.DB.Model(&models.FunnelConversion{}).Where("funnel_id = ?", funnelID).
@@ -6,11 +6,13 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 
 	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/services"
 )
 
 type GuideHandler struct {
@@ -218,6 +220,9 @@ func (h *GuideHandler) CheckGuideAccess(c *gin.Context) {
 	if guide.EmailListID != nil && *guide.EmailListID > 0 {
 		hasAccess = h.checkSubscription(email, *guide.EmailListID)
 	}
+	if !hasAccess {
+		hasAccess = h.checkMembership(email, guide.ID)
+	}
 
 	resp := gin.H{
 		"has_access": hasAccess,
@@ -253,7 +258,8 @@ func (h *GuideHandler) DownloadGuide(c *gin.Context) {
 		return
 	}
 
-	if guide.EmailListID == nil || !h.checkSubscription(email, *guide.EmailListID) {
+	subscribed := guide.EmailListID != nil && h.checkSubscription(email, *guide.EmailListID)
+	if !subscribed && !h.checkMembership(email, guide.ID) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Subscription required"})
 		return
 	}
@@ -305,6 +311,16 @@ func (h *GuideHandler) checkSubscription(email string, listID uint) bool {
 	return count > 0
 }
 
+// checkMembership reports whether the contact with this email holds a
+// membership entitled to the guide.
+func (h *GuideHandler) checkMembership(email string, guideID uint) bool {
+	var contact models.Contact
+	if err := h.db.Where("email = ? AND tenant_id = ?", email, 1).First(&contact).Error; err != nil {
+		return false
+	}
+	return services.NewAccessService(h.db).HasAccess(contact.ID, models.EntitlementGuide, guideID, time.Now())
+}
+
 func generateGuideSlug(name string) string {
 	slug := strings.ToLower(name)
 	slug = strings.Map(func(r rune) rune {
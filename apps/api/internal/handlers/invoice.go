@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/services"
+	"gritcms/apps/api/internal/storage"
+)
+
+// InvoiceHandler serves order invoices to admins and customers.
+type InvoiceHandler struct {
+	db      *gorm.DB
+	storage *storage.Storage
+}
+
+// NewInvoiceHandler creates a new InvoiceHandler.
+func NewInvoiceHandler(db *gorm.DB, storage *storage.Storage) *InvoiceHandler {
+	return &InvoiceHandler{db: db, storage: storage}
+}
+
+// ListInvoices lists issued invoices with pagination.
+func (h *InvoiceHandler) ListInvoices(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * pageSize
+
+	q := h.db.Model(&models.Invoice{}).Where("tenant_id = ?", 1)
+	if contactID := c.Query("contact_id"); contactID != "" {
+		q = q.Where("contact_id = ?", contactID)
+	}
+
+	var total int64
+	q.Count(&total)
+
+	var invoices []models.Invoice
+	if err := q.Preload("Contact").Order("sequence DESC").Offset(offset).Limit(pageSize).Find(&invoices).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list invoices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": invoices,
+		"meta": gin.H{"total": total, "page": page, "page_size": pageSize, "pages": int(math.Ceil(float64(total) / float64(pageSize)))},
+	})
+}
+
+// DownloadOrderInvoice returns the invoice PDF for any paid order, issuing it if needed.
+func (h *InvoiceHandler) DownloadOrderInvoice(c *gin.Context) {
+	orderID, err := strconv.ParseUint(c.Param("orderId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+	h.sendInvoice(c, uint(orderID))
+}
+
+// StudentDownloadInvoice returns the invoice PDF for one of the current user's purchases.
+func (h *InvoiceHandler) StudentDownloadInvoice(c *gin.Context) {
+	orderID := c.Param("orderId")
+	user, _ := c.Get("user")
+	u := user.(models.User)
+
+	var contact models.Contact
+	if err := h.db.Where("email = ? AND tenant_id = ?", u.Email, 1).First(&contact).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Contact not found"})
+		return
+	}
+
+	var order models.Order
+	if err := h.db.Where("id = ? AND contact_id = ? AND paid_at IS NOT NULL", orderID, contact.ID).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Purchase not found"})
+		return
+	}
+
+	h.sendInvoice(c, order.ID)
+}
+
+func (h *InvoiceHandler) sendInvoice(c *gin.Context, orderID uint) {
+	svc := services.NewInvoiceService(h.db, h.storage)
+	invoice, err := svc.IssueInvoice(c.Request.Context(), orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invoice not available: " + err.Error()})
+		return
+	}
+
+	pdf, err := svc.PDF(c.Request.Context(), invoice)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate invoice"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.pdf", invoice.InvoiceNumber))
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/events"
+	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/services"
+)
+
+// LearningPathHandler manages learning paths: ordered course sequences with
+// prerequisites, path progress and path certificates.
+type LearningPathHandler struct {
+	db *gorm.DB
+}
+
+// NewLearningPathHandler creates a new LearningPathHandler.
+func NewLearningPathHandler(db *gorm.DB) *LearningPathHandler {
+	return &LearningPathHandler{db: db}
+}
+
+// ===== Learning paths (admin) =====
+
+// ListPaths returns learning paths, newest first.
+func (h *LearningPathHandler) ListPaths(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	q := h.db.Order("created_at DESC")
+	if status := c.Query("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if search := c.Query("search"); search != "" {
+		q = q.Where("title ILIKE ?", "%"+search+"%")
+	}
+
+	var total int64
+	q.Model(&models.LearningPath{}).Count(&total)
+
+	var paths []models.LearningPath
+	q.Preload("Courses", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position ASC, id ASC")
+	}).Preload("Courses.Course").Offset((page - 1) * pageSize).Limit(pageSize).Find(&paths)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": paths,
+		"meta": gin.H{"total": total, "page": page, "page_size": pageSize, "pages": int(math.Ceil(float64(total) / float64(pageSize)))},
+	})
+}
+
+// GetPath returns a learning path with its courses in order.
+func (h *LearningPathHandler) GetPath(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	path, err := services.NewCourseService(h.db).LoadPath(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": path})
+}
+
+// CreatePath creates a learning path. Courses are set with SetPathCourses.
+func (h *LearningPathHandler) CreatePath(c *gin.Context) {
+	var body models.LearningPath
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	body.TenantID = 1
+	body.Courses = nil
+	if body.Slug == "" {
+		body.Slug = generateSlug(body.Title)
+	}
+	if err := h.db.Create(&body).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create learning path"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": body})
+}
+
+// UpdatePath updates a learning path's details, product and certificate
+// template.
+func (h *LearningPathHandler) UpdatePath(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var path models.LearningPath
+	if err := h.db.First(&path, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Learning path not found"})
+		return
+	}
+	if err := c.ShouldBindJSON(&path); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	path.ID = uint(id)
+	path.Courses = nil
+	h.db.Save(&path)
+	c.JSON(http.StatusOK, gin.H{"data": path})
+}
+
+// DeletePath deletes a learning path. Course enrollments are kept.
+func (h *LearningPathHandler) DeletePath(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	h.db.Delete(&models.LearningPath{}, id)
+	c.JSON(http.StatusOK, gin.H{"message": "Learning path deleted"})
+}
+
+// SetPathCourses replaces a path's courses. Courses are ordered as given;
+// prerequisite_course_id must name an earlier course of the path.
+func (h *LearningPathHandler) SetPathCourses(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var path models.LearningPath
+	if err := h.db.First(&path, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Learning path not found"})
+		return
+	}
+	var body struct {
+		Courses []struct {
+			CourseID             uint  `json:"course_id" binding:"required"`
+			PrerequisiteCourseID *uint `json:"prerequisite_course_id"`
+		} `json:"courses"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	earlier := make(map[uint]bool, len(body.Courses))
+	courses := make([]models.LearningPathCourse, 0, len(body.Courses))
+	for i, in := range body.Courses {
+		if earlier[in.CourseID] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A course can only appear once in a path"})
+			return
+		}
+		if in.PrerequisiteCourseID != nil && !earlier[*in.PrerequisiteCourseID] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A prerequisite must be an earlier course of the path"})
+			return
+		}
+		var count int64
+		h.db.Model(&models.Course{}).Where("id = ?", in.CourseID).Count(&count)
+		if count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Course not found"})
+			return
+		}
+		earlier[in.CourseID] = true
+		courses = append(courses, models.LearningPathCourse{
+			PathID:               path.ID,
+			CourseID:             in.CourseID,
+			Position:             i,
+			PrerequisiteCourseID: in.PrerequisiteCourseID,
+		})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("path_id = ?", path.ID).Delete(&models.LearningPathCourse{}).Error; err != nil {
+			return err
+		}
+		if len(courses) == 0 {
+			return nil
+		}
+		return tx.Create(&courses).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update path courses"})
+		return
+	}
+
+	loaded, _ := services.NewCourseService(h.db).LoadPath(path.ID)
+	c.JSON(http.StatusOK, gin.H{"data": loaded})
+}
+
+// ListPathEnrollments returns a path's students with their progress.
+func (h *LearningPathHandler) ListPathEnrollments(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	q := h.db.Where("path_id = ?", id)
+	if status := c.Query("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+
+	var total int64
+	q.Model(&models.LearningPathEnrollment{}).Count(&total)
+
+	var enrollments []models.LearningPathEnrollment
+	q.Preload("Contact").Order("enrolled_at DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&enrollments)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": enrollments,
+		"meta": gin.H{"total": total, "page": page, "page_size": pageSize, "pages": int(math.Ceil(float64(total) / float64(pageSize)))},
+	})
+}
+
+// EnrollContact enrolls a contact in a path and all of its courses.
+func (h *LearningPathHandler) EnrollContact(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var body struct {
+		ContactID uint   `json:"contact_id" binding:"required"`
+		Source    string `json:"source"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if body.Source == "" {
+		body.Source = "manual"
+	}
+	path, err := services.NewCourseService(h.db).LoadPath(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	h.enroll(c, body.ContactID, path, body.Source)
+}
+
+// enroll enrolls the contact in the path and writes the response.
+func (h *LearningPathHandler) enroll(c *gin.Context, contactID uint, path *models.LearningPath, source string) {
+	enrollment, created, err := services.EnrollInPath(h.db, contactID, path, source, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enroll in learning path"})
+		return
+	}
+	for _, e := range created {
+		events.Emit(events.CourseEnrolled, e)
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": enrollment})
+}
+
+// ===== Public =====
+
+// ListPublishedPaths returns published learning paths for the public site.
+func (h *LearningPathHandler) ListPublishedPaths(c *gin.Context) {
+	var paths []models.LearningPath
+	h.db.Where("status = ?", models.CourseStatusPublished).
+		Preload("Courses", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position ASC, id ASC")
+		}).
+		Preload("Courses.Course").
+		Order("created_at DESC").
+		Find(&paths)
+	c.JSON(http.StatusOK, gin.H{"data": paths})
+}
+
+// GetPublishedPath returns a published learning path by slug.
+func (h *LearningPathHandler) GetPublishedPath(c *gin.Context) {
+	var path models.LearningPath
+	if err := h.db.Where("slug = ? AND status = ?", c.Param("slug"), models.CourseStatusPublished).
+		Preload("Courses", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position ASC, id ASC")
+		}).
+		Preload("Courses.Course").
+		First(&path).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Learning path not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": path})
+}
+
+// ===== Student =====
+
+// StudentPaths lists the learning paths the current user is enrolled in,
+// with their progress.
+func (h *LearningPathHandler) StudentPaths(c *gin.Context) {
+	user, _ := c.Get("user")
+	u := user.(models.User)
+
+	var contact models.Contact
+	if err := h.db.Where("email = ? AND tenant_id = ?", u.Email, 1).First(&contact).Error; err != nil {
+		c.JSON(http.StatusOK, gin.H{"data": []interface{}{}})
+		return
+	}
+
+	var enrollments []models.LearningPathEnrollment
+	h.db.Where("contact_id = ? AND status IN ?", contact.ID, []string{models.EnrollStatusActive, models.EnrollStatusCompleted}).
+		Order("enrolled_at DESC").Find(&enrollments)
+
+	courses := services.NewCourseService(h.db)
+	result := make([]gin.H, 0, len(enrollments))
+	for i := range enrollments {
+		path, err := courses.LoadPath(enrollments[i].PathID)
+		if err != nil {
+			continue
+		}
+		result = append(result, gin.H{
+			"path":     path,
+			"progress": courses.PathProgress(&enrollments[i], path),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// StudentGetPath returns a learning path with the current user's progress
+// through each course and which courses are still locked.
+func (h *LearningPathHandler) StudentGetPath(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	user, _ := c.Get("user")
+	u := user.(models.User)
+
+	courses := services.NewCourseService(h.db)
+	path, err := courses.LoadPath(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var enrollment models.LearningPathEnrollment
+	err = h.db.Joins("JOIN contacts ON contacts.id = learning_path_enrollments.contact_id").
+		Where("contacts.email = ? AND contacts.tenant_id = ? AND learning_path_enrollments.path_id = ?", u.Email, 1, path.ID).
+		First(&enrollment).Error
+	if err != nil {
+		if path.Status != models.CourseStatusPublished {
+			c.JSON(http.StatusNotFound, gin.H{"error": services.ErrPathNotFound.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{"path": path, "progress": nil}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"path": path, "progress": courses.PathProgress(&enrollment, path)}})
+}
+
+// StudentEnrollPath enrolls the current user in a free learning path and its
+// courses. Paths sold as a product, or containing paid courses, are bought
+// through checkout.
+func (h *LearningPathHandler) StudentEnrollPath(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	user, _ := c.Get("user")
+	u := user.(models.User)
+
+	path, err := services.NewCourseService(h.db).LoadPath(uint(id))
+	if err != nil || path.Status != models.CourseStatusPublished {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrPathNotFound.Error()})
+		return
+	}
+	if path.ProductID != nil {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": services.ErrPathRequiresPurchase.Error()})
+		return
+	}
+
+	// Find or create contact for this user
+	var contact models.Contact
+	if err := h.db.Where("email = ? AND tenant_id = ?", u.Email, 1).First(&contact).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enroll in learning path"})
+			return
+		}
+		contact = models.Contact{
+			TenantID:  1,
+			Email:     u.Email,
+			FirstName: u.FirstName,
+			LastName:  u.LastName,
+			Source:    "organic",
+			UserID:    &u.ID,
+		}
+		h.db.Create(&contact)
+	}
+
+	// Paid courses go through checkout and membership courses need a
+	// membership that unlocks them
+	source := "self-enroll"
+	access := services.NewAccessService(h.db)
+	now := time.Now()
+	for _, pc := range path.Courses {
+		switch {
+		case access.HasAccess(contact.ID, models.EntitlementCourse, pc.CourseID, now):
+			if pc.Course.AccessType != models.CourseAccessFree {
+				source = "membership"
+			}
+		case pc.Course.AccessType == models.CourseAccessPaid:
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": services.ErrPathRequiresPurchase.Error()})
+			return
+		case pc.Course.AccessType == models.CourseAccessMembership:
+			c.JSON(http.StatusForbidden, gin.H{"error": services.ErrMembershipRequired.Error()})
+			return
+		}
+	}
+	h.enroll(c, contact.ID, path, source)
+}
@@ -14,6 +14,7 @@ import (
 
 	"gritcms/apps/api/internal/jobs"
 	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/services"
 	"gritcms/apps/api/internal/storage"
 )
 
@@ -112,6 +113,13 @@ func (h *MediaHandler) Upload(c *gin.Context) {
 		_ = h.Jobs.EnqueueProcessImage(asset.ID, key, mimeType)
 	}
 
+	// Transcode videos to HLS for lesson playback
+	if h.Jobs != nil && services.IsVideo(&asset) {
+		services.NewVideoService(h.DB, h.Storage).MarkPending(asset.ID)
+		asset.TranscodeStatus = models.TranscodePending
+		_ = h.Jobs.EnqueueTranscodeVideo(asset.ID)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"data":    asset,
 		"message": "Media uploaded successfully",
@@ -276,6 +284,9 @@ func (h *MediaHandler) Delete(c *gin.Context) {
 			thumbKey := strings.Replace(asset.Path, "media/", "thumbnails/", 1)
 			_ = h.Storage.Delete(c.Request.Context(), thumbKey)
 		}
+		if asset.HLSPath != "" {
+			_ = h.Storage.Private().DeletePrefix(c.Request.Context(), asset.HLSPath+"/")
+		}
 	}
 
 	h.DB.Delete(&asset)
@@ -284,3 +295,50 @@ func (h *MediaHandler) Delete(c *gin.Context) {
 		"message": "Media asset deleted successfully",
 	})
 }
+
+// Transcode (re)queues HLS transcoding of a video asset.
+func (h *MediaHandler) Transcode(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{"code": "VALIDATION_ERROR", "message": "Invalid media ID"},
+		})
+		return
+	}
+
+	tenantID, _ := c.Get("tenant_id")
+	var asset models.MediaAsset
+	if err := h.DB.Where("tenant_id = ?", tenantID).First(&asset, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{"code": "NOT_FOUND", "message": "Media asset not found"},
+		})
+		return
+	}
+	if !services.IsVideo(&asset) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{"code": "INVALID_FILE_TYPE", "message": "Only videos can be transcoded"},
+		})
+		return
+	}
+	if h.Jobs == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": gin.H{"code": "JOBS_UNAVAILABLE", "message": "Background jobs are not configured"},
+		})
+		return
+	}
+
+	services.NewVideoService(h.DB, h.Storage).MarkPending(asset.ID)
+	if err := h.Jobs.EnqueueTranscodeVideo(asset.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{"code": "INTERNAL_ERROR", "message": "Failed to queue transcoding"},
+		})
+		return
+	}
+	asset.TranscodeStatus = models.TranscodePending
+	asset.TranscodeError = ""
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":    asset,
+		"message": "Transcoding queued",
+	})
+}
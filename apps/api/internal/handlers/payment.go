@@ -1,51 +1,57 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/stripe/stripe-go/v82"
-	"github.com/stripe/stripe-go/v82/paymentintent"
-	"github.com/stripe/stripe-go/v82/webhook"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"gritcms/apps/api/internal/config"
-	"gritcms/apps/api/internal/events"
 	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/payments"
 	"gritcms/apps/api/internal/services"
 )
 
-// PaymentHandler handles Stripe checkout and webhook endpoints.
+// PaymentHandler handles checkout and payment webhook endpoints.
 type PaymentHandler struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db        *gorm.DB
+	cfg       *config.Config
+	providers *payments.Registry
+	fulfiller services.OrderFulfiller
 }
 
 // NewPaymentHandler creates a new PaymentHandler.
-func NewPaymentHandler(db *gorm.DB, cfg *config.Config) *PaymentHandler {
-	// Set Stripe secret key globally
-	if cfg.StripeSecretKey != "" {
-		stripe.Key = cfg.StripeSecretKey
-	}
-	return &PaymentHandler{db: db, cfg: cfg}
+func NewPaymentHandler(db *gorm.DB, cfg *config.Config, providers *payments.Registry) *PaymentHandler {
+	return &PaymentHandler{db: db, cfg: cfg, providers: providers, fulfiller: services.NewFulfillmentService(db)}
 }
 
-// Checkout creates a pending order and a Stripe PaymentIntent, returning
-// the client_secret for the frontend to complete payment via Stripe Elements.
+// Checkout creates a pending order and starts a payment with the chosen
+// provider. The response carries what the frontend needs to finish paying:
+// a Stripe client_secret, a hosted redirect_url, or an M-Pesa STK prompt.
 func (h *PaymentHandler) Checkout(c *gin.Context) {
 	var input struct {
-		Type       string `json:"type" binding:"required"` // "product" or "course"
-		ProductID  *uint  `json:"product_id"`
-		CourseID   *uint  `json:"course_id"`
-		PriceID    uint   `json:"price_id"`
-		CouponCode string `json:"coupon_code"`
-		Provider   string `json:"provider"` // "stripe", "paypal", "mpesa"
-		Phone      string `json:"phone"`    // Required for mpesa
+		Type         string   `json:"type" binding:"required"` // "product" or "course"
+		ProductID    *uint    `json:"product_id"`
+		CourseID     *uint    `json:"course_id"`
+		PriceID      uint     `json:"price_id"`
+		CouponCode   string   `json:"coupon_code"`
+		CouponCodes  []string `json:"coupon_codes"`   // several codes when they are stackable
+		Provider     string   `json:"provider"`       // "stripe", "paypal", "mpesa", "flutterwave", "paystack"
+		Phone        string   `json:"phone"`          // Required for mpesa
+		Currency     string   `json:"currency"`       // optional; defaults to the visitor's display currency
+		BumpIDs      []uint   `json:"bump_ids"`       // order bumps ticked on the checkout page
+		FunnelStepID *uint    `json:"funnel_step_id"` // funnel checkout step, for conversions and one-click upsells
+		ReferralCode string   `json:"referral_code"`  // affiliate code; defaults to the referral cookie
+		CohortID     *uint    `json:"cohort_id"`      // course cohort; defaults to the next open one
 	}
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -72,10 +78,26 @@ func (h *PaymentHandler) Checkout(c *gin.Context) {
 		h.db.Save(&contact)
 	}
 
+	// Affiliate attribution — an explicit code or the cookie set by /api/ref/:code
+	referral := input.ReferralCode
+	if referral == "" {
+		referral, _ = c.Cookie(services.AffiliateCookie)
+	}
+	if referral != "" {
+		affiliates := services.NewAffiliateService(h.db)
+		if _, err := affiliates.Attribute(&contact, referral, time.Now()); err == nil {
+			if raw, _ := c.Cookie(services.AffiliateLinkCookie); raw != "" {
+				linkID, _ := strconv.Atoi(raw)
+				affiliates.AttributeLink(&contact, uint(linkID), time.Now())
+			}
+		}
+	}
+
 	// Resolve product and price
 	var product models.Product
 	var price models.Price
 	var currency string
+	var cohortID *uint
 
 	switch input.Type {
 	case "course":
@@ -96,6 +118,15 @@ func (h *PaymentHandler) Checkout(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "This course is free — no payment needed"})
 			return
 		}
+		// Cohort courses sell a seat in the chosen (or next open) cohort
+		cohort, err := services.SelectCohort(h.db, &course, input.CohortID, time.Now())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if cohort != nil {
+			cohortID = &cohort.ID
+		}
 		if course.ProductID != nil {
 			if err := h.db.First(&product, *course.ProductID).Error; err != nil {
 				c.JSON(http.StatusNotFound, gin.H{"error": "Linked product not found"})
@@ -145,6 +176,21 @@ func (h *PaymentHandler) Checkout(c *gin.Context) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 			return
 		}
+		if input.CohortID != nil {
+			var course models.Course
+			err := h.db.Joins("JOIN course_cohorts ON course_cohorts.course_id = courses.id").
+				Where("course_cohorts.id = ? AND courses.id IN ?", *input.CohortID, services.ProductCourseIDs(h.db, product.ID)).
+				First(&course).Error
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrCohortNotFound.Error()})
+				return
+			}
+			if _, err := services.SelectCohort(h.db, &course, input.CohortID, time.Now()); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			cohortID = input.CohortID
+		}
 
 	default:
 		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be 'product' or 'course'"})
@@ -175,201 +221,168 @@ func (h *PaymentHandler) Checkout(c *gin.Context) {
 		}
 	}
 
-	if currency == "" {
-		currency = price.Currency
-	}
-	if currency == "" {
-		currency = "USD"
-	}
-
-	// Calculate amount
-	subtotal := price.Amount
-	var discountAmount float64
-	var couponID *uint
-
-	if input.CouponCode != "" {
-		var coupon models.Coupon
-		if err := h.db.Where("code = ? AND status = 'active'", strings.ToUpper(input.CouponCode)).First(&coupon).Error; err == nil {
-			if coupon.MaxUses == 0 || coupon.UsedCount < coupon.MaxUses {
-				now := time.Now()
-				validTime := true
-				if coupon.ValidFrom != nil && now.Before(*coupon.ValidFrom) {
-					validTime = false
-				}
-				if coupon.ValidUntil != nil && now.After(*coupon.ValidUntil) {
-					validTime = false
-				}
-				if validTime && subtotal >= coupon.MinOrderAmount {
-					if coupon.Type == models.CouponTypePercentage {
-						discountAmount = subtotal * (coupon.Amount / 100)
-					} else {
-						discountAmount = coupon.Amount
-					}
-					if discountAmount > subtotal {
-						discountAmount = subtotal
-					}
-					couponID = &coupon.ID
-				}
-			}
-		}
+	if price.Currency == "" {
+		price.Currency = currency
 	}
 
-	totalAmount := subtotal - discountAmount
-	if totalAmount <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Total amount must be greater than zero"})
-		return
+	// Charge in the shopper's currency when the price has (or can be converted to) one
+	fx := services.NewCurrencyService(h.db)
+	requested := input.Currency
+	if requested == "" {
+		requested = displayCurrency(c, fx)
 	}
+	unitPrice, currency := fx.PriceIn(1, price, requested)
 
-	// price.Amount is already stored in cents (e.g. 3000 = $30.00)
-	amountInCents := int64(math.Round(totalAmount))
-
-	provider := input.Provider
-	if provider == "" {
-		provider = "stripe"
-	}
-
-	// Create pending order
+	// Calculate amount — coupon codes plus any automatic discounts
 	productID := product.ID // capture for pointer use
-	order := models.Order{
-		TenantID:        1,
-		ContactID:       contact.ID,
-		OrderNumber:     generateOrderNumber(),
-		Status:          models.OrderStatusPending,
-		Subtotal:        subtotal,
-		DiscountAmount:  discountAmount,
-		TaxAmount:       0,
-		Total:           totalAmount,
-		Currency:        currency,
-		PaymentProvider: provider,
-		CouponID:        couponID,
-		Items: []models.OrderItem{
-			{
-				TenantID:  1,
-				ProductID: &productID, // ✅ fixed: was product.ID (uint), now &productID (*uint)
-				PriceID:   &price.ID,
-				Quantity:  1,
-				UnitPrice: price.Amount,
-				Total:     price.Amount,
-			},
+	items := []models.OrderItem{
+		{
+			TenantID:  1,
+			ProductID: &productID, // ✅ fixed: was product.ID (uint), now &productID (*uint)
+			PriceID:   &price.ID,
+			CohortID:  cohortID,
+			Quantity:  1,
+			UnitPrice: unitPrice,
+			Total:     unitPrice,
 		},
 	}
-
-	if err := h.db.Create(&order).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+	offers := services.NewOfferService(h.db, h.providers)
+	bumpItems, err := offers.BumpItems(1, product.ID, price.ID, input.BumpIDs, currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	// Increment coupon usage
-	if couponID != nil {
-		h.db.Model(&models.Coupon{}).Where("id = ?", *couponID).UpdateColumn("used_count", gorm.Expr("used_count + 1"))
-	}
-
-	// Initialize payment based on provider
-	switch provider {
-	case "stripe":
-		// Create Stripe PaymentIntent
-		params := &stripe.PaymentIntentParams{
-			Amount:   stripe.Int64(amountInCents),
-			Currency: stripe.String(strings.ToLower(currency)),
-			AutomaticPaymentMethods: &stripe.PaymentIntentAutomaticPaymentMethodsParams{
-				Enabled: stripe.Bool(true),
-			},
-			Description:  stripe.String(product.Name),
-			ReceiptEmail: stripe.String(u.Email),
-			Metadata: map[string]string{
-				"order_id":   fmt.Sprintf("%d", order.ID),
-				"contact_id": fmt.Sprintf("%d", contact.ID),
-				"type":       input.Type,
-			},
-		}
-
-		pi, err := paymentintent.New(params)
-		if err != nil {
-			log.Printf("[payment] Stripe PaymentIntent creation failed: %v", err)
-			h.db.Delete(&order)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize payment"})
+	items = append(items, bumpItems...)
+	if input.FunnelStepID != nil {
+		var step models.FunnelStep
+		if err := h.db.First(&step, *input.FunnelStepID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Funnel step not found"})
 			return
 		}
+	}
 
-		// Store PaymentIntent ID on order
-		order.PaymentID = pi.ID
-		h.db.Model(&order).Update("payment_id", pi.ID)
-
-		c.JSON(http.StatusOK, gin.H{"data": gin.H{
-			"provider":        "stripe",
-			"client_secret":   pi.ClientSecret,
-			"order_id":        order.ID,
-			"order_number":    order.OrderNumber,
-			"amount":          amountInCents,
-			"currency":        currency,
-			"publishable_key": h.cfg.StripePublishableKey,
-		}})
+	quote, err := services.NewCouponService(h.db).Quote(1, contact.ID, currency, items, couponCodes(input.CouponCode, input.CouponCodes))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
+	}
+	subtotal := quote.Subtotal
+	discountAmount := quote.Discount
 
-	case "paypal":
-		paypalSvc := services.NewPayPalService(h.cfg)
-		paypalOrder, err := paypalSvc.CreateOrder(product.Name, totalAmount, currency, fmt.Sprintf("%d", order.ID))
-		if err != nil {
-			log.Printf("[payment] PayPal Order creation failed: %v", err)
-			h.db.Delete(&order)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize PayPal payment"})
-			return
-		}
+	totalAmount := subtotal - discountAmount
+	if totalAmount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Total amount must be greater than zero"})
+		return
+	}
 
-		order.PaymentID = paypalOrder.ID
-		h.db.Model(&order).Update("payment_id", paypalOrder.ID)
+	provider := strings.ToLower(input.Provider)
+	if provider == "" {
+		provider = payments.ProviderStripe
+	}
+	paymentProvider, err := h.providers.Provider(1, provider)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported payment provider"})
+		return
+	}
+	if provider == payments.ProviderMPesa && input.Phone == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Phone number is required for M-Pesa"})
+		return
+	}
 
-		var approvalURL string
-		for _, link := range paypalOrder.Links {
-			if link.Rel == "approve" {
-				approvalURL = link.Href
-				break
+	// Create pending order
+	order := models.Order{
+		TenantID:           1,
+		ContactID:          contact.ID,
+		OrderNumber:        generateOrderNumber(),
+		Status:             models.OrderStatusPending,
+		Subtotal:           subtotal,
+		DiscountAmount:     discountAmount,
+		TaxAmount:          0,
+		Total:              totalAmount,
+		Currency:           currency,
+		PaymentProvider:    provider,
+		CouponID:           quote.CouponID,
+		FunnelStepID:       input.FunnelStepID,
+		AffiliateAccountID: services.ActiveReferral(&contact, time.Now()),
+		AffiliateLinkID:    services.ActiveReferralLink(&contact, time.Now()),
+		IPAddress:          c.ClientIP(),
+		Items:              items,
+		Discounts:          quote.Discounts,
+	}
+
+	// The pending order holds the cohort seat and its coupon uses, so take
+	// them with the cohort and coupons locked
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if cohortID != nil {
+			if err := services.HoldCohortSeat(tx, *cohortID, time.Now()); err != nil {
+				return err
 			}
 		}
-
-		c.JSON(http.StatusOK, gin.H{"data": gin.H{
-			"provider":        "paypal",
-			"order_id":        order.ID,
-			"paypal_order_id": paypalOrder.ID,
-			"approval_url":    approvalURL,
-		}})
-		return
-
-	case "mpesa":
-		if input.Phone == "" {
-			h.db.Delete(&order)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Phone number is required for M-Pesa"})
-			return
+		if err := services.ReserveCoupons(tx, order.Discounts, time.Now()); err != nil {
+			return err
 		}
-
-		mpesaSvc := services.NewMPesaService(h.cfg)
-		// For STK Push, CallbackURL must be a public URL
-		callbackURL := strings.TrimRight(h.cfg.AppURL, "/") + "/api/callbacks/mpesa"
-
-		checkoutRequestID, err := mpesaSvc.STKPush(input.Phone, totalAmount, order.OrderNumber, product.Name, callbackURL)
-		if err != nil {
-			log.Printf("[payment] M-Pesa STK Push failed: %v", err)
-			h.db.Delete(&order)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize M-Pesa payment: " + err.Error()})
+		return tx.Create(&order).Error
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrCohortFull) || errors.Is(err, services.ErrCohortClosed) || errors.Is(err, services.ErrCohortNotFound) ||
+			errors.Is(err, services.ErrCouponExhausted) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-
-		order.PaymentID = checkoutRequestID
-		h.db.Model(&order).Update("payment_id", checkoutRequestID)
-
-		c.JSON(http.StatusOK, gin.H{"data": gin.H{
-			"provider":            "mpesa",
-			"order_id":            order.ID,
-			"checkout_request_id": checkoutRequestID,
-			"message":             "STK Push sent to your phone. Please enter your PIN to complete the payment.",
-		}})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
 		return
+	}
 
-	default:
+	// Coupon usage is counted by fulfillment once the order is paid; until
+	// then the pending order holds it
+
+	// price.Amount is already stored in cents (e.g. 3000 = $30.00)
+	webURL := strings.TrimRight(h.cfg.WebURL, "/")
+	session, err := paymentProvider.CreatePayment(c.Request.Context(), payments.PaymentRequest{
+		OrderID:       order.ID,
+		OrderNumber:   order.OrderNumber,
+		Amount:        totalAmount,
+		Currency:      currency,
+		Description:   product.Name,
+		CustomerEmail: u.Email,
+		CustomerName:  strings.TrimSpace(u.FirstName + " " + u.LastName),
+		Phone:         input.Phone,
+		ReturnURL:     fmt.Sprintf("%s/checkout/success?order_id=%d", webURL, order.ID),
+		CancelURL:     fmt.Sprintf("%s/checkout/cancel?order_id=%d", webURL, order.ID),
+		// Must be a public URL for providers that call back (M-Pesa STK Push)
+		CallbackURL: strings.TrimRight(h.cfg.AppURL, "/") + "/api/webhooks/" + provider,
+		Metadata: map[string]string{
+			"contact_id": fmt.Sprintf("%d", contact.ID),
+			"type":       input.Type,
+		},
+		// Keep the card on file when the funnel follows up with one-click
+		// offers, and for subscriptions to renew against
+		SavePaymentMethod: offers.WantsSavedCard(input.FunnelStepID) || price.Type == models.PriceTypeSubscription,
+	})
+	if err != nil {
+		log.Printf("[payment] %s payment creation failed: %v", provider, err)
 		h.db.Delete(&order)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported payment provider"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize payment: " + err.Error()})
 		return
 	}
+
+	// Store the provider's payment ID on the order
+	order.PaymentID = session.PaymentID
+	h.db.Model(&order).Update("payment_id", session.PaymentID)
+
+	data := gin.H{
+		"provider":     provider,
+		"order_id":     order.ID,
+		"order_number": order.OrderNumber,
+		"amount":       int64(math.Round(totalAmount)),
+		"currency":     currency,
+		"redirect_url": session.RedirectURL,
+		"discounts":    quote.Discounts,
+	}
+	for k, v := range session.Data {
+		data[k] = v
+	}
+	c.JSON(http.StatusOK, gin.H{"data": data})
 }
 
 // CheckoutStatus returns the current status of an order for the authenticated user.
@@ -398,9 +411,9 @@ func (h *PaymentHandler) CheckoutStatus(c *gin.Context) {
 	}})
 }
 
-// ConfirmCheckout is called by the frontend after stripe.confirmPayment succeeds.
-// It verifies the PaymentIntent via Stripe API and immediately fulfills the order,
-// so the user doesn't have to wait for the webhook.
+// ConfirmCheckout is called by the frontend when the customer returns from
+// paying. It verifies the payment with the provider and immediately fulfills
+// the order, so the user doesn't have to wait for the webhook.
 func (h *PaymentHandler) ConfirmCheckout(c *gin.Context) {
 	orderID := c.Param("orderId")
 	user, _ := c.Get("user")
@@ -424,356 +437,495 @@ func (h *PaymentHandler) ConfirmCheckout(c *gin.Context) {
 		return
 	}
 
-	// Verify PaymentIntent status via provider
+	// Verify payment status via provider
 	if order.PaymentID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No payment associated with this order"})
 		return
 	}
 
-	if order.PaymentProvider == "stripe" || order.PaymentProvider == "" {
-		pi, err := paymentintent.Get(order.PaymentID, nil)
-		if err != nil {
-			log.Printf("[confirm] Failed to retrieve PI %s: %v", order.PaymentID, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify payment"})
-			return
-		}
+	name := order.PaymentProvider
+	if name == "" {
+		name = payments.ProviderStripe
+	}
+	provider, err := h.providers.Provider(order.TenantID, name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported payment provider"})
+		return
+	}
 
-		if pi.Status != stripe.PaymentIntentStatusSucceeded {
-			c.JSON(http.StatusOK, gin.H{"data": gin.H{"status": string(pi.Status)}})
-			return
-		}
-	} else if order.PaymentProvider == "paypal" {
-		paypalSvc := services.NewPayPalService(h.cfg)
-		err := paypalSvc.CaptureOrder(order.PaymentID)
-		if err != nil {
-			log.Printf("[confirm] Failed to capture PayPal order %s: %v", order.PaymentID, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to capture PayPal payment"})
-			return
-		}
-	} else if order.PaymentProvider == "mpesa" {
-		if order.Status != models.OrderStatusPaid {
-			c.JSON(http.StatusOK, gin.H{"data": gin.H{"status": "pending", "message": "Waiting for M-Pesa confirmation via callback"}})
-			return
-		}
+	result, err := provider.ConfirmPayment(c.Request.Context(), order.PaymentID)
+	if err != nil {
+		log.Printf("[confirm] Failed to verify %s payment %s: %v", name, order.PaymentID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify payment"})
+		return
 	}
 
-	// Mark as paid and fulfill
-	now := time.Now()
-	order.Status = models.OrderStatusPaid
-	order.PaidAt = &now
-	h.db.Save(&order)
+	switch result.Status {
+	case payments.StatusPending:
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{"status": "pending"}})
+		return
+	case payments.StatusFailed:
+		h.markOrderFailed(&order, result.FailureReason)
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{"status": "failed", "message": result.FailureReason}})
+		return
+	}
 
-	fulfillOrder(h.db, &order)
+	if !result.Covers(order.Total, order.Currency) {
+		log.Printf("[confirm] %s payment %s paid %.0f %s of order %d's %.0f %s", name, order.PaymentID, result.Amount, result.Currency, order.ID, order.Total, order.Currency)
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "The payment does not cover the order total"})
+		return
+	}
 
-	log.Printf("[confirm] Order %d confirmed and fulfilled (PI: %s)", order.ID, order.PaymentID)
+	// Mark as paid and fulfill
+	confirmation := services.PaymentConfirmation{Provider: name, Reference: result.Reference, Fingerprint: result.Fingerprint}
+	if _, _, err := h.fulfiller.FulfillPaidOrder(order.ID, confirmation); err != nil {
+		log.Printf("[confirm] Failed to fulfill order %d: %v", order.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fulfill order"})
+		return
+	}
+
+	log.Printf("[confirm] Order %d confirmed and fulfilled (%s: %s)", order.ID, name, order.PaymentID)
 	c.JSON(http.StatusOK, gin.H{"data": gin.H{"status": "paid"}})
 }
 
-// StripeConfig returns the publishable key for the frontend.
-func (h *PaymentHandler) StripeConfig(c *gin.Context) {
-	if h.cfg.StripePublishableKey == "" {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Payments not configured"})
+// OpenResumeCheckout returns an abandoned checkout for the resume link in a
+// recovery email, with the recovery coupon applied when one was issued.
+// It is a POST because applying the coupon re-prices the order.
+func (h *PaymentHandler) OpenResumeCheckout(c *gin.Context) {
+	rec, order, err := services.NewCheckoutRecoveryService(h.db).Resume(c.Param("token"))
+	if err != nil {
+		h.resumeError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"data": gin.H{
-		"publishable_key": h.cfg.StripePublishableKey,
+		"order":       order,
+		"coupon_code": rec.CouponCode,
+		"providers":   h.providers.Available(1),
 	}})
 }
 
-// StripeWebhook handles incoming Stripe webhook events.
-func (h *PaymentHandler) StripeWebhook(c *gin.Context) {
-	payload, err := c.GetRawData()
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+// ResumeCheckout starts a new payment for an abandoned checkout. The order
+// keeps its number and items; the payment session is replaced and each
+// attempt is sent to the provider under a fresh reference, since gateways
+// reject a reference they have already seen.
+func (h *PaymentHandler) ResumeCheckout(c *gin.Context) {
+	var input struct {
+		Provider string `json:"provider"`
+		Phone    string `json:"phone"` // Required for mpesa
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	sig := c.GetHeader("Stripe-Signature")
-	event, err := webhook.ConstructEvent(payload, sig, h.cfg.StripeWebhookSecret)
+	_, order, err := services.NewCheckoutRecoveryService(h.db).Resume(c.Param("token"))
 	if err != nil {
-		log.Printf("[webhook] Signature verification failed: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid signature"})
+		h.resumeError(c, err)
+		return
+	}
+	if order.Total <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Total amount must be greater than zero"})
 		return
 	}
 
-	switch event.Type {
-	case "payment_intent.succeeded":
-		h.handlePaymentSucceeded(event)
-	case "payment_intent.payment_failed":
-		h.handlePaymentFailed(event)
+	provider := strings.ToLower(input.Provider)
+	if provider == "" {
+		provider = order.PaymentProvider
+	}
+	paymentProvider, err := h.providers.Provider(1, provider)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported payment provider"})
+		return
+	}
+	if provider == payments.ProviderMPesa && input.Phone == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Phone number is required for M-Pesa"})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"received": true})
-}
+	var description []string
+	for _, item := range order.Items {
+		if item.Product != nil {
+			description = append(description, item.Product.Name)
+		}
+	}
+	var email, name string
+	if order.Contact != nil {
+		email = order.Contact.Email
+		name = strings.TrimSpace(order.Contact.FirstName + " " + order.Contact.LastName)
+	}
 
-// PayPalWebhook handles incoming PayPal webhook events.
-func (h *PaymentHandler) PayPalWebhook(c *gin.Context) {
-	// A robust implementation would verify the webhook signature here
-	// For now, we will just parse it
-	var event struct {
-		EventType string `json:"event_type"`
-		Resource  struct {
-			ID string `json:"id"`
-		} `json:"resource"`
+	reference, err := h.nextPaymentReference(order)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resume checkout"})
+		return
 	}
 
-	if err := c.ShouldBindJSON(&event); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+	webURL := strings.TrimRight(h.cfg.WebURL, "/")
+	session, err := paymentProvider.CreatePayment(c.Request.Context(), payments.PaymentRequest{
+		OrderID:       order.ID,
+		OrderNumber:   reference,
+		Amount:        order.Total,
+		Currency:      order.Currency,
+		Description:   strings.Join(description, ", "),
+		CustomerEmail: email,
+		CustomerName:  name,
+		Phone:         input.Phone,
+		ReturnURL:     fmt.Sprintf("%s/checkout/success?order_id=%d", webURL, order.ID),
+		CancelURL:     fmt.Sprintf("%s/checkout/cancel?order_id=%d", webURL, order.ID),
+		CallbackURL:   strings.TrimRight(h.cfg.AppURL, "/") + "/api/webhooks/" + provider,
+		Metadata: map[string]string{
+			"contact_id": fmt.Sprintf("%d", order.ContactID),
+			"recovery":   "true",
+		},
+	})
+	if err != nil {
+		log.Printf("[payment] %s payment creation failed for resumed order %d: %v", provider, order.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize payment: " + err.Error()})
 		return
 	}
 
-	if event.EventType == "CHECKOUT.ORDER.APPROVED" {
-		// Log or trigger capture. We auto-capture in ConfirmCheckout normally,
-		// but we could also capture here.
-		log.Printf("[paypal] Order approved webhook received for order %s", event.Resource.ID)
+	if err := h.db.Model(order).Updates(map[string]interface{}{
+		"payment_provider":   provider,
+		"payment_id":         session.PaymentID,
+		"provider_reference": reference,
+	}).Error; err != nil {
+		log.Printf("[payment] Failed to store %s payment %s for resumed order %d: %v", provider, session.PaymentID, order.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resume checkout"})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"received": true})
+	data := gin.H{
+		"provider":     provider,
+		"order_id":     order.ID,
+		"order_number": order.OrderNumber,
+		"amount":       int64(math.Round(order.Total)),
+		"currency":     order.Currency,
+		"redirect_url": session.RedirectURL,
+		"discounts":    order.Discounts,
+	}
+	for k, v := range session.Data {
+		data[k] = v
+	}
+	c.JSON(http.StatusOK, gin.H{"data": data})
 }
 
-// MPesaCallback handles Safaricom Lipa Na M-Pesa Online callbacks.
-func (h *PaymentHandler) MPesaCallback(c *gin.Context) {
-	var payload struct {
-		Body struct {
-			StkCallback struct {
-				MerchantRequestID string `json:"MerchantRequestID"`
-				CheckoutRequestID string `json:"CheckoutRequestID"`
-				ResultCode        int    `json:"ResultCode"`
-				ResultDesc        string `json:"ResultDesc"`
-				CallbackMetadata  struct {
-					Item []struct {
-						Name  string      `json:"Name"`
-						Value interface{} `json:"Value"`
-					} `json:"Item"`
-				} `json:"CallbackMetadata"`
-			} `json:"stkCallback"`
-		} `json:"Body"`
+// nextPaymentReference counts a new payment attempt on the order and returns
+// the provider reference for it (ORD-123-2, ORD-123-3, ...).
+func (h *PaymentHandler) nextPaymentReference(order *models.Order) (string, error) {
+	var attempts int
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var locked models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "payment_attempts").First(&locked, order.ID).Error; err != nil {
+			return err
+		}
+		attempts = locked.PaymentAttempts + 1
+		return tx.Model(&locked).UpdateColumn("payment_attempts", attempts).Error
+	})
+	if err != nil {
+		return "", err
 	}
+	order.PaymentAttempts = attempts
+	return fmt.Sprintf("%s-%d", order.OrderNumber, attempts+1), nil
+}
 
-	if err := c.ShouldBindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
-		return
+func (h *PaymentHandler) resumeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrRecoveryNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCheckoutClosed):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resume checkout"})
 	}
+}
+
+// ===================== ONE-CLICK OFFERS =====================
 
-	cb := payload.Body.StkCallback
+// customerOrder loads an order of the authenticated customer by :orderId.
+func (h *PaymentHandler) customerOrder(c *gin.Context) (*models.Order, bool) {
+	user, _ := c.Get("user")
+	u := user.(models.User)
 
+	var contact models.Contact
+	if err := h.db.Where("email = ? AND tenant_id = ?", u.Email, 1).First(&contact).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return nil, false
+	}
 	var order models.Order
-	if err := h.db.Where("payment_id = ?", cb.CheckoutRequestID).Preload("Items").First(&order).Error; err != nil {
-		log.Printf("[mpesa] Order not found for CheckoutRequestID: %s", cb.CheckoutRequestID)
-		c.JSON(http.StatusOK, gin.H{"status": "ignored"})
-		return
+	if err := h.db.Where("id = ? AND contact_id = ?", c.Param("orderId"), contact.ID).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return nil, false
 	}
+	return &order, true
+}
 
-	if order.Status == models.OrderStatusPaid {
-		c.JSON(http.StatusOK, gin.H{"status": "already_paid"})
-		return
+// offerResponse describes the next funnel offer for the thank-you flow, or
+// nil when there are no more offers.
+func (h *PaymentHandler) offerResponse(offers *services.OfferService, root *models.Order, step *models.FunnelStep) gin.H {
+	if step == nil {
+		return nil
 	}
-
-	if cb.ResultCode == 0 {
-		// Payment success
-		now := time.Now()
-		order.Status = models.OrderStatusPaid
-		order.PaidAt = &now
-		h.db.Save(&order)
-
-		fulfillOrder(h.db, &order)
-		log.Printf("[mpesa] Order %d marked as paid (M-Pesa: %s)", order.ID, cb.CheckoutRequestID)
-	} else {
-		// Payment failed/cancelled
-		order.Status = models.OrderStatusFailed
-		h.db.Save(&order)
-		log.Printf("[mpesa] Order %d payment failed: %s", order.ID, cb.ResultDesc)
+	_, amount, err := offers.OfferPrice(root, step)
+	if err != nil {
+		return nil
+	}
+	oneClick := false
+	if p, err := h.providers.Provider(1, root.PaymentProvider); err == nil {
+		_, oneClick = p.(payments.OffSessionCharger)
+	}
+	return gin.H{
+		"step":      step,
+		"amount":    int64(math.Round(amount)),
+		"currency":  root.Currency,
+		"one_click": oneClick,
 	}
-
-	c.JSON(http.StatusOK, gin.H{"status": "received"})
 }
 
-func (h *PaymentHandler) handlePaymentSucceeded(event stripe.Event) {
-	pi, ok := event.Data.Object["id"].(string)
+// NextOffer returns the upsell/downsell to show after an order is paid.
+// Call it with the checkout order, then with each accepted offer's order.
+func (h *PaymentHandler) NextOffer(c *gin.Context) {
+	order, ok := h.customerOrder(c)
 	if !ok {
-		log.Println("[webhook] Missing payment_intent ID")
 		return
 	}
-
-	var order models.Order
-	if err := h.db.Where("payment_id = ?", pi).Preload("Items").First(&order).Error; err != nil {
-		log.Printf("[webhook] Order not found for PI %s: %v", pi, err)
+	if order.Status != models.OrderStatusPaid || order.FunnelStepID == nil {
+		c.JSON(http.StatusOK, gin.H{"data": nil})
 		return
 	}
+	offers := services.NewOfferService(h.db, h.providers)
+	root := offers.RootOrder(order)
+	next := offers.NextOffer(root, *order.FunnelStepID, order.ParentOrderID != nil)
+	c.JSON(http.StatusOK, gin.H{"data": h.offerResponse(offers, root, next)})
+}
 
-	// Idempotency: skip if already paid
-	if order.Status == models.OrderStatusPaid {
-		log.Printf("[webhook] Order %d already paid, skipping", order.ID)
+// AcceptOffer buys a funnel upsell/downsell with one click, charging the
+// card saved when the checkout order was paid.
+func (h *PaymentHandler) AcceptOffer(c *gin.Context) {
+	order, ok := h.customerOrder(c)
+	if !ok {
+		return
+	}
+	stepID, err := strconv.Atoi(c.Param("stepId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid step ID"})
 		return
 	}
 
-	// Mark as paid
-	now := time.Now()
-	order.Status = models.OrderStatusPaid
-	order.PaidAt = &now
-	h.db.Save(&order)
-
-	// Fulfill order (auto-enroll in courses, etc.)
-	fulfillOrder(h.db, &order)
+	offers := services.NewOfferService(h.db, h.providers)
+	root := offers.RootOrder(order)
+	upsell, err := offers.AcceptOffer(c.Request.Context(), root, uint(stepID))
+	if err != nil {
+		var payErr *services.OfferPaymentError
+		switch {
+		case errors.Is(err, services.ErrOfferUnavailable):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrOfferTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrOneClickUnavailable), errors.As(err, &payErr):
+			// The frontend falls back to a regular checkout for the offer
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error(), "fallback_checkout": true})
+		default:
+			log.Printf("[payment] one-click offer %d for order %d failed: %v", stepID, root.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to charge the offer"})
+		}
+		return
+	}
 
-	log.Printf("[webhook] Order %d marked as paid (PI: %s)", order.ID, pi)
+	next := offers.NextOffer(root, uint(stepID), true)
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"order":      upsell,
+		"next_offer": h.offerResponse(offers, root, next),
+	}})
 }
 
-func (h *PaymentHandler) handlePaymentFailed(event stripe.Event) {
-	pi, ok := event.Data.Object["id"].(string)
+// DeclineOffer skips a funnel offer and returns the next one (typically the
+// downsell that follows a declined upsell).
+func (h *PaymentHandler) DeclineOffer(c *gin.Context) {
+	order, ok := h.customerOrder(c)
 	if !ok {
 		return
 	}
-
-	var order models.Order
-	if err := h.db.Where("payment_id = ?", pi).First(&order).Error; err != nil {
+	stepID, err := strconv.Atoi(c.Param("stepId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid step ID"})
 		return
 	}
+	offers := services.NewOfferService(h.db, h.providers)
+	root := offers.RootOrder(order)
+	next := offers.NextOffer(root, uint(stepID), false)
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"next_offer": h.offerResponse(offers, root, next)}})
+}
 
-	if order.Status != models.OrderStatusPending {
+// ListOrderBumps returns the order bumps offered with a product at checkout
+// (?product_id=, optional ?price_id=), priced in the visitor's currency.
+func (h *PaymentHandler) ListOrderBumps(c *gin.Context) {
+	productID, _ := strconv.Atoi(c.Query("product_id"))
+	priceID, _ := strconv.Atoi(c.Query("price_id"))
+	if productID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "product_id is required"})
 		return
 	}
 
-	order.Status = models.OrderStatusFailed
-	h.db.Save(&order)
-	log.Printf("[webhook] Order %d payment failed (PI: %s)", order.ID, pi)
+	fx := services.NewCurrencyService(h.db)
+	currency := displayCurrency(c, fx)
+	offers := services.NewOfferService(h.db, h.providers)
+	bumps := offers.BumpsFor(1, uint(productID), uint(priceID))
+
+	data := make([]gin.H, 0, len(bumps))
+	for _, b := range bumps {
+		data = append(data, gin.H{
+			"id":            b.ID,
+			"headline":      b.Headline,
+			"description":   b.Description,
+			"offer_product": b.OfferProduct,
+			"amount":        int64(math.Round(offers.BumpAmount(1, b, currency))),
+			"currency":      currency,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+// StripeConfig returns the publishable key for the frontend.
+func (h *PaymentHandler) StripeConfig(c *gin.Context) {
+	key := h.providers.Credentials(1, payments.ProviderStripe).Get("stripe_publishable_key")
+	if key == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Payments not configured"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"publishable_key": key,
+	}})
 }
 
-// fulfillOrder is a local wrapper to avoid circular imports.
-// It duplicates the logic from services.FulfillOrder.
+// ListProviders returns the payment providers configured for checkout.
+func (h *PaymentHandler) ListProviders(c *gin.Context) {
+	providers := h.providers.Available(1)
+	if providers == nil {
+		providers = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": providers})
+}
 
+// Webhook handles payment and refund notifications for any provider
+// (POST /api/webhooks/:provider). Providers verify their own signatures.
+func (h *PaymentHandler) Webhook(c *gin.Context) {
+	h.handleWebhook(c, c.Param("provider"))
+}
 
-func fulfillOrder(db *gorm.DB, order *models.Order) {
-	for _, item := range order.Items {
-		var product models.Product
-		if err := db.First(&product, item.ProductID).Error; err != nil {
-			log.Printf("[fulfillment] Product %v not found for order %d: %v", item.ProductID, order.ID, err)
-			continue
-		}
+// MPesaCallback keeps the original STK Push callback URL working.
+func (h *PaymentHandler) MPesaCallback(c *gin.Context) {
+	h.handleWebhook(c, payments.ProviderMPesa)
+}
 
-		switch product.Type {
-
-		case models.ProductTypeCourse:
-			// Auto-enroll contact in any courses linked to this product
-			var courses []models.Course
-			db.Where("product_id = ?", product.ID).Find(&courses)
-			for _, course := range courses {
-				enrollment := models.CourseEnrollment{
-					TenantID:  1,
-					ContactID: order.ContactID,
-					CourseID:  course.ID,
-					Status:    "active",
-					Source:    "purchase",
-				}
-				if err := db.FirstOrCreate(&enrollment, models.CourseEnrollment{
-					ContactID: order.ContactID,
-					CourseID:  course.ID,
-				}).Error; err != nil {
-					log.Printf("[fulfillment] Failed to enroll contact %d in course %d: %v", order.ContactID, course.ID, err)
-				} else {
-					log.Printf("[fulfillment] Contact %d enrolled in course %d", order.ContactID, course.ID)
-				}
-			}
+func (h *PaymentHandler) handleWebhook(c *gin.Context, name string) {
+	provider, err := h.providers.Provider(1, name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown payment provider"})
+		return
+	}
 
-		case models.ProductTypeDigital:
-			// Grant access to the digital product
-			access := models.ContactProduct{
-				TenantID:  1,
-				ContactID: order.ContactID,
-				ProductID: product.ID,
-				OrderID:   order.ID,
-				Status:    "active",
-			}
-			if err := db.FirstOrCreate(&access, models.ContactProduct{
-				ContactID: order.ContactID,
-				ProductID: product.ID,
-			}).Error; err != nil {
-				log.Printf("[fulfillment] Failed to grant digital access to contact %d for product %d: %v", order.ContactID, product.ID, err)
-			} else {
-				log.Printf("[fulfillment] Contact %d granted access to digital product %d", order.ContactID, product.ID)
-			}
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
 
-		case models.ProductTypeMembership:
-			// Grant or extend membership access
-			now := time.Now()
-			var expiresAt *time.Time
+	event, err := provider.ParseWebhook(c.Request, body)
+	if err != nil {
+		log.Printf("[webhook] %s webhook rejected: %v", name, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook"})
+		return
+	}
 
-			// Check if product has a duration defined (e.g. 30 days, 365 days)
-			if product.MembershipDays > 0 {
-				exp := now.AddDate(0, 0, product.MembershipDays)
-				expiresAt = &exp
-			}
+	switch event.Type {
+	case payments.EventPaymentSucceeded:
+		if err := h.handlePaymentSucceeded(c.Request.Context(), name, provider, event); err != nil {
+			// Non-2xx makes the provider retry; fulfillment is idempotent
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fulfill order"})
+			return
+		}
+	case payments.EventPaymentFailed:
+		h.handlePaymentFailed(name, event)
+	case payments.EventRefundSucceeded, payments.EventRefundFailed:
+		refundSvc := services.NewRefundService(h.db, h.cfg, h.providers)
+		if err := refundSvc.CompleteProviderRefund(event.OrderID, event.RefundID, event.Type == payments.EventRefundSucceeded, event.FailureReason); err != nil {
+			log.Printf("[webhook] %s refund result ignored: %v", name, err)
+		}
+	}
 
-			var existing models.ContactProduct
-			err := db.Where("contact_id = ? AND product_id = ?", order.ContactID, product.ID).First(&existing).Error
-			if err == gorm.ErrRecordNotFound {
-				// New membership
-				membership := models.ContactProduct{
-					TenantID:   1,
-					ContactID:  order.ContactID,
-					ProductID:  product.ID,
-					OrderID:    order.ID,
-					Status:     "active",
-					StartedAt:  &now,
-					ExpiresAt:  expiresAt,
-				}
-				if err := db.Create(&membership).Error; err != nil {
-					log.Printf("[fulfillment] Failed to create membership for contact %d, product %d: %v", order.ContactID, product.ID, err)
-				} else {
-					log.Printf("[fulfillment] Contact %d granted membership product %d", order.ContactID, product.ID)
-				}
-			} else if err == nil {
-				// Extend existing membership
-				updates := map[string]interface{}{"status": "active", "order_id": order.ID}
-				if expiresAt != nil {
-					// If already has an expiry in the future, extend from that; otherwise extend from now
-					base := now
-					if existing.ExpiresAt != nil && existing.ExpiresAt.After(now) {
-						base = *existing.ExpiresAt
-					}
-					exp := base.AddDate(0, 0, product.MembershipDays)
-					updates["expires_at"] = exp
-				}
-				if err := db.Model(&existing).Updates(updates).Error; err != nil {
-					log.Printf("[fulfillment] Failed to extend membership for contact %d, product %d: %v", order.ContactID, product.ID, err)
-				} else {
-					log.Printf("[fulfillment] Contact %d membership extended for product %d", order.ContactID, product.ID)
-				}
-			}
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
 
-		case models.ProductTypeService:
-			// Record that the contact purchased this service
-			access := models.ContactProduct{
-				TenantID:  1,
-				ContactID: order.ContactID,
-				ProductID: product.ID,
-				OrderID:   order.ID,
-				Status:    "active",
-			}
-			if err := db.FirstOrCreate(&access, models.ContactProduct{
-				ContactID: order.ContactID,
-				ProductID: product.ID,
-			}).Error; err != nil {
-				log.Printf("[fulfillment] Failed to record service purchase for contact %d, product %d: %v", order.ContactID, product.ID, err)
-			} else {
-				log.Printf("[fulfillment] Contact %d service purchase recorded for product %d", order.ContactID, product.ID)
-			}
+func (h *PaymentHandler) handlePaymentSucceeded(ctx context.Context, name string, provider payments.PaymentProvider, event *payments.WebhookEvent) error {
+	var order models.Order
+	if err := h.db.Where("payment_provider = ? AND payment_id = ?", name, event.PaymentID).First(&order).Error; err != nil {
+		log.Printf("[webhook] Order not found for %s payment %s: %v", name, event.PaymentID, err)
+		return nil
+	}
 
-		case models.ProductTypePhysical:
-			// Physical products require shipping — just log for now.
-			// You can extend this to trigger a fulfillment/shipping workflow.
-			log.Printf("[fulfillment] Physical product %d (order %d) — shipping fulfillment required for contact %d", product.ID, order.ID, order.ContactID)
+	// A webhook alone isn't proof of payment (M-Pesa's are unsigned), so ask
+	// the gateway whether the order total was paid. The answer also gives
+	// fraud checks the card fingerprint whichever path fulfils the order.
+	result, err := provider.ConfirmPayment(ctx, order.PaymentID)
+	if err != nil {
+		log.Printf("[webhook] Could not look up %s payment %s: %v", name, order.PaymentID, err)
+		return err
+	}
+	if !result.Covers(order.Total, order.Currency) {
+		log.Printf("[webhook] Not fulfilling order %d: %s payment %s is %s for %.0f %s of %.0f %s",
+			order.ID, name, order.PaymentID, result.Status, result.Amount, result.Currency, order.Total, order.Currency)
+		return nil
+	}
+	fingerprint := event.Fingerprint
+	if fingerprint == "" {
+		fingerprint = result.Fingerprint
+	}
+	reference := event.Reference
+	if reference == "" {
+		reference = result.Reference
+	}
 
-		default:
-			log.Printf("[fulfillment] Unknown product type '%s' for product %d, skipping", product.Type, product.ID)
+	// Mark as paid and fulfill (auto-enroll in courses, etc.)
+	confirmation := services.PaymentConfirmation{Provider: name, Reference: reference, Fingerprint: fingerprint}
+	_, fulfilled, err := h.fulfiller.FulfillPaidOrder(order.ID, confirmation)
+	if err != nil {
+		log.Printf("[webhook] Failed to fulfill order %d: %v", order.ID, err)
+		return err
+	}
+	if !fulfilled {
+		// Confirmed by the frontend first; the webhook may still carry the
+		// reference refunds are issued against (e.g. the M-Pesa receipt).
+		if order.PaymentReference == "" && reference != "" {
+			h.db.Model(&order).Update("payment_reference", reference)
+		}
+		if order.PaymentFingerprint == "" && fingerprint != "" {
+			h.db.Model(&order).Update("payment_fingerprint", fingerprint)
 		}
+		log.Printf("[webhook] Order %d already fulfilled, skipping", order.ID)
+		return nil
 	}
 
-	events.Emit(events.PurchaseCompleted, map[string]interface{}{
-		"order_id":   order.ID,
-		"contact_id": order.ContactID,
-		"total":      order.Total,
-	})
+	log.Printf("[webhook] Order %d marked as paid (%s: %s)", order.ID, name, event.PaymentID)
+	return nil
+}
 
-	log.Printf("[fulfillment] Order %d fulfilled (contact=%d, total=%.2f)", order.ID, order.ContactID, order.Total)
-}
\ No newline at end of file
+func (h *PaymentHandler) handlePaymentFailed(name string, event *payments.WebhookEvent) {
+	var order models.Order
+	if err := h.db.Where("payment_provider = ? AND payment_id = ?", name, event.PaymentID).First(&order).Error; err != nil {
+		return
+	}
+	h.markOrderFailed(&order, event.FailureReason)
+}
+
+func (h *PaymentHandler) markOrderFailed(order *models.Order, reason string) {
+	if order.Status != models.OrderStatusPending {
+		return
+	}
+	order.Status = models.OrderStatusFailed
+	h.db.Model(order).Update("status", order.Status)
+	log.Printf("[payment] Order %d payment failed (%s: %s) %s", order.ID, order.PaymentProvider, order.PaymentID, reason)
+
+	if err := services.NewSubscriptionService(h.db, h.providers).RenewalFailed(order, reason); err != nil {
+		log.Printf("[payment] Failed to mark subscription of renewal order %d past due: %v", order.ID, err)
+	}
+}
@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/config"
+	"gritcms/apps/api/internal/jobs"
+	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/services"
+	"gritcms/apps/api/internal/storage"
+)
+
+// xapiVersion is the xAPI version reported to package content.
+const xapiVersion = "1.0.3"
+
+// ScormHandler imports SCORM and xAPI packages into courses and serves them
+// to enrolled students.
+type ScormHandler struct {
+	db      *gorm.DB
+	storage *storage.Storage
+	jobs    *jobs.Client
+	cfg     *config.Config
+}
+
+// NewScormHandler creates a new ScormHandler.
+func NewScormHandler(db *gorm.DB, storage *storage.Storage, jobs *jobs.Client, cfg *config.Config) *ScormHandler {
+	return &ScormHandler{db: db, storage: storage, jobs: jobs, cfg: cfg}
+}
+
+// ===================== ADMIN =====================
+
+// ListPackages returns the packages imported into a course.
+func (h *ScormHandler) ListPackages(c *gin.Context) {
+	var packages []models.ScormPackage
+	h.db.Preload("Upload").Where("course_id = ?", c.Param("id")).Order("created_at DESC").Find(&packages)
+	c.JSON(http.StatusOK, gin.H{"data": packages})
+}
+
+// ImportPackage imports a SCORM 1.2/2004 or xAPI zip, uploaded through
+// /uploads, into the course. Unpacking runs in the background; the package
+// status turns ready once its lessons are created.
+func (h *ScormHandler) ImportPackage(c *gin.Context) {
+	var course models.Course
+	if err := h.db.First(&course, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Course not found"})
+		return
+	}
+	var body struct {
+		UploadID uint `json:"upload_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var upload models.Upload
+	if err := h.db.First(&upload, body.UploadID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+	if !strings.Contains(upload.MimeType, "zip") && !strings.HasSuffix(strings.ToLower(upload.OriginalName), ".zip") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Packages must be uploaded as a .zip file"})
+		return
+	}
+	if h.storage == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "File storage is not configured"})
+		return
+	}
+
+	pkg := models.ScormPackage{
+		TenantID: 1,
+		CourseID: course.ID,
+		UploadID: upload.ID,
+		Title:    upload.OriginalName,
+		Status:   models.ScormPending,
+	}
+	if err := h.db.Create(&pkg).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create package"})
+		return
+	}
+
+	if h.jobs != nil {
+		if err := h.jobs.EnqueueScormImport(pkg.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue import"})
+			return
+		}
+	} else if err := services.NewScormService(h.db, h.storage).Import(c.Request.Context(), pkg.ID); err != nil {
+		h.db.First(&pkg, pkg.ID)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "data": pkg})
+		return
+	}
+	h.db.First(&pkg, pkg.ID)
+	c.JSON(http.StatusAccepted, gin.H{"data": pkg})
+}
+
+// DeletePackage removes a package and the lessons it created.
+func (h *ScormHandler) DeletePackage(c *gin.Context) {
+	var pkg models.ScormPackage
+	if err := h.db.Where("id = ? AND course_id = ?", c.Param("packageId"), c.Param("id")).First(&pkg).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Package not found"})
+		return
+	}
+	if err := services.NewScormService(h.db, h.storage).DeletePackage(c.Request.Context(), &pkg); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete package"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Package deleted"})
+}
+
+// ===================== STUDENT =====================
+
+// studentPackageLesson loads the student's enrollment and an unlocked
+// package lesson of the course, writing the error response if either is
+// unavailable.
+func (h *ScormHandler) studentPackageLesson(c *gin.Context) (*models.CourseEnrollment, *models.Lesson, *models.ScormPackage, bool) {
+	courseID, _ := strconv.Atoi(c.Param("id"))
+	lessonID, _ := strconv.Atoi(c.Param("lessonId"))
+	user, _ := c.Get("user")
+	u := user.(models.User)
+
+	courses := services.NewCourseService(h.db)
+	enrollment, err := courses.StudentEnrollment(u.Email, uint(courseID))
+	if err != nil {
+		if errors.Is(err, services.ErrMembershipRequired) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return nil, nil, nil, false
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not enrolled in this course"})
+		return nil, nil, nil, false
+	}
+	if err := courses.CheckUnlocked(enrollment, uint(lessonID), time.Now()); err != nil {
+		if errors.Is(err, services.ErrLessonLocked) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return nil, nil, nil, false
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
+		return nil, nil, nil, false
+	}
+
+	lesson, pkg, err := services.NewScormService(h.db, h.storage).PackageLesson(uint(lessonID))
+	if err != nil {
+		if errors.Is(err, services.ErrPackageNotReady) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return nil, nil, nil, false
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, nil, nil, false
+	}
+	return enrollment, lesson, pkg, true
+}
+
+// StudentLaunch returns the signed launch URL for a package lesson, with
+// the cmi data the player's SCORM API starts from.
+func (h *ScormHandler) StudentLaunch(c *gin.Context) {
+	enrollment, lesson, pkg, ok := h.studentPackageLesson(c)
+	if !ok {
+		return
+	}
+	user, _ := c.Get("user")
+	u := user.(models.User)
+
+	launch, err := services.NewScormService(h.db, h.storage).Launch(enrollment, lesson, pkg, &u, h.cfg.AppURL, h.cfg.ScormSecret, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to launch lesson"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": launch})
+}
+
+// StudentCommit records the cmi data the player's SCORM API collected, on
+// LMSCommit/Commit and with finish set on LMSFinish/Terminate.
+func (h *ScormHandler) StudentCommit(c *gin.Context) {
+	enrollment, lesson, pkg, ok := h.studentPackageLesson(c)
+	if !ok {
+		return
+	}
+	if pkg.Version == models.ScormVersionXAPI {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "xAPI lessons report through their statement endpoint"})
+		return
+	}
+	var body struct {
+		CMI    map[string]string `json:"cmi" binding:"required"`
+		Finish bool              `json:"finish"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	progress, err := services.NewScormService(h.db, h.storage).Commit(enrollment, lesson, pkg, body.CMI, body.Finish, time.Now())
+	if err != nil {
+		if errors.Is(err, services.ErrLessonLocked) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record progress"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": progress})
+}
+
+// ===================== CONTENT =====================
+
+// Content serves a file of an imported package. The SCO's pages load their
+// assets by relative URL, so the signed token in the path is the
+// credential.
+func (h *ScormHandler) Content(c *gin.Context) {
+	packageID, err := services.ParsePackageToken(h.cfg.ScormSecret, c.Param("token"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	var pkg models.ScormPackage
+	if err := h.db.First(&pkg, packageID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Package not found"})
+		return
+	}
+
+	reader, contentType, err := services.NewScormService(h.db, h.storage).Content(c.Request.Context(), &pkg, c.Param("file"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidContentPath):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrPackageNotReady):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		}
+		return
+	}
+	defer reader.Close()
+
+	c.Header("Cache-Control", "private, max-age=3600")
+	c.DataFromReader(http.StatusOK, -1, contentType, reader, nil)
+}
+
+// ===================== xAPI =====================
+
+// xapiLesson resolves an xAPI token to the student's enrollment and lesson.
+func (h *ScormHandler) xapiLesson(c *gin.Context) (*models.CourseEnrollment, *models.Lesson, bool) {
+	c.Header("X-Experience-API-Version", xapiVersion)
+	enrollmentID, lessonID, err := services.ParseXAPIToken(h.cfg.ScormSecret, c.Param("token"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+	var enrollment models.CourseEnrollment
+	if err := h.db.Where("id = ? AND status IN ?", enrollmentID, []string{models.EnrollStatusActive, models.EnrollStatusCompleted}).
+		First(&enrollment).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not enrolled in this course"})
+		return nil, nil, false
+	}
+	if err := services.NewAccessService(h.db).CheckEnrollment(&enrollment, time.Now()); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+	var lesson models.Lesson
+	if err := h.db.First(&lesson, lessonID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
+		return nil, nil, false
+	}
+	return &enrollment, &lesson, true
+}
+
+// XAPIStatements stores statements posted by xAPI content: POST takes one
+// statement or an array and returns their ids, PUT takes one with its id
+// in the statementId parameter.
+func (h *ScormHandler) XAPIStatements(c *gin.Context) {
+	enrollment, lesson, ok := h.xapiLesson(c)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil || len(body) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidStatement.Error()})
+		return
+	}
+
+	ids, err := services.NewScormService(h.db, h.storage).RecordStatements(enrollment, lesson, body, c.Query("statementId"), time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidStatement):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrLessonLocked):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrLessonNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store statements"})
+		}
+		return
+	}
+	if c.Request.Method == http.MethodPut {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, ids)
+}
+
+// XAPIState serves the xAPI state API, which content uses to bookmark and
+// resume. Documents are kept on the student's lesson progress.
+func (h *ScormHandler) XAPIState(c *gin.Context) {
+	enrollment, lesson, ok := h.xapiLesson(c)
+	if !ok {
+		return
+	}
+	stateID := c.Query("stateId")
+	if stateID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "stateId is required"})
+		return
+	}
+	svc := services.NewScormService(h.db, h.storage)
+
+	switch c.Request.Method {
+	case http.MethodGet:
+		value, found := svc.State(enrollment.ID, lesson.ID, stateID)
+		if !found {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		contentType := "application/octet-stream"
+		if json.Valid([]byte(value)) {
+			contentType = "application/json"
+		}
+		c.Data(http.StatusOK, contentType, []byte(value))
+	case http.MethodDelete:
+		if err := svc.SaveState(enrollment, lesson.ID, stateID, nil, time.Now()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete state"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	default:
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		value := string(body)
+		if err := svc.SaveState(enrollment, lesson.ID, stateID, &value, time.Now()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save state"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
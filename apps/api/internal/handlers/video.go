@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/config"
+	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/services"
+	"gritcms/apps/api/internal/storage"
+)
+
+// VideoHandler serves hosted lesson videos to enrolled students.
+type VideoHandler struct {
+	db      *gorm.DB
+	storage *storage.Storage
+	cfg     *config.Config
+}
+
+// NewVideoHandler creates a new VideoHandler.
+func NewVideoHandler(db *gorm.DB, storage *storage.Storage, cfg *config.Config) *VideoHandler {
+	return &VideoHandler{db: db, storage: storage, cfg: cfg}
+}
+
+// StudentPlayback returns short-lived playback URLs for a lesson's hosted
+// video. The student must be enrolled and the lesson unlocked.
+func (h *VideoHandler) StudentPlayback(c *gin.Context) {
+	courseID, _ := strconv.Atoi(c.Param("id"))
+	lessonID, _ := strconv.Atoi(c.Param("lessonId"))
+	user, _ := c.Get("user")
+	u := user.(models.User)
+
+	courses := services.NewCourseService(h.db)
+	enrollment, err := courses.StudentEnrollment(u.Email, uint(courseID))
+	if err != nil {
+		if errors.Is(err, services.ErrMembershipRequired) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not enrolled in this course"})
+		return
+	}
+	if err := courses.CheckUnlocked(enrollment, uint(lessonID), time.Now()); err != nil {
+		if errors.Is(err, services.ErrLessonLocked) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
+		return
+	}
+
+	var lesson models.Lesson
+	if err := h.db.Preload("MediaAsset").First(&lesson, lessonID).Error; err != nil || lesson.MediaAsset == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "This lesson has no hosted video"})
+		return
+	}
+
+	playback, err := services.NewVideoService(h.db, h.storage).Playback(c.Request.Context(), lesson.MediaAsset, h.cfg.AppURL, h.cfg.VideoSecret, time.Now())
+	if err != nil {
+		if errors.Is(err, services.ErrVideoNotReady) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign video URL"})
+		return
+	}
+
+	// Resume where the student left off
+	var progress models.LessonProgress
+	position := 0
+	if h.db.Where("enrollment_id = ? AND lesson_id = ?", enrollment.ID, lessonID).First(&progress).Error == nil {
+		position = progress.LastPositionSeconds
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"playback":              playback,
+		"last_position_seconds": position,
+	}})
+}
+
+// Playlist serves an HLS playlist for a playback token, with segments
+// rewritten to signed storage URLs. Players can't send auth headers for
+// every request, so the token in the path is the credential.
+func (h *VideoHandler) Playlist(c *gin.Context) {
+	assetID, err := services.ParsePlaybackToken(h.cfg.VideoSecret, c.Param("token"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	var asset models.MediaAsset
+	if err := h.db.First(&asset, assetID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+
+	playlist, err := services.NewVideoService(h.db, h.storage).Playlist(c.Request.Context(), &asset, c.Param("file"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPlaylistPath) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Playlist not found"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, no-store")
+	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", playlist)
+}
@@ -3,6 +3,7 @@ package jobs
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/hibiken/asynq"
 )
@@ -14,6 +15,17 @@ const (
 	TypeTokensCleanup   = "tokens:cleanup"
 	TypeCampaignProcess        = "campaign:process"
 	TypeCampaignCheckScheduled = "campaign:check-scheduled"
+	TypeInvoiceGenerate        = "invoice:generate"
+	TypeCheckoutRecover        = "checkout:recover"
+	TypeMetricsSnapshot        = "metrics:snapshot"
+	TypeAffiliateRelease       = "affiliates:release-commissions"
+	TypeCourseDripNotify       = "courses:drip-notify"
+	TypeCertificateIssue       = "certificate:issue"
+	TypeVideoTranscode         = "video:transcode"
+	TypeAssignmentNotify       = "courses:assignment-notify"
+	TypeMembershipExpire       = "memberships:expire"
+	TypeScormImport            = "courses:scorm-import"
+	TypeSubscriptionRenew      = "subscriptions:renew"
 )
 
 // Client wraps asynq.Client for enqueuing background jobs.
@@ -120,3 +132,131 @@ func (c *Client) EnqueueTokensCleanup() error {
 	}
 	return nil
 }
+
+// InvoicePayload holds the data for an invoice generation job.
+type InvoicePayload struct {
+	OrderID uint `json:"order_id"`
+}
+
+// EnqueueInvoiceGenerate enqueues generation (and emailing) of an order's invoice.
+func (c *Client) EnqueueInvoiceGenerate(orderID uint) error {
+	payload, err := json.Marshal(InvoicePayload{OrderID: orderID})
+	if err != nil {
+		return fmt.Errorf("marshaling invoice payload: %w", err)
+	}
+
+	task := asynq.NewTask(TypeInvoiceGenerate, payload)
+	_, err = c.client.Enqueue(task, asynq.MaxRetry(5))
+	if err != nil {
+		return fmt.Errorf("enqueuing invoice job: %w", err)
+	}
+	return nil
+}
+
+// CertificatePayload holds the data for a certificate issue job. Course
+// certificates are found by enrollment, learning path certificates by ID.
+type CertificatePayload struct {
+	EnrollmentID  uint `json:"enrollment_id,omitempty"`
+	CertificateID uint `json:"certificate_id,omitempty"`
+}
+
+// EnqueueCertificateIssue enqueues rendering (and emailing) of the
+// certificate for a completed enrollment.
+func (c *Client) EnqueueCertificateIssue(enrollmentID uint) error {
+	payload, err := json.Marshal(CertificatePayload{EnrollmentID: enrollmentID})
+	if err != nil {
+		return fmt.Errorf("marshaling certificate payload: %w", err)
+	}
+
+	task := asynq.NewTask(TypeCertificateIssue, payload)
+	_, err = c.client.Enqueue(task, asynq.MaxRetry(5))
+	if err != nil {
+		return fmt.Errorf("enqueuing certificate job: %w", err)
+	}
+	return nil
+}
+
+// EnqueuePathCertificateIssue enqueues rendering (and emailing) of a
+// learning path certificate.
+func (c *Client) EnqueuePathCertificateIssue(certificateID uint) error {
+	payload, err := json.Marshal(CertificatePayload{CertificateID: certificateID})
+	if err != nil {
+		return fmt.Errorf("marshaling certificate payload: %w", err)
+	}
+
+	task := asynq.NewTask(TypeCertificateIssue, payload)
+	_, err = c.client.Enqueue(task, asynq.MaxRetry(5))
+	if err != nil {
+		return fmt.Errorf("enqueuing certificate job: %w", err)
+	}
+	return nil
+}
+
+// VideoPayload holds the data for a video transcode job.
+type VideoPayload struct {
+	AssetID uint `json:"asset_id"`
+}
+
+// EnqueueTranscodeVideo enqueues HLS transcoding of an uploaded video.
+func (c *Client) EnqueueTranscodeVideo(assetID uint) error {
+	payload, err := json.Marshal(VideoPayload{AssetID: assetID})
+	if err != nil {
+		return fmt.Errorf("marshaling video payload: %w", err)
+	}
+
+	task := asynq.NewTask(TypeVideoTranscode, payload)
+	_, err = c.client.Enqueue(task, asynq.MaxRetry(2), asynq.Queue("low"), asynq.Timeout(2*time.Hour))
+	if err != nil {
+		return fmt.Errorf("enqueuing video job: %w", err)
+	}
+	return nil
+}
+
+// ScormPayload holds the data for a SCORM package import job.
+type ScormPayload struct {
+	PackageID uint `json:"package_id"`
+}
+
+// EnqueueScormImport enqueues unpacking a SCORM or xAPI package into its
+// course.
+func (c *Client) EnqueueScormImport(packageID uint) error {
+	payload, err := json.Marshal(ScormPayload{PackageID: packageID})
+	if err != nil {
+		return fmt.Errorf("marshaling scorm payload: %w", err)
+	}
+
+	task := asynq.NewTask(TypeScormImport, payload)
+	_, err = c.client.Enqueue(task, asynq.MaxRetry(2), asynq.Queue("low"), asynq.Timeout(30*time.Minute))
+	if err != nil {
+		return fmt.Errorf("enqueuing scorm import job: %w", err)
+	}
+	return nil
+}
+
+// Assignment notification kinds.
+const (
+	AssignmentSubmitted = "submitted" // tell the instructor
+	AssignmentGraded    = "graded"    // tell the student
+)
+
+// AssignmentPayload holds the data for an assignment notification job.
+type AssignmentPayload struct {
+	SubmissionID uint   `json:"submission_id"`
+	Kind         string `json:"kind"`
+}
+
+// EnqueueAssignmentNotify enqueues an email about a submission to its
+// instructor or student.
+func (c *Client) EnqueueAssignmentNotify(submissionID uint, kind string) error {
+	payload, err := json.Marshal(AssignmentPayload{SubmissionID: submissionID, Kind: kind})
+	if err != nil {
+		return fmt.Errorf("marshaling assignment payload: %w", err)
+	}
+
+	task := asynq.NewTask(TypeAssignmentNotify, payload)
+	_, err = c.client.Enqueue(task, asynq.MaxRetry(3))
+	if err != nil {
+		return fmt.Errorf("enqueuing assignment job: %w", err)
+	}
+	return nil
+}
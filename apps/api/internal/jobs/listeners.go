@@ -0,0 +1,70 @@
+package jobs
+
+import (
+	"log"
+
+	"gritcms/apps/api/internal/events"
+	"gritcms/apps/api/internal/models"
+)
+
+// RegisterEventListeners enqueues background jobs in response to domain events.
+func RegisterEventListeners(c *Client) {
+	bus := events.Default()
+
+	// Issue and email the invoice once an order is paid
+	bus.On(events.PurchaseCompleted, func(data interface{}) {
+		m, ok := data.(map[string]interface{})
+		if !ok {
+			return
+		}
+		orderID, _ := m["order_id"].(uint)
+		if orderID == 0 {
+			return
+		}
+		if err := c.EnqueueInvoiceGenerate(orderID); err != nil {
+			log.Printf("[jobs] Failed to enqueue invoice for order %d: %v", orderID, err)
+		}
+	})
+
+	// Render and email the certificate once a course is completed
+	bus.On(events.CourseCompleted, func(data interface{}) {
+		enrollment, ok := data.(models.CourseEnrollment)
+		if !ok {
+			return
+		}
+		if err := c.EnqueueCertificateIssue(enrollment.ID); err != nil {
+			log.Printf("[jobs] Failed to enqueue certificate for enrollment %d: %v", enrollment.ID, err)
+		}
+	})
+
+	// Render and email the path certificate once a learning path is completed
+	bus.On(events.LearningPathCompleted, func(data interface{}) {
+		enrollment, ok := data.(models.LearningPathEnrollment)
+		if !ok || enrollment.CertificateID == nil {
+			return
+		}
+		if err := c.EnqueuePathCertificateIssue(*enrollment.CertificateID); err != nil {
+			log.Printf("[jobs] Failed to enqueue certificate for learning path enrollment %d: %v", enrollment.ID, err)
+		}
+	})
+
+	// Tell the instructor about new submissions and the student about grades
+	bus.On(events.CourseAssignmentSubmitted, func(data interface{}) {
+		submission, ok := data.(models.AssignmentSubmission)
+		if !ok {
+			return
+		}
+		if err := c.EnqueueAssignmentNotify(submission.ID, AssignmentSubmitted); err != nil {
+			log.Printf("[jobs] Failed to enqueue submission notice %d: %v", submission.ID, err)
+		}
+	})
+	bus.On(events.CourseAssignmentGraded, func(data interface{}) {
+		submission, ok := data.(models.AssignmentSubmission)
+		if !ok {
+			return
+		}
+		if err := c.EnqueueAssignmentNotify(submission.ID, AssignmentGraded); err != nil {
+			log.Printf("[jobs] Failed to enqueue grade notice %d: %v", submission.ID, err)
+		}
+	})
+}
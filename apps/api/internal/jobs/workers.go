@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -17,17 +18,25 @@ import (
 	"gritcms/apps/api/internal/cache"
 	"gritcms/apps/api/internal/mail"
 	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/payments"
+	"gritcms/apps/api/internal/services"
 	"gritcms/apps/api/internal/storage"
 )
 
 // WorkerDeps holds dependencies needed by job handlers.
 type WorkerDeps struct {
-	DB      *gorm.DB
-	Mailer  *mail.Mailer
-	Storage *storage.Storage
-	Cache   *cache.Cache
-	Jobs    *Client
-	AppURL  string // Base API URL for generating links (e.g. unsubscribe URLs)
+	DB       *gorm.DB
+	Mailer   *mail.Mailer
+	Storage  *storage.Storage
+	Cache    *cache.Cache
+	Jobs     *Client
+	Payments *payments.Registry // charges subscription renewals
+	AppURL   string             // Base API URL for generating links (e.g. unsubscribe URLs)
+	WebURL   string             // Public web frontend URL for customer-facing links
+	AppName  string
+
+	FFmpegPath  string // ffmpeg binary for video transcoding
+	FFprobePath string
 }
 
 // StartWorker starts the asynq worker server in a goroutine.
@@ -53,6 +62,17 @@ func StartWorker(redisURL string, deps WorkerDeps) (func(), error) {
 	mux.HandleFunc(TypeTokensCleanup, handleTokensCleanup(deps))
 	mux.HandleFunc(TypeCampaignProcess, handleCampaignProcess(deps))
 	mux.HandleFunc(TypeCampaignCheckScheduled, handleCampaignCheckScheduled(deps))
+	mux.HandleFunc(TypeInvoiceGenerate, handleInvoiceGenerate(deps))
+	mux.HandleFunc(TypeCheckoutRecover, handleCheckoutRecover(deps))
+	mux.HandleFunc(TypeMetricsSnapshot, handleMetricsSnapshot(deps))
+	mux.HandleFunc(TypeAffiliateRelease, handleAffiliateRelease(deps))
+	mux.HandleFunc(TypeCourseDripNotify, handleCourseDripNotify(deps))
+	mux.HandleFunc(TypeCertificateIssue, handleCertificateIssue(deps))
+	mux.HandleFunc(TypeVideoTranscode, handleVideoTranscode(deps))
+	mux.HandleFunc(TypeAssignmentNotify, handleAssignmentNotify(deps))
+	mux.HandleFunc(TypeMembershipExpire, handleMembershipExpire(deps))
+	mux.HandleFunc(TypeScormImport, handleScormImport(deps))
+	mux.HandleFunc(TypeSubscriptionRenew, handleSubscriptionRenew(deps))
 
 	go func() {
 		if err := srv.Run(mux); err != nil {
@@ -87,6 +107,420 @@ func handleEmailSend(deps WorkerDeps) func(ctx context.Context, task *asynq.Task
 	}
 }
 
+func handleInvoiceGenerate(deps WorkerDeps) func(ctx context.Context, task *asynq.Task) error {
+	return func(ctx context.Context, task *asynq.Task) error {
+		if deps.DB == nil {
+			return fmt.Errorf("database not configured")
+		}
+
+		var payload InvoicePayload
+		if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+			return fmt.Errorf("unmarshaling invoice payload: %w", err)
+		}
+
+		invoiceSvc := services.NewInvoiceService(deps.DB, deps.Storage)
+		invoice, err := invoiceSvc.IssueInvoice(ctx, payload.OrderID)
+		if err != nil {
+			return fmt.Errorf("issuing invoice for order %d: %w", payload.OrderID, err)
+		}
+
+		// Email once — retries after a successful send must not re-send
+		if invoice.EmailedAt != nil || deps.Mailer == nil {
+			return nil
+		}
+
+		var order models.Order
+		if err := deps.DB.Preload("Contact").First(&order, invoice.OrderID).Error; err != nil {
+			return fmt.Errorf("loading order %d: %w", invoice.OrderID, err)
+		}
+		if order.Contact == nil || order.Contact.Email == "" {
+			return nil
+		}
+
+		pdf, err := invoiceSvc.PDF(ctx, invoice)
+		if err != nil {
+			return fmt.Errorf("loading invoice PDF: %w", err)
+		}
+
+		err = deps.Mailer.Send(ctx, mail.SendOptions{
+			To:       order.Contact.Email,
+			Subject:  fmt.Sprintf("Your invoice %s", invoice.InvoiceNumber),
+			Template: "invoice",
+			Data:     services.InvoiceEmailData(invoice, &order, siteName(deps, invoice.TenantID), deps.WebURL),
+			Attachments: []mail.Attachment{
+				{Filename: invoice.InvoiceNumber + ".pdf", Content: pdf},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("emailing invoice %s: %w", invoice.InvoiceNumber, err)
+		}
+
+		now := time.Now()
+		deps.DB.Model(&models.Invoice{}).Where("id = ?", invoice.ID).Update("emailed_at", now)
+		log.Printf("Invoice %s emailed to %s", invoice.InvoiceNumber, order.Contact.Email)
+		return nil
+	}
+}
+
+// handleCheckoutRecover runs the abandoned checkout pipeline: detect newly
+// abandoned checkouts, send due recovery emails, then expire stale orders.
+func handleCheckoutRecover(deps WorkerDeps) func(ctx context.Context, task *asynq.Task) error {
+	return func(ctx context.Context, task *asynq.Task) error {
+		if deps.DB == nil {
+			return fmt.Errorf("database not configured")
+		}
+
+		const tenantID = 1
+		now := time.Now()
+		svc := services.NewCheckoutRecoveryService(deps.DB)
+
+		abandoned, err := svc.DetectAbandoned(tenantID, now)
+		if err != nil {
+			return fmt.Errorf("detecting abandoned checkouts: %w", err)
+		}
+		if len(abandoned) > 0 {
+			log.Printf("Detected %d abandoned checkouts", len(abandoned))
+		}
+
+		if deps.Mailer != nil {
+			due, err := svc.DueEmails(tenantID, now)
+			if err != nil {
+				log.Printf("Checkout recovery: %v", err)
+			}
+			appName := siteName(deps, tenantID)
+			for _, email := range due {
+				subject := "You left something in your cart"
+				if email.Recovery.CouponCode != "" {
+					subject = "A little something to finish your order"
+				} else if email.Step > 1 {
+					subject = "Your order is still waiting"
+				}
+				err := deps.Mailer.Send(ctx, mail.SendOptions{
+					To:       email.Recovery.Email,
+					Subject:  subject,
+					Template: "checkout-recovery",
+					Data:     services.RecoveryEmailData(email, appName, deps.WebURL),
+				})
+				if err != nil {
+					log.Printf("Failed to send recovery email for order %d: %v", email.Order.ID, err)
+					continue
+				}
+				if err := svc.MarkEmailSent(email.Recovery, email.Step, now); err != nil {
+					log.Printf("Failed to record recovery email for order %d: %v", email.Order.ID, err)
+				}
+			}
+		}
+
+		expired, err := svc.ExpireStale(tenantID, now)
+		if err != nil {
+			return fmt.Errorf("expiring stale orders: %w", err)
+		}
+		if expired > 0 {
+			log.Printf("Expired %d stale pending orders", expired)
+		}
+		return nil
+	}
+}
+
+// metricsBackfillDays is how far back the first metrics snapshot run reaches.
+const metricsBackfillDays = 90
+
+func handleMetricsSnapshot(deps WorkerDeps) func(ctx context.Context, task *asynq.Task) error {
+	return func(ctx context.Context, task *asynq.Task) error {
+		if deps.DB == nil {
+			return fmt.Errorf("database not configured")
+		}
+
+		const tenantID = 1
+		days, err := services.NewSaaSMetricsService(deps.DB).CatchUp(tenantID, time.Now(), metricsBackfillDays)
+		if err != nil {
+			return fmt.Errorf("snapshotting metrics: %w", err)
+		}
+		if days > 0 {
+			log.Printf("Snapshotted SaaS metrics for %d days", days)
+		}
+		return nil
+	}
+}
+
+// handleAffiliateRelease approves affiliate commissions whose hold period
+// has ended.
+func handleAffiliateRelease(deps WorkerDeps) func(ctx context.Context, task *asynq.Task) error {
+	return func(ctx context.Context, task *asynq.Task) error {
+		if deps.DB == nil {
+			return fmt.Errorf("database not configured")
+		}
+
+		released, err := services.NewAffiliateService(deps.DB).ReleaseHeldCommissions(time.Now())
+		if err != nil {
+			return fmt.Errorf("releasing commissions: %w", err)
+		}
+		if released > 0 {
+			log.Printf("Released %d held affiliate commissions", released)
+		}
+		return nil
+	}
+}
+
+// handleSubscriptionRenew charges subscriptions whose billing period has
+// ended and cancels the ones set to end.
+func handleSubscriptionRenew(deps WorkerDeps) func(ctx context.Context, task *asynq.Task) error {
+	return func(ctx context.Context, task *asynq.Task) error {
+		if deps.DB == nil || deps.Payments == nil {
+			return fmt.Errorf("database or payments not configured")
+		}
+
+		renewed, err := services.NewSubscriptionService(deps.DB, deps.Payments).RenewDue(ctx, time.Now())
+		if err != nil {
+			return fmt.Errorf("renewing subscriptions: %w", err)
+		}
+		if renewed > 0 {
+			log.Printf("Started %d subscription renewals", renewed)
+		}
+		return nil
+	}
+}
+
+// handleMembershipExpire marks membership grants past their MembershipDays
+// as expired.
+func handleMembershipExpire(deps WorkerDeps) func(ctx context.Context, task *asynq.Task) error {
+	return func(ctx context.Context, task *asynq.Task) error {
+		if deps.DB == nil {
+			return fmt.Errorf("database not configured")
+		}
+
+		expired, err := services.NewAccessService(deps.DB).ExpireMemberships(time.Now())
+		if err != nil {
+			return fmt.Errorf("expiring memberships: %w", err)
+		}
+		if expired > 0 {
+			log.Printf("Expired %d lapsed memberships", expired)
+		}
+		return nil
+	}
+}
+
+// handleCourseDripNotify emails students about course lessons that have
+// unlocked since their last notice.
+func handleCourseDripNotify(deps WorkerDeps) func(ctx context.Context, task *asynq.Task) error {
+	return func(ctx context.Context, task *asynq.Task) error {
+		if deps.DB == nil {
+			return fmt.Errorf("database not configured")
+		}
+		if deps.Mailer == nil {
+			return nil
+		}
+
+		const tenantID = 1
+		now := time.Now()
+		svc := services.NewCourseService(deps.DB)
+		notices, err := svc.DueUnlockNotices(now)
+		if err != nil {
+			return fmt.Errorf("finding unlocked lessons: %w", err)
+		}
+
+		appName := siteName(deps, tenantID)
+		sent := 0
+		for _, notice := range notices {
+			if notice.Enrollment.Contact.Email == "" {
+				continue
+			}
+			err := deps.Mailer.Send(ctx, mail.SendOptions{
+				To:       notice.Enrollment.Contact.Email,
+				Subject:  fmt.Sprintf("New lessons unlocked in %s", notice.Course.Title),
+				Template: "lesson-unlocked",
+				Data:     services.UnlockEmailData(notice, appName, deps.WebURL),
+			})
+			if err != nil {
+				log.Printf("Failed to send unlock email for enrollment %d: %v", notice.Enrollment.ID, err)
+				continue
+			}
+			svc.MarkUnlockNotified(notice.Enrollment.ID, now)
+			sent++
+		}
+		if sent > 0 {
+			log.Printf("Sent %d lesson unlock emails", sent)
+		}
+		return nil
+	}
+}
+
+func handleCertificateIssue(deps WorkerDeps) func(ctx context.Context, task *asynq.Task) error {
+	return func(ctx context.Context, task *asynq.Task) error {
+		if deps.DB == nil {
+			return fmt.Errorf("database not configured")
+		}
+
+		var payload CertificatePayload
+		if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+			return fmt.Errorf("unmarshaling certificate payload: %w", err)
+		}
+
+		var found models.Certificate
+		if payload.CertificateID != 0 {
+			if err := deps.DB.First(&found, payload.CertificateID).Error; err != nil {
+				return fmt.Errorf("certificate %d not found: %w", payload.CertificateID, err)
+			}
+		} else if err := deps.DB.Where("enrollment_id = ? AND learning_path_id IS NULL", payload.EnrollmentID).First(&found).Error; err != nil {
+			return fmt.Errorf("no certificate for enrollment %d: %w", payload.EnrollmentID, err)
+		}
+
+		certSvc := services.NewCertificateService(deps.DB, deps.Storage, deps.WebURL)
+		cert, err := certSvc.Issue(ctx, found.ID)
+		if err != nil {
+			return fmt.Errorf("issuing certificate %s: %w", found.CertificateNumber, err)
+		}
+
+		// Email once — retries after a successful send must not re-send
+		if cert.EmailedAt != nil || deps.Mailer == nil || cert.Contact.Email == "" {
+			return nil
+		}
+
+		pdf, err := certSvc.PDF(ctx, cert)
+		if err != nil {
+			return fmt.Errorf("loading certificate PDF: %w", err)
+		}
+
+		err = deps.Mailer.Send(ctx, mail.SendOptions{
+			To:       cert.Contact.Email,
+			Subject:  fmt.Sprintf("Your certificate for %s", cert.Title()),
+			Template: "certificate",
+			Data:     services.CertificateEmailData(cert, siteName(deps, cert.TenantID), certSvc.VerifyURL(cert.CertificateNumber)),
+			Attachments: []mail.Attachment{
+				{Filename: cert.CertificateNumber + ".pdf", Content: pdf},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("emailing certificate %s: %w", cert.CertificateNumber, err)
+		}
+
+		now := time.Now()
+		deps.DB.Model(&models.Certificate{}).Where("id = ?", cert.ID).Update("emailed_at", now)
+		log.Printf("Certificate %s emailed to %s", cert.CertificateNumber, cert.Contact.Email)
+		return nil
+	}
+}
+
+// handleVideoTranscode renders an uploaded video into HLS renditions.
+func handleVideoTranscode(deps WorkerDeps) func(ctx context.Context, task *asynq.Task) error {
+	return func(ctx context.Context, task *asynq.Task) error {
+		if deps.DB == nil || deps.Storage == nil {
+			return fmt.Errorf("database or storage not configured")
+		}
+
+		var payload VideoPayload
+		if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+			return fmt.Errorf("unmarshaling video payload: %w", err)
+		}
+
+		ffmpeg, ffprobe := deps.FFmpegPath, deps.FFprobePath
+		if ffmpeg == "" {
+			ffmpeg = "ffmpeg"
+		}
+		if ffprobe == "" {
+			ffprobe = "ffprobe"
+		}
+		if err := services.NewVideoService(deps.DB, deps.Storage).Transcode(ctx, payload.AssetID, ffmpeg, ffprobe); err != nil {
+			return fmt.Errorf("transcoding video %d: %w", payload.AssetID, err)
+		}
+		log.Printf("Transcoded video %d to HLS", payload.AssetID)
+		return nil
+	}
+}
+
+// handleScormImport unpacks an uploaded SCORM or xAPI package and creates
+// its lessons.
+func handleScormImport(deps WorkerDeps) func(ctx context.Context, task *asynq.Task) error {
+	return func(ctx context.Context, task *asynq.Task) error {
+		if deps.DB == nil || deps.Storage == nil {
+			return fmt.Errorf("database or storage not configured")
+		}
+
+		var payload ScormPayload
+		if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+			return fmt.Errorf("unmarshaling scorm payload: %w", err)
+		}
+
+		err := services.NewScormService(deps.DB, deps.Storage).Import(ctx, payload.PackageID)
+		if errors.Is(err, services.ErrInvalidPackage) {
+			// A broken package won't fix itself; the error is on the package
+			log.Printf("SCORM package %d rejected: %v", payload.PackageID, err)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("importing scorm package %d: %w", payload.PackageID, err)
+		}
+		log.Printf("Imported SCORM package %d", payload.PackageID)
+		return nil
+	}
+}
+
+// handleAssignmentNotify emails the course instructor about a new
+// submission, or the student about their grade.
+func handleAssignmentNotify(deps WorkerDeps) func(ctx context.Context, task *asynq.Task) error {
+	return func(ctx context.Context, task *asynq.Task) error {
+		if deps.DB == nil {
+			return fmt.Errorf("database not configured")
+		}
+		if deps.Mailer == nil {
+			return nil
+		}
+
+		var payload AssignmentPayload
+		if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+			return fmt.Errorf("unmarshaling assignment payload: %w", err)
+		}
+
+		var submission models.AssignmentSubmission
+		if err := deps.DB.Preload("Assignment").Preload("Enrollment.Contact").Preload("Enrollment.Course.Instructor").
+			First(&submission, payload.SubmissionID).Error; err != nil {
+			return fmt.Errorf("loading submission %d: %w", payload.SubmissionID, err)
+		}
+		appName := siteName(deps, submission.TenantID)
+
+		opts := mail.SendOptions{}
+		switch payload.Kind {
+		case AssignmentSubmitted:
+			instructor := submission.Enrollment.Course.Instructor
+			if instructor == nil || instructor.Email == "" {
+				return nil
+			}
+			opts = mail.SendOptions{
+				To:       instructor.Email,
+				Subject:  fmt.Sprintf("New submission for %s", submission.Assignment.Title),
+				Template: "assignment-submitted",
+				Data:     services.SubmittedEmailData(&submission, appName),
+			}
+		case AssignmentGraded:
+			if submission.Enrollment.Contact.Email == "" {
+				return nil
+			}
+			opts = mail.SendOptions{
+				To:       submission.Enrollment.Contact.Email,
+				Subject:  fmt.Sprintf("Your submission for %s has been graded", submission.Assignment.Title),
+				Template: "assignment-graded",
+				Data:     services.GradedEmailData(&submission, appName, deps.WebURL),
+			}
+		default:
+			return fmt.Errorf("unknown assignment notice %q", payload.Kind)
+		}
+
+		if err := deps.Mailer.Send(ctx, opts); err != nil {
+			return fmt.Errorf("emailing assignment notice for submission %d: %w", submission.ID, err)
+		}
+		return nil
+	}
+}
+
+// siteName returns the tenant's site name setting, falling back to AppName.
+func siteName(deps WorkerDeps, tenantID uint) string {
+	var setting models.Setting
+	if err := deps.DB.Where("key = ? AND tenant_id = ?", "site_name", tenantID).First(&setting).Error; err == nil && setting.Value != "" {
+		return setting.Value
+	}
+	return deps.AppName
+}
+
 func handleImageProcess(deps WorkerDeps) func(ctx context.Context, task *asynq.Task) error {
 	return func(ctx context.Context, task *asynq.Task) error {
 		if deps.Storage == nil {
@@ -3,6 +3,7 @@ package mail
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"html/template"
@@ -30,8 +31,15 @@ func New(apiKey, from string) *Mailer {
 type SendOptions struct {
 	To       string
 	Subject  string
-	Template string
-	Data     map[string]interface{}
+	Template    string
+	Data        map[string]interface{}
+	Attachments []Attachment
+}
+
+// Attachment is a file sent along with an email (e.g. an invoice PDF).
+type Attachment struct {
+	Filename string
+	Content  []byte
 }
 
 // Send renders a template and sends the email via Resend.
@@ -49,6 +57,16 @@ func (m *Mailer) Send(ctx context.Context, opts SendOptions) error {
 		"subject": opts.Subject,
 		"html":    htmlBody,
 	}
+	if len(opts.Attachments) > 0 {
+		attachments := make([]map[string]string, 0, len(opts.Attachments))
+		for _, a := range opts.Attachments {
+			attachments = append(attachments, map[string]string{
+				"filename": a.Filename,
+				"content":  base64.StdEncoding.EncodeToString(a.Content),
+			})
+		}
+		payload["attachments"] = attachments
+	}
 
 	body, err := json.Marshal(payload)
 	if err != nil {
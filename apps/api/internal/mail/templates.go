@@ -7,6 +7,12 @@ var EmailTemplates = map[string]string{
 	"email-verification":   emailVerificationTemplate,
 	"notification":         notificationTemplate,
 	"subscription-confirm": subscriptionConfirmTemplate,
+	"invoice":              invoiceTemplate,
+	"checkout-recovery":    checkoutRecoveryTemplate,
+	"lesson-unlocked":      lessonUnlockedTemplate,
+	"certificate":          certificateTemplate,
+	"assignment-submitted": assignmentSubmittedTemplate,
+	"assignment-graded":    assignmentGradedTemplate,
 }
 
 const baseLayout = `<!DOCTYPE html>
@@ -211,3 +217,217 @@ const subscriptionConfirmTemplate = `<!DOCTYPE html>
   </div>
 </body>
 </html>`
+
+
+const invoiceTemplate = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <style>
+    body { margin: 0; padding: 0; background-color: #0a0a0f; color: #e8e8f0; font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; }
+    .container { max-width: 600px; margin: 0 auto; padding: 40px 20px; }
+    .card { background-color: #111118; border: 1px solid #2a2a3a; border-radius: 12px; padding: 32px; }
+    .logo { text-align: center; margin-bottom: 24px; font-size: 24px; font-weight: 700; color: #6c5ce7; }
+    h1 { font-size: 20px; margin: 0 0 16px; color: #e8e8f0; }
+    p { font-size: 14px; line-height: 1.6; color: #9090a8; margin: 0 0 16px; }
+    .btn { display: inline-block; background-color: #6c5ce7; color: #ffffff; text-decoration: none; padding: 12px 24px; border-radius: 8px; font-weight: 600; font-size: 14px; }
+    .footer { text-align: center; margin-top: 24px; font-size: 12px; color: #606078; }
+  </style>
+</head>
+<body>
+  <div class="container">
+    <div class="card">
+      <div class="logo">{{.AppName}}</div>
+      <h1>Thanks for your purchase</h1>
+      <p>Hi{{if .FirstName}} {{.FirstName}}{{end}},</p>
+      <p>Your invoice <strong style="color: #e8e8f0;">{{.InvoiceNumber}}</strong> for order {{.OrderNumber}} ({{.Total}}) is attached to this email.</p>
+      {{if .ActionURL}}
+      <p style="text-align: center; margin: 24px 0;">
+        <a href="{{.ActionURL}}" class="btn">View Purchase</a>
+      </p>
+      {{end}}
+    </div>
+    <div class="footer">
+      <p>&copy; {{.Year}} {{.AppName}}. All rights reserved.</p>
+    </div>
+  </div>
+</body>
+</html>`
+
+const checkoutRecoveryTemplate = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <style>
+    body { margin: 0; padding: 0; background-color: #0a0a0f; color: #e8e8f0; font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; }
+    .container { max-width: 600px; margin: 0 auto; padding: 40px 20px; }
+    .card { background-color: #111118; border: 1px solid #2a2a3a; border-radius: 12px; padding: 32px; }
+    .logo { text-align: center; margin-bottom: 24px; font-size: 24px; font-weight: 700; color: #6c5ce7; }
+    h1 { font-size: 20px; margin: 0 0 16px; color: #e8e8f0; }
+    p { font-size: 14px; line-height: 1.6; color: #9090a8; margin: 0 0 16px; }
+    .btn { display: inline-block; background-color: #6c5ce7; color: #ffffff; text-decoration: none; padding: 12px 24px; border-radius: 8px; font-weight: 600; font-size: 14px; }
+    .code { display: inline-block; border: 1px dashed #6c5ce7; border-radius: 8px; padding: 8px 16px; font-size: 16px; font-weight: 700; letter-spacing: 2px; color: #e8e8f0; }
+    .footer { text-align: center; margin-top: 24px; font-size: 12px; color: #606078; }
+  </style>
+</head>
+<body>
+  <div class="container">
+    <div class="card">
+      <div class="logo">{{.AppName}}</div>
+      <h1>{{if .Last}}Last chance to complete your order{{else}}You left something behind{{end}}</h1>
+      <p>Hi{{if .FirstName}} {{.FirstName}}{{end}},</p>
+      <p>Your checkout for <strong style="color: #e8e8f0;">{{.Items}}</strong> ({{.Total}}) wasn't completed. Pick up right where you left off.</p>
+      {{if .CouponCode}}
+      <p>Use this code to get a discount on your order:</p>
+      <p style="text-align: center;"><span class="code">{{.CouponCode}}</span></p>
+      {{end}}
+      <p style="text-align: center; margin: 24px 0;">
+        <a href="{{.ActionURL}}" class="btn">Complete Your Order</a>
+      </p>
+    </div>
+    <div class="footer">
+      <p>&copy; {{.Year}} {{.AppName}}. All rights reserved.</p>
+    </div>
+  </div>
+</body>
+</html>`
+
+const lessonUnlockedTemplate = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <style>
+    body { margin: 0; padding: 0; background-color: #0a0a0f; color: #e8e8f0; font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; }
+    .container { max-width: 600px; margin: 0 auto; padding: 40px 20px; }
+    .card { background-color: #111118; border: 1px solid #2a2a3a; border-radius: 12px; padding: 32px; }
+    .logo { text-align: center; margin-bottom: 24px; font-size: 24px; font-weight: 700; color: #6c5ce7; }
+    h1 { font-size: 20px; margin: 0 0 16px; color: #e8e8f0; }
+    p { font-size: 14px; line-height: 1.6; color: #9090a8; margin: 0 0 16px; }
+    ul { font-size: 14px; line-height: 1.8; color: #e8e8f0; margin: 0 0 16px; padding-left: 20px; }
+    .btn { display: inline-block; background-color: #6c5ce7; color: #ffffff; text-decoration: none; padding: 12px 24px; border-radius: 8px; font-weight: 600; font-size: 14px; }
+    .footer { text-align: center; margin-top: 24px; font-size: 12px; color: #606078; }
+  </style>
+</head>
+<body>
+  <div class="container">
+    <div class="card">
+      <div class="logo">{{.AppName}}</div>
+      <h1>New lessons are ready</h1>
+      <p>Hi{{if .FirstName}} {{.FirstName}}{{end}},</p>
+      <p>The following lessons in <strong style="color: #e8e8f0;">{{.CourseTitle}}</strong> are now unlocked:</p>
+      <ul>
+        {{range .Lessons}}<li>{{.}}</li>{{end}}
+      </ul>
+      <p style="text-align: center; margin: 24px 0;">
+        <a href="{{.ActionURL}}" class="btn">Continue Learning</a>
+      </p>
+    </div>
+    <div class="footer">
+      <p>&copy; {{.Year}} {{.AppName}}. All rights reserved.</p>
+    </div>
+  </div>
+</body>
+</html>`
+
+const certificateTemplate = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <style>
+    body { margin: 0; padding: 0; background-color: #0a0a0f; color: #e8e8f0; font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; }
+    .container { max-width: 600px; margin: 0 auto; padding: 40px 20px; }
+    .card { background-color: #111118; border: 1px solid #2a2a3a; border-radius: 12px; padding: 32px; }
+    .logo { text-align: center; margin-bottom: 24px; font-size: 24px; font-weight: 700; color: #6c5ce7; }
+    h1 { font-size: 20px; margin: 0 0 16px; color: #e8e8f0; }
+    p { font-size: 14px; line-height: 1.6; color: #9090a8; margin: 0 0 16px; }
+    .btn { display: inline-block; background-color: #6c5ce7; color: #ffffff; text-decoration: none; padding: 12px 24px; border-radius: 8px; font-weight: 600; font-size: 14px; }
+    .footer { text-align: center; margin-top: 24px; font-size: 12px; color: #606078; }
+  </style>
+</head>
+<body>
+  <div class="container">
+    <div class="card">
+      <div class="logo">{{.AppName}}</div>
+      <h1>Congratulations{{if .FirstName}}, {{.FirstName}}{{end}}!</h1>
+      <p>You've completed <strong style="color: #e8e8f0;">{{.CourseTitle}}</strong>. Your certificate is attached to this email.</p>
+      <p>Certificate number: <strong style="color: #e8e8f0;">{{.CertificateNumber}}</strong></p>
+      <p style="text-align: center; margin: 24px 0;">
+        <a href="{{.ActionURL}}" class="btn">Verify Certificate</a>
+      </p>
+    </div>
+    <div class="footer">
+      <p>&copy; {{.Year}} {{.AppName}}. All rights reserved.</p>
+    </div>
+  </div>
+</body>
+</html>`
+
+const assignmentSubmittedTemplate = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <style>
+    body { margin: 0; padding: 0; background-color: #0a0a0f; color: #e8e8f0; font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; }
+    .container { max-width: 600px; margin: 0 auto; padding: 40px 20px; }
+    .card { background-color: #111118; border: 1px solid #2a2a3a; border-radius: 12px; padding: 32px; }
+    .logo { text-align: center; margin-bottom: 24px; font-size: 24px; font-weight: 700; color: #6c5ce7; }
+    h1 { font-size: 20px; margin: 0 0 16px; color: #e8e8f0; }
+    p { font-size: 14px; line-height: 1.6; color: #9090a8; margin: 0 0 16px; }
+    .btn { display: inline-block; background-color: #6c5ce7; color: #ffffff; text-decoration: none; padding: 12px 24px; border-radius: 8px; font-weight: 600; font-size: 14px; }
+    .footer { text-align: center; margin-top: 24px; font-size: 12px; color: #606078; }
+  </style>
+</head>
+<body>
+  <div class="container">
+    <div class="card">
+      <div class="logo">{{.AppName}}</div>
+      <h1>New assignment submission</h1>
+      <p><strong style="color: #e8e8f0;">{{if .StudentName}}{{.StudentName}}{{else}}{{.StudentEmail}}{{end}}</strong> submitted <strong style="color: #e8e8f0;">{{.AssignmentTitle}}</strong> in {{.CourseTitle}}.</p>
+      <p>It's waiting for your review in the submissions queue.</p>
+    </div>
+    <div class="footer">
+      <p>&copy; {{.Year}} {{.AppName}}. All rights reserved.</p>
+    </div>
+  </div>
+</body>
+</html>`
+
+const assignmentGradedTemplate = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <style>
+    body { margin: 0; padding: 0; background-color: #0a0a0f; color: #e8e8f0; font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; }
+    .container { max-width: 600px; margin: 0 auto; padding: 40px 20px; }
+    .card { background-color: #111118; border: 1px solid #2a2a3a; border-radius: 12px; padding: 32px; }
+    .logo { text-align: center; margin-bottom: 24px; font-size: 24px; font-weight: 700; color: #6c5ce7; }
+    h1 { font-size: 20px; margin: 0 0 16px; color: #e8e8f0; }
+    p { font-size: 14px; line-height: 1.6; color: #9090a8; margin: 0 0 16px; }
+    .btn { display: inline-block; background-color: #6c5ce7; color: #ffffff; text-decoration: none; padding: 12px 24px; border-radius: 8px; font-weight: 600; font-size: 14px; }
+    .footer { text-align: center; margin-top: 24px; font-size: 12px; color: #606078; }
+  </style>
+</head>
+<body>
+  <div class="container">
+    <div class="card">
+      <div class="logo">{{.AppName}}</div>
+      <h1>Your assignment has been graded</h1>
+      <p>Hi{{if .FirstName}} {{.FirstName}}{{end}},</p>
+      <p>Your submission for <strong style="color: #e8e8f0;">{{.AssignmentTitle}}</strong> in {{.CourseTitle}} scored <strong style="color: #e8e8f0;">{{.Score}}%</strong>{{if .Passed}} &mdash; you passed!{{else}}. It didn't reach the passing score yet, so take a look at the feedback and resubmit.{{end}}</p>
+      {{if .Feedback}}<p style="border-left: 3px solid #6c5ce7; padding-left: 12px; color: #e8e8f0;">{{.Feedback}}</p>{{end}}
+      <p style="text-align: center; margin: 24px 0;">
+        <a href="{{.ActionURL}}" class="btn">View Feedback</a>
+      </p>
+    </div>
+    <div class="footer">
+      <p>&copy; {{.Year}} {{.AppName}}. All rights reserved.</p>
+    </div>
+  </div>
+</body>
+</html>`
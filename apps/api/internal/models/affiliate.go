@@ -3,6 +3,7 @@ package models
 import (
 	"time"
 
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
@@ -17,44 +18,105 @@ const (
 	CommissionApproved = "approved"
 	CommissionPaid     = "paid"
 	CommissionRejected = "rejected"
+	CommissionReversed = "reversed"
 
 	PayoutPending    = "pending"
 	PayoutProcessing = "processing"
 	PayoutCompleted  = "completed"
+	PayoutFailed     = "failed"
+
+	LedgerEarned     = "earned"
+	LedgerReversal   = "reversal"
+	LedgerPayout     = "payout"
+	LedgerAdjustment = "adjustment"
+
+	PayoutMethodPayPal = "paypal"
+	PayoutMethodBank   = "bank_transfer"
+
+	PayoutBatchDraft     = "draft"
+	PayoutBatchExported  = "exported"
+	PayoutBatchCompleted = "completed"
+
+	FraudSelfReferralIP      = "self_referral_ip"      // buyer's IP matches the affiliate's
+	FraudSelfReferralPayment = "self_referral_payment" // buyer's card matches one the affiliate paid with
+	FraudClickVelocity       = "click_velocity"        // account's clicks in the last hour over the program limit
+	FraudConversionVelocity  = "conversion_velocity"   // account's commissions in the last day over the program limit
 )
 
 type AffiliateProgram struct {
-	ID               uint           `gorm:"primarykey" json:"id"`
-	TenantID         uint           `gorm:"index;not null;default:1" json:"tenant_id"`
-	Name             string         `gorm:"size:255;not null" json:"name"`
-	Description      string         `gorm:"type:text" json:"description"`
-	CommissionType   string         `gorm:"size:20;default:'percentage'" json:"commission_type"` // percentage, fixed
-	CommissionAmount int64          `gorm:"default:0" json:"commission_amount"` // percentage (e.g. 30 = 30%) or cents
-	CookieDays       int            `gorm:"default:30" json:"cookie_days"`
-	MinPayoutAmount  int64          `gorm:"default:5000" json:"min_payout_amount"` // in cents
-	AutoApprove      bool           `gorm:"default:false" json:"auto_approve"`
-	Status           string         `gorm:"size:20;default:'active'" json:"status"`
-	CreatedAt        time.Time      `json:"created_at"`
-	UpdatedAt        time.Time      `json:"updated_at"`
-	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
-
-	Accounts []AffiliateAccount `gorm:"foreignKey:ProgramID" json:"accounts,omitempty"`
+	ID                   uint           `gorm:"primarykey" json:"id"`
+	TenantID             uint           `gorm:"index;not null;default:1" json:"tenant_id"`
+	Name                 string         `gorm:"size:255;not null" json:"name"`
+	Description          string         `gorm:"type:text" json:"description"`
+	CommissionType       string         `gorm:"size:20;default:'percentage'" json:"commission_type"` // percentage, fixed
+	CommissionAmount     int64          `gorm:"default:0" json:"commission_amount"`                  // percentage (e.g. 30 = 30%) or cents
+	CookieDays           int            `gorm:"default:30" json:"cookie_days"`
+	MinPayoutAmount      int64          `gorm:"default:5000" json:"min_payout_amount"` // in cents
+	AutoApprove          bool           `gorm:"default:false" json:"auto_approve"`
+	HoldDays             int            `gorm:"default:30" json:"hold_days"`                          // refund window before a commission can be approved
+	ClickWindowMinutes   int            `gorm:"default:1440" json:"click_window_minutes"`             // repeat clicks from one IP and user agent within this window count once
+	MaxClicksPerHour     int            `gorm:"default:0" json:"max_clicks_per_hour"`                 // unique clicks per account above which commissions are flagged; 0 = off
+	MaxConversionsPerDay int            `gorm:"default:0" json:"max_conversions_per_day"`             // commissions per account above which new ones are flagged; 0 = off
+	Tiers                datatypes.JSON `gorm:"type:jsonb" json:"tiers"`                              // []CommissionTier, by monthly referred revenue
+	RecurringMonths      int            `gorm:"default:0" json:"recurring_months"`                    // commission on subscription renewals for N months; 0 = first payment only
+	SecondTierType       string         `gorm:"size:20;default:'percentage'" json:"second_tier_type"` // percentage, fixed
+	SecondTierAmount     int64          `gorm:"default:0" json:"second_tier_amount"`                  // paid to the recruiting affiliate; 0 = off
+	LinkDomains          datatypes.JSON `gorm:"type:jsonb" json:"link_domains"`                       // []string hosts plain links may point to besides the site
+	Status               string         `gorm:"size:20;default:'active'" json:"status"`
+	CreatedAt            time.Time      `json:"created_at"`
+	UpdatedAt            time.Time      `json:"updated_at"`
+	DeletedAt            gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Accounts         []AffiliateAccount           `gorm:"foreignKey:ProgramID" json:"accounts,omitempty"`
+	ProductOverrides []AffiliateProductCommission `gorm:"foreignKey:ProgramID" json:"product_overrides,omitempty"`
+}
+
+// CommissionTier is a rate that applies once the affiliate's referred
+// revenue in the calendar month (base currency, cents) reaches MinRevenue.
+type CommissionTier struct {
+	MinRevenue       int64  `json:"min_revenue"`
+	CommissionType   string `json:"commission_type"` // percentage, fixed
+	CommissionAmount int64  `json:"commission_amount"`
+}
+
+// AffiliateProductCommission overrides a program's rate for one product.
+// Fixed amounts are per unit sold.
+type AffiliateProductCommission struct {
+	ID               uint      `gorm:"primarykey" json:"id"`
+	TenantID         uint      `gorm:"index;not null;default:1" json:"tenant_id"`
+	ProgramID        uint      `gorm:"uniqueIndex:idx_affiliate_product_commission;not null" json:"program_id"`
+	ProductID        uint      `gorm:"uniqueIndex:idx_affiliate_product_commission;not null" json:"product_id"`
+	CommissionType   string    `gorm:"size:20;default:'percentage'" json:"commission_type"` // percentage, fixed
+	CommissionAmount int64     `gorm:"default:0" json:"commission_amount"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+
+	Product *Product `gorm:"foreignKey:ProductID" json:"product,omitempty"`
 }
 
 type AffiliateAccount struct {
-	ID             uint           `gorm:"primarykey" json:"id"`
-	TenantID       uint           `gorm:"index;not null;default:1" json:"tenant_id"`
-	ContactID      uint           `gorm:"index;not null" json:"contact_id"`
-	ProgramID      uint           `gorm:"index;not null" json:"program_id"`
-	Status         string         `gorm:"size:20;default:'pending'" json:"status"`
-	ReferralCode   string         `gorm:"size:50;uniqueIndex" json:"referral_code"`
-	CustomSlug     string         `gorm:"size:100" json:"custom_slug"`
-	Balance        int64          `gorm:"default:0" json:"balance"` // in cents
-	TotalEarned    int64          `gorm:"default:0" json:"total_earned"`
-	TotalPaid      int64          `gorm:"default:0" json:"total_paid"`
-	CreatedAt      time.Time      `json:"created_at"`
-	UpdatedAt      time.Time      `json:"updated_at"`
-	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
+	ID                uint           `gorm:"primarykey" json:"id"`
+	TenantID          uint           `gorm:"index;not null;default:1" json:"tenant_id"`
+	ContactID         uint           `gorm:"index;not null" json:"contact_id"`
+	ProgramID         uint           `gorm:"index;not null" json:"program_id"`
+	Status            string         `gorm:"size:20;default:'pending'" json:"status"`
+	ReferralCode      string         `gorm:"size:50;uniqueIndex" json:"referral_code"`
+	CustomSlug        string         `gorm:"size:100" json:"custom_slug"`
+	Balance           int64          `gorm:"default:0" json:"balance"` // in cents
+	TotalEarned       int64          `gorm:"default:0" json:"total_earned"`
+	TotalPaid         int64          `gorm:"default:0" json:"total_paid"`
+	RecruitedByID     *uint          `gorm:"index" json:"recruited_by_id"` // affiliate who recruited this one, for second-tier commissions
+	SignupIP          string         `gorm:"size:45" json:"signup_ip"`
+	PayoutMethod      string         `gorm:"size:50" json:"payout_method"` // paypal, bank_transfer
+	PayPalEmail       string         `gorm:"size:255" json:"paypal_email"`
+	BankAccountName   string         `gorm:"size:255" json:"bank_account_name"`
+	BankName          string         `gorm:"size:255" json:"bank_name"`
+	BankAccountNumber string         `gorm:"size:100" json:"bank_account_number"` // account number or IBAN
+	BankRoutingNumber string         `gorm:"size:100" json:"bank_routing_number"` // routing, sort or branch code
+	BankSwift         string         `gorm:"size:20" json:"bank_swift"`
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
 
 	Contact     *Contact          `gorm:"foreignKey:ContactID" json:"contact,omitempty"`
 	Program     *AffiliateProgram `gorm:"foreignKey:ProgramID" json:"program,omitempty"`
@@ -62,28 +124,58 @@ type AffiliateAccount struct {
 	Commissions []Commission      `gorm:"foreignKey:AccountID" json:"commissions,omitempty"`
 }
 
+// AffiliateLink is a tracked link served at /api/l/:slug. It redirects to
+// URL, which for deep links is resolved from the target product, course or
+// funnel step when the link is created.
 type AffiliateLink struct {
 	ID          uint      `gorm:"primarykey" json:"id"`
-	TenantID    uint      `gorm:"index;not null;default:1" json:"tenant_id"`
+	TenantID    uint      `gorm:"index;not null;default:1;uniqueIndex:idx_affiliate_link_slug,where:slug <> ''" json:"tenant_id"`
 	AccountID   uint      `gorm:"index;not null" json:"account_id"`
 	URL         string    `gorm:"size:500;not null" json:"url"`
-	Slug        string    `gorm:"size:100" json:"slug"`
+	Slug        string    `gorm:"size:100;uniqueIndex:idx_affiliate_link_slug" json:"slug"`
+	TargetType  string    `gorm:"size:20;default:'url'" json:"target_type"` // url, product, course, funnel_step
+	TargetID    *uint     `json:"target_id"`
+	UTMSource   string    `gorm:"size:100" json:"utm_source"` // defaults, overridden by utm_* on the incoming click
+	UTMMedium   string    `gorm:"size:100" json:"utm_medium"`
+	UTMCampaign string    `gorm:"size:100" json:"utm_campaign"`
+	UTMContent  string    `gorm:"size:100" json:"utm_content"`
 	Clicks      int64     `gorm:"default:0" json:"clicks"`
 	Conversions int64     `gorm:"default:0" json:"conversions"`
 	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
 }
 
+// Affiliate link targets.
+const (
+	LinkTargetURL        = "url"
+	LinkTargetProduct    = "product"
+	LinkTargetCourse     = "course"
+	LinkTargetFunnelStep = "funnel_step"
+)
+
 type Commission struct {
-	ID         uint       `gorm:"primarykey" json:"id"`
-	TenantID   uint       `gorm:"index;not null;default:1" json:"tenant_id"`
-	AccountID  uint       `gorm:"index;not null" json:"account_id"`
-	OrderID    *uint      `gorm:"index" json:"order_id"`
-	ProductID  *uint      `gorm:"index" json:"product_id"`
-	Amount     int64      `gorm:"not null" json:"amount"` // in cents
-	Status     string     `gorm:"size:20;default:'pending'" json:"status"`
-	ApprovedAt *time.Time `json:"approved_at"`
-	PaidAt     *time.Time `json:"paid_at"`
-	CreatedAt  time.Time  `json:"created_at"`
+	ID             uint           `gorm:"primarykey" json:"id"`
+	TenantID       uint           `gorm:"index;not null;default:1" json:"tenant_id"`
+	AccountID      uint           `gorm:"index;not null" json:"account_id"`
+	OrderID        *uint          `gorm:"index;uniqueIndex:idx_commission_order_level,where:subscription_id IS NULL" json:"order_id"`
+	ProductID      *uint          `gorm:"index" json:"product_id"`
+	LinkID         *uint          `gorm:"index" json:"link_id"`             // affiliate link the sale came through
+	Amount         int64          `gorm:"not null" json:"amount"`           // in cents
+	ReversedAmount int64          `gorm:"default:0" json:"reversed_amount"` // clawed back by refunds; Amount + ReversedAmount is the original
+	Status         string         `gorm:"size:20;default:'pending'" json:"status"`
+	Level          int            `gorm:"default:1;uniqueIndex:idx_commission_order_level;uniqueIndex:idx_commission_renewal_level" json:"level"` // 1 = referring affiliate, 2 = their recruiter
+	ParentID       *uint          `gorm:"index" json:"parent_id"`                                                                                 // level-1 commission a second-tier one derives from
+	SubscriptionID *uint          `gorm:"index;uniqueIndex:idx_commission_renewal_level" json:"subscription_id"`                                  // set on recurring renewal commissions
+	PeriodStart    *time.Time     `gorm:"uniqueIndex:idx_commission_renewal_level" json:"period_start"`                                           // renewal period the commission covers
+	Description    string         `gorm:"size:255" json:"description"`
+	HoldUntil      *time.Time     `gorm:"index" json:"hold_until"`       // not approvable before this (refund window)
+	PaidAmount     int64          `gorm:"default:0" json:"paid_amount"`  // settled by completed payouts
+	FraudFlags     datatypes.JSON `gorm:"type:jsonb" json:"fraud_flags"` // []string of Fraud* reasons; flagged commissions wait for review
+	FlaggedAt      *time.Time     `gorm:"index" json:"flagged_at"`
+	ReviewedAt     *time.Time     `json:"reviewed_at"`
+	ApprovedAt     *time.Time     `json:"approved_at"`
+	PaidAt         *time.Time     `json:"paid_at"`
+	CreatedAt      time.Time      `json:"created_at"`
 
 	Account *AffiliateAccount `gorm:"foreignKey:AccountID" json:"account,omitempty"`
 }
@@ -93,11 +185,96 @@ type Payout struct {
 	TenantID      uint       `gorm:"index;not null;default:1" json:"tenant_id"`
 	AccountID     uint       `gorm:"index;not null" json:"account_id"`
 	Amount        int64      `gorm:"not null" json:"amount"` // in cents
-	Method        string     `gorm:"size:50" json:"method"` // paypal, bank_transfer, etc.
+	Method        string     `gorm:"size:50" json:"method"`  // paypal, bank_transfer, etc.
 	Status        string     `gorm:"size:20;default:'pending'" json:"status"`
 	ProcessedAt   *time.Time `json:"processed_at"`
 	TransactionID string     `gorm:"size:255" json:"transaction_id"`
+	BatchID       *uint      `gorm:"index" json:"batch_id"`
+	Currency      string     `gorm:"size:3" json:"currency"`
+	Note          string     `gorm:"size:255" json:"note"`
 	CreatedAt     time.Time  `json:"created_at"`
 
-	Account *AffiliateAccount `gorm:"foreignKey:AccountID" json:"account,omitempty"`
+	Account     *AffiliateAccount  `gorm:"foreignKey:AccountID" json:"account,omitempty"`
+	Allocations []PayoutAllocation `gorm:"foreignKey:PayoutID" json:"allocations,omitempty"`
+}
+
+// AffiliateClick is one hit on a referral link. Repeat hits from the same IP
+// and user agent inside the program's ClickWindowMinutes are stored as
+// duplicates and don't count towards link clicks.
+type AffiliateClick struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	TenantID  uint      `gorm:"index;not null;default:1" json:"tenant_id"`
+	AccountID uint      `gorm:"index:idx_affiliate_clicks_visitor;not null" json:"account_id"`
+	LinkID    *uint     `gorm:"index" json:"link_id"`
+	IPAddress string    `gorm:"size:45;index:idx_affiliate_clicks_visitor" json:"ip_address"`
+	UserAgent string    `gorm:"size:500" json:"user_agent"`
+	Referrer  string    `gorm:"size:500" json:"referrer"`
+	Duplicate bool      `gorm:"default:false" json:"duplicate"`
+	CreatedAt time.Time `gorm:"index:idx_affiliate_clicks_visitor" json:"created_at"`
+}
+
+// PayoutAllocation ties part of a payout to the commission it settles. A
+// commission is paid once completed payouts cover its whole amount.
+type PayoutAllocation struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	PayoutID     uint      `gorm:"index;not null" json:"payout_id"`
+	CommissionID uint      `gorm:"index;not null" json:"commission_id"`
+	Amount       int64     `gorm:"not null" json:"amount"` // in cents
+	CreatedAt    time.Time `json:"created_at"`
+
+	Commission *Commission `gorm:"foreignKey:CommissionID" json:"commission,omitempty"`
+}
+
+// AffiliateLedgerEntry records every change to an affiliate's balance:
+// approved commissions (+), reversals (-) and payouts (-). The account's
+// Balance is the running sum, kept in BalanceAfter.
+type AffiliateLedgerEntry struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	TenantID     uint      `gorm:"index;not null;default:1" json:"tenant_id"`
+	AccountID    uint      `gorm:"index;not null" json:"account_id"`
+	Type         string    `gorm:"size:20;not null;index" json:"type"` // earned, reversal, payout, adjustment
+	Amount       int64     `gorm:"not null" json:"amount"`             // signed, in cents
+	BalanceAfter int64     `gorm:"not null" json:"balance_after"`
+	CommissionID *uint     `gorm:"index" json:"commission_id"`
+	PayoutID     *uint     `gorm:"index" json:"payout_id"`
+	Description  string    `gorm:"size:255" json:"description"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// PayoutBatch groups payouts paid together through one channel and exported
+// as a PayPal Payouts or bank-transfer file.
+type PayoutBatch struct {
+	ID          uint       `gorm:"primarykey" json:"id"`
+	TenantID    uint       `gorm:"index;not null;default:1" json:"tenant_id"`
+	Method      string     `gorm:"size:50;not null" json:"method"` // paypal, bank_transfer
+	Status      string     `gorm:"size:20;default:'draft'" json:"status"`
+	Currency    string     `gorm:"size:3" json:"currency"`
+	TotalAmount int64      `gorm:"default:0" json:"total_amount"` // in cents
+	PayoutCount int        `gorm:"default:0" json:"payout_count"`
+	ExportedAt  *time.Time `json:"exported_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+
+	Payouts []Payout `gorm:"foreignKey:BatchID" json:"payouts,omitempty"`
+}
+
+// AffiliateAsset is marketing material offered to affiliates: banners and
+// logos (FileURL) or swipe copy (Content, where {link} is replaced with the
+// affiliate's referral link). A nil ProgramID makes it available to all programs.
+type AffiliateAsset struct {
+	ID          uint           `gorm:"primarykey" json:"id"`
+	TenantID    uint           `gorm:"index;not null;default:1" json:"tenant_id"`
+	ProgramID   *uint          `gorm:"index" json:"program_id"`
+	Title       string         `gorm:"size:255;not null" json:"title"`
+	Description string         `gorm:"type:text" json:"description"`
+	Type        string         `gorm:"size:20;default:'banner'" json:"type"` // banner, logo, copy, email, video
+	FileURL     string         `gorm:"size:500" json:"file_url"`
+	Content     string         `gorm:"type:text" json:"content"`
+	Width       int            `json:"width"`
+	Height      int            `json:"height"`
+	SortOrder   int            `gorm:"default:0" json:"sort_order"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
 }
@@ -45,6 +45,17 @@ type Product struct {
 	MembershipDays int `gorm:"default:0" json:"membership_days"`
 }
 
+// ProductCourse adds a course to a bundle product, on top of courses whose
+// ProductID points at the product.
+type ProductCourse struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	ProductID uint      `gorm:"uniqueIndex:idx_product_course;not null" json:"product_id"`
+	CourseID  uint      `gorm:"uniqueIndex:idx_product_course;not null" json:"course_id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Course Course `gorm:"foreignKey:CourseID" json:"course,omitempty"`
+}
+
 // --- Prices ---
 
 const (
@@ -62,9 +73,14 @@ type Price struct {
 	Interval  string         `gorm:"size:10" json:"interval"` // month, year
 	TrialDays int            `gorm:"default:0" json:"trial_days"`
 	SortOrder int            `gorm:"default:0" json:"sort_order"`
+	Amounts   datatypes.JSON `gorm:"type:jsonb" json:"amounts"` // extra prices per currency, e.g. {"KES": 390000}
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// Set on storefront responses for the visitor's display currency
+	DisplayAmount   float64 `gorm:"-" json:"display_amount,omitempty"`
+	DisplayCurrency string  `gorm:"-" json:"display_currency,omitempty"`
 }
 
 // --- Product Variants ---
@@ -91,31 +107,49 @@ const (
 	OrderStatusFailed             = "failed"
 	OrderStatusRefunded           = "refunded"
 	OrderStatusPartiallyRefunded  = "partially_refunded"
+	OrderStatusExpired            = "expired" // abandoned checkout that was never paid
 )
 
 type Order struct {
-	ID              uint           `gorm:"primarykey" json:"id"`
-	TenantID        uint           `gorm:"index;not null;default:1" json:"tenant_id"`
-	ContactID       uint           `gorm:"index;not null" json:"contact_id"`
-	OrderNumber     string         `gorm:"size:50;uniqueIndex;not null" json:"order_number"`
-	Status          string         `gorm:"size:30;default:'pending';index" json:"status"`
-	Subtotal        float64        `gorm:"type:decimal(10,2);default:0" json:"subtotal"`
-	DiscountAmount  float64        `gorm:"type:decimal(10,2);default:0" json:"discount_amount"`
-	TaxAmount       float64        `gorm:"type:decimal(10,2);default:0" json:"tax_amount"`
-	Total           float64        `gorm:"type:decimal(10,2);default:0" json:"total"`
-	Currency        string         `gorm:"size:3;default:'USD'" json:"currency"`
-	PaymentProvider string         `gorm:"size:50" json:"payment_provider"`
-	PaymentID       string         `gorm:"size:255" json:"payment_id"`
-	CouponID        *uint          `gorm:"index" json:"coupon_id"`
-	Metadata        datatypes.JSON `gorm:"type:jsonb" json:"metadata"`
-	PaidAt          *time.Time     `json:"paid_at"`
-	CreatedAt       time.Time      `json:"created_at"`
-	UpdatedAt       time.Time      `json:"updated_at"`
-	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
-
-	Contact  *Contact    `gorm:"foreignKey:ContactID" json:"contact,omitempty"`
-	Items    []OrderItem `gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE" json:"items,omitempty"`
-	Coupon   *Coupon     `gorm:"foreignKey:CouponID" json:"coupon,omitempty"`
+	ID                 uint           `gorm:"primarykey" json:"id"`
+	TenantID           uint           `gorm:"index;not null;default:1" json:"tenant_id"`
+	ContactID          uint           `gorm:"index;not null" json:"contact_id"`
+	OrderNumber        string         `gorm:"size:50;uniqueIndex;not null" json:"order_number"`
+	Status             string         `gorm:"size:30;default:'pending';index" json:"status"`
+	Subtotal           float64        `gorm:"type:decimal(10,2);default:0" json:"subtotal"`
+	DiscountAmount     float64        `gorm:"type:decimal(10,2);default:0" json:"discount_amount"`
+	TaxAmount          float64        `gorm:"type:decimal(10,2);default:0" json:"tax_amount"`
+	Total              float64        `gorm:"type:decimal(10,2);default:0" json:"total"`
+	Currency           string         `gorm:"size:3;default:'USD'" json:"currency"`
+	PaymentProvider    string         `gorm:"size:50" json:"payment_provider"`
+	PaymentID          string         `gorm:"size:255" json:"payment_id"`
+	PaymentReference   string         `gorm:"size:255" json:"payment_reference"` // PayPal capture ID, M-Pesa receipt number
+	PaymentAttempts    int            `gorm:"default:0" json:"payment_attempts"`                 // payment sessions started after the first, by resumed checkouts
+	ProviderReference  string         `gorm:"size:60" json:"provider_reference"`                 // reference sent to the provider for the latest attempt, when not OrderNumber
+	RefundedAmount     float64        `gorm:"type:decimal(10,2);default:0" json:"refunded_amount"`
+	CouponID           *uint          `gorm:"index" json:"coupon_id"`
+	FunnelStepID       *uint          `gorm:"index" json:"funnel_step_id"`                       // funnel checkout/upsell step the order came from
+	ParentOrderID      *uint          `gorm:"index" json:"parent_order_id"`                      // original order of a one-click upsell
+	SubscriptionID     *uint          `gorm:"index" json:"subscription_id"`                      // subscription a renewal order pays the next period of
+	AffiliateAccountID *uint          `gorm:"index" json:"affiliate_account_id"`                 // referring affiliate at checkout
+	AffiliateLinkID    *uint          `gorm:"index" json:"affiliate_link_id"`                    // affiliate link the buyer arrived through
+	BaseCurrency       string         `gorm:"size:3" json:"base_currency"`                       // tenant base currency when paid
+	ExchangeRate       float64        `gorm:"type:decimal(18,8);default:0" json:"exchange_rate"` // Currency → BaseCurrency, 0 = not captured yet
+	BaseTotal          float64        `gorm:"type:decimal(12,2);default:0" json:"base_total"`    // Total in BaseCurrency
+	IPAddress          string         `gorm:"size:45" json:"ip_address"`                         // buyer's IP at checkout
+	PaymentFingerprint string         `gorm:"size:100;index" json:"payment_fingerprint"`         // card fingerprint reported by the provider
+	Metadata           datatypes.JSON `gorm:"type:jsonb" json:"metadata"`
+	PaidAt             *time.Time     `json:"paid_at"`
+	FulfilledAt        *time.Time     `json:"fulfilled_at"`
+	CreatedAt          time.Time      `json:"created_at"`
+	UpdatedAt          time.Time      `json:"updated_at"`
+	DeletedAt          gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Contact   *Contact        `gorm:"foreignKey:ContactID" json:"contact,omitempty"`
+	Items     []OrderItem     `gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE" json:"items,omitempty"`
+	Coupon    *Coupon         `gorm:"foreignKey:CouponID" json:"coupon,omitempty"`
+	Discounts []OrderDiscount `gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE" json:"discounts,omitempty"`
+	Refunds   []Refund        `gorm:"foreignKey:OrderID" json:"refunds,omitempty"`
 }
 
 // --- Order Items ---
@@ -128,6 +162,8 @@ type OrderItem struct {
 	CourseID  *uint          `gorm:"index" json:"course_id"`
 	PriceID   *uint          `gorm:"index" json:"price_id"`
 	VariantID *uint          `gorm:"index" json:"variant_id"`
+	BumpID    *uint          `gorm:"index" json:"bump_id"`   // set when added through an order bump
+	CohortID  *uint          `gorm:"index" json:"cohort_id"` // course cohort chosen at checkout
 	Quantity  int            `gorm:"default:1" json:"quantity"`
 	UnitPrice float64        `gorm:"type:decimal(10,2);not null" json:"unit_price"`
 	Total     float64        `gorm:"type:decimal(10,2);not null" json:"total"`
@@ -138,11 +174,55 @@ type OrderItem struct {
 	Course  *Course  `gorm:"foreignKey:CourseID" json:"course,omitempty"`
 }
 
+// --- Order Fulfillment Steps ---
+
+// OrderFulfillmentStep records a fulfillment action (enrollment, access grant,
+// coupon usage) applied for an order. It is written with the rest of the
+// fulfillment, as an audit trail.
+type OrderFulfillmentStep struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	TenantID  uint      `gorm:"index;not null;default:1" json:"tenant_id"`
+	OrderID   uint      `gorm:"uniqueIndex:idx_fulfillment_order_step;not null" json:"order_id"`
+	Step      string    `gorm:"size:100;uniqueIndex:idx_fulfillment_order_step;not null" json:"step"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// --- Refunds ---
+
+const (
+	RefundStatusPending   = "pending"
+	RefundStatusSucceeded = "succeeded"
+	RefundStatusFailed    = "failed"
+)
+
+// Refund is a ledger entry for money returned to the customer through the
+// order's payment provider. OrderItemID is set for per-item refunds.
+type Refund struct {
+	ID               uint       `gorm:"primarykey" json:"id"`
+	TenantID         uint       `gorm:"index;not null;default:1" json:"tenant_id"`
+	OrderID          uint       `gorm:"index;not null" json:"order_id"`
+	OrderItemID      *uint      `gorm:"index" json:"order_item_id"`
+	Amount           float64    `gorm:"type:decimal(10,2);not null" json:"amount"`
+	Currency         string     `gorm:"size:3;default:'USD'" json:"currency"`
+	Reason           string     `gorm:"type:text" json:"reason"`
+	Status           string     `gorm:"size:20;default:'pending';index" json:"status"`
+	PaymentProvider  string     `gorm:"size:50" json:"payment_provider"`
+	ProviderRefundID string     `gorm:"size:255;index" json:"provider_refund_id"`
+	FailureReason    string     `gorm:"type:text" json:"failure_reason"`
+	ProcessedAt      *time.Time `json:"processed_at"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+
+	OrderItem *OrderItem `gorm:"foreignKey:OrderItemID" json:"order_item,omitempty"`
+}
+
 // --- Coupons ---
 
 const (
 	CouponTypePercentage = "percentage"
 	CouponTypeFixed      = "fixed"
+	CouponTypeBuyXGetY   = "buy_x_get_y" // Amount = percent off the "get" items (0 or 100 = free)
+	CouponTypeFreeTrial  = "free_trial"  // extends the trial of subscription prices by TrialDays
 )
 
 const (
@@ -167,6 +247,39 @@ type Coupon struct {
 	CreatedAt      time.Time      `json:"created_at"`
 	UpdatedAt      time.Time      `json:"updated_at"`
 	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Name               string         `gorm:"size:255" json:"name"`
+	Automatic          bool           `gorm:"default:false;index" json:"automatic"`   // applied without a code
+	Stackable          bool           `gorm:"default:false" json:"stackable"`         // may combine with other stackable discounts
+	Priority           int            `gorm:"default:0" json:"priority"`              // automatic discounts are tried highest first
+	MaxUsesPerCustomer int            `gorm:"default:0" json:"max_uses_per_customer"` // 0 = unlimited
+	FirstPurchaseOnly  bool           `gorm:"default:false" json:"first_purchase_only"`
+	BuyQuantity        int            `gorm:"default:0" json:"buy_quantity"`     // buy_x_get_y: units of ProductIDs to buy
+	GetQuantity        int            `gorm:"default:0" json:"get_quantity"`     // buy_x_get_y: units discounted per set
+	GetProductIDs      datatypes.JSON `gorm:"type:jsonb" json:"get_product_ids"` // empty = same as ProductIDs
+	TrialDays          int            `gorm:"default:0" json:"trial_days"`       // free_trial extension
+	ParentID           *uint          `gorm:"index" json:"parent_id"`            // bulk-generated from this coupon
+}
+
+// --- Order Discounts ---
+
+// OrderDiscount is a coupon or automatic discount applied to an order.
+// RedeemedAt is set when the order is paid; only redeemed rows count towards
+// usage limits.
+type OrderDiscount struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	TenantID   uint       `gorm:"index;not null;default:1" json:"tenant_id"`
+	OrderID    uint       `gorm:"index;not null" json:"order_id"`
+	CouponID   uint       `gorm:"index;not null" json:"coupon_id"`
+	ContactID  uint       `gorm:"index;not null" json:"contact_id"`
+	Code       string     `gorm:"size:50" json:"code"`
+	Name       string     `gorm:"size:255" json:"name"`
+	Type       string     `gorm:"size:20" json:"type"`
+	Automatic  bool       `gorm:"default:false" json:"automatic"`
+	Amount     float64    `gorm:"type:decimal(10,2);not null;default:0" json:"amount"`
+	TrialDays  int        `gorm:"default:0" json:"trial_days"`
+	RedeemedAt *time.Time `gorm:"index" json:"redeemed_at"`
+	CreatedAt  time.Time  `json:"created_at"`
 }
 
 // --- Subscriptions ---
@@ -187,6 +300,11 @@ type Subscription struct {
 	Status                 string         `gorm:"size:20;default:'active';index" json:"status"`
 	PaymentProvider        string         `gorm:"size:50" json:"payment_provider"`
 	ProviderSubscriptionID string         `gorm:"size:255" json:"provider_subscription_id"`
+	OrderID                *uint          `gorm:"index" json:"order_id"`                      // checkout order that started it
+	Amount                 float64        `gorm:"type:decimal(10,2);default:0" json:"amount"` // per period, minor units; 0 on rows from before it was recorded
+	Currency               string         `gorm:"size:3" json:"currency"`
+	Interval               string         `gorm:"size:10" json:"interval"`
+	TrialEndsAt            *time.Time     `json:"trial_ends_at"`
 	CurrentPeriodStart     time.Time      `json:"current_period_start"`
 	CurrentPeriodEnd       time.Time      `json:"current_period_end"`
 	CancelledAt            *time.Time     `json:"cancelled_at"`
@@ -198,5 +316,28 @@ type Subscription struct {
 	Contact *Contact `gorm:"foreignKey:ContactID" json:"contact,omitempty"`
 	Product *Product `gorm:"foreignKey:ProductID" json:"product,omitempty"`
 	Price   *Price   `gorm:"foreignKey:PriceID" json:"price,omitempty"`
-	
+}
+
+// --- Order Bumps ---
+
+// OrderBump is a one-tick add-on shown on the checkout of a product, e.g.
+// "Add the workbook for $9". Accepted bumps become extra order items.
+type OrderBump struct {
+	ID             uint           `gorm:"primarykey" json:"id"`
+	TenantID       uint           `gorm:"index;not null;default:1" json:"tenant_id"`
+	ProductID      uint           `gorm:"index;not null" json:"product_id"` // product being checked out
+	PriceID        *uint          `json:"price_id"`                         // only for this price; nil = any
+	OfferProductID uint           `gorm:"not null" json:"offer_product_id"`
+	OfferPriceID   uint           `gorm:"not null" json:"offer_price_id"`
+	Amount         float64        `gorm:"type:decimal(10,2);default:0" json:"amount"` // bump price in minor units; 0 = the offer price's amount
+	Headline       string         `gorm:"size:255;not null" json:"headline"`
+	Description    string         `gorm:"type:text" json:"description"`
+	Active         bool           `gorm:"default:true" json:"active"`
+	SortOrder      int            `gorm:"default:0" json:"sort_order"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
+
+	OfferProduct *Product `gorm:"foreignKey:OfferProductID" json:"offer_product,omitempty"`
+	OfferPrice   *Price   `gorm:"foreignKey:OfferPriceID" json:"offer_price,omitempty"`
 }
@@ -34,9 +34,16 @@ type Contact struct {
 	CustomFields   datatypes.JSON `gorm:"type:jsonb" json:"custom_fields"`
 	UserID         *uint          `gorm:"index" json:"user_id"` // Optional link to a User account
 	LastActivityAt *time.Time     `gorm:"index" json:"last_activity_at"`
-	CreatedAt      time.Time      `json:"created_at"`
-	UpdatedAt      time.Time      `json:"updated_at"`
-	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// Affiliate attribution — the account that referred this contact, valid until ReferralExpiresAt
+	ReferredByAccountID *uint      `gorm:"index" json:"referred_by_account_id"`
+	ReferredByLinkID    *uint      `json:"referred_by_link_id"` // most recent link of that account the contact followed
+	ReferredAt          *time.Time `json:"referred_at"`
+	ReferralExpiresAt   *time.Time `json:"referral_expires_at"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 
 	// Relationships
 	Tags       []Tag             `gorm:"many2many:contact_tags" json:"tags,omitempty"`
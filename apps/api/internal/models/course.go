@@ -23,31 +23,59 @@ const (
 
 // Course represents an online course.
 type Course struct {
-	ID               uint           `gorm:"primarykey" json:"id"`
-	TenantID         uint           `gorm:"index;not null;default:1" json:"tenant_id"`
-	Title            string         `gorm:"size:500;not null" json:"title"`
-	Slug             string         `gorm:"size:500;uniqueIndex:idx_course_slug_tenant;not null" json:"slug"`
-	Description      string         `gorm:"type:text" json:"description"`
-	ShortDescription string         `gorm:"size:500" json:"short_description"`
-	Thumbnail        string         `gorm:"size:500" json:"thumbnail"`
-	Price            float64        `gorm:"type:decimal(10,2);default:0" json:"price"`
-	Currency         string         `gorm:"size:3;default:'USD'" json:"currency"`
-	Status           string         `gorm:"size:20;default:'draft';index" json:"status"`
-	AccessType       string         `gorm:"size:20;default:'free'" json:"access_type"`
-	ProductID        *uint          `gorm:"index" json:"product_id"`
-	InstructorID     *uint          `gorm:"index" json:"instructor_id"`
-	PublishedAt      *time.Time     `json:"published_at"`
-	CreatedAt        time.Time      `json:"created_at"`
-	UpdatedAt        time.Time      `json:"updated_at"`
-	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
+	ID                    uint           `gorm:"primarykey" json:"id"`
+	TenantID              uint           `gorm:"index;not null;default:1" json:"tenant_id"`
+	Title                 string         `gorm:"size:500;not null" json:"title"`
+	Slug                  string         `gorm:"size:500;uniqueIndex:idx_course_slug_tenant;not null" json:"slug"`
+	Description           string         `gorm:"type:text" json:"description"`
+	ShortDescription      string         `gorm:"size:500" json:"short_description"`
+	Thumbnail             string         `gorm:"size:500" json:"thumbnail"`
+	Price                 float64        `gorm:"type:decimal(10,2);default:0" json:"price"`
+	Currency              string         `gorm:"size:3;default:'USD'" json:"currency"`
+	Status                string         `gorm:"size:20;default:'draft';index" json:"status"`
+	AccessType            string         `gorm:"size:20;default:'free'" json:"access_type"`
+	ProductID             *uint          `gorm:"index" json:"product_id"`
+	InstructorID          *uint          `gorm:"index" json:"instructor_id"`
+	CertificateTemplateID *uint          `gorm:"index" json:"certificate_template_id"`
+	AutoCompletePercent   int            `gorm:"default:90" json:"auto_complete_percent"` // share of a lesson video watched that completes it; 0 = off
+	CohortBased           bool           `gorm:"default:false" json:"cohort_based"`       // students enroll into a cohort
+	PublishedAt           *time.Time     `json:"published_at"`
+	CreatedAt             time.Time      `json:"created_at"`
+	UpdatedAt             time.Time      `json:"updated_at"`
+	DeletedAt             gorm.DeletedAt `gorm:"index" json:"-"`
 
 	Modules     []CourseModule     `gorm:"foreignKey:CourseID;constraint:OnDelete:CASCADE" json:"modules,omitempty"`
 	Enrollments []CourseEnrollment `gorm:"foreignKey:CourseID" json:"enrollments,omitempty"`
 	Instructor  *User              `gorm:"foreignKey:InstructorID" json:"instructor,omitempty"`
+	Cohorts     []CourseCohort     `gorm:"foreignKey:CourseID;constraint:OnDelete:CASCADE" json:"cohorts,omitempty"`
 
 	EnrollmentCount int64 `gorm:"-" json:"enrollment_count,omitempty"`
 }
 
+// --- Course Cohorts ---
+
+// CourseCohort is a group of students taking a course together. Drip delays
+// count from StartsAt rather than each student's enrollment.
+type CourseCohort struct {
+	ID             uint           `gorm:"primarykey" json:"id"`
+	TenantID       uint           `gorm:"index;not null;default:1" json:"tenant_id"`
+	CourseID       uint           `gorm:"index;not null" json:"course_id"`
+	Name           string         `gorm:"size:255;not null" json:"name"`
+	StartsAt       time.Time      `gorm:"not null" json:"starts_at"`
+	EndsAt         *time.Time     `json:"ends_at"`
+	EnrollOpensAt  *time.Time     `json:"enroll_opens_at"`             // nil = open now
+	EnrollClosesAt *time.Time     `json:"enroll_closes_at"`            // nil = until EndsAt
+	SeatLimit      int            `gorm:"default:0" json:"seat_limit"` // 0 = unlimited
+	SpaceID        *uint          `gorm:"index" json:"space_id"`       // community space members join
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Space *Space `gorm:"foreignKey:SpaceID" json:"space,omitempty"`
+
+	SeatsTaken int64 `gorm:"-" json:"seats_taken"`
+}
+
 // --- Course Modules ---
 
 // CourseModule groups lessons within a course.
@@ -68,11 +96,20 @@ type CourseModule struct {
 // --- Lessons ---
 
 const (
-	LessonTypeVideo = "video"
-	LessonTypeText  = "text"
-	LessonTypeAudio = "audio"
-	LessonTypePDF   = "pdf"
-	LessonTypeEmbed = "embed"
+	LessonTypeVideo      = "video"
+	LessonTypeText       = "text"
+	LessonTypeAudio      = "audio"
+	LessonTypePDF        = "pdf"
+	LessonTypeEmbed      = "embed"
+	LessonTypeAssignment = "assignment"
+	LessonTypeScorm      = "scorm" // launches a SCO from an imported ScormPackage
+)
+
+// Lesson drip types: when a lesson unlocks for an enrolled student.
+const (
+	LessonDripDelay          = "delay"           // DripDelayDays after enrollment
+	LessonDripDate           = "date"            // on DripDate
+	LessonDripPreviousModule = "previous_module" // once the previous module is completed
 )
 
 // Lesson is an individual piece of learning content in a course module.
@@ -85,15 +122,26 @@ type Lesson struct {
 	Content         datatypes.JSON `gorm:"type:jsonb" json:"content"`
 	Type            string         `gorm:"size:20;default:'text'" json:"type"`
 	VideoURL        string         `gorm:"size:500" json:"video_url"`
+	MediaAssetID    *uint          `gorm:"index" json:"media_asset_id"` // hosted video, played through signed URLs
 	DurationMinutes int            `gorm:"default:0" json:"duration_minutes"`
 	SortOrder       int            `gorm:"default:0" json:"sort_order"`
 	IsFreePreview   bool           `gorm:"default:false" json:"is_free_preview"`
 	DripDelayDays   int            `gorm:"default:0" json:"drip_delay_days"`
+	DripType        string         `gorm:"size:20;default:'delay'" json:"drip_type"`
+	DripDate        *time.Time     `json:"drip_date"`
+	ScormPackageID  *uint          `gorm:"index" json:"scorm_package_id"`
+	ScormLaunch     string         `gorm:"size:1000" json:"scorm_launch,omitempty"` // entry point within the package
+	ScormItemID     string         `gorm:"size:500" json:"scorm_item_id,omitempty"` // manifest item, or xAPI activity id
 	CreatedAt       time.Time      `json:"created_at"`
 	UpdatedAt       time.Time      `json:"updated_at"`
 	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
 
-	Quizzes []Quiz `gorm:"foreignKey:LessonID;constraint:OnDelete:CASCADE" json:"quizzes,omitempty"`
+	Quizzes    []Quiz      `gorm:"foreignKey:LessonID;constraint:OnDelete:CASCADE" json:"quizzes,omitempty"`
+	Assignment *Assignment `gorm:"foreignKey:LessonID;constraint:OnDelete:CASCADE" json:"assignment,omitempty"`
+	MediaAsset *MediaAsset `gorm:"foreignKey:MediaAssetID" json:"media_asset,omitempty"`
+
+	Locked    bool       `gorm:"-" json:"locked,omitempty"`
+	UnlocksAt *time.Time `gorm:"-" json:"unlocks_at,omitempty"`
 }
 
 // --- Course Enrollments ---
@@ -103,6 +151,7 @@ const (
 	EnrollStatusCompleted = "completed"
 	EnrollStatusExpired   = "expired"
 	EnrollStatusSuspended = "suspended"
+	EnrollStatusRevoked   = "revoked"
 )
 
 // CourseEnrollment tracks a contact's enrollment in a course.
@@ -116,11 +165,14 @@ type CourseEnrollment struct {
 	CompletedAt        *time.Time `json:"completed_at"`
 	ProgressPercentage float64    `gorm:"type:decimal(5,2);default:0" json:"progress_percentage"`
 	Source             string     `gorm:"size:50" json:"source"` // purchase, manual, coupon, free
+	DripNotifiedAt     *time.Time `json:"-"`                     // last unlock email
+	CohortID           *uint      `gorm:"index" json:"cohort_id"`
 	CreatedAt          time.Time  `json:"created_at"`
 	UpdatedAt          time.Time  `json:"updated_at"`
 
 	Contact          Contact          `gorm:"foreignKey:ContactID" json:"contact,omitempty"`
 	Course           Course           `gorm:"foreignKey:CourseID" json:"course,omitempty"`
+	Cohort           *CourseCohort    `gorm:"foreignKey:CohortID" json:"cohort,omitempty"`
 	LessonProgresses []LessonProgress `gorm:"foreignKey:EnrollmentID" json:"lesson_progresses,omitempty"`
 }
 
@@ -134,16 +186,19 @@ const (
 
 // LessonProgress tracks a student's progress on a specific lesson.
 type LessonProgress struct {
-	ID               uint       `gorm:"primarykey" json:"id"`
-	TenantID         uint       `gorm:"index;not null;default:1" json:"tenant_id"`
-	EnrollmentID     uint       `gorm:"uniqueIndex:idx_progress_enrollment_lesson;not null" json:"enrollment_id"`
-	LessonID         uint       `gorm:"uniqueIndex:idx_progress_enrollment_lesson;not null" json:"lesson_id"`
-	Status           string     `gorm:"size:20;default:'not_started'" json:"status"`
-	StartedAt        *time.Time `json:"started_at"`
-	CompletedAt      *time.Time `json:"completed_at"`
-	TimeSpentSeconds int        `gorm:"default:0" json:"time_spent_seconds"`
-	CreatedAt        time.Time  `json:"created_at"`
-	UpdatedAt        time.Time  `json:"updated_at"`
+	ID                  uint           `gorm:"primarykey" json:"id"`
+	TenantID            uint           `gorm:"index;not null;default:1" json:"tenant_id"`
+	EnrollmentID        uint           `gorm:"uniqueIndex:idx_progress_enrollment_lesson;not null" json:"enrollment_id"`
+	LessonID            uint           `gorm:"uniqueIndex:idx_progress_enrollment_lesson;not null" json:"lesson_id"`
+	Status              string         `gorm:"size:20;default:'not_started'" json:"status"`
+	StartedAt           *time.Time     `json:"started_at"`
+	CompletedAt         *time.Time     `json:"completed_at"`
+	TimeSpentSeconds    int            `gorm:"default:0" json:"time_spent_seconds"`
+	LastPositionSeconds int            `gorm:"default:0" json:"last_position_seconds"`           // video resume point
+	VideoPercent        float64        `gorm:"type:decimal(5,2);default:0" json:"video_percent"` // furthest point watched
+	ScormData           datatypes.JSON `gorm:"type:jsonb" json:"-"`                              // SCORM cmi data and xAPI state, for resuming
+	CreatedAt           time.Time      `json:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at"`
 
 	Lesson Lesson `gorm:"foreignKey:LessonID" json:"lesson,omitempty"`
 }
@@ -160,6 +215,11 @@ type Quiz struct {
 	PassingScore       int            `gorm:"default:70" json:"passing_score"` // percentage
 	MaxAttempts        int            `gorm:"default:0" json:"max_attempts"`   // 0 = unlimited
 	ShowCorrectAnswers bool           `gorm:"default:true" json:"show_correct_answers"`
+	TimeLimitMinutes   int            `gorm:"default:0" json:"time_limit_minutes"` // 0 = untimed
+	ShuffleQuestions   bool           `gorm:"default:false" json:"shuffle_questions"`
+	ShuffleOptions     bool           `gorm:"default:false" json:"shuffle_options"`
+	Required           bool           `gorm:"default:true" json:"required"`  // must be passed to complete the lesson and course
+	External           bool           `gorm:"default:false" json:"external"` // scored by the lesson's SCORM/xAPI content, not questions
 	CreatedAt          time.Time      `json:"created_at"`
 	UpdatedAt          time.Time      `json:"updated_at"`
 	DeletedAt          gorm.DeletedAt `gorm:"index" json:"-"`
@@ -199,10 +259,13 @@ type QuizAttempt struct {
 	TenantID     uint           `gorm:"index;not null;default:1" json:"tenant_id"`
 	QuizID       uint           `gorm:"index;not null" json:"quiz_id"`
 	EnrollmentID uint           `gorm:"index;not null" json:"enrollment_id"`
-	Answers      datatypes.JSON `gorm:"type:jsonb" json:"answers"` // [{question_id, answer}]
+	Answers      datatypes.JSON `gorm:"type:jsonb" json:"answers"` // [{question_id, answer, correct, points}]
 	Score        float64        `gorm:"type:decimal(5,2);default:0" json:"score"`
 	Passed       bool           `gorm:"default:false" json:"passed"`
+	TimedOut     bool           `gorm:"default:false" json:"timed_out"`
+	Seed         int64          `json:"-"` // question/option shuffle order
 	StartedAt    time.Time      `json:"started_at"`
+	ExpiresAt    *time.Time     `json:"expires_at"` // time limit, for timed quizzes
 	CompletedAt  *time.Time     `json:"completed_at"`
 	CreatedAt    time.Time      `json:"created_at"`
 
@@ -210,21 +273,184 @@ type QuizAttempt struct {
 	Enrollment CourseEnrollment `gorm:"foreignKey:EnrollmentID" json:"enrollment,omitempty"`
 }
 
+// --- Assignments ---
+
+// Assignment submission types.
+const (
+	AssignmentSubmitFile = "file"
+	AssignmentSubmitText = "text"
+	AssignmentSubmitAny  = "any" // files, text or both
+)
+
+// Assignment is work submitted by students and graded by the course
+// instructor, attached to an assignment lesson.
+type Assignment struct {
+	ID             uint           `gorm:"primarykey" json:"id"`
+	TenantID       uint           `gorm:"index;not null;default:1" json:"tenant_id"`
+	LessonID       uint           `gorm:"index;not null" json:"lesson_id"`
+	Title          string         `gorm:"size:500;not null" json:"title"`
+	Instructions   string         `gorm:"type:text" json:"instructions"`
+	SubmissionType string         `gorm:"size:20;default:'any'" json:"submission_type"`
+	MaxFiles       int            `gorm:"default:5" json:"max_files"`
+	Rubric         datatypes.JSON `gorm:"type:jsonb" json:"rubric"`         // [{criterion, description, points}]
+	MaxPoints      int            `gorm:"default:100" json:"max_points"`    // when there is no rubric
+	PassingScore   int            `gorm:"default:70" json:"passing_score"`  // percentage
+	MaxSubmissions int            `gorm:"default:0" json:"max_submissions"` // 0 = unlimited
+	Required       bool           `gorm:"default:true" json:"required"`     // must be passed to complete the lesson and course
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// --- Assignment Submissions ---
+
+const (
+	SubmissionPending = "submitted"
+	SubmissionGraded  = "graded"
+)
+
+// AssignmentSubmission is a student's submission for an assignment. A
+// submission awaiting review is replaced when the student resubmits; graded
+// ones are kept.
+type AssignmentSubmission struct {
+	ID           uint           `gorm:"primarykey" json:"id"`
+	TenantID     uint           `gorm:"index;not null;default:1" json:"tenant_id"`
+	AssignmentID uint           `gorm:"index;not null" json:"assignment_id"`
+	EnrollmentID uint           `gorm:"index;not null" json:"enrollment_id"`
+	Text         string         `gorm:"type:text" json:"text"`
+	Files        datatypes.JSON `gorm:"type:jsonb" json:"files"` // [{upload_id, filename, mime_type, size, path}]
+	Status       string         `gorm:"size:20;default:'submitted';index" json:"status"`
+	Score        float64        `gorm:"type:decimal(5,2);default:0" json:"score"` // percentage
+	Passed       bool           `gorm:"default:false" json:"passed"`
+	RubricScores datatypes.JSON `gorm:"type:jsonb" json:"rubric_scores"` // [{criterion, points, comment}]
+	Feedback     string         `gorm:"type:text" json:"feedback"`
+	GradedByID   *uint          `gorm:"index" json:"graded_by_id"`
+	SubmittedAt  time.Time      `json:"submitted_at"`
+	GradedAt     *time.Time     `json:"graded_at"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+
+	Assignment Assignment       `gorm:"foreignKey:AssignmentID" json:"assignment,omitempty"`
+	Enrollment CourseEnrollment `gorm:"foreignKey:EnrollmentID" json:"enrollment,omitempty"`
+	GradedBy   *User            `gorm:"foreignKey:GradedByID" json:"graded_by,omitempty"`
+}
+
 // --- Certificates ---
 
 // Certificate is issued when a student completes a course.
 type Certificate struct {
-	ID                uint      `gorm:"primarykey" json:"id"`
-	TenantID          uint      `gorm:"index;not null;default:1" json:"tenant_id"`
-	CourseID          uint      `gorm:"index;not null" json:"course_id"`
-	EnrollmentID      uint      `gorm:"index;not null" json:"enrollment_id"`
-	ContactID         uint      `gorm:"index;not null" json:"contact_id"`
-	CertificateNumber string    `gorm:"size:100;uniqueIndex;not null" json:"certificate_number"`
-	IssuedAt          time.Time `json:"issued_at"`
-	Template          string    `gorm:"size:100;default:'default'" json:"template"`
-	CreatedAt         time.Time `json:"created_at"`
-
-	Course     Course           `gorm:"foreignKey:CourseID" json:"course,omitempty"`
-	Enrollment CourseEnrollment `gorm:"foreignKey:EnrollmentID" json:"enrollment,omitempty"`
-	Contact    Contact          `gorm:"foreignKey:ContactID" json:"contact,omitempty"`
+	ID                uint       `gorm:"primarykey" json:"id"`
+	TenantID          uint       `gorm:"index;not null;default:1" json:"tenant_id"`
+	CourseID          uint       `gorm:"index;not null" json:"course_id"`
+	EnrollmentID      uint       `gorm:"index;not null" json:"enrollment_id"`
+	ContactID         uint       `gorm:"index;not null" json:"contact_id"`
+	CertificateNumber string     `gorm:"size:100;uniqueIndex;not null" json:"certificate_number"`
+	IssuedAt          time.Time  `json:"issued_at"`
+	Template          string     `gorm:"size:100;default:'default'" json:"template"`
+	TemplateID        *uint      `gorm:"index" json:"template_id"`
+	StorageKey        string     `gorm:"size:500" json:"-"` // rendered PDF
+	EmailedAt         *time.Time `json:"emailed_at"`
+	LearningPathID    *uint      `gorm:"index" json:"learning_path_id"` // set on path certificates; Course is the path's last course
+	CreatedAt         time.Time  `json:"created_at"`
+
+	Course       Course           `gorm:"foreignKey:CourseID" json:"course,omitempty"`
+	Enrollment   CourseEnrollment `gorm:"foreignKey:EnrollmentID" json:"enrollment,omitempty"`
+	Contact      Contact          `gorm:"foreignKey:ContactID" json:"contact,omitempty"`
+	LearningPath *LearningPath    `gorm:"foreignKey:LearningPathID" json:"learning_path,omitempty"`
+}
+
+// Title is what the certificate was awarded for: the learning path or the
+// course. Course and LearningPath must be loaded.
+func (c *Certificate) Title() string {
+	if c.LearningPath != nil {
+		return c.LearningPath.Title
+	}
+	return c.Course.Title
+}
+
+// --- Certificate Templates ---
+
+// Certificate template field types.
+const (
+	CertFieldText        = "text" // static text
+	CertFieldStudentName = "student_name"
+	CertFieldCourseTitle = "course_title"
+	CertFieldDate        = "date"
+	CertFieldInstructor  = "instructor_name"
+	CertFieldSignature   = "instructor_signature"
+	CertFieldNumber      = "certificate_number"
+	CertFieldQRCode      = "qr_code" // links to the public verification page
+)
+
+// CertificateTemplate lays out a certificate PDF: the page, a background
+// image, the font, and the fields positioned on it.
+type CertificateTemplate struct {
+	ID            uint           `gorm:"primarykey" json:"id"`
+	TenantID      uint           `gorm:"index;not null;default:1" json:"tenant_id"`
+	Name          string         `gorm:"size:255;not null" json:"name"`
+	Orientation   string         `gorm:"size:1;default:'L'" json:"orientation"` // L or P
+	PageSize      string         `gorm:"size:10;default:'A4'" json:"page_size"` // A4 or Letter
+	BackgroundKey string         `gorm:"size:500" json:"background_key"`        // storage key of a JPEG/PNG
+	FontFamily    string         `gorm:"size:50;default:'Helvetica'" json:"font_family"`
+	FontKey       string         `gorm:"size:500" json:"font_key"`      // storage key of a TTF registered as FontFamily
+	SignatureKey  string         `gorm:"size:500" json:"signature_key"` // storage key of the instructor signature image
+	Fields        datatypes.JSON `gorm:"type:jsonb" json:"fields"`      // [{type, text, x, y, width, height, font_size, style, color, align, format}]
+	IsDefault     bool           `gorm:"default:false" json:"is_default"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// --- Learning Paths ---
+
+// LearningPath is an ordered sequence of courses. Students enroll in the
+// path, and a path certificate is issued once every course is completed.
+type LearningPath struct {
+	ID                    uint           `gorm:"primarykey" json:"id"`
+	TenantID              uint           `gorm:"index;not null;default:1" json:"tenant_id"`
+	Title                 string         `gorm:"size:500;not null" json:"title"`
+	Slug                  string         `gorm:"size:500;uniqueIndex:idx_learning_path_slug_tenant;not null" json:"slug"`
+	Description           string         `gorm:"type:text" json:"description"`
+	Thumbnail             string         `gorm:"size:500" json:"thumbnail"`
+	Status                string         `gorm:"size:20;default:'draft';index" json:"status"` // CourseStatus*
+	ProductID             *uint          `gorm:"index" json:"product_id"`                     // course-type product; buying it enrolls in the path and its courses
+	Sequential            bool           `gorm:"default:true" json:"sequential"`              // each course requires the one before it
+	CertificateTemplateID *uint          `gorm:"index" json:"certificate_template_id"`
+	CreatedAt             time.Time      `json:"created_at"`
+	UpdatedAt             time.Time      `json:"updated_at"`
+	DeletedAt             gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Courses []LearningPathCourse `gorm:"foreignKey:PathID;constraint:OnDelete:CASCADE" json:"courses,omitempty"`
+}
+
+// LearningPathCourse places a course in a path. PrerequisiteCourseID names
+// the course that must be completed first; when unset on a sequential path
+// it is the previous course.
+type LearningPathCourse struct {
+	ID                   uint  `gorm:"primarykey" json:"id"`
+	PathID               uint  `gorm:"uniqueIndex:idx_learning_path_course;not null" json:"path_id"`
+	CourseID             uint  `gorm:"uniqueIndex:idx_learning_path_course;not null" json:"course_id"`
+	Position             int   `gorm:"default:0" json:"position"`
+	PrerequisiteCourseID *uint `json:"prerequisite_course_id"`
+
+	Course Course `gorm:"foreignKey:CourseID" json:"course,omitempty"`
+}
+
+// LearningPathEnrollment tracks a student's progress through a path.
+type LearningPathEnrollment struct {
+	ID                 uint       `gorm:"primarykey" json:"id"`
+	TenantID           uint       `gorm:"index;not null;default:1" json:"tenant_id"`
+	PathID             uint       `gorm:"uniqueIndex:idx_learning_path_contact;not null" json:"path_id"`
+	ContactID          uint       `gorm:"uniqueIndex:idx_learning_path_contact;not null" json:"contact_id"`
+	Status             string     `gorm:"size:20;default:'active';index" json:"status"` // EnrollStatus*
+	ProgressPercentage float64    `gorm:"default:0" json:"progress_percentage"`
+	Source             string     `gorm:"size:50" json:"source"` // self-enroll, purchase, manual
+	EnrolledAt         time.Time  `json:"enrolled_at"`
+	CompletedAt        *time.Time `json:"completed_at"`
+	CertificateID      *uint      `json:"certificate_id"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+
+	Path    LearningPath `gorm:"foreignKey:PathID" json:"path,omitempty"`
+	Contact Contact      `gorm:"foreignKey:ContactID" json:"contact,omitempty"`
 }
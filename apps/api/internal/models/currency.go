@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// ExchangeRate is the value of one unit of Currency in the tenant's base
+// currency (Setting "default_currency"). Rates are maintained by hand or
+// imported from a file; orders capture the rate in effect when they are paid.
+type ExchangeRate struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	TenantID     uint      `gorm:"uniqueIndex:idx_exchange_rate_tenant_currency;not null;default:1" json:"tenant_id"`
+	BaseCurrency string    `gorm:"size:3;not null" json:"base_currency"`
+	Currency     string    `gorm:"size:3;uniqueIndex:idx_exchange_rate_tenant_currency;not null" json:"currency"`
+	Rate         float64   `gorm:"type:decimal(18,8);not null" json:"rate"`
+	Source       string    `gorm:"size:20;default:'manual'" json:"source"` // manual, import
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
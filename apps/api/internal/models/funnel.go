@@ -57,7 +57,14 @@ type FunnelStep struct {
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 
-	Funnel *Funnel `gorm:"foreignKey:FunnelID" json:"funnel,omitempty"`
+	// Offer on upsell/downsell steps, charged one-click to the card used at checkout
+	ProductID   *uint   `gorm:"index" json:"product_id"`
+	PriceID     *uint   `json:"price_id"`
+	OfferAmount float64 `gorm:"type:decimal(10,2);default:0" json:"offer_amount"` // minor units; 0 = the price's amount
+
+	Funnel  *Funnel  `gorm:"foreignKey:FunnelID" json:"funnel,omitempty"`
+	Product *Product `gorm:"foreignKey:ProductID" json:"product,omitempty"`
+	Price   *Price   `gorm:"foreignKey:PriceID" json:"price,omitempty"`
 }
 
 type FunnelVisit struct {
@@ -78,7 +85,8 @@ type FunnelConversion struct {
 	FunnelID    uint      `gorm:"index;not null" json:"funnel_id"`
 	StepID      uint      `gorm:"index;not null" json:"step_id"`
 	ContactID   *uint     `gorm:"index" json:"contact_id"`
-	Type        string    `gorm:"size:20" json:"type"` // optin, purchase
+	OrderID     *uint     `gorm:"index" json:"order_id"`
+	Type        string    `gorm:"size:20" json:"type"`    // optin, purchase, bump, upsell, downsell
 	Value       int64     `gorm:"default:0" json:"value"` // in cents
 	ConvertedAt time.Time `gorm:"not null" json:"converted_at"`
 }
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+)
+
+// --- Invoices ---
+
+// Invoice is the tax document issued for a paid order. Numbers are sequential
+// per tenant and the seller details are snapshotted from settings at issue time.
+type Invoice struct {
+	ID             uint       `gorm:"primarykey" json:"id"`
+	TenantID       uint       `gorm:"uniqueIndex:idx_invoice_number_tenant;uniqueIndex:idx_invoice_sequence_tenant;not null;default:1" json:"tenant_id"`
+	OrderID        uint       `gorm:"uniqueIndex;not null" json:"order_id"`
+	ContactID      uint       `gorm:"index;not null" json:"contact_id"`
+	InvoiceNumber  string     `gorm:"size:50;uniqueIndex:idx_invoice_number_tenant;not null" json:"invoice_number"`
+	Sequence       int64      `gorm:"uniqueIndex:idx_invoice_sequence_tenant;not null" json:"sequence"`
+	Currency       string     `gorm:"size:3;default:'USD'" json:"currency"`
+	Subtotal       float64    `gorm:"type:decimal(10,2);default:0" json:"subtotal"`
+	DiscountAmount float64    `gorm:"type:decimal(10,2);default:0" json:"discount_amount"`
+	TaxAmount      float64    `gorm:"type:decimal(10,2);default:0" json:"tax_amount"`
+	Total          float64    `gorm:"type:decimal(10,2);default:0" json:"total"`
+	SellerName     string     `gorm:"size:255" json:"seller_name"`
+	SellerTaxID    string     `gorm:"size:100" json:"seller_tax_id"`
+	SellerAddress  string     `gorm:"type:text" json:"seller_address"`
+	StorageKey     string     `gorm:"size:500" json:"-"`
+	IssuedAt       time.Time  `json:"issued_at"`
+	EmailedAt      *time.Time `json:"emailed_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+
+	Order   *Order   `gorm:"foreignKey:OrderID" json:"order,omitempty"`
+	Contact *Contact `gorm:"foreignKey:ContactID" json:"contact,omitempty"`
+}
+
+// InvoiceSequence holds the last issued invoice number for a tenant.
+// The row is locked while a new number is allocated.
+type InvoiceSequence struct {
+	TenantID   uint      `gorm:"primarykey;autoIncrement:false" json:"tenant_id"`
+	LastNumber int64     `gorm:"not null;default:0" json:"last_number"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
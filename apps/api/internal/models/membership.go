@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// --- Membership Entitlements ---
+
+// Entitlement resource types.
+const (
+	EntitlementCourse  = "course"
+	EntitlementSpace   = "space"
+	EntitlementGuide   = "guide"
+	EntitlementProduct = "product"
+)
+
+// MembershipEntitlement grants holders of a membership product access to a
+// course, community space, premium guide or another product while their
+// membership is active.
+type MembershipEntitlement struct {
+	ID                  uint      `gorm:"primarykey" json:"id"`
+	TenantID            uint      `gorm:"index;not null;default:1" json:"tenant_id"`
+	MembershipProductID uint      `gorm:"uniqueIndex:idx_membership_entitlement;not null" json:"membership_product_id"`
+	ResourceType        string    `gorm:"size:20;uniqueIndex:idx_membership_entitlement;index:idx_entitlement_resource;not null" json:"resource_type"`
+	ResourceID          uint      `gorm:"uniqueIndex:idx_membership_entitlement;index:idx_entitlement_resource;not null" json:"resource_id"`
+	CreatedAt           time.Time `json:"created_at"`
+
+	MembershipProduct *Product `gorm:"foreignKey:MembershipProductID" json:"membership_product,omitempty"`
+}
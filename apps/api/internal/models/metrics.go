@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+)
+
+// MetricSnapshot holds one day of subscription metrics, computed nightly.
+// Amounts are monthly recurring revenue in minor units of the base currency.
+type MetricSnapshot struct {
+	ID                   uint      `gorm:"primarykey" json:"id"`
+	TenantID             uint      `gorm:"uniqueIndex:idx_metric_snapshot_tenant_date;not null;default:1" json:"tenant_id"`
+	Date                 time.Time `gorm:"type:date;uniqueIndex:idx_metric_snapshot_tenant_date;not null" json:"date"`
+	Currency             string    `gorm:"size:3" json:"currency"`
+	MRR                  float64   `gorm:"type:decimal(14,2);default:0" json:"mrr"` // at end of day
+	ActiveCustomers      int64     `gorm:"default:0" json:"active_customers"`
+	NewMRR               float64   `gorm:"type:decimal(14,2);default:0" json:"new_mrr"`
+	ExpansionMRR         float64   `gorm:"type:decimal(14,2);default:0" json:"expansion_mrr"`
+	ContractionMRR       float64   `gorm:"type:decimal(14,2);default:0" json:"contraction_mrr"`
+	ChurnedMRR           float64   `gorm:"type:decimal(14,2);default:0" json:"churned_mrr"`
+	ReactivatedMRR       float64   `gorm:"type:decimal(14,2);default:0" json:"reactivated_mrr"`
+	NewCustomers         int64     `gorm:"default:0" json:"new_customers"`
+	ChurnedCustomers     int64     `gorm:"default:0" json:"churned_customers"`
+	ReactivatedCustomers int64     `gorm:"default:0" json:"reactivated_customers"`
+	TrialsStarted        int64     `gorm:"default:0" json:"trials_started"`
+	TrialsEnded          int64     `gorm:"default:0" json:"trials_ended"`
+	TrialsConverted      int64     `gorm:"default:0" json:"trials_converted"`
+	CreatedAt            time.Time `json:"created_at"`
+	UpdatedAt            time.Time `json:"updated_at"`
+}
+
+// CustomerMRRSnapshot is a paying customer's MRR at the end of a day. Only
+// customers with MRR are stored; movements compare consecutive days.
+type CustomerMRRSnapshot struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	TenantID  uint      `gorm:"index:idx_customer_mrr_tenant_date;not null;default:1" json:"tenant_id"`
+	Date      time.Time `gorm:"type:date;index:idx_customer_mrr_tenant_date;not null" json:"date"`
+	ContactID uint      `gorm:"index;not null" json:"contact_id"`
+	MRR       float64   `gorm:"type:decimal(14,2);not null" json:"mrr"`
+}
+
+// CohortRetention is one cell of the cohort retention table: customers who
+// first paid in Cohort month, and how many still paid MonthOffset months later.
+type CohortRetention struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	TenantID    uint      `gorm:"uniqueIndex:idx_cohort_retention_cell;not null;default:1" json:"tenant_id"`
+	Cohort      time.Time `gorm:"type:date;uniqueIndex:idx_cohort_retention_cell;not null" json:"cohort"`
+	MonthOffset int       `gorm:"uniqueIndex:idx_cohort_retention_cell;not null" json:"month_offset"`
+	CohortSize  int64     `gorm:"not null" json:"cohort_size"`
+	Customers   int64     `gorm:"not null" json:"customers"`
+	Retention   float64   `gorm:"type:decimal(6,2)" json:"retention"` // percent of cohort size
+	MRR         float64   `gorm:"type:decimal(14,2)" json:"mrr"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// CheckoutRecovery tracks a pending checkout that was abandoned: the
+// recovery emails sent for it, the resume token in their links, an optional
+// single-use coupon, and whether the order was eventually paid or expired.
+type CheckoutRecovery struct {
+	ID              uint       `gorm:"primarykey" json:"id"`
+	TenantID        uint       `gorm:"index;not null;default:1" json:"tenant_id"`
+	OrderID         uint       `gorm:"uniqueIndex;not null" json:"order_id"`
+	Order           *Order     `gorm:"foreignKey:OrderID" json:"order,omitempty"`
+	ContactID       uint       `gorm:"index;not null" json:"contact_id"`
+	Contact         *Contact   `gorm:"foreignKey:ContactID" json:"contact,omitempty"`
+	Email           string     `gorm:"size:255;not null" json:"email"`
+	Token           string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	EmailsSent      int        `gorm:"default:0" json:"emails_sent"`
+	LastEmailAt     *time.Time `json:"last_email_at"`
+	CouponID        *uint      `json:"coupon_id"`
+	CouponCode      string     `gorm:"size:50" json:"coupon_code"`
+	AbandonedAt     time.Time  `gorm:"index;not null" json:"abandoned_at"`
+	RecoveredAt     *time.Time `gorm:"index" json:"recovered_at"`
+	RecoveredAmount float64    `gorm:"type:decimal(12,2);default:0" json:"recovered_amount"` // base currency
+	ExpiredAt       *time.Time `json:"expired_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
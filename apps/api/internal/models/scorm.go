@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// --- SCORM / xAPI Packages ---
+
+// Package content standards.
+const (
+	ScormVersion12   = "1.2"
+	ScormVersion2004 = "2004"
+	ScormVersionXAPI = "xapi" // tincan.xml packages
+)
+
+// Package import statuses.
+const (
+	ScormPending    = "pending"
+	ScormProcessing = "processing"
+	ScormReady      = "ready"
+	ScormFailed     = "failed"
+)
+
+// ScormPackage is an uploaded SCORM or xAPI zip, unpacked to storage under
+// ContentPath. Importing it creates a module of lessons that launch its SCOs.
+type ScormPackage struct {
+	ID          uint           `gorm:"primarykey" json:"id"`
+	TenantID    uint           `gorm:"index;not null;default:1" json:"tenant_id"`
+	CourseID    uint           `gorm:"index;not null" json:"course_id"`
+	UploadID    uint           `gorm:"not null" json:"upload_id"`
+	Title       string         `gorm:"size:500" json:"title"`
+	Version     string         `gorm:"size:10" json:"version"`
+	ContentPath string         `gorm:"size:500" json:"content_path"`
+	Status      string         `gorm:"size:20;default:'pending'" json:"status"`
+	Error       string         `gorm:"size:500" json:"error,omitempty"`
+	LessonCount int            `gorm:"default:0" json:"lesson_count"`
+	ImportedAt  *time.Time     `json:"imported_at"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Upload *Upload `gorm:"foreignKey:UploadID" json:"upload,omitempty"`
+}
+
+// XAPIStatement is a statement emitted by a lesson's package content.
+type XAPIStatement struct {
+	ID           uint           `gorm:"primarykey" json:"id"`
+	TenantID     uint           `gorm:"index;not null;default:1" json:"tenant_id"`
+	StatementID  string         `gorm:"size:36;uniqueIndex;not null" json:"statement_id"`
+	EnrollmentID uint           `gorm:"index;not null" json:"enrollment_id"`
+	LessonID     uint           `gorm:"index;not null" json:"lesson_id"`
+	Verb         string         `gorm:"size:255" json:"verb"`
+	ObjectID     string         `gorm:"size:500" json:"object_id"`
+	Statement    datatypes.JSON `gorm:"type:jsonb" json:"statement"`
+	Timestamp    time.Time      `json:"timestamp"`
+	CreatedAt    time.Time      `json:"created_at"`
+}
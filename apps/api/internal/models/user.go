@@ -146,6 +146,34 @@ func Models() []interface{} {
 		&WorkflowExecution{},
 		&PremiumGuide{},
 		&GuideDownload{},
+		&Refund{},
+		&Invoice{},
+		&InvoiceSequence{},
+		&OrderFulfillmentStep{},
+		&OrderDiscount{},
+		&ExchangeRate{},
+		&CheckoutRecovery{},
+		&OrderBump{},
+		&MetricSnapshot{},
+		&CustomerMRRSnapshot{},
+		&CohortRetention{},
+		&AffiliateAsset{},
+		&AffiliateProductCommission{},
+		&PayoutAllocation{},
+		&AffiliateLedgerEntry{},
+		&PayoutBatch{},
+		&AffiliateClick{},
+		&CertificateTemplate{},
+		&Assignment{},
+		&AssignmentSubmission{},
+		&CourseCohort{},
+		&ProductCourse{},
+		&LearningPath{},
+		&LearningPathCourse{},
+		&LearningPathEnrollment{},
+		&MembershipEntitlement{},
+		&ScormPackage{},
+		&XAPIStatement{},
 		// grit:models
 	}
 }
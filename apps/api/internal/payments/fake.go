@@ -0,0 +1,121 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// FakeProvider is an in-memory PaymentProvider for tests and local
+// development. It never touches the network: payments succeed according to
+// ConfirmStatus and refunds always succeed. Install it with Registry.Register.
+type FakeProvider struct {
+	mu sync.Mutex
+
+	// ConfirmStatus is returned by ConfirmPayment (default StatusSucceeded).
+	ConfirmStatus string
+	// FailRefunds makes Refund return an error.
+	FailRefunds bool
+
+	seq      int
+	Payments map[string]PaymentRequest
+	Refunds  []RefundRequest
+}
+
+// NewFakeProvider creates a FakeProvider whose payments succeed.
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{ConfirmStatus: StatusSucceeded, Payments: map[string]PaymentRequest{}}
+}
+
+var _ OffSessionCharger = (*FakeProvider)(nil)
+
+func (p *FakeProvider) Name() string { return ProviderFake }
+
+func (p *FakeProvider) CreatePayment(ctx context.Context, req PaymentRequest) (*PaymentSession, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seq++
+	id := fmt.Sprintf("fake_pay_%d", p.seq)
+	p.Payments[id] = req
+	return &PaymentSession{
+		PaymentID:   id,
+		RedirectURL: req.ReturnURL,
+		Data:        map[string]interface{}{"payment_id": id},
+	}, nil
+}
+
+func (p *FakeProvider) ConfirmPayment(ctx context.Context, paymentID string) (*PaymentResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	req, ok := p.Payments[paymentID]
+	if !ok {
+		return nil, fmt.Errorf("unknown payment %s", paymentID)
+	}
+	res := &PaymentResult{Status: p.ConfirmStatus, PaymentID: paymentID}
+	if res.Status == StatusSucceeded {
+		res.Reference = paymentID
+		res.Amount = req.Amount
+		res.Currency = req.Currency
+	}
+	return res, nil
+}
+
+// SavedPaymentMethod returns a fake card for any payment created with
+// SavePaymentMethod.
+func (p *FakeProvider) SavedPaymentMethod(ctx context.Context, paymentID string) (*SavedPaymentMethod, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	req, ok := p.Payments[paymentID]
+	if !ok || !req.SavePaymentMethod {
+		return nil, ErrNoSavedPaymentMethod
+	}
+	return &SavedPaymentMethod{CustomerID: "fake_cus_" + req.CustomerEmail, PaymentMethodID: "fake_pm_" + paymentID}, nil
+}
+
+// ChargeSaved records an off-session payment with status ConfirmStatus.
+func (p *FakeProvider) ChargeSaved(ctx context.Context, req PaymentRequest, method SavedPaymentMethod) (*PaymentResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seq++
+	id := fmt.Sprintf("fake_pay_%d", p.seq)
+	p.Payments[id] = req
+	res := &PaymentResult{Status: p.ConfirmStatus, PaymentID: id}
+	if res.Status == StatusSucceeded {
+		res.Reference = id
+	}
+	return res, nil
+}
+
+func (p *FakeProvider) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.FailRefunds {
+		return nil, fmt.Errorf("fake refund failure")
+	}
+	p.Refunds = append(p.Refunds, req)
+	return &RefundResult{RefundID: fmt.Sprintf("fake_refund_%d", len(p.Refunds)), Status: StatusSucceeded}, nil
+}
+
+// ParseWebhook accepts the normalised event as JSON, e.g.
+// {"type":"payment.succeeded","payment_id":"fake_pay_1"}.
+func (p *FakeProvider) ParseWebhook(r *http.Request, body []byte) (*WebhookEvent, error) {
+	var event struct {
+		Type          string `json:"type"`
+		PaymentID     string `json:"payment_id"`
+		Reference     string `json:"reference"`
+		RefundID      string `json:"refund_id"`
+		FailureReason string `json:"failure_reason"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	return &WebhookEvent{
+		Type:          event.Type,
+		PaymentID:     event.PaymentID,
+		Reference:     event.Reference,
+		RefundID:      event.RefundID,
+		FailureReason: event.FailureReason,
+	}, nil
+}
@@ -0,0 +1,176 @@
+package payments
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const flutterwaveBaseURL = "https://api.flutterwave.com/v3"
+
+// FlutterwaveProvider takes card, mobile money and bank payments through
+// Flutterwave Standard (hosted checkout).
+type FlutterwaveProvider struct {
+	secretKey   string
+	publicKey   string
+	webhookHash string
+}
+
+// NewFlutterwaveProvider creates a Flutterwave provider from tenant credentials.
+func NewFlutterwaveProvider(creds Credentials) (*FlutterwaveProvider, error) {
+	if creds.Get("flutterwave_secret_key") == "" {
+		return nil, ErrNotConfigured
+	}
+	return &FlutterwaveProvider{
+		secretKey:   creds.Get("flutterwave_secret_key"),
+		publicKey:   creds.Get("flutterwave_public_key"),
+		webhookHash: creds.Get("flutterwave_webhook_hash"),
+	}, nil
+}
+
+func (p *FlutterwaveProvider) Name() string { return ProviderFlutterwave }
+
+func (p *FlutterwaveProvider) call(ctx context.Context, method, path string, payload, out interface{}) error {
+	return doJSON(ctx, method, flutterwaveBaseURL+path, map[string]string{"Authorization": "Bearer " + p.secretKey}, payload, out)
+}
+
+// CreatePayment creates a hosted payment link. Our order number is used as
+// tx_ref, which is what Flutterwave reports back in webhooks.
+func (p *FlutterwaveProvider) CreatePayment(ctx context.Context, req PaymentRequest) (*PaymentSession, error) {
+	txRef := req.OrderNumber
+	payload := map[string]interface{}{
+		"tx_ref":       txRef,
+		"amount":       majorUnits(req.Amount),
+		"currency":     strings.ToUpper(req.Currency),
+		"redirect_url": req.ReturnURL,
+		"customer": map[string]interface{}{
+			"email":       req.CustomerEmail,
+			"name":        req.CustomerName,
+			"phonenumber": req.Phone,
+		},
+		"customizations": map[string]interface{}{
+			"title": req.Description,
+		},
+		"meta": map[string]interface{}{
+			"order_id": req.OrderID,
+		},
+	}
+
+	var result struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Data    struct {
+			Link string `json:"link"`
+		} `json:"data"`
+	}
+	if err := p.call(ctx, "POST", "/payments", payload, &result); err != nil {
+		return nil, fmt.Errorf("Flutterwave payment failed: %w", err)
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("Flutterwave payment failed: %s", result.Message)
+	}
+
+	return &PaymentSession{
+		PaymentID:   txRef,
+		RedirectURL: result.Data.Link,
+		Data: map[string]interface{}{
+			"payment_url": result.Data.Link,
+			"public_key":  p.publicKey,
+		},
+	}, nil
+}
+
+type flutterwaveTransaction struct {
+	ID       int64   `json:"id"`
+	TxRef    string  `json:"tx_ref"`
+	Status   string  `json:"status"` // successful, failed, pending
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+// ConfirmPayment verifies the transaction by our tx_ref.
+func (p *FlutterwaveProvider) ConfirmPayment(ctx context.Context, paymentID string) (*PaymentResult, error) {
+	var result struct {
+		Status string                 `json:"status"`
+		Data   flutterwaveTransaction `json:"data"`
+	}
+	if err := p.call(ctx, "GET", "/transactions/verify_by_reference?tx_ref="+url.QueryEscape(paymentID), nil, &result); err != nil {
+		var herr *httpError
+		if errors.As(err, &herr) && strings.Contains(herr.Body, "No transaction was found") {
+			return &PaymentResult{Status: StatusPending, PaymentID: paymentID}, nil
+		}
+		return nil, err
+	}
+	return flutterwaveResult(paymentID, result.Data), nil
+}
+
+func flutterwaveResult(paymentID string, tx flutterwaveTransaction) *PaymentResult {
+	res := &PaymentResult{Status: StatusPending, PaymentID: paymentID}
+	switch tx.Status {
+	case "successful":
+		res.Status = StatusSucceeded
+		res.Reference = fmt.Sprintf("%d", tx.ID)
+		res.Amount = math.Round(tx.Amount * 100)
+		res.Currency = tx.Currency
+	case "failed":
+		res.Status = StatusFailed
+	}
+	return res
+}
+
+// Refund refunds a transaction by its Flutterwave ID.
+func (p *FlutterwaveProvider) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	if req.Reference == "" {
+		return nil, fmt.Errorf("order has no Flutterwave transaction to refund")
+	}
+	var result struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Data    struct {
+			ID     int64  `json:"id"`
+			Status string `json:"status"`
+		} `json:"data"`
+	}
+	payload := map[string]interface{}{"amount": majorUnits(req.Amount)}
+	if err := p.call(ctx, "POST", "/transactions/"+url.PathEscape(req.Reference)+"/refund", payload, &result); err != nil {
+		return nil, fmt.Errorf("Flutterwave refund failed: %w", err)
+	}
+	if result.Status != "success" || result.Data.Status == "failed" {
+		return nil, fmt.Errorf("Flutterwave refund failed: %s", result.Message)
+	}
+	return &RefundResult{RefundID: fmt.Sprintf("%d", result.Data.ID), Status: StatusSucceeded}, nil
+}
+
+// ParseWebhook checks the verif-hash header against the configured secret
+// hash and maps charge.completed events.
+func (p *FlutterwaveProvider) ParseWebhook(r *http.Request, body []byte) (*WebhookEvent, error) {
+	if p.webhookHash == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("verif-hash")), []byte(p.webhookHash)) != 1 {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	var event struct {
+		Event string                 `json:"event"`
+		Data  flutterwaveTransaction `json:"data"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	if event.Event != "charge.completed" {
+		return &WebhookEvent{Type: EventIgnored}, nil
+	}
+
+	res := flutterwaveResult(event.Data.TxRef, event.Data)
+	switch res.Status {
+	case StatusSucceeded:
+		return &WebhookEvent{Type: EventPaymentSucceeded, PaymentID: event.Data.TxRef, Reference: res.Reference}, nil
+	case StatusFailed:
+		return &WebhookEvent{Type: EventPaymentFailed, PaymentID: event.Data.TxRef}, nil
+	}
+	return &WebhookEvent{Type: EventIgnored}, nil
+}
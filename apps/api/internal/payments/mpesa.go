@@ -0,0 +1,306 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MPesaProvider takes payments through Lipa Na M-Pesa Online (STK Push) on
+// Safaricom's Daraja API. Refunds are transaction reversals, whose result
+// arrives asynchronously.
+type MPesaProvider struct {
+	baseURL            string
+	consumerKey        string
+	consumerSecret     string
+	shortcode          string
+	passkey            string
+	initiatorName      string
+	securityCredential string
+}
+
+// NewMPesaProvider creates an M-Pesa provider from tenant credentials.
+func NewMPesaProvider(creds Credentials) (*MPesaProvider, error) {
+	if creds.Get("mpesa_consumer_key") == "" || creds.Get("mpesa_consumer_secret") == "" {
+		return nil, ErrNotConfigured
+	}
+	baseURL := "https://sandbox.safaricom.co.ke"
+	if creds.Get("mpesa_environment") == "production" {
+		baseURL = "https://api.safaricom.co.ke"
+	}
+	return &MPesaProvider{
+		baseURL:            baseURL,
+		consumerKey:        creds.Get("mpesa_consumer_key"),
+		consumerSecret:     creds.Get("mpesa_consumer_secret"),
+		shortcode:          creds.Get("mpesa_shortcode"),
+		passkey:            creds.Get("mpesa_passkey"),
+		initiatorName:      creds.Get("mpesa_initiator_name"),
+		securityCredential: creds.Get("mpesa_security_credential"),
+	}, nil
+}
+
+func (p *MPesaProvider) Name() string { return ProviderMPesa }
+
+// accessToken generates an OAuth token from Daraja API.
+func (p *MPesaProvider) accessToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.baseURL+"/oauth/v1/generate?grant_type=client_credentials", nil)
+	if err != nil {
+		return "", err
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(p.consumerKey + ":" + p.consumerSecret))
+	req.Header.Add("Authorization", "Basic "+auth)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("M-Pesa auth failed: %s", resp.Status)
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.AccessToken, nil
+}
+
+func (p *MPesaProvider) call(ctx context.Context, path string, payload, out interface{}) error {
+	token, err := p.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	return doJSON(ctx, "POST", p.baseURL+path, map[string]string{"Authorization": "Bearer " + token}, payload, out)
+}
+
+func (p *MPesaProvider) password(timestamp string) string {
+	return base64.StdEncoding.EncodeToString([]byte(p.shortcode + p.passkey + timestamp))
+}
+
+// normalizePhone makes sure the number starts with 254.
+func normalizePhone(phone string) string {
+	if phone != "" && phone[0] == '+' {
+		phone = phone[1:]
+	}
+	if phone != "" && phone[0] == '0' {
+		phone = "254" + phone[1:]
+	}
+	return phone
+}
+
+// wholeShillings converts minor units to the integer amount M-Pesa expects.
+func wholeShillings(amount float64) int {
+	return int(math.Round(majorUnits(amount)))
+}
+
+// CreatePayment sends an STK Push prompt to the customer's phone.
+func (p *MPesaProvider) CreatePayment(ctx context.Context, req PaymentRequest) (*PaymentSession, error) {
+	if req.Phone == "" {
+		return nil, fmt.Errorf("phone number is required for M-Pesa")
+	}
+	phone := normalizePhone(req.Phone)
+	timestamp := time.Now().Format("20060102150405")
+
+	payload := map[string]interface{}{
+		"BusinessShortCode": p.shortcode,
+		"Password":          p.password(timestamp),
+		"Timestamp":         timestamp,
+		"TransactionType":   "CustomerPayBillOnline", // Or "CustomerBuyGoodsOnline"
+		"Amount":            wholeShillings(req.Amount),
+		"PartyA":            phone,
+		"PartyB":            p.shortcode,
+		"PhoneNumber":       phone,
+		"CallBackURL":       req.CallbackURL,
+		"AccountReference":  req.OrderNumber,
+		"TransactionDesc":   req.Description,
+	}
+
+	var result struct {
+		CheckoutRequestID string `json:"CheckoutRequestID"`
+		ResponseCode      string `json:"ResponseCode"`
+		CustomerMessage   string `json:"CustomerMessage"`
+	}
+	if err := p.call(ctx, "/mpesa/stkpush/v1/processrequest", payload, &result); err != nil {
+		return nil, fmt.Errorf("M-Pesa STK Push failed: %w", err)
+	}
+	if result.ResponseCode != "0" {
+		return nil, fmt.Errorf("STK Push error: %s", result.CustomerMessage)
+	}
+
+	return &PaymentSession{
+		PaymentID: result.CheckoutRequestID,
+		Data: map[string]interface{}{
+			"checkout_request_id": result.CheckoutRequestID,
+			"message":             "STK Push sent to your phone. Please enter your PIN to complete the payment.",
+		},
+	}, nil
+}
+
+// ConfirmPayment queries the STK Push status. The receipt number is only
+// delivered by the callback, so Reference is empty here.
+func (p *MPesaProvider) ConfirmPayment(ctx context.Context, paymentID string) (*PaymentResult, error) {
+	timestamp := time.Now().Format("20060102150405")
+	payload := map[string]interface{}{
+		"BusinessShortCode": p.shortcode,
+		"Password":          p.password(timestamp),
+		"Timestamp":         timestamp,
+		"CheckoutRequestID": paymentID,
+	}
+
+	var result struct {
+		ResultCode string `json:"ResultCode"`
+		ResultDesc string `json:"ResultDesc"`
+	}
+	if err := p.call(ctx, "/mpesa/stkpushquery/v1/query", payload, &result); err != nil {
+		var herr *httpError
+		// Daraja answers with an error while the customer has not responded yet
+		if errors.As(err, &herr) {
+			return &PaymentResult{Status: StatusPending, PaymentID: paymentID}, nil
+		}
+		return nil, err
+	}
+
+	switch result.ResultCode {
+	case "0":
+		return &PaymentResult{Status: StatusSucceeded, PaymentID: paymentID}, nil
+	case "":
+		return &PaymentResult{Status: StatusPending, PaymentID: paymentID}, nil
+	}
+	return &PaymentResult{Status: StatusFailed, PaymentID: paymentID, FailureReason: result.ResultDesc}, nil
+}
+
+// Refund requests a reversal of the transaction identified by the receipt
+// number. The result is posted to req.CallbackURL, signed for the order.
+func (p *MPesaProvider) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	if req.Reference == "" {
+		return nil, fmt.Errorf("order has no M-Pesa receipt number to reverse")
+	}
+	if p.initiatorName == "" || p.securityCredential == "" {
+		return nil, fmt.Errorf("M-Pesa reversal credentials not configured")
+	}
+
+	remarks := req.Reason
+	if remarks == "" {
+		remarks = "Refund"
+	}
+	payload := map[string]interface{}{
+		"Initiator":              p.initiatorName,
+		"SecurityCredential":     p.securityCredential,
+		"CommandID":              "TransactionReversal",
+		"TransactionID":          req.Reference,
+		"Amount":                 wholeShillings(req.Amount),
+		"ReceiverParty":          p.shortcode,
+		"RecieverIdentifierType": "11", // sic — Daraja's spelling
+		"ResultURL":              p.reversalURL(req.CallbackURL, req.OrderID),
+		"QueueTimeOutURL":        p.reversalURL(req.CallbackURL, req.OrderID),
+		"Remarks":                remarks,
+		"Occasion":               "",
+	}
+
+	var result struct {
+		ConversationID      string `json:"ConversationID"`
+		ResponseCode        string `json:"ResponseCode"`
+		ResponseDescription string `json:"ResponseDescription"`
+	}
+	if err := p.call(ctx, "/mpesa/reversal/v1/request", payload, &result); err != nil {
+		return nil, fmt.Errorf("M-Pesa reversal failed: %w", err)
+	}
+	if result.ResponseCode != "0" {
+		return nil, fmt.Errorf("M-Pesa reversal error: %s", result.ResponseDescription)
+	}
+	return &RefundResult{RefundID: result.ConversationID, Status: StatusPending}, nil
+}
+
+// reversalURL adds the order and its signature to a reversal result URL.
+// Daraja doesn't sign callbacks, so the signature, which only Daraja sees,
+// is what shows a result came back on a URL we issued.
+func (p *MPesaProvider) reversalURL(callbackURL string, orderID uint) string {
+	sep := "?"
+	if strings.Contains(callbackURL, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%sorder=%d&sig=%s", callbackURL, sep, orderID, p.reversalSignature(orderID))
+}
+
+func (p *MPesaProvider) reversalSignature(orderID uint) string {
+	mac := hmac.New(sha256.New, []byte(p.consumerSecret))
+	fmt.Fprintf(mac, "reversal:%d", orderID)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ParseWebhook handles both the STK Push callback and the reversal result.
+// Daraja callbacks are not signed, and the CheckoutRequestID reaches the
+// customer, so an STK result is only a hint: confirm it with ConfirmPayment
+// (the STK query) before fulfilling. Reversal results must arrive on the
+// signed URL Refund gave Daraja; the event carries the order it was for.
+func (p *MPesaProvider) ParseWebhook(r *http.Request, body []byte) (*WebhookEvent, error) {
+	var payload struct {
+		Body *struct {
+			StkCallback struct {
+				CheckoutRequestID string `json:"CheckoutRequestID"`
+				ResultCode        int    `json:"ResultCode"`
+				ResultDesc        string `json:"ResultDesc"`
+				CallbackMetadata  struct {
+					Item []struct {
+						Name  string      `json:"Name"`
+						Value interface{} `json:"Value"`
+					} `json:"Item"`
+				} `json:"CallbackMetadata"`
+			} `json:"stkCallback"`
+		} `json:"Body"`
+		Result *struct {
+			ResultCode     int    `json:"ResultCode"`
+			ResultDesc     string `json:"ResultDesc"`
+			ConversationID string `json:"ConversationID"`
+		} `json:"Result"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+
+	// Reversal result
+	if payload.Result != nil {
+		query := r.URL.Query()
+		orderID, err := strconv.ParseUint(query.Get("order"), 10, 64)
+		if err != nil || !hmac.Equal([]byte(query.Get("sig")), []byte(p.reversalSignature(uint(orderID)))) {
+			return nil, fmt.Errorf("reversal result without a valid signature")
+		}
+		res := payload.Result
+		if res.ResultCode == 0 {
+			return &WebhookEvent{Type: EventRefundSucceeded, RefundID: res.ConversationID, OrderID: uint(orderID)}, nil
+		}
+		return &WebhookEvent{Type: EventRefundFailed, RefundID: res.ConversationID, OrderID: uint(orderID), FailureReason: res.ResultDesc}, nil
+	}
+
+	if payload.Body == nil {
+		return &WebhookEvent{Type: EventIgnored}, nil
+	}
+	cb := payload.Body.StkCallback
+	if cb.ResultCode != 0 {
+		return &WebhookEvent{Type: EventPaymentFailed, PaymentID: cb.CheckoutRequestID, FailureReason: cb.ResultDesc}, nil
+	}
+
+	event := &WebhookEvent{Type: EventPaymentSucceeded, PaymentID: cb.CheckoutRequestID}
+	for _, item := range cb.CallbackMetadata.Item {
+		if item.Name == "MpesaReceiptNumber" {
+			if receipt, ok := item.Value.(string); ok {
+				event.Reference = receipt
+			}
+		}
+	}
+	return event, nil
+}
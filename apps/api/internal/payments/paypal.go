@@ -0,0 +1,287 @@
+package payments
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// PayPalProvider takes payments through PayPal Checkout (Orders v2).
+type PayPalProvider struct {
+	clientID  string
+	secret    string
+	webhookID string
+	baseURL   string
+}
+
+// NewPayPalProvider creates a PayPal provider from tenant credentials.
+func NewPayPalProvider(creds Credentials) (*PayPalProvider, error) {
+	if creds.Get("paypal_client_id") == "" || creds.Get("paypal_secret") == "" {
+		return nil, ErrNotConfigured
+	}
+	baseURL := "https://api-m.sandbox.paypal.com"
+	if creds.Get("paypal_mode") == "live" {
+		baseURL = "https://api-m.paypal.com"
+	}
+	return &PayPalProvider{
+		clientID:  creds.Get("paypal_client_id"),
+		secret:    creds.Get("paypal_secret"),
+		webhookID: creds.Get("paypal_webhook_id"),
+		baseURL:   baseURL,
+	}, nil
+}
+
+func (p *PayPalProvider) Name() string { return ProviderPayPal }
+
+// accessToken generates the OAuth2 access token.
+func (p *PayPalProvider) accessToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/v1/oauth2/token", strings.NewReader("grant_type=client_credentials"))
+	if err != nil {
+		return "", err
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(p.clientID + ":" + p.secret))
+	req.Header.Add("Authorization", "Basic "+auth)
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("PayPal auth failed: %s", resp.Status)
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.AccessToken, nil
+}
+
+func (p *PayPalProvider) call(ctx context.Context, method, path string, payload, out interface{}) error {
+	token, err := p.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	return doJSON(ctx, method, p.baseURL+path, map[string]string{"Authorization": "Bearer " + token}, payload, out)
+}
+
+type payPalOrder struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Links  []struct {
+		Href string `json:"href"`
+		Rel  string `json:"rel"`
+	} `json:"links"`
+	PurchaseUnits []struct {
+		Payments struct {
+			Captures []struct {
+				ID     string `json:"id"`
+				Status string `json:"status"`
+				Amount struct {
+					CurrencyCode string `json:"currency_code"`
+					Value        string `json:"value"`
+				} `json:"amount"`
+			} `json:"captures"`
+		} `json:"payments"`
+	} `json:"purchase_units"`
+}
+
+func (o *payPalOrder) captureID() string {
+	for _, pu := range o.PurchaseUnits {
+		if len(pu.Payments.Captures) > 0 {
+			return pu.Payments.Captures[0].ID
+		}
+	}
+	return ""
+}
+
+// succeeded reports the captured order, with the amount captured.
+func (o *payPalOrder) succeeded(paymentID string) *PaymentResult {
+	res := &PaymentResult{Status: StatusSucceeded, PaymentID: paymentID, Reference: o.captureID()}
+	for _, pu := range o.PurchaseUnits {
+		for _, capture := range pu.Payments.Captures {
+			if capture.Status != "COMPLETED" {
+				continue
+			}
+			value, err := strconv.ParseFloat(capture.Amount.Value, 64)
+			if err != nil {
+				continue
+			}
+			res.Amount += math.Round(value * 100)
+			res.Currency = capture.Amount.CurrencyCode
+		}
+	}
+	return res
+}
+
+// CreatePayment creates a PayPal order and returns the approval link.
+func (p *PayPalProvider) CreatePayment(ctx context.Context, req PaymentRequest) (*PaymentSession, error) {
+	payload := map[string]interface{}{
+		"intent": "CAPTURE",
+		"purchase_units": []map[string]interface{}{
+			{
+				"reference_id": fmt.Sprintf("%d", req.OrderID),
+				"description":  req.Description,
+				"amount": map[string]interface{}{
+					"currency_code": strings.ToUpper(req.Currency),
+					"value":         fmt.Sprintf("%.2f", majorUnits(req.Amount)),
+				},
+			},
+		},
+	}
+	if req.ReturnURL != "" {
+		payload["application_context"] = map[string]interface{}{
+			"return_url": req.ReturnURL,
+			"cancel_url": req.CancelURL,
+		}
+	}
+
+	var order payPalOrder
+	if err := p.call(ctx, "POST", "/v2/checkout/orders", payload, &order); err != nil {
+		return nil, fmt.Errorf("PayPal create order failed: %w", err)
+	}
+
+	var approvalURL string
+	for _, link := range order.Links {
+		if link.Rel == "approve" {
+			approvalURL = link.Href
+			break
+		}
+	}
+
+	return &PaymentSession{
+		PaymentID:   order.ID,
+		RedirectURL: approvalURL,
+		Data: map[string]interface{}{
+			"paypal_order_id": order.ID,
+			"approval_url":    approvalURL,
+		},
+	}, nil
+}
+
+// ConfirmPayment captures an approved order. The capture ID is returned as
+// the reference that refunds are issued against.
+func (p *PayPalProvider) ConfirmPayment(ctx context.Context, paymentID string) (*PaymentResult, error) {
+	var order payPalOrder
+	err := p.call(ctx, "POST", "/v2/checkout/orders/"+paymentID+"/capture", nil, &order)
+	if err != nil {
+		var herr *httpError
+		// For idempotency — if already captured, look the capture up on the order instead
+		if errors.As(err, &herr) && strings.Contains(herr.Body, "ORDER_ALREADY_CAPTURED") {
+			if err := p.call(ctx, "GET", "/v2/checkout/orders/"+paymentID, nil, &order); err != nil {
+				return nil, err
+			}
+			return order.succeeded(paymentID), nil
+		}
+		if errors.As(err, &herr) && strings.Contains(herr.Body, "ORDER_NOT_APPROVED") {
+			return &PaymentResult{Status: StatusPending, PaymentID: paymentID}, nil
+		}
+		return nil, fmt.Errorf("PayPal capture order failed: %w", err)
+	}
+
+	if order.Status != "COMPLETED" {
+		return &PaymentResult{Status: StatusPending, PaymentID: paymentID}, nil
+	}
+	return order.succeeded(paymentID), nil
+}
+
+// Refund refunds all or part of a capture.
+func (p *PayPalProvider) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	if req.Reference == "" {
+		return nil, fmt.Errorf("order has no PayPal capture to refund")
+	}
+	payload := map[string]interface{}{
+		"amount": map[string]interface{}{
+			"currency_code": strings.ToUpper(req.Currency),
+			"value":         fmt.Sprintf("%.2f", majorUnits(req.Amount)),
+		},
+	}
+	if req.Reason != "" {
+		payload["note_to_payer"] = req.Reason
+	}
+
+	var refund struct {
+		ID     string `json:"id"`
+		Status string `json:"status"` // COMPLETED, PENDING, FAILED, CANCELLED
+	}
+	if err := p.call(ctx, "POST", "/v2/payments/captures/"+url.PathEscape(req.Reference)+"/refund", payload, &refund); err != nil {
+		return nil, fmt.Errorf("PayPal refund failed: %w", err)
+	}
+	if refund.Status == "FAILED" || refund.Status == "CANCELLED" {
+		return nil, fmt.Errorf("PayPal refund %s", strings.ToLower(refund.Status))
+	}
+	return &RefundResult{RefundID: refund.ID, Status: StatusSucceeded}, nil
+}
+
+// ParseWebhook verifies the event's signature with PayPal and maps capture
+// events. Without paypal_webhook_id there is nothing to verify against, so
+// every event is rejected.
+func (p *PayPalProvider) ParseWebhook(r *http.Request, body []byte) (*WebhookEvent, error) {
+	var event struct {
+		EventType string `json:"event_type"`
+		Resource  struct {
+			ID            string `json:"id"`
+			StatusDetails struct {
+				Reason string `json:"reason"`
+			} `json:"status_details"`
+			SupplementaryData struct {
+				RelatedIDs struct {
+					OrderID string `json:"order_id"`
+				} `json:"related_ids"`
+			} `json:"supplementary_data"`
+		} `json:"resource"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+
+	if p.webhookID == "" {
+		return nil, fmt.Errorf("paypal_webhook_id not configured; cannot verify webhooks")
+	}
+	if err := p.verifyWebhook(r, body); err != nil {
+		return nil, err
+	}
+
+	orderID := event.Resource.SupplementaryData.RelatedIDs.OrderID
+	switch event.EventType {
+	case "PAYMENT.CAPTURE.COMPLETED":
+		return &WebhookEvent{Type: EventPaymentSucceeded, PaymentID: orderID, Reference: event.Resource.ID}, nil
+	case "PAYMENT.CAPTURE.DENIED":
+		return &WebhookEvent{Type: EventPaymentFailed, PaymentID: orderID, FailureReason: event.Resource.StatusDetails.Reason}, nil
+	}
+	return &WebhookEvent{Type: EventIgnored}, nil
+}
+
+func (p *PayPalProvider) verifyWebhook(r *http.Request, body []byte) error {
+	payload := map[string]interface{}{
+		"auth_algo":         r.Header.Get("PAYPAL-AUTH-ALGO"),
+		"cert_url":          r.Header.Get("PAYPAL-CERT-URL"),
+		"transmission_id":   r.Header.Get("PAYPAL-TRANSMISSION-ID"),
+		"transmission_sig":  r.Header.Get("PAYPAL-TRANSMISSION-SIG"),
+		"transmission_time": r.Header.Get("PAYPAL-TRANSMISSION-TIME"),
+		"webhook_id":        p.webhookID,
+		"webhook_event":     json.RawMessage(body),
+	}
+	var result struct {
+		VerificationStatus string `json:"verification_status"`
+	}
+	if err := p.call(r.Context(), "POST", "/v1/notifications/verify-webhook-signature", payload, &result); err != nil {
+		return fmt.Errorf("verifying webhook: %w", err)
+	}
+	if result.VerificationStatus != "SUCCESS" {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
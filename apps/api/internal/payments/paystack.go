@@ -0,0 +1,173 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const paystackBaseURL = "https://api.paystack.co"
+
+// PaystackProvider takes payments through Paystack's hosted checkout.
+type PaystackProvider struct {
+	secretKey string
+	publicKey string
+}
+
+// NewPaystackProvider creates a Paystack provider from tenant credentials.
+func NewPaystackProvider(creds Credentials) (*PaystackProvider, error) {
+	if creds.Get("paystack_secret_key") == "" {
+		return nil, ErrNotConfigured
+	}
+	return &PaystackProvider{
+		secretKey: creds.Get("paystack_secret_key"),
+		publicKey: creds.Get("paystack_public_key"),
+	}, nil
+}
+
+func (p *PaystackProvider) Name() string { return ProviderPaystack }
+
+func (p *PaystackProvider) call(ctx context.Context, method, path string, payload, out interface{}) error {
+	return doJSON(ctx, method, paystackBaseURL+path, map[string]string{"Authorization": "Bearer " + p.secretKey}, payload, out)
+}
+
+// CreatePayment initializes a transaction. Paystack takes amounts in
+// subunits (kobo, pesewas, cents), the same unit orders are stored in.
+func (p *PaystackProvider) CreatePayment(ctx context.Context, req PaymentRequest) (*PaymentSession, error) {
+	payload := map[string]interface{}{
+		"email":        req.CustomerEmail,
+		"amount":       int64(math.Round(req.Amount)),
+		"currency":     strings.ToUpper(req.Currency),
+		"reference":    req.OrderNumber,
+		"callback_url": req.ReturnURL,
+		"metadata": map[string]interface{}{
+			"order_id": req.OrderID,
+		},
+	}
+
+	var result struct {
+		Status  bool   `json:"status"`
+		Message string `json:"message"`
+		Data    struct {
+			AuthorizationURL string `json:"authorization_url"`
+			AccessCode       string `json:"access_code"`
+			Reference        string `json:"reference"`
+		} `json:"data"`
+	}
+	if err := p.call(ctx, "POST", "/transaction/initialize", payload, &result); err != nil {
+		return nil, fmt.Errorf("Paystack initialize failed: %w", err)
+	}
+	if !result.Status {
+		return nil, fmt.Errorf("Paystack initialize failed: %s", result.Message)
+	}
+
+	return &PaymentSession{
+		PaymentID:   result.Data.Reference,
+		RedirectURL: result.Data.AuthorizationURL,
+		Data: map[string]interface{}{
+			"payment_url": result.Data.AuthorizationURL,
+			"access_code": result.Data.AccessCode,
+			"public_key":  p.publicKey,
+		},
+	}, nil
+}
+
+type paystackTransaction struct {
+	ID              int64  `json:"id"`
+	Reference       string `json:"reference"`
+	Status          string `json:"status"` // success, failed, abandoned, ongoing, pending
+	GatewayResponse string `json:"gateway_response"`
+	Amount          int64  `json:"amount"` // kobo
+	Currency        string `json:"currency"`
+}
+
+func paystackResult(tx paystackTransaction) *PaymentResult {
+	res := &PaymentResult{Status: StatusPending, PaymentID: tx.Reference}
+	switch tx.Status {
+	case "success":
+		res.Status = StatusSucceeded
+		res.Reference = tx.Reference
+		res.Amount = float64(tx.Amount)
+		res.Currency = tx.Currency
+	case "failed", "reversed":
+		res.Status = StatusFailed
+		res.FailureReason = tx.GatewayResponse
+	}
+	return res
+}
+
+// ConfirmPayment verifies a transaction by reference.
+func (p *PaystackProvider) ConfirmPayment(ctx context.Context, paymentID string) (*PaymentResult, error) {
+	var result struct {
+		Status bool                `json:"status"`
+		Data   paystackTransaction `json:"data"`
+	}
+	if err := p.call(ctx, "GET", "/transaction/verify/"+url.PathEscape(paymentID), nil, &result); err != nil {
+		return nil, err
+	}
+	return paystackResult(result.Data), nil
+}
+
+// Refund refunds all or part of a transaction.
+func (p *PaystackProvider) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	transaction := req.Reference
+	if transaction == "" {
+		transaction = req.PaymentID
+	}
+	payload := map[string]interface{}{
+		"transaction": transaction,
+		"amount":      int64(math.Round(req.Amount)),
+	}
+	if req.Reason != "" {
+		payload["merchant_note"] = req.Reason
+	}
+
+	var result struct {
+		Status  bool   `json:"status"`
+		Message string `json:"message"`
+		Data    struct {
+			ID     int64  `json:"id"`
+			Status string `json:"status"`
+		} `json:"data"`
+	}
+	if err := p.call(ctx, "POST", "/refund", payload, &result); err != nil {
+		return nil, fmt.Errorf("Paystack refund failed: %w", err)
+	}
+	if !result.Status || result.Data.Status == "failed" {
+		return nil, fmt.Errorf("Paystack refund failed: %s", result.Message)
+	}
+	return &RefundResult{RefundID: fmt.Sprintf("%d", result.Data.ID), Status: StatusSucceeded}, nil
+}
+
+// ParseWebhook verifies the x-paystack-signature HMAC and maps charge events.
+func (p *PaystackProvider) ParseWebhook(r *http.Request, body []byte) (*WebhookEvent, error) {
+	mac := hmac.New(sha512.New, []byte(p.secretKey))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get("x-paystack-signature"))) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	var event struct {
+		Event string              `json:"event"`
+		Data  paystackTransaction `json:"data"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+
+	switch event.Event {
+	case "charge.success":
+		return &WebhookEvent{Type: EventPaymentSucceeded, PaymentID: event.Data.Reference, Reference: event.Data.Reference}, nil
+	case "charge.failed":
+		return &WebhookEvent{Type: EventPaymentFailed, PaymentID: event.Data.Reference, FailureReason: event.Data.GatewayResponse}, nil
+	}
+	return &WebhookEvent{Type: EventIgnored}, nil
+}
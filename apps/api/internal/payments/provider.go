@@ -0,0 +1,168 @@
+// Package payments defines the PaymentProvider abstraction used by checkout,
+// webhooks and refunds, and the gateway implementations behind it.
+//
+// Amounts are always in minor units (cents, kobo), the same unit stored on
+// Price, Order and OrderItem. Each provider converts to whatever its API
+// expects.
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Provider names.
+const (
+	ProviderStripe      = "stripe"
+	ProviderPayPal      = "paypal"
+	ProviderMPesa       = "mpesa"
+	ProviderFlutterwave = "flutterwave"
+	ProviderPaystack    = "paystack"
+	ProviderFake        = "fake"
+)
+
+// Payment statuses reported by ConfirmPayment and webhooks.
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Webhook event types, normalised across providers.
+const (
+	EventPaymentSucceeded = "payment.succeeded"
+	EventPaymentFailed    = "payment.failed"
+	EventRefundSucceeded  = "refund.succeeded"
+	EventRefundFailed     = "refund.failed"
+	EventIgnored          = "ignored"
+)
+
+// ErrNotConfigured is returned when a provider has no credentials for the tenant.
+var ErrNotConfigured = errors.New("payment provider not configured")
+
+// PaymentProvider is implemented by every payment gateway.
+type PaymentProvider interface {
+	// Name returns the provider identifier stored on Order.PaymentProvider.
+	Name() string
+	// CreatePayment starts a payment for an order.
+	CreatePayment(ctx context.Context, req PaymentRequest) (*PaymentSession, error)
+	// ConfirmPayment asks the gateway for the current state of a payment.
+	ConfirmPayment(ctx context.Context, paymentID string) (*PaymentResult, error)
+	// Refund returns all or part of a captured payment.
+	Refund(ctx context.Context, req RefundRequest) (*RefundResult, error)
+	// ParseWebhook verifies and normalises an incoming webhook/callback.
+	ParseWebhook(r *http.Request, body []byte) (*WebhookEvent, error)
+}
+
+// ErrNoSavedPaymentMethod is returned when a payment did not leave a
+// payment method that can be charged again.
+var ErrNoSavedPaymentMethod = errors.New("no saved payment method for this payment")
+
+// SavedPaymentMethod identifies a payment method kept on file by the gateway.
+type SavedPaymentMethod struct {
+	CustomerID      string
+	PaymentMethodID string
+}
+
+// OffSessionCharger is implemented by providers that can charge a saved
+// payment method without the customer present, for one-click upsells.
+type OffSessionCharger interface {
+	// SavedPaymentMethod returns the payment method saved by a payment
+	// created with PaymentRequest.SavePaymentMethod.
+	SavedPaymentMethod(ctx context.Context, paymentID string) (*SavedPaymentMethod, error)
+	// ChargeSaved charges a saved payment method immediately. A card that
+	// needs the customer to authenticate reports StatusFailed.
+	ChargeSaved(ctx context.Context, req PaymentRequest, method SavedPaymentMethod) (*PaymentResult, error)
+}
+
+// PaymentRequest describes the payment to create.
+type PaymentRequest struct {
+	OrderID       uint
+	OrderNumber   string
+	Amount        float64 // minor units
+	Currency      string
+	Description   string
+	CustomerEmail string
+	CustomerName  string
+	Phone         string // required by M-Pesa
+	ReturnURL     string // where hosted checkouts send the customer back
+	CancelURL     string
+	CallbackURL   string // server-to-server notification URL, if the provider takes one per payment
+	Metadata      map[string]string
+	// SavePaymentMethod keeps the payment method on file for later
+	// off-session charges (OffSessionCharger). Ignored by other providers.
+	SavePaymentMethod bool
+}
+
+// PaymentSession is what the frontend needs to complete the payment.
+type PaymentSession struct {
+	// PaymentID is stored on Order.PaymentID and used to match webhooks.
+	PaymentID string
+	// RedirectURL is set for hosted checkouts (PayPal, Flutterwave, Paystack).
+	RedirectURL string
+	// Data holds provider-specific fields returned to the client as-is
+	// (e.g. Stripe's client_secret).
+	Data map[string]interface{}
+}
+
+// PaymentResult is the gateway's view of a payment.
+type PaymentResult struct {
+	Status        string // StatusPending, StatusSucceeded, StatusFailed
+	PaymentID     string
+	Reference     string // capture ID / receipt / transaction ID used for refunds
+	FailureReason string
+	Fingerprint   string  // card fingerprint, where the provider exposes one
+	Amount        float64 // amount paid, when the provider reports it
+	Currency      string
+}
+
+// Covers reports whether the payment succeeded for at least amount in
+// currency. M-Pesa's STK query doesn't report the amount; an STK push is
+// for a fixed amount the customer can't change, so a succeeded push covers
+// the order it was created for.
+func (r *PaymentResult) Covers(amount float64, currency string) bool {
+	if r.Status != StatusSucceeded {
+		return false
+	}
+	if r.Amount == 0 && r.Currency == "" {
+		return true
+	}
+	return strings.EqualFold(r.Currency, currency) && r.Amount >= amount-0.5
+}
+
+// RefundRequest describes a refund of a captured payment.
+type RefundRequest struct {
+	OrderID     uint
+	PaymentID   string
+	Reference   string
+	Amount      float64 // minor units
+	Currency    string
+	Reason      string
+	CallbackURL string // used by providers that report the outcome asynchronously
+}
+
+// RefundResult reports the refund. StatusPending means the outcome arrives
+// later via ParseWebhook (EventRefundSucceeded / EventRefundFailed).
+type RefundResult struct {
+	RefundID string
+	Status   string
+}
+
+// WebhookEvent is a provider notification normalised to what the
+// application acts on.
+type WebhookEvent struct {
+	Type          string
+	PaymentID     string // matches Order.PaymentID
+	Reference     string
+	RefundID      string // matches Refund.ProviderRefundID
+	OrderID       uint   // order a signed refund callback URL was issued for
+	FailureReason string
+	Fingerprint   string // card fingerprint, when the payload carries one
+}
+
+// majorUnits converts a minor-unit amount for APIs that take decimals.
+func majorUnits(amount float64) float64 {
+	return amount / 100
+}
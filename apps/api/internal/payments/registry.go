@@ -0,0 +1,133 @@
+package payments
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/config"
+	"gritcms/apps/api/internal/models"
+)
+
+// Credentials holds a provider's keys for one tenant, read from settings
+// (keys prefixed with the provider name, e.g. "stripe_secret_key") and
+// falling back to environment configuration.
+type Credentials map[string]string
+
+// Get returns a credential value or "".
+func (c Credentials) Get(key string) string {
+	return c[key]
+}
+
+// Registry builds PaymentProviders with per-tenant credentials.
+type Registry struct {
+	db  *gorm.DB
+	cfg *config.Config
+
+	mu        sync.RWMutex
+	overrides map[string]PaymentProvider
+}
+
+// NewRegistry creates a provider registry.
+func NewRegistry(db *gorm.DB, cfg *config.Config) *Registry {
+	return &Registry{db: db, cfg: cfg, overrides: map[string]PaymentProvider{}}
+}
+
+// Register installs a provider instance that is used for every tenant,
+// replacing the built-in one with the same name. Intended for tests and
+// local development (see FakeProvider).
+func (r *Registry) Register(p PaymentProvider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.overrides[p.Name()] = p
+}
+
+// Provider returns the named provider configured for a tenant.
+func (r *Registry) Provider(tenantID uint, name string) (PaymentProvider, error) {
+	name = strings.ToLower(name)
+
+	r.mu.RLock()
+	p, ok := r.overrides[name]
+	r.mu.RUnlock()
+	if ok {
+		return p, nil
+	}
+
+	creds := r.Credentials(tenantID, name)
+	switch name {
+	case ProviderStripe:
+		return NewStripeProvider(creds)
+	case ProviderPayPal:
+		return NewPayPalProvider(creds)
+	case ProviderMPesa:
+		return NewMPesaProvider(creds)
+	case ProviderFlutterwave:
+		return NewFlutterwaveProvider(creds)
+	case ProviderPaystack:
+		return NewPaystackProvider(creds)
+	}
+	return nil, fmt.Errorf("unsupported payment provider %q", name)
+}
+
+// Available lists the providers that have credentials for a tenant.
+func (r *Registry) Available(tenantID uint) []string {
+	var names []string
+	for _, name := range []string{ProviderStripe, ProviderPayPal, ProviderMPesa, ProviderFlutterwave, ProviderPaystack, ProviderFake} {
+		if _, err := r.Provider(tenantID, name); err == nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Credentials loads a provider's keys for a tenant.
+func (r *Registry) Credentials(tenantID uint, provider string) Credentials {
+	creds := r.envDefaults(provider)
+
+	if r.db != nil {
+		var settings []models.Setting
+		r.db.Where("tenant_id = ? AND key LIKE ?", tenantID, provider+"\\_%").Find(&settings)
+		for _, s := range settings {
+			if v := strings.TrimSpace(s.Value); v != "" {
+				creds[s.Key] = v
+			}
+		}
+	}
+	return creds
+}
+
+// envDefaults maps environment configuration onto setting keys.
+func (r *Registry) envDefaults(provider string) Credentials {
+	c := Credentials{}
+	if r.cfg == nil {
+		return c
+	}
+	switch provider {
+	case ProviderStripe:
+		c["stripe_secret_key"] = r.cfg.StripeSecretKey
+		c["stripe_publishable_key"] = r.cfg.StripePublishableKey
+		c["stripe_webhook_secret"] = r.cfg.StripeWebhookSecret
+	case ProviderPayPal:
+		c["paypal_client_id"] = r.cfg.PayPalClientID
+		c["paypal_secret"] = r.cfg.PayPalSecret
+		c["paypal_mode"] = r.cfg.PayPalMode
+	case ProviderMPesa:
+		c["mpesa_environment"] = r.cfg.MPesaEnvironment
+		c["mpesa_consumer_key"] = r.cfg.MPesaConsumerKey
+		c["mpesa_consumer_secret"] = r.cfg.MPesaConsumerSecret
+		c["mpesa_shortcode"] = r.cfg.MPesaShortcode
+		c["mpesa_passkey"] = r.cfg.MPesaPasskey
+		c["mpesa_initiator_name"] = r.cfg.MPesaInitiatorName
+		c["mpesa_security_credential"] = r.cfg.MPesaSecurityCredential
+	case ProviderFlutterwave:
+		c["flutterwave_secret_key"] = r.cfg.FlutterwaveSecretKey
+		c["flutterwave_public_key"] = r.cfg.FlutterwavePublicKey
+		c["flutterwave_webhook_hash"] = r.cfg.FlutterwaveWebhookHash
+	case ProviderPaystack:
+		c["paystack_secret_key"] = r.cfg.PaystackSecretKey
+		c["paystack_public_key"] = r.cfg.PaystackPublicKey
+	}
+	return c
+}
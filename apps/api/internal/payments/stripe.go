@@ -0,0 +1,248 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/stripe/stripe-go/v82"
+	"github.com/stripe/stripe-go/v82/customer"
+	"github.com/stripe/stripe-go/v82/paymentintent"
+	"github.com/stripe/stripe-go/v82/refund"
+	"github.com/stripe/stripe-go/v82/webhook"
+)
+
+// StripeProvider takes card payments through PaymentIntents and Stripe Elements.
+type StripeProvider struct {
+	secretKey      string
+	publishableKey string
+	webhookSecret  string
+	intents        paymentintent.Client
+	refunds        refund.Client
+	customers      customer.Client
+}
+
+var _ OffSessionCharger = (*StripeProvider)(nil)
+
+// NewStripeProvider creates a Stripe provider from tenant credentials.
+func NewStripeProvider(creds Credentials) (*StripeProvider, error) {
+	key := creds.Get("stripe_secret_key")
+	if key == "" {
+		return nil, ErrNotConfigured
+	}
+	backend := stripe.GetBackend(stripe.APIBackend)
+	return &StripeProvider{
+		secretKey:      key,
+		publishableKey: creds.Get("stripe_publishable_key"),
+		webhookSecret:  creds.Get("stripe_webhook_secret"),
+		intents:        paymentintent.Client{B: backend, Key: key},
+		refunds:        refund.Client{B: backend, Key: key},
+		customers:      customer.Client{B: backend, Key: key},
+	}, nil
+}
+
+func (p *StripeProvider) Name() string { return ProviderStripe }
+
+// PublishableKey is exposed to the frontend for Stripe Elements.
+func (p *StripeProvider) PublishableKey() string { return p.publishableKey }
+
+// CreatePayment creates a PaymentIntent and returns its client secret.
+func (p *StripeProvider) CreatePayment(ctx context.Context, req PaymentRequest) (*PaymentSession, error) {
+	params := &stripe.PaymentIntentParams{
+		Amount:   stripe.Int64(int64(math.Round(req.Amount))),
+		Currency: stripe.String(strings.ToLower(req.Currency)),
+		AutomaticPaymentMethods: &stripe.PaymentIntentAutomaticPaymentMethodsParams{
+			Enabled: stripe.Bool(true),
+		},
+		Description: stripe.String(req.Description),
+	}
+	params.Context = ctx
+	if req.CustomerEmail != "" {
+		params.ReceiptEmail = stripe.String(req.CustomerEmail)
+	}
+	params.AddMetadata("order_id", fmt.Sprintf("%d", req.OrderID))
+	for k, v := range req.Metadata {
+		params.AddMetadata(k, v)
+	}
+	if req.SavePaymentMethod && req.CustomerEmail != "" {
+		// The card is attached to a Customer so upsells can charge it off-session
+		cus, err := p.customerFor(ctx, req.CustomerEmail, req.CustomerName)
+		if err != nil {
+			return nil, err
+		}
+		params.Customer = stripe.String(cus)
+		params.SetupFutureUsage = stripe.String(string(stripe.PaymentIntentSetupFutureUsageOffSession))
+	}
+
+	pi, err := p.intents.New(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PaymentSession{
+		PaymentID: pi.ID,
+		Data: map[string]interface{}{
+			"client_secret":   pi.ClientSecret,
+			"publishable_key": p.publishableKey,
+		},
+	}, nil
+}
+
+// customerFor returns the Stripe Customer for an email, creating it if needed.
+func (p *StripeProvider) customerFor(ctx context.Context, email, name string) (string, error) {
+	list := &stripe.CustomerListParams{Email: stripe.String(email)}
+	list.Context = ctx
+	list.Limit = stripe.Int64(1)
+	iter := p.customers.List(list)
+	if iter.Next() {
+		return iter.Customer().ID, nil
+	}
+	if err := iter.Err(); err != nil {
+		return "", err
+	}
+
+	params := &stripe.CustomerParams{Email: stripe.String(email)}
+	params.Context = ctx
+	if name != "" {
+		params.Name = stripe.String(name)
+	}
+	cus, err := p.customers.New(params)
+	if err != nil {
+		return "", err
+	}
+	return cus.ID, nil
+}
+
+// SavedPaymentMethod returns the customer and card of a PaymentIntent that
+// was created with setup_future_usage=off_session.
+func (p *StripeProvider) SavedPaymentMethod(ctx context.Context, paymentID string) (*SavedPaymentMethod, error) {
+	params := &stripe.PaymentIntentParams{}
+	params.Context = ctx
+	pi, err := p.intents.Get(paymentID, params)
+	if err != nil {
+		return nil, err
+	}
+	if pi.Customer == nil || pi.PaymentMethod == nil || pi.SetupFutureUsage == "" {
+		return nil, ErrNoSavedPaymentMethod
+	}
+	return &SavedPaymentMethod{CustomerID: pi.Customer.ID, PaymentMethodID: pi.PaymentMethod.ID}, nil
+}
+
+// ChargeSaved confirms an off-session PaymentIntent against a saved card.
+func (p *StripeProvider) ChargeSaved(ctx context.Context, req PaymentRequest, method SavedPaymentMethod) (*PaymentResult, error) {
+	params := &stripe.PaymentIntentParams{
+		Amount:        stripe.Int64(int64(math.Round(req.Amount))),
+		Currency:      stripe.String(strings.ToLower(req.Currency)),
+		Customer:      stripe.String(method.CustomerID),
+		PaymentMethod: stripe.String(method.PaymentMethodID),
+		OffSession:    stripe.Bool(true),
+		Confirm:       stripe.Bool(true),
+		Description:   stripe.String(req.Description),
+	}
+	params.Context = ctx
+	if req.CustomerEmail != "" {
+		params.ReceiptEmail = stripe.String(req.CustomerEmail)
+	}
+	params.AddMetadata("order_id", fmt.Sprintf("%d", req.OrderID))
+	for k, v := range req.Metadata {
+		params.AddMetadata(k, v)
+	}
+
+	pi, err := p.intents.New(params)
+	if err != nil {
+		var serr *stripe.Error
+		if errors.As(err, &serr) && serr.Type == stripe.ErrorTypeCard {
+			// Declined, or the bank wants the customer to authenticate
+			res := &PaymentResult{Status: StatusFailed, FailureReason: string(serr.Code)}
+			if serr.PaymentIntent != nil {
+				res.PaymentID = serr.PaymentIntent.ID
+			}
+			return res, nil
+		}
+		return nil, err
+	}
+
+	result := &PaymentResult{PaymentID: pi.ID, Status: StatusPending, Amount: float64(pi.Amount), Currency: string(pi.Currency)}
+	switch pi.Status {
+	case stripe.PaymentIntentStatusSucceeded:
+		result.Status = StatusSucceeded
+		result.Reference = pi.ID
+	case stripe.PaymentIntentStatusRequiresAction, stripe.PaymentIntentStatusRequiresPaymentMethod, stripe.PaymentIntentStatusCanceled:
+		result.Status = StatusFailed
+		result.FailureReason = string(pi.Status)
+	}
+	return result, nil
+}
+
+// ConfirmPayment retrieves the PaymentIntent status, with the card
+// fingerprint of its latest charge.
+func (p *StripeProvider) ConfirmPayment(ctx context.Context, paymentID string) (*PaymentResult, error) {
+	params := &stripe.PaymentIntentParams{}
+	params.Context = ctx
+	params.AddExpand("latest_charge")
+	pi, err := p.intents.Get(paymentID, params)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PaymentResult{PaymentID: pi.ID, Status: StatusPending, Amount: float64(pi.Amount), Currency: string(pi.Currency)}
+	switch pi.Status {
+	case stripe.PaymentIntentStatusSucceeded:
+		result.Status = StatusSucceeded
+		result.Reference = pi.ID
+		if ch := pi.LatestCharge; ch != nil && ch.PaymentMethodDetails != nil && ch.PaymentMethodDetails.Card != nil {
+			result.Fingerprint = ch.PaymentMethodDetails.Card.Fingerprint
+		}
+	case stripe.PaymentIntentStatusCanceled:
+		result.Status = StatusFailed
+		result.FailureReason = string(pi.CancellationReason)
+	}
+	return result, nil
+}
+
+// Refund refunds a PaymentIntent.
+func (p *StripeProvider) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	if req.PaymentID == "" {
+		return nil, fmt.Errorf("order has no Stripe PaymentIntent")
+	}
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(req.PaymentID),
+		Amount:        stripe.Int64(int64(math.Round(req.Amount))),
+		Reason:        stripe.String(string(stripe.RefundReasonRequestedByCustomer)),
+	}
+	params.Context = ctx
+	params.AddMetadata("order_id", fmt.Sprintf("%d", req.OrderID))
+	if req.Reason != "" {
+		params.AddMetadata("reason", req.Reason)
+	}
+
+	r, err := p.refunds.New(params)
+	if err != nil {
+		return nil, err
+	}
+	if r.Status == stripe.RefundStatusFailed || r.Status == stripe.RefundStatusCanceled {
+		return nil, fmt.Errorf("Stripe refund %s", r.Status)
+	}
+	// Stripe has committed to pending refunds, so they count as done.
+	return &RefundResult{RefundID: r.ID, Status: StatusSucceeded}, nil
+}
+
+// ParseWebhook verifies the Stripe-Signature header and maps PaymentIntent events.
+func (p *StripeProvider) ParseWebhook(r *http.Request, body []byte) (*WebhookEvent, error) {
+	event, err := webhook.ConstructEvent(body, r.Header.Get("Stripe-Signature"), p.webhookSecret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+
+	id, _ := event.Data.Object["id"].(string)
+	switch event.Type {
+	case "payment_intent.succeeded":
+		return &WebhookEvent{Type: EventPaymentSucceeded, PaymentID: id, Reference: id}, nil
+	case "payment_intent.payment_failed":
+		return &WebhookEvent{Type: EventPaymentFailed, PaymentID: id}, nil
+	}
+	return &WebhookEvent{Type: EventIgnored}, nil
+}
@@ -22,6 +22,7 @@ import (
 	"gritcms/apps/api/internal/mail"
 	"gritcms/apps/api/internal/middleware"
 	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/payments"
 	"gritcms/apps/api/internal/services"
 	"gritcms/apps/api/internal/storage"
 )
@@ -103,7 +104,7 @@ func Setup(db *gorm.DB, cfg *config.Config, svc *Services) *gin.Engine {
 				cfg.GORMStudioUsername: cfg.GORMStudioPassword,
 			})
 		}
-		studio.Mount(r, db, []interface{}{&models.Tenant{}, &models.User{}, &models.Upload{}, &models.Blog{}, &models.Setting{}, &models.MediaAsset{}, &models.Tag{}, &models.Contact{}, &models.ContactActivity{}, &models.CustomFieldDefinition{}, &models.Page{}, &models.Post{}, &models.PostCategory{}, &models.PostTag{}, &models.Menu{}, &models.MenuItem{}, &models.EmailList{}, &models.EmailSubscription{}, &models.EmailTemplate{}, &models.EmailCampaign{}, &models.EmailSend{}, &models.EmailSequence{}, &models.EmailSequenceStep{}, &models.EmailSequenceEnrollment{}, &models.Segment{}, &models.Course{}, &models.CourseModule{}, &models.Lesson{}, &models.CourseEnrollment{}, &models.LessonProgress{}, &models.Quiz{}, &models.QuizQuestion{}, &models.QuizAttempt{}, &models.Certificate{}, &models.Product{}, &models.Price{}, &models.ProductVariant{}, &models.Coupon{}, &models.Order{}, &models.OrderItem{}, &models.Subscription{}, &models.Space{}, &models.CommunityMember{}, &models.Thread{}, &models.Reply{}, &models.Reaction{}, &models.CommunityEvent{}, &models.EventAttendee{}, &models.Funnel{}, &models.FunnelStep{}, &models.FunnelVisit{}, &models.FunnelConversion{}, &models.Calendar{}, &models.BookingEventType{}, &models.Availability{}, &models.Appointment{}, &models.AffiliateProgram{}, &models.AffiliateAccount{}, &models.AffiliateLink{}, &models.Commission{}, &models.Payout{}, &models.Workflow{}, &models.WorkflowAction{}, &models.WorkflowExecution{}, &models.PremiumGuide{}, &models.GuideDownload{} /* grit:studio */}, studioCfg)
+		studio.Mount(r, db, []interface{}{&models.Tenant{}, &models.User{}, &models.Upload{}, &models.Blog{}, &models.Setting{}, &models.MediaAsset{}, &models.Tag{}, &models.Contact{}, &models.ContactActivity{}, &models.CustomFieldDefinition{}, &models.Page{}, &models.Post{}, &models.PostCategory{}, &models.PostTag{}, &models.Menu{}, &models.MenuItem{}, &models.EmailList{}, &models.EmailSubscription{}, &models.EmailTemplate{}, &models.EmailCampaign{}, &models.EmailSend{}, &models.EmailSequence{}, &models.EmailSequenceStep{}, &models.EmailSequenceEnrollment{}, &models.Segment{}, &models.Course{}, &models.CourseModule{}, &models.Lesson{}, &models.CourseEnrollment{}, &models.LessonProgress{}, &models.Quiz{}, &models.QuizQuestion{}, &models.QuizAttempt{}, &models.Certificate{}, &models.Product{}, &models.Price{}, &models.ProductVariant{}, &models.Coupon{}, &models.Order{}, &models.OrderItem{}, &models.Subscription{}, &models.Space{}, &models.CommunityMember{}, &models.Thread{}, &models.Reply{}, &models.Reaction{}, &models.CommunityEvent{}, &models.EventAttendee{}, &models.Funnel{}, &models.FunnelStep{}, &models.FunnelVisit{}, &models.FunnelConversion{}, &models.Calendar{}, &models.BookingEventType{}, &models.Availability{}, &models.Appointment{}, &models.AffiliateProgram{}, &models.AffiliateAccount{}, &models.AffiliateLink{}, &models.Commission{}, &models.Payout{}, &models.Workflow{}, &models.WorkflowAction{}, &models.WorkflowExecution{}, &models.PremiumGuide{}, &models.GuideDownload{}, &models.Refund{}, &models.Invoice{}, &models.InvoiceSequence{}, &models.OrderFulfillmentStep{}, &models.OrderDiscount{}, &models.ExchangeRate{}, &models.CheckoutRecovery{}, &models.OrderBump{}, &models.MetricSnapshot{}, &models.CustomerMRRSnapshot{}, &models.CohortRetention{}, &models.AffiliateAsset{}, &models.AffiliateProductCommission{}, &models.PayoutAllocation{}, &models.AffiliateLedgerEntry{}, &models.PayoutBatch{}, &models.AffiliateClick{}, &models.CertificateTemplate{}, &models.Assignment{}, &models.AssignmentSubmission{}, &models.CourseCohort{}, &models.ProductCourse{}, &models.LearningPath{}, &models.LearningPathCourse{}, &models.LearningPathEnrollment{}, &models.MembershipEntitlement{}, &models.ScormPackage{}, &models.XAPIStatement{} /* grit:studio */}, studioCfg)
 		log.Println("GORM Studio mounted at /studio")
 	}
 
@@ -114,7 +115,7 @@ func Setup(db *gorm.DB, cfg *config.Config, svc *Services) *gin.Engine {
 		Version:     "1.0.0",
 		UI:          gindocs.UIScalar,
 		ScalarTheme: "kepler",
-		Models:      []interface{}{&models.Tenant{}, &models.User{}, &models.Upload{}, &models.Blog{}, &models.Setting{}, &models.MediaAsset{}, &models.Tag{}, &models.Contact{}, &models.ContactActivity{}, &models.CustomFieldDefinition{}, &models.Page{}, &models.Post{}, &models.PostCategory{}, &models.PostTag{}, &models.Menu{}, &models.MenuItem{}, &models.EmailList{}, &models.EmailSubscription{}, &models.EmailTemplate{}, &models.EmailCampaign{}, &models.EmailSend{}, &models.EmailSequence{}, &models.EmailSequenceStep{}, &models.EmailSequenceEnrollment{}, &models.Segment{}, &models.Course{}, &models.CourseModule{}, &models.Lesson{}, &models.CourseEnrollment{}, &models.LessonProgress{}, &models.Quiz{}, &models.QuizQuestion{}, &models.QuizAttempt{}, &models.Certificate{}, &models.Product{}, &models.Price{}, &models.ProductVariant{}, &models.Coupon{}, &models.Order{}, &models.OrderItem{}, &models.Subscription{}, &models.Space{}, &models.CommunityMember{}, &models.Thread{}, &models.Reply{}, &models.Reaction{}, &models.CommunityEvent{}, &models.EventAttendee{}, &models.Funnel{}, &models.FunnelStep{}, &models.FunnelVisit{}, &models.FunnelConversion{}, &models.Calendar{}, &models.BookingEventType{}, &models.Availability{}, &models.Appointment{}, &models.AffiliateProgram{}, &models.AffiliateAccount{}, &models.AffiliateLink{}, &models.Commission{}, &models.Payout{}, &models.Workflow{}, &models.WorkflowAction{}, &models.WorkflowExecution{}, &models.PremiumGuide{}, &models.GuideDownload{}},
+		Models:      []interface{}{&models.Tenant{}, &models.User{}, &models.Upload{}, &models.Blog{}, &models.Setting{}, &models.MediaAsset{}, &models.Tag{}, &models.Contact{}, &models.ContactActivity{}, &models.CustomFieldDefinition{}, &models.Page{}, &models.Post{}, &models.PostCategory{}, &models.PostTag{}, &models.Menu{}, &models.MenuItem{}, &models.EmailList{}, &models.EmailSubscription{}, &models.EmailTemplate{}, &models.EmailCampaign{}, &models.EmailSend{}, &models.EmailSequence{}, &models.EmailSequenceStep{}, &models.EmailSequenceEnrollment{}, &models.Segment{}, &models.Course{}, &models.CourseModule{}, &models.Lesson{}, &models.CourseEnrollment{}, &models.LessonProgress{}, &models.Quiz{}, &models.QuizQuestion{}, &models.QuizAttempt{}, &models.Certificate{}, &models.Product{}, &models.Price{}, &models.ProductVariant{}, &models.Coupon{}, &models.Order{}, &models.OrderItem{}, &models.Subscription{}, &models.Space{}, &models.CommunityMember{}, &models.Thread{}, &models.Reply{}, &models.Reaction{}, &models.CommunityEvent{}, &models.EventAttendee{}, &models.Funnel{}, &models.FunnelStep{}, &models.FunnelVisit{}, &models.FunnelConversion{}, &models.Calendar{}, &models.BookingEventType{}, &models.Availability{}, &models.Appointment{}, &models.AffiliateProgram{}, &models.AffiliateAccount{}, &models.AffiliateLink{}, &models.Commission{}, &models.Payout{}, &models.Workflow{}, &models.WorkflowAction{}, &models.WorkflowExecution{}, &models.PremiumGuide{}, &models.GuideDownload{}, &models.Refund{}, &models.Invoice{}, &models.InvoiceSequence{}, &models.OrderFulfillmentStep{}, &models.OrderDiscount{}, &models.ExchangeRate{}, &models.CheckoutRecovery{}, &models.OrderBump{}, &models.MetricSnapshot{}, &models.CustomerMRRSnapshot{}, &models.CohortRetention{}, &models.AffiliateAsset{}, &models.AffiliateProductCommission{}, &models.PayoutAllocation{}, &models.AffiliateLedgerEntry{}, &models.PayoutBatch{}, &models.AffiliateClick{}, &models.CertificateTemplate{}, &models.Assignment{}, &models.AssignmentSubmission{}, &models.CourseCohort{}, &models.ProductCourse{}, &models.LearningPath{}, &models.LearningPathCourse{}, &models.LearningPathEnrollment{}, &models.MembershipEntitlement{}, &models.ScormPackage{}, &models.XAPIStatement{}},
 		Auth: gindocs.AuthConfig{
 			Type:         gindocs.AuthBearer,
 			BearerFormat: "JWT",
@@ -192,16 +193,24 @@ func Setup(db *gorm.DB, cfg *config.Config, svc *Services) *gin.Engine {
 	settingHandler := handlers.NewSettingHandler(db)
 	emailHandler := handlers.NewEmailHandler(db, svc.Jobs, cfg, svc.Mailer)
 	courseHandler := handlers.NewCourseHandler(db)
-	commerceHandler := handlers.NewCommerceHandler(db, svc.Cache)
+	learningPathHandler := handlers.NewLearningPathHandler(db)
+	providers := payments.NewRegistry(db, cfg)
+	commerceHandler := handlers.NewCommerceHandler(db, svc.Cache, cfg, providers)
 	analyticsHandler := handlers.NewAnalyticsHandler(db)
 	communityHandler := handlers.NewCommunityHandler(db)
 	funnelHandler := handlers.NewFunnelHandler(db)
 	meetingService := integrations.NewMeetingService(db, cfg)
 	bookingHandler := handlers.NewBookingHandler(db, meetingService, cfg)
-	affiliateHandler := handlers.NewAffiliateHandler(db)
+	affiliateHandler := handlers.NewAffiliateHandler(db, cfg)
 	workflowHandler := handlers.NewWorkflowHandler(db)
-	paymentHandler := handlers.NewPaymentHandler(db, cfg)
+	paymentHandler := handlers.NewPaymentHandler(db, cfg, providers)
 	guideHandler := handlers.NewGuideHandler(db)
+	invoiceHandler := handlers.NewInvoiceHandler(db, svc.Storage)
+	certificateHandler := handlers.NewCertificateHandler(db, svc.Storage, cfg.WebURL)
+	videoHandler := handlers.NewVideoHandler(db, svc.Storage, cfg)
+	assignmentHandler := handlers.NewAssignmentHandler(db, svc.Storage)
+	scormHandler := handlers.NewScormHandler(db, svc.Storage, svc.Jobs, cfg)
+	currencyHandler := handlers.NewCurrencyHandler(db)
 	// grit:handlers
 
 	// Health check
@@ -248,6 +257,8 @@ func Setup(db *gorm.DB, cfg *config.Config, svc *Services) *gin.Engine {
 	r.GET("/api/p/courses", publicCache, courseHandler.ListPublishedCourses)
 	r.GET("/api/p/courses/:slug", publicCache, courseHandler.GetPublishedCourse)
 	r.GET("/api/certificates/verify/:number", publicCache, courseHandler.VerifyCertificate)
+	r.GET("/api/p/learning-paths", publicCache, learningPathHandler.ListPublishedPaths)
+	r.GET("/api/p/learning-paths/:slug", publicCache, learningPathHandler.GetPublishedPath)
 
 	// Public commerce routes (cached)
 	r.GET("/api/p/products", publicCache, commerceHandler.ListPublicProducts)
@@ -283,16 +294,34 @@ func Setup(db *gorm.DB, cfg *config.Config, svc *Services) *gin.Engine {
 	// Google Calendar OAuth callback (public — Google redirects here)
 	r.GET("/api/integrations/google/callback", bookingHandler.GoogleCallback)
 
-	// Stripe webhook (public, no auth — Stripe sends events here)
-	r.POST("/api/webhooks/stripe", paymentHandler.StripeWebhook)
-	r.POST("/api/webhooks/paypal", paymentHandler.PayPalWebhook)
+	// Payment webhooks (public, no auth — providers send events here)
+	r.POST("/api/webhooks/:provider", paymentHandler.Webhook)
 	r.POST("/api/callbacks/mpesa", paymentHandler.MPesaCallback)
+	r.POST("/api/callbacks/mpesa/reversal", paymentHandler.MPesaCallback)
 
-	// Public Stripe config (publishable key)
+	// Public Stripe config (publishable key) and available payment providers
 	r.GET("/api/p/stripe/config", paymentHandler.StripeConfig)
+	r.GET("/api/p/payment-providers", paymentHandler.ListProviders)
+	r.GET("/api/p/currencies", currencyHandler.PublicCurrencies)
+	r.GET("/api/p/order-bumps", paymentHandler.ListOrderBumps)
+	r.POST("/api/p/checkout/resume/:token", paymentHandler.OpenResumeCheckout)
+	r.POST("/api/p/checkout/resume/:token/pay", paymentHandler.ResumeCheckout)
 
 	// Public affiliate routes
 	r.GET("/api/ref/:code", affiliateHandler.TrackReferral)
+	r.GET("/api/l/:slug", affiliateHandler.FollowLink)
+
+	// Hosted lesson video playlists (the signed token is the credential)
+	r.GET("/api/video/:token/*file", videoHandler.Playlist)
+
+	// SCORM/xAPI package content and statements (the signed token is the credential)
+	r.GET("/api/scorm/:token/*file", scormHandler.Content)
+	r.POST("/api/xapi/:token/statements", scormHandler.XAPIStatements)
+	r.PUT("/api/xapi/:token/statements", scormHandler.XAPIStatements)
+	r.GET("/api/xapi/:token/activities/state", scormHandler.XAPIState)
+	r.PUT("/api/xapi/:token/activities/state", scormHandler.XAPIState)
+	r.POST("/api/xapi/:token/activities/state", scormHandler.XAPIState)
+	r.DELETE("/api/xapi/:token/activities/state", scormHandler.XAPIState)
 
 	// Public auth routes
 	auth := r.Group("/api/auth")
@@ -341,8 +370,35 @@ func Setup(db *gorm.DB, cfg *config.Config, svc *Services) *gin.Engine {
 			student.GET("/courses/:id", courseHandler.StudentGetCourse)
 			student.POST("/courses/:id/enroll", courseHandler.StudentEnroll)
 			student.POST("/courses/:id/lessons/:lessonId/complete", courseHandler.StudentMarkLessonComplete)
+			student.POST("/courses/:id/lessons/:lessonId/heartbeat", courseHandler.StudentLessonHeartbeat)
+			student.POST("/courses/:id/lessons/:lessonId/position", courseHandler.StudentLessonHeartbeat) // older players
+			student.GET("/courses/:id/lessons/:lessonId/playback", videoHandler.StudentPlayback)
+			student.GET("/courses/:id/lessons/:lessonId/scorm", scormHandler.StudentLaunch)
+			student.POST("/courses/:id/lessons/:lessonId/scorm/commit", scormHandler.StudentCommit)
+			student.GET("/courses/:id/quizzes/:quizId", courseHandler.StudentGetQuiz)
+			student.POST("/courses/:id/quizzes/:quizId/start", courseHandler.StudentStartQuiz)
+			student.POST("/courses/:id/quizzes/:quizId/submit", courseHandler.StudentSubmitQuiz)
+			student.GET("/courses/:id/quizzes/:quizId/attempts", courseHandler.StudentQuizAttempts)
+			student.GET("/courses/:id/assignments/:assignmentId", assignmentHandler.StudentGetAssignment)
+			student.POST("/courses/:id/assignments/:assignmentId/submit", assignmentHandler.StudentSubmitAssignment)
+			student.GET("/learning-paths", learningPathHandler.StudentPaths)
+			student.GET("/learning-paths/:id", learningPathHandler.StudentGetPath)
+			student.POST("/learning-paths/:id/enroll", learningPathHandler.StudentEnrollPath)
 			student.GET("/purchases", commerceHandler.StudentGetPurchases)
+			student.GET("/memberships", commerceHandler.StudentGetMemberships)
+			student.GET("/products/:id/downloads", commerceHandler.StudentGetProductDownloads)
             student.GET("/purchases/:orderId", commerceHandler.StudentGetPurchase)
+			student.GET("/purchases/:orderId/invoice", invoiceHandler.StudentDownloadInvoice)
+			student.GET("/certificates", certificateHandler.StudentCertificates)
+			student.GET("/certificates/:certId/download", certificateHandler.StudentDownloadCertificate)
+		}
+
+		// Assignment review (course instructor or admin, checked per submission)
+		instructor := protected.Group("/instructor")
+		{
+			instructor.GET("/submissions", assignmentHandler.ReviewQueue)
+			instructor.GET("/submissions/:subId", assignmentHandler.GetSubmission)
+			instructor.POST("/submissions/:subId/grade", assignmentHandler.GradeSubmission)
 		}
 
 		// Community (authenticated user routes)
@@ -354,8 +410,31 @@ func Setup(db *gorm.DB, cfg *config.Config, svc *Services) *gin.Engine {
 
 		// Checkout (any authenticated user)
 		protected.POST("/checkout", paymentHandler.Checkout)
+		protected.POST("/checkout/quote", commerceHandler.QuoteDiscounts)
 		protected.GET("/checkout/:orderId/status", paymentHandler.CheckoutStatus)
 		protected.POST("/checkout/:orderId/confirm", paymentHandler.ConfirmCheckout)
+		protected.GET("/checkout/:orderId/offer", paymentHandler.NextOffer)
+		protected.POST("/checkout/:orderId/offers/:stepId/accept", paymentHandler.AcceptOffer)
+		protected.POST("/checkout/:orderId/offers/:stepId/decline", paymentHandler.DeclineOffer)
+
+		// Affiliate portal (own affiliate account only)
+		affiliate := protected.Group("/affiliate")
+		{
+			affiliate.GET("/programs", affiliateHandler.PortalPrograms)
+			affiliate.POST("/apply", affiliateHandler.PortalApply)
+			affiliate.GET("/accounts", affiliateHandler.PortalAccounts)
+			affiliate.GET("/dashboard", affiliateHandler.PortalDashboard)
+			affiliate.GET("/links", affiliateHandler.PortalLinks)
+			affiliate.POST("/links", affiliateHandler.PortalCreateLink)
+			affiliate.DELETE("/links/:linkId", affiliateHandler.PortalDeleteLink)
+			affiliate.GET("/commissions", affiliateHandler.PortalCommissions)
+			affiliate.GET("/payouts", affiliateHandler.PortalPayouts)
+			affiliate.POST("/payouts", affiliateHandler.PortalRequestPayout)
+			affiliate.GET("/ledger", affiliateHandler.PortalLedger)
+			affiliate.PUT("/payout-details", affiliateHandler.PortalUpdatePayoutDetails)
+			affiliate.GET("/assets", affiliateHandler.PortalAssets)
+			affiliate.GET("/assets/:assetId/download", affiliateHandler.PortalDownloadAsset)
+		}
 
 		// grit:routes:protected
 	}
@@ -413,6 +492,7 @@ func Setup(db *gorm.DB, cfg *config.Config, svc *Services) *gin.Engine {
 		admin.GET("/media/:id", mediaHandler.GetByID)
 		admin.PUT("/media/:id", mediaHandler.Update)
 		admin.DELETE("/media/:id", mediaHandler.Delete)
+		admin.POST("/media/:id/transcode", mediaHandler.Transcode)
 
 		// Page management (admin)
 		admin.GET("/pages", pageHandler.List)
@@ -546,6 +626,14 @@ func Setup(db *gorm.DB, cfg *config.Config, svc *Services) *gin.Engine {
 		admin.POST("/courses/:id/enroll", courseHandler.EnrollContact)
 		admin.GET("/courses/:id/enrollments", courseHandler.ListEnrollments)
 		admin.DELETE("/courses/:id/enrollments/:enrollId", courseHandler.UnenrollContact)
+		admin.PUT("/courses/:id/enrollments/:enrollId/cohort", courseHandler.MoveEnrollmentCohort)
+
+		// Course cohorts (admin)
+		admin.GET("/courses/:id/cohorts", courseHandler.ListCohorts)
+		admin.POST("/courses/:id/cohorts", courseHandler.CreateCohort)
+		admin.PUT("/courses/:id/cohorts/:cohortId", courseHandler.UpdateCohort)
+		admin.DELETE("/courses/:id/cohorts/:cohortId", courseHandler.DeleteCohort)
+		admin.GET("/courses/:id/cohorts/:cohortId/analytics", courseHandler.CohortAnalytics)
 
 		// Course progress (admin)
 		admin.POST("/courses/progress/complete", courseHandler.MarkLessonComplete)
@@ -565,15 +653,46 @@ func Setup(db *gorm.DB, cfg *config.Config, svc *Services) *gin.Engine {
 		admin.POST("/courses/:id/quizzes/:quizId/attempt", courseHandler.SubmitQuizAttempt)
 		admin.GET("/courses/:id/quizzes/:quizId/attempts", courseHandler.ListQuizAttempts)
 
+		// Course assignments (admin)
+		admin.POST("/courses/:id/lessons/:lessonId/assignment", assignmentHandler.CreateAssignment)
+		admin.GET("/courses/:id/scorm", scormHandler.ListPackages)
+		admin.POST("/courses/:id/scorm", scormHandler.ImportPackage)
+		admin.DELETE("/courses/:id/scorm/:packageId", scormHandler.DeletePackage)
+		admin.PUT("/courses/:id/assignments/:assignmentId", assignmentHandler.UpdateAssignment)
+		admin.DELETE("/courses/:id/assignments/:assignmentId", assignmentHandler.DeleteAssignment)
+
 		// Certificates (admin)
 		admin.GET("/certificates", courseHandler.ListCertificates)
 
+		// Learning paths (admin)
+		admin.GET("/learning-paths", learningPathHandler.ListPaths)
+		admin.GET("/learning-paths/:id", learningPathHandler.GetPath)
+		admin.POST("/learning-paths", learningPathHandler.CreatePath)
+		admin.PUT("/learning-paths/:id", learningPathHandler.UpdatePath)
+		admin.DELETE("/learning-paths/:id", learningPathHandler.DeletePath)
+		admin.PUT("/learning-paths/:id/courses", learningPathHandler.SetPathCourses)
+		admin.GET("/learning-paths/:id/enrollments", learningPathHandler.ListPathEnrollments)
+		admin.POST("/learning-paths/:id/enroll", learningPathHandler.EnrollContact)
+		admin.GET("/certificates/:certId/download", certificateHandler.DownloadCertificate)
+		admin.POST("/certificates/:certId/reissue", certificateHandler.ReissueCertificate)
+		admin.GET("/certificate-templates", certificateHandler.ListTemplates)
+		admin.GET("/certificate-templates/:templateId", certificateHandler.GetTemplate)
+		admin.POST("/certificate-templates", certificateHandler.CreateTemplate)
+		admin.PUT("/certificate-templates/:templateId", certificateHandler.UpdateTemplate)
+		admin.DELETE("/certificate-templates/:templateId", certificateHandler.DeleteTemplate)
+		admin.GET("/certificate-templates/:templateId/preview", certificateHandler.PreviewTemplate)
+
 		// Products (admin)
 		admin.GET("/products", commerceHandler.ListProducts)
 		admin.GET("/products/:id", commerceHandler.GetProduct)
 		admin.POST("/products", commerceHandler.CreateProduct)
 		admin.PUT("/products/:id", commerceHandler.UpdateProduct)
 		admin.DELETE("/products/:id", commerceHandler.DeleteProduct)
+		admin.GET("/products/:id/courses", commerceHandler.ListProductCourses)
+		admin.PUT("/products/:id/courses", commerceHandler.SetProductCourses)
+		admin.GET("/products/:id/entitlements", commerceHandler.ListEntitlements)
+		admin.POST("/products/:id/entitlements", commerceHandler.CreateEntitlement)
+		admin.DELETE("/products/:id/entitlements/:entitlementId", commerceHandler.DeleteEntitlement)
 
 		// Prices (admin)
 		admin.POST("/products/:id/prices", commerceHandler.CreatePrice)
@@ -591,6 +710,9 @@ func Setup(db *gorm.DB, cfg *config.Config, svc *Services) *gin.Engine {
 		admin.POST("/orders", commerceHandler.CreateOrder)
 		admin.PUT("/orders/:orderId/status", commerceHandler.UpdateOrderStatus)
 		admin.POST("/orders/:orderId/refund", commerceHandler.RefundOrder)
+		admin.GET("/orders/:orderId/refunds", commerceHandler.ListOrderRefunds)
+		admin.GET("/orders/:orderId/invoice", invoiceHandler.DownloadOrderInvoice)
+		admin.GET("/invoices", invoiceHandler.ListInvoices)
 
 		// Coupons (admin)
 		admin.GET("/coupons", commerceHandler.ListCoupons)
@@ -598,6 +720,8 @@ func Setup(db *gorm.DB, cfg *config.Config, svc *Services) *gin.Engine {
 		admin.POST("/coupons", commerceHandler.CreateCoupon)
 		admin.PUT("/coupons/:couponId", commerceHandler.UpdateCoupon)
 		admin.DELETE("/coupons/:couponId", commerceHandler.DeleteCoupon)
+		admin.POST("/coupons/:couponId/generate", commerceHandler.GenerateCouponCodes)
+		admin.GET("/coupons/:couponId/codes", commerceHandler.ListCouponCodes)
 
 		// Subscriptions (admin)
 		admin.GET("/subscriptions", commerceHandler.ListSubscriptions)
@@ -607,12 +731,32 @@ func Setup(db *gorm.DB, cfg *config.Config, svc *Services) *gin.Engine {
 		// Revenue dashboard (admin)
 		admin.GET("/commerce/dashboard", commerceHandler.RevenueDashboard)
 
+		// Order bumps (admin)
+		admin.GET("/order-bumps", commerceHandler.ListOrderBumps)
+		admin.POST("/order-bumps", commerceHandler.CreateOrderBump)
+		admin.PUT("/order-bumps/:bumpId", commerceHandler.UpdateOrderBump)
+		admin.DELETE("/order-bumps/:bumpId", commerceHandler.DeleteOrderBump)
+
+		// Abandoned checkouts (admin)
+		admin.GET("/abandoned-checkouts", commerceHandler.ListAbandonedCheckouts)
+		admin.GET("/abandoned-checkouts/report", commerceHandler.AbandonedCheckoutReport)
+
+		// Currencies & exchange rates (admin)
+		admin.GET("/currency", currencyHandler.GetSettings)
+		admin.PUT("/currency", currencyHandler.UpdateSettings)
+		admin.PUT("/exchange-rates", currencyHandler.SetRate)
+		admin.DELETE("/exchange-rates/:currency", currencyHandler.DeleteRate)
+		admin.POST("/exchange-rates/import", currencyHandler.ImportRates)
+
 		// Analytics & CRM (admin)
 		admin.GET("/analytics/dashboard", analyticsHandler.Dashboard)
 		admin.GET("/analytics/revenue-chart", analyticsHandler.RevenueChart)
 		admin.GET("/analytics/subscriber-growth", analyticsHandler.SubscriberGrowth)
 		admin.GET("/analytics/top-products", analyticsHandler.TopProducts)
 		admin.GET("/analytics/activity-timeline", analyticsHandler.ActivityTimeline)
+		admin.GET("/analytics/saas", analyticsHandler.SaaSMetrics)
+		admin.GET("/analytics/saas/cohorts", analyticsHandler.SaaSCohorts)
+		admin.POST("/analytics/saas/rebuild", analyticsHandler.RebuildSaaSMetrics)
 		admin.GET("/contacts/:id/profile", analyticsHandler.ContactProfile)
 		admin.GET("/contacts/export", analyticsHandler.ContactExport)
 		admin.POST("/contacts/import", contactHandler.ImportContacts)
@@ -701,12 +845,16 @@ func Setup(db *gorm.DB, cfg *config.Config, svc *Services) *gin.Engine {
 		admin.POST("/affiliates/programs", affiliateHandler.CreateProgram)
 		admin.PUT("/affiliates/programs/:id", affiliateHandler.UpdateProgram)
 		admin.DELETE("/affiliates/programs/:id", affiliateHandler.DeleteProgram)
+		admin.PUT("/affiliates/programs/:id/products/:productId", affiliateHandler.SetProductCommission)
+		admin.DELETE("/affiliates/programs/:id/products/:productId", affiliateHandler.DeleteProductCommission)
 
 		// Affiliate accounts (admin)
 		admin.GET("/affiliates/accounts", affiliateHandler.ListAccounts)
 		admin.GET("/affiliates/accounts/:accountId", affiliateHandler.GetAccount)
 		admin.POST("/affiliates/accounts", affiliateHandler.CreateAccount)
 		admin.PUT("/affiliates/accounts/:accountId/status", affiliateHandler.UpdateAccountStatus)
+		admin.GET("/affiliates/accounts/:accountId/ledger", affiliateHandler.AccountLedger)
+		admin.POST("/affiliates/accounts/:accountId/adjustments", affiliateHandler.AdjustBalance)
 
 		// Affiliate links (admin)
 		admin.POST("/affiliates/accounts/:accountId/links", affiliateHandler.CreateLink)
@@ -716,15 +864,30 @@ func Setup(db *gorm.DB, cfg *config.Config, svc *Services) *gin.Engine {
 		admin.GET("/affiliates/commissions", affiliateHandler.ListCommissions)
 		admin.POST("/affiliates/commissions/:commissionId/approve", affiliateHandler.ApproveCommission)
 		admin.POST("/affiliates/commissions/:commissionId/reject", affiliateHandler.RejectCommission)
+		admin.POST("/affiliates/commissions/:commissionId/clear", affiliateHandler.ClearCommission)
+		admin.GET("/affiliates/review", affiliateHandler.ReviewQueue)
+		admin.GET("/affiliates/accounts/:accountId/clicks", affiliateHandler.AccountClicks)
 
 		// Payouts (admin)
 		admin.GET("/affiliates/payouts", affiliateHandler.ListPayouts)
 		admin.POST("/affiliates/payouts", affiliateHandler.CreatePayout)
 		admin.POST("/affiliates/payouts/:payoutId/process", affiliateHandler.ProcessPayout)
+		admin.POST("/affiliates/payouts/:payoutId/fail", affiliateHandler.FailPayout)
+		admin.GET("/affiliates/payout-batches", affiliateHandler.ListPayoutBatches)
+		admin.POST("/affiliates/payout-batches", affiliateHandler.CreatePayoutBatch)
+		admin.GET("/affiliates/payout-batches/:batchId", affiliateHandler.GetPayoutBatch)
+		admin.POST("/affiliates/payout-batches/:batchId/export", affiliateHandler.ExportPayoutBatch)
+		admin.POST("/affiliates/payout-batches/:batchId/complete", affiliateHandler.CompletePayoutBatch)
 
 		// Affiliate dashboard (admin)
 		admin.GET("/affiliates/dashboard", affiliateHandler.Dashboard)
 
+		// Affiliate marketing assets (admin)
+		admin.GET("/affiliates/assets", affiliateHandler.ListAssets)
+		admin.POST("/affiliates/assets", affiliateHandler.CreateAsset)
+		admin.PUT("/affiliates/assets/:assetId", affiliateHandler.UpdateAsset)
+		admin.DELETE("/affiliates/assets/:assetId", affiliateHandler.DeleteAsset)
+
 		// Workflows (admin)
 		admin.GET("/workflows", workflowHandler.ListWorkflows)
 		admin.GET("/workflows/:id", workflowHandler.GetWorkflow)
@@ -800,6 +963,10 @@ func Setup(db *gorm.DB, cfg *config.Config, svc *Services) *gin.Engine {
 
 	// Register contact activity event listeners
 	services.RegisterActivityListeners(db)
+	services.RegisterAffiliateListeners(db)
+	if svc.Jobs != nil {
+		jobs.RegisterEventListeners(svc.Jobs)
+	}
 
 	return r
 }
@@ -173,6 +173,20 @@ func RegisterActivityListeners(db *gorm.DB) {
 			fmt.Sprintf("Refunded order #%d", orderID), m)
 	})
 
+	bus.On(events.CheckoutAbandoned, func(data interface{}) {
+		m, ok := data.(map[string]interface{})
+		if !ok {
+			return
+		}
+		contactID := toUint(m["contact_id"])
+		orderNumber, _ := m["order_number"].(string)
+		if contactID == 0 {
+			return
+		}
+		logActivity(db, contactID, 1, "commerce", "checkout_abandoned",
+			fmt.Sprintf("Abandoned checkout %s", orderNumber), m)
+	})
+
 	bus.On(events.SubscriptionCancelled, func(data interface{}) {
 		m, ok := data.(map[string]interface{})
 		if !ok {
@@ -278,6 +292,19 @@ func RegisterActivityListeners(db *gorm.DB) {
 		logActivity(db, contactID, 1, "affiliates", "referral", "Referred a new visitor", m)
 	})
 
+	bus.On(events.AffiliateCommission, func(data interface{}) {
+		m, ok := data.(map[string]interface{})
+		if !ok {
+			return
+		}
+		contactID := toUint(m["contact_id"])
+		if contactID == 0 {
+			return
+		}
+		logActivity(db, contactID, 1, "affiliates", "commission",
+			fmt.Sprintf("Earned a commission on order #%d", toUint(m["order_id"])), m)
+	})
+
 	log.Println("[activity] Registered contact activity listeners")
 }
 
@@ -0,0 +1,716 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"gritcms/apps/api/internal/events"
+	"gritcms/apps/api/internal/models"
+)
+
+// AffiliateCookie holds the referral code captured at /api/ref/:code.
+const AffiliateCookie = "grit_ref"
+
+// Affiliate errors.
+var (
+	ErrInvalidReferral     = errors.New("invalid referral code")
+	ErrPayoutBelowMinimum  = errors.New("payout is below the program minimum")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrPayoutPending       = errors.New("a payout request is already pending")
+	ErrCommissionOnHold    = errors.New("commission is still in its hold period")
+)
+
+// AffiliateService attributes contacts to affiliates and turns their
+// purchases into commissions.
+type AffiliateService struct {
+	db *gorm.DB
+}
+
+func NewAffiliateService(db *gorm.DB) *AffiliateService {
+	return &AffiliateService{db: db}
+}
+
+// ResolveCode returns the active account behind a referral code, with its
+// program, provided the program itself is active.
+func (s *AffiliateService) ResolveCode(code string) (*models.AffiliateAccount, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, ErrInvalidReferral
+	}
+	var account models.AffiliateAccount
+	if err := s.db.Preload("Program").
+		Where("(referral_code = ? OR custom_slug = ?) AND status = ?", code, code, models.AffiliateStatusActive).
+		First(&account).Error; err != nil {
+		return nil, ErrInvalidReferral
+	}
+	if account.Program == nil || account.Program.Status != "active" {
+		return nil, ErrInvalidReferral
+	}
+	return &account, nil
+}
+
+// Attribute records that a contact was referred by the code's affiliate. The
+// referral is valid for the program's CookieDays; an unexpired referral from
+// another affiliate is kept (first click wins). Affiliates can't refer
+// themselves.
+func (s *AffiliateService) Attribute(contact *models.Contact, code string, at time.Time) (*models.AffiliateAccount, error) {
+	account, err := s.ResolveCode(code)
+	if err != nil {
+		return nil, err
+	}
+	if account.ContactID == contact.ID {
+		return nil, ErrInvalidReferral
+	}
+	if current := ActiveReferral(contact, at); current != nil && *current != account.ID {
+		return account, nil
+	}
+
+	days := account.Program.CookieDays
+	if days <= 0 {
+		days = 30
+	}
+	expires := at.AddDate(0, 0, days)
+	if contact.ReferredByAccountID == nil || *contact.ReferredByAccountID != account.ID {
+		contact.ReferredByLinkID = nil
+	}
+	contact.ReferredByAccountID = &account.ID
+	contact.ReferredAt = &at
+	contact.ReferralExpiresAt = &expires
+	err = s.db.Model(&models.Contact{}).Where("id = ?", contact.ID).Updates(map[string]interface{}{
+		"referred_by_account_id": account.ID,
+		"referred_by_link_id":    contact.ReferredByLinkID,
+		"referred_at":            at,
+		"referral_expires_at":    expires,
+	}).Error
+	return account, err
+}
+
+// ActiveReferral returns the contact's referring account while the referral
+// window is still open.
+func ActiveReferral(contact *models.Contact, at time.Time) *uint {
+	if contact.ReferredByAccountID == nil || contact.ReferralExpiresAt == nil || !contact.ReferralExpiresAt.After(at) {
+		return nil
+	}
+	return contact.ReferredByAccountID
+}
+
+// programRate returns the rate an affiliate earns given their referred
+// revenue this month: the highest tier reached, or the program's base rate.
+func programRate(program *models.AffiliateProgram, monthRevenue float64) models.CommissionTier {
+	rate := models.CommissionTier{CommissionType: program.CommissionType, CommissionAmount: program.CommissionAmount}
+	if len(program.Tiers) == 0 {
+		return rate
+	}
+	var tiers []models.CommissionTier
+	if err := json.Unmarshal(program.Tiers, &tiers); err != nil {
+		return rate
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinRevenue < tiers[j].MinRevenue })
+	for _, t := range tiers {
+		if monthRevenue >= float64(t.MinRevenue) {
+			rate = t
+		}
+	}
+	return rate
+}
+
+// commissionOn applies a rate to a base-currency amount in cents. Fixed
+// rates are a flat amount regardless of the sale.
+func commissionOn(rate models.CommissionTier, amount float64) float64 {
+	if rate.CommissionType == "fixed" {
+		return float64(rate.CommissionAmount)
+	}
+	return amount * float64(rate.CommissionAmount) / 100
+}
+
+// monthRevenue is the base-currency revenue the account referred in the
+// calendar month of `at`, excluding one order (the one being credited).
+func (s *AffiliateService) monthRevenue(accountID uint, at time.Time, excludeOrderID uint) float64 {
+	start := time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, at.Location())
+	var revenue float64
+	s.db.Model(&models.Order{}).
+		Where("affiliate_account_id = ? AND status IN ? AND paid_at >= ? AND paid_at < ? AND id <> ?", accountID,
+			[]string{models.OrderStatusPaid, models.OrderStatusPartiallyRefunded}, start, start.AddDate(0, 1, 0), excludeOrderID).
+		Select(BaseRevenueSQL).Scan(&revenue)
+	return revenue
+}
+
+// productOverrides loads the program's per-product rates keyed by product.
+func (s *AffiliateService) productOverrides(programID uint) map[uint]models.AffiliateProductCommission {
+	var rows []models.AffiliateProductCommission
+	s.db.Where("program_id = ?", programID).Find(&rows)
+	out := make(map[uint]models.AffiliateProductCommission, len(rows))
+	for _, r := range rows {
+		out[r.ProductID] = r
+	}
+	return out
+}
+
+// orderCommission works out the level-1 commission on an order: items with a
+// product override earn that rate on their share of the total; everything
+// else earns the program rate, or the tier the affiliate has reached this month.
+func (s *AffiliateService) orderCommission(account *models.AffiliateAccount, order *models.Order, base float64, at time.Time) (int64, string) {
+	program := account.Program
+	overrides := s.productOverrides(program.ID)
+
+	// Item totals are before the order discount and in the order currency
+	scale := 1.0
+	if order.Subtotal > 0 {
+		scale = base / order.Subtotal
+	}
+
+	var amount, rest float64
+	restItems := 0
+	for _, item := range order.Items {
+		share := item.Total * scale
+		if item.ProductID != nil {
+			if ov, ok := overrides[*item.ProductID]; ok {
+				rate := models.CommissionTier{CommissionType: ov.CommissionType, CommissionAmount: ov.CommissionAmount}
+				// A fixed override is paid once per order line
+				amount += commissionOn(rate, share)
+				continue
+			}
+		}
+		rest += share
+		restItems++
+	}
+	if len(order.Items) == 0 {
+		rest, restItems = base, 1
+	}
+
+	description := "Referral commission"
+	if restItems > 0 {
+		revenue := s.monthRevenue(account.ID, at, order.ID) + base
+		rate := programRate(program, revenue)
+		amount += commissionOn(rate, rest)
+		if rate.MinRevenue > 0 {
+			currency := order.BaseCurrency
+			if currency == "" {
+				currency = order.Currency
+			}
+			description = fmt.Sprintf("Referral commission (tier from %s)", formatMoney(float64(rate.MinRevenue), currency))
+		}
+	}
+	return int64(math.Round(amount)), description
+}
+
+// orderBaseTotal is the order total in the base currency.
+func orderBaseTotal(order *models.Order) float64 {
+	if order.ExchangeRate > 0 {
+		return order.BaseTotal
+	}
+	return order.Total
+}
+
+// CreateOrderCommission credits the referring affiliate for a paid order, and
+// their recruiter when the program pays a second tier. The affiliate captured
+// on the order at checkout wins; otherwise the contact's active referral at
+// payment time is used. Safe to call more than once.
+func (s *AffiliateService) CreateOrderCommission(orderID uint) (*models.Commission, error) {
+	var order models.Order
+	if err := s.db.Preload("Items").First(&order, orderID).Error; err != nil {
+		return nil, err
+	}
+	// Renewal orders earn through CreateRenewalCommission instead
+	if order.Status != models.OrderStatusPaid || order.SubscriptionID != nil {
+		return nil, nil
+	}
+	paidAt := time.Now()
+	if order.PaidAt != nil {
+		paidAt = *order.PaidAt
+	}
+
+	accountID := order.AffiliateAccountID
+	if accountID == nil {
+		var contact models.Contact
+		if err := s.db.First(&contact, order.ContactID).Error; err != nil {
+			return nil, nil
+		}
+		accountID = ActiveReferral(&contact, paidAt)
+	}
+	if accountID == nil {
+		return nil, nil
+	}
+	account, ok := s.payableAccount(*accountID, order.ContactID)
+	if !ok {
+		return nil, nil
+	}
+
+	base := orderBaseTotal(&order)
+	amount, description := s.orderCommission(account, &order, base, paidAt)
+	if amount <= 0 {
+		return nil, nil
+	}
+
+	comm := models.Commission{
+		TenantID:    order.TenantID,
+		AccountID:   account.ID,
+		OrderID:     &order.ID,
+		Amount:      amount,
+		Status:      models.CommissionPending,
+		Level:       1,
+		Description: description,
+	}
+	if len(order.Items) == 1 {
+		comm.ProductID = order.Items[0].ProductID
+	}
+	if order.AffiliateAccountID != nil && *order.AffiliateAccountID == account.ID {
+		comm.LinkID = order.AffiliateLinkID
+	}
+	flagCommission(&comm, s.orderFraudFlags(account, &order, paidAt), time.Now())
+
+	return s.record(account, &comm, base, order.ContactID, func(tx *gorm.DB) *gorm.DB {
+		if order.AffiliateAccountID == nil {
+			tx.Model(&models.Order{}).Where("id = ?", order.ID).Update("affiliate_account_id", account.ID)
+		}
+		return tx.Where("order_id = ? AND level = 1", order.ID)
+	})
+}
+
+// CreateRenewalCommission credits the affiliate who referred a subscription
+// for one renewal payment (amount in minor units of currency), as long as it
+// falls within the program's RecurringMonths from the start of the
+// subscription. Safe to call more than once per period.
+func (s *AffiliateService) CreateRenewalCommission(subscriptionID uint, amount float64, currency string, periodStart time.Time, orderID *uint) (*models.Commission, error) {
+	var sub models.Subscription
+	if err := s.db.First(&sub, subscriptionID).Error; err != nil {
+		return nil, err
+	}
+
+	// The affiliate credited for the first purchase of the product, else the
+	// contact's referral at the time they subscribed
+	var accountID uint
+	s.db.Raw(`
+		SELECT c.account_id FROM commissions c
+		JOIN orders o ON o.id = c.order_id
+		JOIN order_items oi ON oi.order_id = o.id
+		WHERE o.contact_id = ? AND oi.product_id = ? AND c.level = 1 AND c.subscription_id IS NULL
+		ORDER BY c.created_at ASC LIMIT 1
+	`, sub.ContactID, sub.ProductID).Scan(&accountID)
+	if accountID == 0 {
+		var contact models.Contact
+		if err := s.db.First(&contact, sub.ContactID).Error; err == nil {
+			if id := ActiveReferral(&contact, sub.CreatedAt); id != nil {
+				accountID = *id
+			}
+		}
+	}
+	if accountID == 0 {
+		return nil, nil
+	}
+	account, ok := s.payableAccount(accountID, sub.ContactID)
+	if !ok {
+		return nil, nil
+	}
+	program := account.Program
+	if program.RecurringMonths <= 0 || !periodStart.Before(sub.CreatedAt.AddDate(0, program.RecurringMonths, 0)) {
+		return nil, nil
+	}
+
+	fx := NewCurrencyService(s.db)
+	base, err := fx.Convert(sub.TenantID, amount, currency, fx.BaseCurrency(sub.TenantID))
+	if err != nil {
+		base = amount
+	}
+
+	rate := programRate(program, s.monthRevenue(account.ID, periodStart, 0)+base)
+	if ov, ok := s.productOverrides(program.ID)[sub.ProductID]; ok {
+		rate = models.CommissionTier{CommissionType: ov.CommissionType, CommissionAmount: ov.CommissionAmount}
+	}
+	commission := int64(math.Round(commissionOn(rate, base)))
+	if commission <= 0 {
+		return nil, nil
+	}
+
+	productID, start := sub.ProductID, periodStart
+	comm := models.Commission{
+		TenantID:       sub.TenantID,
+		AccountID:      account.ID,
+		OrderID:        orderID,
+		ProductID:      &productID,
+		Amount:         commission,
+		Status:         models.CommissionPending,
+		Level:          1,
+		SubscriptionID: &sub.ID,
+		PeriodStart:    &start,
+		Description:    fmt.Sprintf("Recurring commission for %s", periodStart.Format("January 2006")),
+	}
+	return s.record(account, &comm, base, sub.ContactID, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("subscription_id = ? AND period_start = ? AND level = 1", sub.ID, periodStart)
+	})
+}
+
+// payableAccount loads an account that can earn commission on a purchase by
+// the given buyer: active, in an active program, and not the buyer themselves.
+func (s *AffiliateService) payableAccount(accountID, buyerContactID uint) (*models.AffiliateAccount, bool) {
+	var account models.AffiliateAccount
+	if err := s.db.Preload("Program").First(&account, accountID).Error; err != nil {
+		return nil, false
+	}
+	if account.Status != models.AffiliateStatusActive || account.Program == nil ||
+		account.Program.Status != "active" || account.ContactID == buyerContactID {
+		return nil, false
+	}
+	return &account, true
+}
+
+// errCommissionExists rolls back record when another call created the same
+// commission first.
+var errCommissionExists = errors.New("commission already recorded")
+
+// record creates a level-1 commission unless `existing` already matches one,
+// plus the recruiter's second-tier commission, which shares its fraud flags.
+// Both are held for the program's HoldDays; without a hold or flags they are
+// auto-approved straight away when the program says so.
+func (s *AffiliateService) record(account *models.AffiliateAccount, comm *models.Commission, base float64, buyerContactID uint, existing func(tx *gorm.DB) *gorm.DB) (*models.Commission, error) {
+	program := account.Program
+	created := []*models.Commission{}
+	contacts := map[uint]uint{account.ID: account.ContactID}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		existing(tx).Model(&models.Commission{}).Count(&count)
+		if count > 0 {
+			return nil
+		}
+		if program.HoldDays > 0 {
+			holdUntil := time.Now().AddDate(0, 0, program.HoldDays)
+			comm.HoldUntil = &holdUntil
+		}
+		// The unique indexes on commissions catch a concurrent call that
+		// passed the check above
+		if err := tx.Create(comm).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return errCommissionExists
+			}
+			return err
+		}
+		created = append(created, comm)
+		if comm.LinkID != nil && comm.SubscriptionID == nil {
+			tx.Model(&models.AffiliateLink{}).Where("id = ?", *comm.LinkID).
+				UpdateColumn("conversions", gorm.Expr("conversions + 1"))
+		}
+
+		if program.SecondTierAmount > 0 && account.RecruitedByID != nil {
+			var recruiter models.AffiliateAccount
+			if err := tx.First(&recruiter, *account.RecruitedByID).Error; err == nil &&
+				recruiter.Status == models.AffiliateStatusActive && recruiter.ID != account.ID && recruiter.ContactID != buyerContactID {
+				rate := models.CommissionTier{CommissionType: program.SecondTierType, CommissionAmount: program.SecondTierAmount}
+				if amount := int64(math.Round(commissionOn(rate, base))); amount > 0 {
+					second := models.Commission{
+						TenantID:       comm.TenantID,
+						AccountID:      recruiter.ID,
+						OrderID:        comm.OrderID,
+						ProductID:      comm.ProductID,
+						Amount:         amount,
+						Status:         models.CommissionPending,
+						Level:          2,
+						ParentID:       &comm.ID,
+						SubscriptionID: comm.SubscriptionID,
+						PeriodStart:    comm.PeriodStart,
+						Description:    "Second-tier commission",
+						HoldUntil:      comm.HoldUntil,
+						FraudFlags:     comm.FraudFlags,
+						FlaggedAt:      comm.FlaggedAt,
+					}
+					if err := tx.Create(&second).Error; err != nil {
+						return err
+					}
+					created = append(created, &second)
+					contacts[recruiter.ID] = recruiter.ContactID
+				}
+			}
+		}
+
+		// Held commissions are approved by the release job once the hold ends;
+		// flagged ones wait in the review queue
+		if program.AutoApprove && comm.HoldUntil == nil && comm.FlaggedAt == nil {
+			now := time.Now()
+			for _, cm := range created {
+				if err := approveCommission(tx, cm, now); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errCommissionExists) {
+		return nil, nil
+	}
+	if err != nil || len(created) == 0 {
+		return nil, err
+	}
+
+	for _, cm := range created {
+		log.Printf("[affiliate] Commission %d of %d for account %d (level %d, %s)", cm.ID, cm.Amount, cm.AccountID, cm.Level, cm.Status)
+		events.Emit(events.AffiliateCommission, map[string]interface{}{
+			"commission_id": cm.ID,
+			"account_id":    cm.AccountID,
+			"contact_id":    contacts[cm.AccountID],
+			"order_id":      cm.OrderID,
+			"amount":        cm.Amount,
+			"level":         cm.Level,
+			"status":        cm.Status,
+		})
+	}
+	return comm, nil
+}
+
+// ApproveCommission approves a pending commission once its hold period is
+// over and credits it to the affiliate's balance through the ledger.
+func (s *AffiliateService) ApproveCommission(comm *models.Commission) error {
+	if comm.Status != models.CommissionPending {
+		return nil
+	}
+	now := time.Now()
+	if comm.HoldUntil != nil && comm.HoldUntil.After(now) {
+		return ErrCommissionOnHold
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// An admin approving a flagged commission counts as reviewing it
+		if comm.FlaggedAt != nil && comm.ReviewedAt == nil {
+			if err := tx.Model(&models.Commission{}).Where("id = ?", comm.ID).Update("reviewed_at", now).Error; err != nil {
+				return err
+			}
+			comm.ReviewedAt = &now
+		}
+		return approveCommission(tx, comm, now)
+	})
+}
+
+func approveCommission(tx *gorm.DB, comm *models.Commission, now time.Time) error {
+	res := tx.Model(&models.Commission{}).Where("id = ? AND status = ?", comm.ID, models.CommissionPending).
+		Updates(map[string]interface{}{"status": models.CommissionApproved, "approved_at": now})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error
+	}
+	comm.Status = models.CommissionApproved
+	comm.ApprovedAt = &now
+
+	description := comm.Description
+	if description == "" {
+		description = "Commission approved"
+	}
+	return postLedger(tx, comm.AccountID, models.LedgerEarned, comm.Amount, &comm.ID, nil, description)
+}
+
+// ReverseOrderCommissions claws back the refunded share of any affiliate
+// commission earned on the order. refundedFraction is the share of the order
+// refunded so far, across all refunds, so each call only reverses what the
+// earlier ones have not: the target is worked out from the commission's
+// original amount, not from what is left of it. Approved and paid
+// commissions are debited through the ledger (the balance may go negative
+// for paid ones).
+func (s *AffiliateService) ReverseOrderCommissions(orderID uint, refundedFraction float64, full bool) {
+	var ids []uint
+	s.db.Model(&models.Commission{}).Where("order_id = ? AND status IN ?", orderID,
+		[]string{models.CommissionPending, models.CommissionApproved, models.CommissionPaid}).Pluck("id", &ids)
+
+	for _, id := range ids {
+		var reverse int64
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			var cm models.Commission
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&cm, id).Error; err != nil {
+				return err
+			}
+			if cm.Status == models.CommissionReversed || cm.Status == models.CommissionRejected {
+				return nil
+			}
+			original := cm.Amount + cm.ReversedAmount
+			target := int64(math.Round(float64(original) * refundedFraction))
+			if full || target > original {
+				target = original
+			}
+			reverse = target - cm.ReversedAmount
+			if reverse <= 0 {
+				reverse = 0
+				return nil
+			}
+
+			updates := map[string]interface{}{"amount": cm.Amount - reverse, "reversed_amount": target}
+			if reverse == cm.Amount {
+				updates["status"] = models.CommissionReversed
+			}
+			if err := tx.Model(&models.Commission{}).Where("id = ?", cm.ID).Updates(updates).Error; err != nil {
+				return err
+			}
+			if err := releaseReversedAllocations(tx, cm.ID, cm.Amount-reverse); err != nil {
+				return err
+			}
+			if cm.Status == models.CommissionPending {
+				return nil
+			}
+			return postLedger(tx, cm.AccountID, models.LedgerReversal, -reverse, &cm.ID, nil,
+				fmt.Sprintf("Refund on order #%d", orderID))
+		})
+		if err != nil {
+			log.Printf("[affiliate] Failed to reverse commission %d: %v", id, err)
+			continue
+		}
+		if reverse > 0 {
+			log.Printf("[affiliate] Reversed %d of commission %d (order %d)", reverse, id, orderID)
+		}
+	}
+}
+
+// GrantAffiliateRole gives the user behind an active affiliate account the
+// affiliate role. Only plain users are promoted; staff and member roles are
+// left alone since the portal is scoped by account, not by role.
+func (s *AffiliateService) GrantAffiliateRole(account *models.AffiliateAccount) {
+	if account.Status != models.AffiliateStatusActive {
+		return
+	}
+	var contact models.Contact
+	if err := s.db.First(&contact, account.ContactID).Error; err != nil || contact.UserID == nil {
+		return
+	}
+	s.db.Model(&models.User{}).Where("id = ? AND role = ?", *contact.UserID, models.RoleUser).
+		Update("role", models.RoleAffiliate)
+}
+
+// AffiliateStats summarises an account's performance for the portal.
+type AffiliateStats struct {
+	Clicks          int64 `json:"clicks"`
+	Conversions     int64 `json:"conversions"`
+	PendingAmount   int64 `json:"pending_amount"`
+	ApprovedAmount  int64 `json:"approved_amount"`
+	PaidAmount      int64 `json:"paid_amount"`
+	Balance         int64 `json:"balance"`
+	RequestedPayout int64 `json:"requested_payout"` // pending payout requests
+	Available       int64 `json:"available"`        // balance not yet requested
+	MinPayoutAmount int64 `json:"min_payout_amount"`
+}
+
+// Stats returns click, conversion and earnings totals for an account.
+func (s *AffiliateService) Stats(account *models.AffiliateAccount) AffiliateStats {
+	stats := AffiliateStats{Balance: account.Balance, RequestedPayout: s.requestedPayout(account.ID)}
+	if account.Program != nil {
+		stats.MinPayoutAmount = account.Program.MinPayoutAmount
+	}
+
+	s.db.Model(&models.AffiliateClick{}).Where("account_id = ? AND duplicate = ?", account.ID, false).Count(&stats.Clicks)
+	s.db.Model(&models.Commission{}).Where("account_id = ? AND level = 1 AND subscription_id IS NULL AND status NOT IN ?", account.ID,
+		[]string{models.CommissionRejected, models.CommissionReversed}).Count(&stats.Conversions)
+
+	type sum struct {
+		Status string
+		Total  int64
+	}
+	var sums []sum
+	s.db.Model(&models.Commission{}).Select("status, COALESCE(SUM(amount), 0) as total").
+		Where("account_id = ?", account.ID).Group("status").Scan(&sums)
+	for _, row := range sums {
+		switch row.Status {
+		case models.CommissionPending:
+			stats.PendingAmount = row.Total
+		case models.CommissionApproved:
+			stats.ApprovedAmount = row.Total
+		case models.CommissionPaid:
+			stats.PaidAmount = row.Total
+		}
+	}
+
+	stats.Available = stats.Balance - stats.RequestedPayout
+	if stats.Available < 0 {
+		stats.Available = 0
+	}
+	return stats
+}
+
+func (s *AffiliateService) requestedPayout(accountID uint) int64 {
+	var total int64
+	s.db.Model(&models.Payout{}).Where("account_id = ? AND status IN ?", accountID,
+		[]string{models.PayoutPending, models.PayoutProcessing}).Select("COALESCE(SUM(amount), 0)").Scan(&total)
+	return total
+}
+
+// RequestPayout files a payout request from the affiliate, allocated against
+// their approved commissions. An amount of 0 requests the whole available
+// balance, which must reach the program's MinPayoutAmount. One request can
+// be open at a time. The account row is locked while the balance is checked
+// and the payout created, so concurrent requests can't both pass.
+func (s *AffiliateService) RequestPayout(account *models.AffiliateAccount, amount int64, method string) (*models.Payout, error) {
+	var payout *models.Payout
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var locked models.AffiliateAccount
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, account.ID).Error; err != nil {
+			return err
+		}
+		var open int64
+		if err := tx.Model(&models.Payout{}).Where("account_id = ? AND status IN ?", account.ID,
+			[]string{models.PayoutPending, models.PayoutProcessing}).Count(&open).Error; err != nil {
+			return err
+		}
+		if open > 0 {
+			return ErrPayoutPending
+		}
+		if amount <= 0 {
+			amount = locked.Balance
+		}
+		if amount > locked.Balance || amount <= 0 {
+			return ErrInsufficientBalance
+		}
+		if account.Program != nil && amount < account.Program.MinPayoutAmount {
+			return ErrPayoutBelowMinimum
+		}
+
+		var err error
+		payout, err = s.createPayout(tx, account.ID, amount, method, "Requested by affiliate", nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return payout, nil
+}
+
+// RegisterAffiliateListeners creates commissions when referred purchases
+// complete and referred subscriptions renew.
+func RegisterAffiliateListeners(db *gorm.DB) {
+	svc := NewAffiliateService(db)
+	events.Default().On(events.PurchaseCompleted, func(data interface{}) {
+		m, ok := data.(map[string]interface{})
+		if !ok {
+			return
+		}
+		orderID := toUint(m["order_id"])
+		if orderID == 0 {
+			return
+		}
+		if _, err := svc.CreateOrderCommission(orderID); err != nil {
+			log.Printf("[affiliate] Failed to create commission for order %d: %v", orderID, err)
+		}
+	})
+
+	// Renewal payload: subscription_id, amount (minor units), currency,
+	// period_start (time.Time) and optionally order_id
+	events.Default().On(events.SubscriptionRenewed, func(data interface{}) {
+		m, ok := data.(map[string]interface{})
+		if !ok {
+			return
+		}
+		subID := toUint(m["subscription_id"])
+		amount, _ := m["amount"].(float64)
+		currency, _ := m["currency"].(string)
+		periodStart, ok := m["period_start"].(time.Time)
+		if subID == 0 || amount <= 0 || !ok {
+			return
+		}
+		var orderID *uint
+		if id := toUint(m["order_id"]); id > 0 {
+			orderID = &id
+		}
+		if _, err := svc.CreateRenewalCommission(subID, amount, currency, periodStart, orderID); err != nil {
+			log.Printf("[affiliate] Failed to create renewal commission for subscription %d: %v", subID, err)
+		}
+	})
+}
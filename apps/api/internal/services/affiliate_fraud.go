@@ -0,0 +1,132 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/models"
+)
+
+// ErrCommissionNotFlagged is returned when clearing a commission that isn't
+// waiting for review.
+var ErrCommissionNotFlagged = errors.New("commission is not flagged for review")
+
+// RecordClick logs a hit on a referral link. A repeat from the same IP and
+// user agent inside the program's ClickWindowMinutes is stored as a
+// duplicate, which callers don't count.
+func (s *AffiliateService) RecordClick(account *models.AffiliateAccount, linkID *uint, ip, userAgent, referrer string, at time.Time) (*models.AffiliateClick, error) {
+	window := 24 * 60
+	if account.Program != nil && account.Program.ClickWindowMinutes > 0 {
+		window = account.Program.ClickWindowMinutes
+	}
+	if len(userAgent) > 500 {
+		userAgent = userAgent[:500]
+	}
+	if len(referrer) > 500 {
+		referrer = referrer[:500]
+	}
+
+	var repeats int64
+	s.db.Model(&models.AffiliateClick{}).
+		Where("account_id = ? AND ip_address = ? AND user_agent = ? AND created_at > ?",
+			account.ID, ip, userAgent, at.Add(-time.Duration(window)*time.Minute)).
+		Count(&repeats)
+
+	click := models.AffiliateClick{
+		TenantID:  account.TenantID,
+		AccountID: account.ID,
+		LinkID:    linkID,
+		IPAddress: ip,
+		UserAgent: userAgent,
+		Referrer:  referrer,
+		Duplicate: repeats > 0,
+		CreatedAt: at,
+	}
+	if err := s.db.Create(&click).Error; err != nil {
+		return nil, err
+	}
+	return &click, nil
+}
+
+// orderFraudFlags checks a referred order for signs of self-referral (the
+// buyer shares an IP or card with the affiliate) and for unusual click or
+// conversion velocity on the account.
+func (s *AffiliateService) orderFraudFlags(account *models.AffiliateAccount, order *models.Order, at time.Time) []string {
+	var flags []string
+
+	if order.IPAddress != "" {
+		var matches int64
+		if account.SignupIP == order.IPAddress {
+			matches = 1
+		} else {
+			s.db.Model(&models.Contact{}).Where("id = ? AND ip_address = ?", account.ContactID, order.IPAddress).Count(&matches)
+			if matches == 0 {
+				s.db.Model(&models.Order{}).Where("contact_id = ? AND ip_address = ?", account.ContactID, order.IPAddress).Count(&matches)
+			}
+		}
+		if matches > 0 {
+			flags = append(flags, models.FraudSelfReferralIP)
+		}
+	}
+
+	if order.PaymentFingerprint != "" {
+		var matches int64
+		s.db.Model(&models.Order{}).Where("contact_id = ? AND payment_fingerprint = ?", account.ContactID, order.PaymentFingerprint).Count(&matches)
+		if matches > 0 {
+			flags = append(flags, models.FraudSelfReferralPayment)
+		}
+	}
+
+	program := account.Program
+	if program.MaxClicksPerHour > 0 {
+		var clicks int64
+		s.db.Model(&models.AffiliateClick{}).Where("account_id = ? AND duplicate = ? AND created_at > ?",
+			account.ID, false, at.Add(-time.Hour)).Count(&clicks)
+		if clicks > int64(program.MaxClicksPerHour) {
+			flags = append(flags, models.FraudClickVelocity)
+		}
+	}
+	if program.MaxConversionsPerDay > 0 {
+		var conversions int64
+		s.db.Model(&models.Commission{}).Where("account_id = ? AND level = 1 AND subscription_id IS NULL AND created_at > ?",
+			account.ID, at.Add(-24*time.Hour)).Count(&conversions)
+		if conversions >= int64(program.MaxConversionsPerDay) {
+			flags = append(flags, models.FraudConversionVelocity)
+		}
+	}
+	return flags
+}
+
+// flagCommission marks a commission for review; flagged commissions are never
+// auto-approved or released by the hold job.
+func flagCommission(comm *models.Commission, flags []string, at time.Time) {
+	if len(flags) == 0 {
+		return
+	}
+	raw, _ := json.Marshal(flags)
+	comm.FraudFlags = raw
+	comm.FlaggedAt = &at
+}
+
+// ClearCommission accepts a flagged commission after review. It's approved
+// straight away if its hold period is over, otherwise the release job
+// approves it when the hold ends (for auto-approve programs).
+func (s *AffiliateService) ClearCommission(comm *models.Commission) error {
+	if comm.FlaggedAt == nil || comm.ReviewedAt != nil {
+		return ErrCommissionNotFlagged
+	}
+	now := time.Now()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Commission{}).Where("id = ?", comm.ID).Update("reviewed_at", now).Error; err != nil {
+			return err
+		}
+		comm.ReviewedAt = &now
+		if comm.Status != models.CommissionPending || (comm.HoldUntil != nil && comm.HoldUntil.After(now)) {
+			return nil
+		}
+		return approveCommission(tx, comm, now)
+	})
+}
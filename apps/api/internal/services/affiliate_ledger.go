@@ -0,0 +1,529 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"gritcms/apps/api/internal/models"
+)
+
+// Payout errors.
+var (
+	ErrPayoutNotFound      = errors.New("payout not found")
+	ErrPayoutNotPending    = errors.New("payout is not pending")
+	ErrBatchNotFound       = errors.New("payout batch not found")
+	ErrEmptyBatch          = errors.New("no affiliates are due a payout by this method")
+	ErrUnknownPayoutMethod = errors.New("payout method must be 'paypal' or 'bank_transfer'")
+)
+
+// postLedger applies a signed change to an affiliate's balance and records it
+// in the ledger. Every balance change goes through here, inside the caller's
+// transaction, with the account row locked.
+func postLedger(tx *gorm.DB, accountID uint, entryType string, amount int64, commissionID, payoutID *uint, description string) error {
+	var account models.AffiliateAccount
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, accountID).Error; err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{"balance": account.Balance + amount}
+	switch entryType {
+	case models.LedgerEarned, models.LedgerReversal:
+		updates["total_earned"] = account.TotalEarned + amount
+	case models.LedgerPayout:
+		updates["total_paid"] = account.TotalPaid - amount
+	}
+	if err := tx.Model(&models.AffiliateAccount{}).Where("id = ?", accountID).UpdateColumns(updates).Error; err != nil {
+		return err
+	}
+
+	return tx.Create(&models.AffiliateLedgerEntry{
+		TenantID:     account.TenantID,
+		AccountID:    accountID,
+		Type:         entryType,
+		Amount:       amount,
+		BalanceAfter: account.Balance + amount,
+		CommissionID: commissionID,
+		PayoutID:     payoutID,
+		Description:  description,
+	}).Error
+}
+
+// Adjust posts a manual balance correction to an account's ledger.
+func (s *AffiliateService) Adjust(accountID uint, amount int64, description string) error {
+	if description == "" {
+		description = "Manual adjustment"
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return postLedger(tx, accountID, models.LedgerAdjustment, amount, nil, nil, description)
+	})
+}
+
+// Ledger returns a page of an account's ledger entries, newest first.
+func (s *AffiliateService) Ledger(accountID uint, page, pageSize int) ([]models.AffiliateLedgerEntry, int64) {
+	var total int64
+	q := s.db.Model(&models.AffiliateLedgerEntry{}).Where("account_id = ?", accountID)
+	q.Count(&total)
+	var entries []models.AffiliateLedgerEntry
+	q.Order("created_at DESC, id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&entries)
+	return entries, total
+}
+
+// ReleaseHeldCommissions approves pending commissions whose hold period has
+// ended, for programs that auto-approve. Commissions flagged for fraud are
+// left until reviewed. Returns how many were approved.
+func (s *AffiliateService) ReleaseHeldCommissions(now time.Time) (int, error) {
+	var due []models.Commission
+	s.db.Joins("JOIN affiliate_accounts aa ON aa.id = commissions.account_id").
+		Joins("JOIN affiliate_programs ap ON ap.id = aa.program_id").
+		Where("commissions.status = ? AND ap.auto_approve = ? AND (commissions.hold_until IS NULL OR commissions.hold_until <= ?)"+
+			" AND (commissions.flagged_at IS NULL OR commissions.reviewed_at IS NOT NULL)",
+			models.CommissionPending, true, now).
+		Order("commissions.created_at ASC").Find(&due)
+
+	released := 0
+	for i := range due {
+		if err := s.ApproveCommission(&due[i]); err != nil {
+			return released, err
+		}
+		released++
+	}
+	return released, nil
+}
+
+// commissionRemainder is the part of an approved commission that no open or
+// completed payout has claimed yet.
+type commissionRemainder struct {
+	ID        uint
+	Remaining int64
+}
+
+func allocatable(tx *gorm.DB, accountID uint) []commissionRemainder {
+	var rows []commissionRemainder
+	tx.Raw(`
+		SELECT c.id, c.amount - COALESCE(SUM(pa.amount), 0) AS remaining
+		FROM commissions c
+		LEFT JOIN payout_allocations pa ON pa.commission_id = c.id
+			AND pa.payout_id IN (SELECT id FROM payouts WHERE status <> ?)
+		WHERE c.account_id = ? AND c.status = ?
+		GROUP BY c.id, c.amount, c.approved_at
+		HAVING c.amount - COALESCE(SUM(pa.amount), 0) > 0
+		ORDER BY c.approved_at ASC, c.id ASC
+	`, models.PayoutFailed, accountID, models.CommissionApproved).Scan(&rows)
+	return rows
+}
+
+// createPayout creates a pending payout and allocates it against the
+// account's approved commissions, oldest first. The amount can't exceed the
+// balance not already claimed by open payouts; any part of it beyond the
+// unallocated commissions (e.g. a manual credit) is paid unallocated.
+func (s *AffiliateService) createPayout(tx *gorm.DB, accountID uint, amount int64, method, note string, batchID *uint) (*models.Payout, error) {
+	var account models.AffiliateAccount
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, accountID).Error; err != nil {
+		return nil, err
+	}
+	var requested int64
+	tx.Model(&models.Payout{}).Where("account_id = ? AND status IN ?", accountID,
+		[]string{models.PayoutPending, models.PayoutProcessing}).Select("COALESCE(SUM(amount), 0)").Scan(&requested)
+	if amount <= 0 || amount > account.Balance-requested {
+		return nil, ErrInsufficientBalance
+	}
+
+	remainders := allocatable(tx, accountID)
+
+	if method == "" {
+		method = account.PayoutMethod
+	}
+	payout := models.Payout{
+		TenantID:  account.TenantID,
+		AccountID: accountID,
+		Amount:    amount,
+		Method:    method,
+		Status:    models.PayoutPending,
+		BatchID:   batchID,
+		Currency:  NewCurrencyService(tx).BaseCurrency(account.TenantID),
+		Note:      note,
+	}
+	if err := tx.Create(&payout).Error; err != nil {
+		return nil, err
+	}
+
+	left := amount
+	for _, r := range remainders {
+		if left <= 0 {
+			break
+		}
+		part := r.Remaining
+		if part > left {
+			part = left
+		}
+		if err := tx.Create(&models.PayoutAllocation{PayoutID: payout.ID, CommissionID: r.ID, Amount: part}).Error; err != nil {
+			return nil, err
+		}
+		left -= part
+	}
+	return &payout, nil
+}
+
+// CreatePayout creates a payout for an account on the admin's behalf; unlike
+// RequestPayout it ignores the program minimum.
+func (s *AffiliateService) CreatePayout(accountID uint, amount int64, method string) (*models.Payout, error) {
+	var payout *models.Payout
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		payout, err = s.createPayout(tx, accountID, amount, method, "", nil)
+		return err
+	})
+	return payout, err
+}
+
+// CompletePayout marks a payout as sent: the amount leaves the balance
+// through the ledger, and each commission it fully settles becomes paid.
+func (s *AffiliateService) CompletePayout(payoutID uint, transactionID string) (*models.Payout, error) {
+	var payout models.Payout
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Allocations").First(&payout, payoutID).Error; err != nil {
+			return ErrPayoutNotFound
+		}
+		return s.completePayout(tx, &payout, transactionID, time.Now())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &payout, nil
+}
+
+func (s *AffiliateService) completePayout(tx *gorm.DB, payout *models.Payout, transactionID string, now time.Time) error {
+	if payout.Status != models.PayoutPending && payout.Status != models.PayoutProcessing {
+		return ErrPayoutNotPending
+	}
+	updates := map[string]interface{}{"status": models.PayoutCompleted, "processed_at": now}
+	if transactionID != "" {
+		updates["transaction_id"] = transactionID
+	}
+	if err := tx.Model(payout).Updates(updates).Error; err != nil {
+		return err
+	}
+	payout.Status = models.PayoutCompleted
+	payout.ProcessedAt = &now
+
+	if err := postLedger(tx, payout.AccountID, models.LedgerPayout, -payout.Amount, nil, &payout.ID,
+		fmt.Sprintf("Payout #%d via %s", payout.ID, payout.Method)); err != nil {
+		return err
+	}
+
+	for _, a := range payout.Allocations {
+		var comm models.Commission
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&comm, a.CommissionID).Error; err != nil {
+			continue
+		}
+		comm.PaidAmount += a.Amount
+		cu := map[string]interface{}{"paid_amount": comm.PaidAmount}
+		if comm.PaidAmount >= comm.Amount && comm.Status == models.CommissionApproved {
+			cu["status"] = models.CommissionPaid
+			cu["paid_at"] = now
+		}
+		if err := tx.Model(&comm).Updates(cu).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FailPayout marks a payout as failed and releases its commissions so they
+// can be paid by a later payout.
+func (s *AffiliateService) FailPayout(payoutID uint, reason string) (*models.Payout, error) {
+	var payout models.Payout
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payout, payoutID).Error; err != nil {
+			return ErrPayoutNotFound
+		}
+		if payout.Status != models.PayoutPending && payout.Status != models.PayoutProcessing {
+			return ErrPayoutNotPending
+		}
+		payout.Status = models.PayoutFailed
+		if reason != "" {
+			payout.Note = reason
+		}
+		if err := tx.Model(&payout).Updates(map[string]interface{}{"status": payout.Status, "note": payout.Note}).Error; err != nil {
+			return err
+		}
+		return tx.Where("payout_id = ?", payout.ID).Delete(&models.PayoutAllocation{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &payout, nil
+}
+
+// payoutDestination reports whether an account has the details needed to be
+// paid by a method.
+func payoutDestination(account *models.AffiliateAccount, method string) bool {
+	switch method {
+	case models.PayoutMethodPayPal:
+		return account.PayPalEmail != ""
+	case models.PayoutMethodBank:
+		return account.BankAccountNumber != "" && account.BankAccountName != ""
+	}
+	return false
+}
+
+// CreatePayoutBatch collects everything owed to affiliates paid by one
+// method: open payout requests not yet in a batch, plus a new payout of the
+// available balance for each account that reaches its program's minimum.
+// Accounts without payout details for the method are skipped.
+func (s *AffiliateService) CreatePayoutBatch(tenantID uint, method string) (*models.PayoutBatch, error) {
+	if method != models.PayoutMethodPayPal && method != models.PayoutMethodBank {
+		return nil, ErrUnknownPayoutMethod
+	}
+
+	var accounts []models.AffiliateAccount
+	s.db.Preload("Program").Where("tenant_id = ? AND status = ? AND payout_method = ?", tenantID,
+		models.AffiliateStatusActive, method).Find(&accounts)
+
+	batch := models.PayoutBatch{
+		TenantID: tenantID,
+		Method:   method,
+		Status:   models.PayoutBatchDraft,
+		Currency: NewCurrencyService(s.db).BaseCurrency(tenantID),
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&batch).Error; err != nil {
+			return err
+		}
+		for i := range accounts {
+			account := &accounts[i]
+			if !payoutDestination(account, method) {
+				continue
+			}
+
+			// Requests the affiliate already made join the batch as they are
+			var open []models.Payout
+			tx.Where("account_id = ? AND status = ? AND batch_id IS NULL", account.ID, models.PayoutPending).Find(&open)
+			if len(open) > 0 {
+				for _, p := range open {
+					tx.Model(&models.Payout{}).Where("id = ?", p.ID).Updates(map[string]interface{}{"batch_id": batch.ID, "method": method})
+					batch.TotalAmount += p.Amount
+					batch.PayoutCount++
+				}
+				continue
+			}
+
+			available := account.Balance - s.requestedPayout(account.ID)
+			min := int64(0)
+			if account.Program != nil {
+				min = account.Program.MinPayoutAmount
+			}
+			if available <= 0 || available < min {
+				continue
+			}
+			payout, err := s.createPayout(tx, account.ID, available, method, "", &batch.ID)
+			if err != nil {
+				if errors.Is(err, ErrInsufficientBalance) {
+					continue
+				}
+				return err
+			}
+			batch.TotalAmount += payout.Amount
+			batch.PayoutCount++
+		}
+		if batch.PayoutCount == 0 {
+			return ErrEmptyBatch
+		}
+		return tx.Model(&batch).Updates(map[string]interface{}{
+			"total_amount": batch.TotalAmount,
+			"payout_count": batch.PayoutCount,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[affiliate] Payout batch %d: %d payouts, %d via %s", batch.ID, batch.PayoutCount, batch.TotalAmount, method)
+	return &batch, nil
+}
+
+// ExportPayoutBatch renders a batch for upload to the payment channel: a
+// PayPal Payouts CSV (recipient, amount, currency, reference, note, wallet)
+// or a bank-transfer CSV with a header row. A draft batch is marked exported
+// and its payouts processing.
+func (s *AffiliateService) ExportPayoutBatch(batchID uint) ([]byte, string, error) {
+	var batch models.PayoutBatch
+	var buf bytes.Buffer
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&batch, batchID).Error; err != nil {
+			return ErrBatchNotFound
+		}
+		if err := tx.Preload("Account.Contact").Where("batch_id = ? AND status IN ?", batch.ID,
+			[]string{models.PayoutPending, models.PayoutProcessing}).Find(&batch.Payouts).Error; err != nil {
+			return err
+		}
+
+		w := csv.NewWriter(&buf)
+		if batch.Method == models.PayoutMethodBank {
+			w.Write([]string{"Account Name", "Bank Name", "Account Number", "Routing Number", "SWIFT/BIC", "Amount", "Currency", "Reference", "Email"})
+		}
+		for _, p := range batch.Payouts {
+			a := p.Account
+			if a == nil {
+				continue
+			}
+			amount := fmt.Sprintf("%.2f", float64(p.Amount)/100)
+			currency := p.Currency
+			if currency == "" {
+				currency = batch.Currency
+			}
+			reference := fmt.Sprintf("PAYOUT-%d", p.ID)
+			email := ""
+			if a.Contact != nil {
+				email = a.Contact.Email
+			}
+			switch batch.Method {
+			case models.PayoutMethodPayPal:
+				w.Write(csvRow(a.PayPalEmail, amount, currency, reference, "Affiliate commission payout", "PayPal"))
+			default:
+				w.Write(csvRow(a.BankAccountName, a.BankName, a.BankAccountNumber, a.BankRoutingNumber, a.BankSwift, amount, currency, reference, email))
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+
+		if batch.Status != models.PayoutBatchDraft {
+			return nil
+		}
+		now := time.Now()
+		if err := tx.Model(&batch).Updates(map[string]interface{}{"status": models.PayoutBatchExported, "exported_at": now}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Payout{}).Where("batch_id = ? AND status = ?", batch.ID, models.PayoutPending).
+			Update("status", models.PayoutProcessing).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), fmt.Sprintf("payout-batch-%d-%s.csv", batch.ID, batch.Method), nil
+}
+
+// csvRow neutralises cells a spreadsheet would read as a formula (leading
+// = + - @, tab or carriage return) by prefixing them with a quote.
+func csvRow(cells ...string) []string {
+	for i, cell := range cells {
+		if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+			cells[i] = "'" + cell
+		}
+	}
+	return cells
+}
+
+// releaseReversedAllocations shrinks the pending payouts claiming a
+// commission that a refund reduced to `remaining`, newest first, so they
+// don't pay out reversed money. Payouts left empty are failed. Payouts
+// already exported (processing) are left alone.
+func releaseReversedAllocations(tx *gorm.DB, commissionID uint, remaining int64) error {
+	type claim struct {
+		ID       uint
+		PayoutID uint
+		Amount   int64
+		Status   string
+	}
+	var claims []claim
+	if err := tx.Raw(`
+		SELECT pa.id, pa.payout_id, pa.amount, p.status
+		FROM payout_allocations pa JOIN payouts p ON p.id = pa.payout_id
+		WHERE pa.commission_id = ? AND p.status <> ?
+		ORDER BY p.created_at DESC, pa.id DESC
+	`, commissionID, models.PayoutFailed).Scan(&claims).Error; err != nil {
+		return err
+	}
+	var claimed int64
+	for _, c := range claims {
+		claimed += c.Amount
+	}
+	excess := claimed - remaining
+
+	for _, c := range claims {
+		if excess <= 0 {
+			break
+		}
+		if c.Status != models.PayoutPending {
+			continue
+		}
+		release := c.Amount
+		if release > excess {
+			release = excess
+		}
+		excess -= release
+
+		if release == c.Amount {
+			if err := tx.Delete(&models.PayoutAllocation{}, c.ID).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Model(&models.PayoutAllocation{}).Where("id = ?", c.ID).
+			UpdateColumn("amount", c.Amount-release).Error; err != nil {
+			return err
+		}
+
+		var payout models.Payout
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payout, c.PayoutID).Error; err != nil {
+			return err
+		}
+		updates := map[string]interface{}{"amount": payout.Amount - release}
+		if payout.Amount-release <= 0 {
+			updates["amount"] = int64(0)
+			updates["status"] = models.PayoutFailed
+			updates["note"] = "Commissions reversed by refunds"
+		}
+		if err := tx.Model(&payout).Updates(updates).Error; err != nil {
+			return err
+		}
+		if payout.BatchID != nil {
+			bu := map[string]interface{}{"total_amount": gorm.Expr("total_amount - ?", release)}
+			if updates["status"] == models.PayoutFailed {
+				bu["payout_count"] = gorm.Expr("payout_count - 1")
+			}
+			if err := tx.Model(&models.PayoutBatch{}).Where("id = ?", *payout.BatchID).UpdateColumns(bu).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CompletePayoutBatch marks every open payout in the batch as sent, once the
+// channel has confirmed the transfer.
+func (s *AffiliateService) CompletePayoutBatch(batchID uint, reference string) (*models.PayoutBatch, error) {
+	var batch models.PayoutBatch
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&batch, batchID).Error; err != nil {
+			return ErrBatchNotFound
+		}
+		var payouts []models.Payout
+		tx.Preload("Allocations").Where("batch_id = ? AND status IN ?", batch.ID,
+			[]string{models.PayoutPending, models.PayoutProcessing}).Find(&payouts)
+		now := time.Now()
+		for i := range payouts {
+			txID := reference
+			if txID != "" {
+				txID = fmt.Sprintf("%s/%d", reference, payouts[i].ID)
+			}
+			if err := s.completePayout(tx, &payouts[i], txID, now); err != nil {
+				return err
+			}
+		}
+		batch.Status = models.PayoutBatchCompleted
+		batch.CompletedAt = &now
+		return tx.Model(&batch).Updates(map[string]interface{}{"status": batch.Status, "completed_at": now}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
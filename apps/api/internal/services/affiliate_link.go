@@ -0,0 +1,195 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/models"
+)
+
+// AffiliateLinkCookie holds the ID of the affiliate link followed at
+// /api/l/:slug, so the conversion can be credited to that link.
+const AffiliateLinkCookie = "grit_ref_link"
+
+// Affiliate link errors.
+var (
+	ErrLinkNotFound       = errors.New("affiliate link not found")
+	ErrInvalidLinkTarget  = errors.New("link target not found")
+	ErrInvalidLinkURL     = errors.New("url must be an absolute http(s) URL")
+	ErrLinkHostNotAllowed = errors.New("url must point to this site or one of the program's link domains")
+	ErrLinkSlugTaken      = errors.New("could not find a free link slug")
+)
+
+// utmParams are passed from the incoming click through to the destination.
+var utmParams = []string{"utm_source", "utm_medium", "utm_campaign", "utm_content", "utm_term"}
+
+// ResolveLink finds a link by slug, provided its account can still earn.
+func (s *AffiliateService) ResolveLink(slug string) (*models.AffiliateLink, *models.AffiliateAccount, error) {
+	var link models.AffiliateLink
+	if slug == "" || s.db.Where("slug = ? AND tenant_id = ?", slug, 1).First(&link).Error != nil {
+		return nil, nil, ErrLinkNotFound
+	}
+	var account models.AffiliateAccount
+	if err := s.db.Preload("Program").First(&account, link.AccountID).Error; err != nil {
+		return nil, nil, ErrLinkNotFound
+	}
+	if account.Status != models.AffiliateStatusActive || account.Program == nil || account.Program.Status != "active" {
+		return nil, nil, ErrLinkNotFound
+	}
+	return &link, &account, nil
+}
+
+// LinkURL resolves where a link points. Deep links to a product, course or
+// funnel step become the public page on webURL; plain links must be
+// absolute http(s) URLs on the site or one of the program's link domains.
+func (s *AffiliateService) LinkURL(webURL string, program *models.AffiliateProgram, targetType string, targetID *uint, raw string) (string, error) {
+	webURL = strings.TrimRight(webURL, "/")
+	if targetType != "" && targetType != models.LinkTargetURL && targetID == nil {
+		return "", ErrInvalidLinkTarget
+	}
+
+	switch targetType {
+	case "", models.LinkTargetURL:
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "", ErrInvalidLinkURL
+		}
+		if !LinkHostAllowed(webURL, program, raw) {
+			return "", ErrLinkHostNotAllowed
+		}
+		return raw, nil
+	case models.LinkTargetProduct:
+		var product models.Product
+		if s.db.Where("id = ? AND status = ?", *targetID, "active").First(&product).Error != nil {
+			return "", ErrInvalidLinkTarget
+		}
+		return fmt.Sprintf("%s/products/%s", webURL, product.Slug), nil
+	case models.LinkTargetCourse:
+		var course models.Course
+		if s.db.First(&course, *targetID).Error != nil {
+			return "", ErrInvalidLinkTarget
+		}
+		return fmt.Sprintf("%s/courses/%s", webURL, course.Slug), nil
+	case models.LinkTargetFunnelStep:
+		var step models.FunnelStep
+		if s.db.First(&step, *targetID).Error != nil {
+			return "", ErrInvalidLinkTarget
+		}
+		var funnel models.Funnel
+		if s.db.First(&funnel, step.FunnelID).Error != nil {
+			return "", ErrInvalidLinkTarget
+		}
+		return fmt.Sprintf("%s/f/%s/%s", webURL, funnel.Slug, step.Slug), nil
+	}
+	return "", ErrInvalidLinkTarget
+}
+
+// LinkHostAllowed reports whether a link may redirect to raw: the site's own
+// host, or one of the program's link domains (subdomains included).
+func LinkHostAllowed(webURL string, program *models.AffiliateProgram, raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	allowed := []string{}
+	if site, err := url.Parse(webURL); err == nil && site.Hostname() != "" {
+		allowed = append(allowed, site.Hostname())
+	}
+	if program != nil && len(program.LinkDomains) > 0 {
+		var domains []string
+		json.Unmarshal(program.LinkDomains, &domains)
+		allowed = append(allowed, domains...)
+	}
+	for _, domain := range allowed {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "."))
+		if domain != "" && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateLink saves a link under base, or base with a numeric suffix. The
+// unique index on (tenant_id, slug) settles races: a slug taken in between
+// the check and the insert is retried with the next suffix.
+func (s *AffiliateService) CreateLink(link *models.AffiliateLink, base string) error {
+	for attempt := 0; attempt < 5; attempt++ {
+		link.ID = 0
+		link.Slug = s.UniqueLinkSlug(base, 0)
+		err := s.db.Create(link).Error
+		if !errors.Is(err, gorm.ErrDuplicatedKey) {
+			return err
+		}
+	}
+	return ErrLinkSlugTaken
+}
+
+// UniqueLinkSlug returns base, or base with a numeric suffix, so that no
+// other link uses it.
+func (s *AffiliateService) UniqueLinkSlug(base string, excludeID uint) string {
+	slug := base
+	for i := 2; ; i++ {
+		var count int64
+		s.db.Model(&models.AffiliateLink{}).Where("slug = ? AND tenant_id = ? AND id <> ?", slug, 1, excludeID).Count(&count)
+		if count == 0 {
+			return slug
+		}
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// LinkDestination builds the redirect for a click: the link's URL with the
+// referral code and UTM tags. Tags on the incoming request win over the
+// link's defaults.
+func LinkDestination(link *models.AffiliateLink, code string, incoming url.Values) string {
+	u, err := url.Parse(link.URL)
+	if err != nil {
+		return link.URL
+	}
+	q := u.Query()
+	q.Set("ref", code)
+	defaults := map[string]string{
+		"utm_source":   link.UTMSource,
+		"utm_medium":   link.UTMMedium,
+		"utm_campaign": link.UTMCampaign,
+		"utm_content":  link.UTMContent,
+	}
+	for _, key := range utmParams {
+		if v := incoming.Get(key); v != "" {
+			q.Set(key, v)
+		} else if v := defaults[key]; v != "" {
+			q.Set(key, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// AttributeLink records the link a referred contact arrived through, if it
+// belongs to the affiliate they are currently attributed to.
+func (s *AffiliateService) AttributeLink(contact *models.Contact, linkID uint, at time.Time) {
+	accountID := ActiveReferral(contact, at)
+	if accountID == nil || linkID == 0 {
+		return
+	}
+	var link models.AffiliateLink
+	if s.db.First(&link, linkID).Error != nil || link.AccountID != *accountID {
+		return
+	}
+	contact.ReferredByLinkID = &link.ID
+	s.db.Model(&models.Contact{}).Where("id = ?", contact.ID).Update("referred_by_link_id", link.ID)
+}
+
+// ActiveReferralLink returns the link behind the contact's active referral.
+func ActiveReferralLink(contact *models.Contact, at time.Time) *uint {
+	if ActiveReferral(contact, at) == nil {
+		return nil
+	}
+	return contact.ReferredByLinkID
+}
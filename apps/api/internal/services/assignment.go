@@ -0,0 +1,466 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"path"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/events"
+	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/storage"
+)
+
+// Assignment errors.
+var (
+	ErrAssignmentNotPassed = errors.New("pass this lesson's assignment before completing it")
+	ErrMaxSubmissions      = errors.New("maximum submissions reached")
+	ErrEmptySubmission     = errors.New("add a file or some text to submit")
+	ErrSubmissionType      = errors.New("this assignment doesn't accept that kind of submission")
+	ErrTooManyFiles        = errors.New("too many files for this assignment")
+	ErrInvalidUpload       = errors.New("one or more files weren't found in your uploads")
+	ErrInvalidGrade        = errors.New("grade every rubric criterion, or give points when there is no rubric")
+	ErrNotInstructor       = errors.New("only the course instructor can review these submissions")
+)
+
+// submissionFileTTL is how long signed links to submitted files stay valid.
+const submissionFileTTL = time.Hour
+
+// RubricCriterion is one line of an assignment's rubric.
+type RubricCriterion struct {
+	Criterion   string `json:"criterion"`
+	Description string `json:"description"`
+	Points      int    `json:"points"` // maximum
+}
+
+// RubricScore is the grade awarded on one rubric criterion.
+type RubricScore struct {
+	Criterion string  `json:"criterion"`
+	Points    float64 `json:"points"`
+	Comment   string  `json:"comment"`
+}
+
+// storedFile is a file attached to a submission, as stored.
+type storedFile struct {
+	UploadID uint   `json:"upload_id"`
+	Filename string `json:"filename"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	Path     string `json:"path"`
+}
+
+// SubmissionFile is a submitted file as served, with a signed link instead
+// of its storage path.
+type SubmissionFile struct {
+	UploadID uint   `json:"upload_id"`
+	Filename string `json:"filename"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	URL      string `json:"url"`
+}
+
+// SubmissionInput is what a student submits.
+type SubmissionInput struct {
+	Text      string `json:"text"`
+	UploadIDs []uint `json:"upload_ids"`
+}
+
+// GradeInput is an instructor's grade: per-criterion scores when the
+// assignment has a rubric, otherwise points out of MaxPoints.
+type GradeInput struct {
+	Scores   []RubricScore `json:"scores"`
+	Points   *float64      `json:"points"`
+	Feedback string        `json:"feedback"`
+}
+
+// SubmissionView is a submission with signed links to its files.
+type SubmissionView struct {
+	models.AssignmentSubmission
+	Files []SubmissionFile `json:"files"`
+}
+
+// AssignmentService takes student submissions and instructor grades.
+// Submitted files are moved to the private bucket and served through signed
+// links.
+type AssignmentService struct {
+	db      *gorm.DB
+	storage *storage.Storage
+	public  *storage.Storage // where students upload before submitting
+}
+
+// NewAssignmentService creates an assignment service.
+func NewAssignmentService(db *gorm.DB, store *storage.Storage) *AssignmentService {
+	s := &AssignmentService{db: db, public: store}
+	if store != nil {
+		s.storage = store.Private()
+	}
+	return s
+}
+
+// requiredAssignmentsPassed reports whether the enrollment has a passing
+// submission for every required assignment of the given lessons.
+func (s *CourseService) requiredAssignmentsPassed(enrollmentID uint, lessonIDs []uint) bool {
+	if len(lessonIDs) == 0 {
+		return true
+	}
+	var missing int64
+	s.db.Model(&models.Assignment{}).
+		Where("lesson_id IN ? AND required = ?", lessonIDs, true).
+		Where("NOT EXISTS (SELECT 1 FROM assignment_submissions sub WHERE sub.assignment_id = assignments.id AND sub.enrollment_id = ? AND sub.passed = ?)", enrollmentID, true).
+		Count(&missing)
+	return missing == 0
+}
+
+// ParseRubric decodes an assignment's rubric.
+func ParseRubric(assignment *models.Assignment) []RubricCriterion {
+	var rubric []RubricCriterion
+	if len(assignment.Rubric) > 0 {
+		_ = json.Unmarshal(assignment.Rubric, &rubric)
+	}
+	return rubric
+}
+
+// Submissions lists an enrollment's submissions for an assignment, newest
+// first, with signed file links.
+func (s *AssignmentService) Submissions(ctx context.Context, assignmentID, enrollmentID uint) []SubmissionView {
+	var submissions []models.AssignmentSubmission
+	s.db.Where("assignment_id = ? AND enrollment_id = ?", assignmentID, enrollmentID).
+		Order("submitted_at DESC").Find(&submissions)
+	views := make([]SubmissionView, 0, len(submissions))
+	for _, sub := range submissions {
+		views = append(views, s.View(ctx, &sub))
+	}
+	return views
+}
+
+// View signs a submission's file links.
+func (s *AssignmentService) View(ctx context.Context, submission *models.AssignmentSubmission) SubmissionView {
+	var stored []storedFile
+	if len(submission.Files) > 0 {
+		_ = json.Unmarshal(submission.Files, &stored)
+	}
+	files := make([]SubmissionFile, 0, len(stored))
+	for _, f := range stored {
+		file := SubmissionFile{UploadID: f.UploadID, Filename: f.Filename, MimeType: f.MimeType, Size: f.Size}
+		if s.storage != nil {
+			file.URL, _ = s.storage.GetSignedURL(ctx, f.Path, submissionFileTTL)
+		}
+		files = append(files, file)
+	}
+	return SubmissionView{AssignmentSubmission: *submission, Files: files}
+}
+
+// Submit records a student's submission. Files must already be uploaded by
+// the student (see UploadHandler.Presign); once the submission is saved they
+// move to the private bucket and leave the student's uploads. A submission
+// still awaiting review is replaced rather than counted again, keeping the
+// files it resubmits.
+func (s *AssignmentService) Submit(ctx context.Context, assignment *models.Assignment, enrollment *models.CourseEnrollment, userID uint, input SubmissionInput, now time.Time) (*models.AssignmentSubmission, error) {
+	text := strings.TrimSpace(input.Text)
+	if text == "" && len(input.UploadIDs) == 0 {
+		return nil, ErrEmptySubmission
+	}
+	switch assignment.SubmissionType {
+	case models.AssignmentSubmitFile:
+		if len(input.UploadIDs) == 0 || text != "" {
+			return nil, ErrSubmissionType
+		}
+	case models.AssignmentSubmitText:
+		if text == "" || len(input.UploadIDs) > 0 {
+			return nil, ErrSubmissionType
+		}
+	}
+	if assignment.MaxFiles > 0 && len(input.UploadIDs) > assignment.MaxFiles {
+		return nil, ErrTooManyFiles
+	}
+
+	var pending models.AssignmentSubmission
+	hasPending := s.db.Where("assignment_id = ? AND enrollment_id = ? AND status = ?", assignment.ID, enrollment.ID, models.SubmissionPending).
+		First(&pending).Error == nil
+	if !hasPending && assignment.MaxSubmissions > 0 {
+		var used int64
+		s.db.Model(&models.AssignmentSubmission{}).Where("assignment_id = ? AND enrollment_id = ?", assignment.ID, enrollment.ID).Count(&used)
+		if int(used) >= assignment.MaxSubmissions {
+			return nil, ErrMaxSubmissions
+		}
+	}
+
+	// Uploads still on file are moved in once the submission is saved; ones
+	// a pending submission already moved are kept as they are.
+	type move struct {
+		upload models.Upload
+		to     string
+	}
+	files := []storedFile{}
+	var moves []move
+	if len(input.UploadIDs) > 0 {
+		kept := map[uint]storedFile{}
+		if hasPending && len(pending.Files) > 0 {
+			var previous []storedFile
+			_ = json.Unmarshal(pending.Files, &previous)
+			for _, f := range previous {
+				kept[f.UploadID] = f
+			}
+		}
+		var uploads []models.Upload
+		s.db.Where("id IN ? AND user_id = ?", input.UploadIDs, userID).Find(&uploads)
+		found := make(map[uint]models.Upload, len(uploads))
+		for _, u := range uploads {
+			found[u.ID] = u
+		}
+		for _, id := range input.UploadIDs {
+			u, ok := found[id]
+			if !ok {
+				f, ok := kept[id]
+				if !ok {
+					return nil, ErrInvalidUpload
+				}
+				files = append(files, f)
+				continue
+			}
+			f := storedFile{UploadID: u.ID, Filename: u.OriginalName, MimeType: u.MimeType, Size: u.Size, Path: u.Path}
+			if s.storage != nil {
+				f.Path = fmt.Sprintf("submissions/%d/%d/%s", assignment.ID, enrollment.ID, path.Base(u.Path))
+				moves = append(moves, move{upload: u, to: f.Path})
+			}
+			files = append(files, f)
+		}
+	}
+	filesJSON, _ := json.Marshal(files)
+
+	submission := pending
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if hasPending {
+			submission.Text = text
+			submission.Files = filesJSON
+			submission.SubmittedAt = now
+			return tx.Model(&submission).Updates(map[string]interface{}{
+				"text":         submission.Text,
+				"files":        submission.Files,
+				"submitted_at": now,
+			}).Error
+		}
+		submission = models.AssignmentSubmission{
+			TenantID:     assignment.TenantID,
+			AssignmentID: assignment.ID,
+			EnrollmentID: enrollment.ID,
+			Text:         text,
+			Files:        filesJSON,
+			Status:       models.SubmissionPending,
+			SubmittedAt:  now,
+		}
+		return tx.Create(&submission).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// An upload's row goes once its file has moved, so resubmitting after a
+	// failed move picks it up again
+	for _, m := range moves {
+		if err := s.public.MoveToPrivate(ctx, m.upload.Path, m.to); err != nil {
+			log.Printf("[assignments] Failed to move upload %d into submission %d: %v", m.upload.ID, submission.ID, err)
+			return nil, err
+		}
+		s.db.Delete(&m.upload)
+	}
+
+	if !hasPending {
+		s.markStarted(enrollment, assignment.LessonID, now)
+	}
+	events.Emit(events.CourseAssignmentSubmitted, submission)
+	return &submission, nil
+}
+
+// markStarted puts the assignment's lesson in progress on first submission.
+func (s *AssignmentService) markStarted(enrollment *models.CourseEnrollment, lessonID uint, now time.Time) {
+	var progress models.LessonProgress
+	if s.db.Where("enrollment_id = ? AND lesson_id = ?", enrollment.ID, lessonID).First(&progress).Error == nil {
+		return
+	}
+	s.db.Create(&models.LessonProgress{
+		TenantID:     enrollment.TenantID,
+		EnrollmentID: enrollment.ID,
+		LessonID:     lessonID,
+		Status:       models.ProgressInProgress,
+		StartedAt:    &now,
+	})
+}
+
+// CanReview reports whether the user may review submissions for the course:
+// its instructor, or an admin.
+func CanReview(user *models.User, course *models.Course) bool {
+	if models.IsAdminRole(user.Role) {
+		return true
+	}
+	return course.InstructorID != nil && *course.InstructorID == user.ID
+}
+
+// ReviewQueue lists submissions the user may review, oldest first, filtered
+// by status and course.
+func (s *AssignmentService) ReviewQueue(user *models.User, status string, courseID uint, page, pageSize int) ([]models.AssignmentSubmission, int64) {
+	query := s.db.Model(&models.AssignmentSubmission{}).
+		Joins("JOIN assignments ON assignments.id = assignment_submissions.assignment_id").
+		Joins("JOIN course_enrollments ON course_enrollments.id = assignment_submissions.enrollment_id").
+		Joins("JOIN courses ON courses.id = course_enrollments.course_id")
+	if !models.IsAdminRole(user.Role) {
+		query = query.Where("courses.instructor_id = ?", user.ID)
+	}
+	if status != "" {
+		query = query.Where("assignment_submissions.status = ?", status)
+	}
+	if courseID > 0 {
+		query = query.Where("courses.id = ?", courseID)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var submissions []models.AssignmentSubmission
+	query.Preload("Assignment").Preload("Enrollment.Contact").Preload("Enrollment.Course").
+		Order("assignment_submissions.submitted_at ASC").
+		Offset((page - 1) * pageSize).Limit(pageSize).
+		Find(&submissions)
+	return submissions, total
+}
+
+// LoadForReview loads a submission with its assignment, student and course,
+// provided the user may review it.
+func (s *AssignmentService) LoadForReview(user *models.User, submissionID uint) (*models.AssignmentSubmission, error) {
+	var submission models.AssignmentSubmission
+	if err := s.db.Preload("Assignment").Preload("Enrollment.Contact").Preload("Enrollment.Course").Preload("GradedBy").
+		First(&submission, submissionID).Error; err != nil {
+		return nil, err
+	}
+	if !CanReview(user, &submission.Enrollment.Course) {
+		return nil, ErrNotInstructor
+	}
+	return &submission, nil
+}
+
+// Grade scores a submission against the assignment's rubric (or MaxPoints)
+// and records the feedback. Passing the lesson's required assignments
+// completes the lesson. Graded submissions may be regraded.
+func (s *AssignmentService) Grade(submission *models.AssignmentSubmission, graderID uint, input GradeInput, now time.Time) error {
+	assignment := &submission.Assignment
+	rubric := ParseRubric(assignment)
+
+	var score float64
+	var scores []RubricScore
+	if len(rubric) > 0 {
+		awarded := make(map[string]RubricScore, len(input.Scores))
+		for _, sc := range input.Scores {
+			awarded[strings.ToLower(strings.TrimSpace(sc.Criterion))] = sc
+		}
+		var earned, total float64
+		for _, criterion := range rubric {
+			sc, ok := awarded[strings.ToLower(strings.TrimSpace(criterion.Criterion))]
+			if !ok {
+				return ErrInvalidGrade
+			}
+			sc.Criterion = criterion.Criterion
+			sc.Points = math.Max(0, math.Min(sc.Points, float64(criterion.Points)))
+			earned += sc.Points
+			total += float64(criterion.Points)
+			scores = append(scores, sc)
+		}
+		if total > 0 {
+			score = earned / total * 100
+		}
+	} else {
+		if input.Points == nil || assignment.MaxPoints <= 0 {
+			return ErrInvalidGrade
+		}
+		points := math.Max(0, math.Min(*input.Points, float64(assignment.MaxPoints)))
+		score = points / float64(assignment.MaxPoints) * 100
+	}
+	scoresJSON, _ := json.Marshal(scores)
+
+	submission.Status = models.SubmissionGraded
+	submission.Score = math.Round(score*100) / 100
+	submission.Passed = submission.Score >= float64(assignment.PassingScore)
+	submission.RubricScores = scoresJSON
+	submission.Feedback = strings.TrimSpace(input.Feedback)
+	submission.GradedByID = &graderID
+	submission.GradedAt = &now
+	if err := s.db.Model(submission).Updates(map[string]interface{}{
+		"status":        submission.Status,
+		"score":         submission.Score,
+		"passed":        submission.Passed,
+		"rubric_scores": submission.RubricScores,
+		"feedback":      submission.Feedback,
+		"graded_by_id":  graderID,
+		"graded_at":     now,
+	}).Error; err != nil {
+		return err
+	}
+
+	courses := NewCourseService(s.db)
+	lessonIDs := []uint{assignment.LessonID}
+	if submission.Passed {
+		if courses.requiredAssignmentsPassed(submission.EnrollmentID, lessonIDs) &&
+			courses.requiredQuizzesPassed(submission.EnrollmentID, lessonIDs) {
+			courses.CompleteLesson(submission.EnrollmentID, assignment.LessonID, false)
+		}
+	} else if assignment.Required && !courses.requiredAssignmentsPassed(submission.EnrollmentID, lessonIDs) {
+		// Regraded to failing with no other passing submission
+		if err := courses.reopenLesson(submission.EnrollmentID, assignment.LessonID); err != nil {
+			return err
+		}
+	}
+
+	events.Emit(events.CourseAssignmentGraded, *submission)
+	return nil
+}
+
+// reopenLesson puts a completed lesson back in progress, e.g. when the
+// assignment that completed it is regraded to failing.
+func (s *CourseService) reopenLesson(enrollmentID, lessonID uint) error {
+	res := s.db.Model(&models.LessonProgress{}).
+		Where("enrollment_id = ? AND lesson_id = ? AND status = ?", enrollmentID, lessonID, models.ProgressCompleted).
+		Updates(map[string]interface{}{"status": models.ProgressInProgress, "completed_at": nil})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		s.RecalculateProgress(enrollmentID)
+	}
+	return nil
+}
+
+// SubmittedEmailData builds the template data for the instructor's new
+// submission email.
+func SubmittedEmailData(submission *models.AssignmentSubmission, appName string) map[string]interface{} {
+	contact := submission.Enrollment.Contact
+	return map[string]interface{}{
+		"AppName":         appName,
+		"Year":            time.Now().Year(),
+		"StudentName":     strings.TrimSpace(contact.FirstName + " " + contact.LastName),
+		"StudentEmail":    contact.Email,
+		"AssignmentTitle": submission.Assignment.Title,
+		"CourseTitle":     submission.Enrollment.Course.Title,
+	}
+}
+
+// GradedEmailData builds the template data for the student's graded
+// submission email.
+func GradedEmailData(submission *models.AssignmentSubmission, appName, webURL string) map[string]interface{} {
+	return map[string]interface{}{
+		"AppName":         appName,
+		"Year":            time.Now().Year(),
+		"FirstName":       submission.Enrollment.Contact.FirstName,
+		"AssignmentTitle": submission.Assignment.Title,
+		"CourseTitle":     submission.Enrollment.Course.Title,
+		"Score":           submission.Score,
+		"Passed":          submission.Passed,
+		"Feedback":        submission.Feedback,
+		"ActionURL":       strings.TrimRight(webURL, "/") + "/learn/" + submission.Enrollment.Course.Slug,
+	}
+}
@@ -0,0 +1,402 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+	"github.com/go-pdf/fpdf"
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/storage"
+)
+
+// CertificateField positions one element on a certificate template.
+// Coordinates and sizes are in millimetres from the top-left of the page.
+type CertificateField struct {
+	Type     string  `json:"type"`
+	Text     string  `json:"text,omitempty"` // static text, or a label before the field's value
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Width    float64 `json:"width"`  // 0 = to the right edge
+	Height   float64 `json:"height"` // signature and QR code
+	FontSize float64 `json:"font_size"`
+	Style    string  `json:"style"` // "", B, I or BI
+	Color    string  `json:"color"` // hex, e.g. #1a1a2e
+	Align    string  `json:"align"` // L, C or R
+	Format   string  `json:"format,omitempty"`
+}
+
+// defaultCertificateFields is the layout used when no template is set,
+// on a landscape A4 page.
+var defaultCertificateFields = []CertificateField{
+	{Type: models.CertFieldText, Text: "Certificate of Completion", Y: 40, FontSize: 32, Style: "B", Align: "C"},
+	{Type: models.CertFieldText, Text: "This certifies that", Y: 70, FontSize: 14, Align: "C"},
+	{Type: models.CertFieldStudentName, Y: 82, FontSize: 28, Style: "B", Align: "C"},
+	{Type: models.CertFieldText, Text: "has successfully completed", Y: 104, FontSize: 14, Align: "C"},
+	{Type: models.CertFieldCourseTitle, Y: 116, FontSize: 22, Style: "B", Align: "C"},
+	{Type: models.CertFieldDate, X: 40, Y: 158, Width: 80, FontSize: 12, Align: "C"},
+	{Type: models.CertFieldSignature, X: 190, Y: 136, Width: 50, Height: 20},
+	{Type: models.CertFieldInstructor, X: 175, Y: 158, Width: 80, FontSize: 12, Align: "C"},
+	{Type: models.CertFieldNumber, Text: "Certificate no. ", X: 20, Y: 190, Width: 150, FontSize: 9, Align: "L"},
+	{Type: models.CertFieldQRCode, X: 257, Y: 170, Width: 25, Height: 25},
+}
+
+// CertificateService renders certificates to PDF and stores them in the
+// private bucket; they are only served through the API.
+type CertificateService struct {
+	db      *gorm.DB
+	storage *storage.Storage
+	assets  *storage.Storage // template fonts and images, uploaded through the media library
+	webURL  string
+}
+
+// NewCertificateService creates a certificate service. webURL is the public
+// site, used for the verification link in the QR code.
+func NewCertificateService(db *gorm.DB, store *storage.Storage, webURL string) *CertificateService {
+	s := &CertificateService{db: db, assets: store, webURL: webURL}
+	if store != nil {
+		s.storage = store.Private()
+	}
+	return s
+}
+
+// VerifyURL is the public verification page for a certificate number.
+func (s *CertificateService) VerifyURL(number string) string {
+	return strings.TrimRight(s.webURL, "/") + "/certificates/verify/" + number
+}
+
+// Template returns the template a certificate is rendered with: its own,
+// then the course's (or learning path's), then the tenant default. Nil means
+// the built-in layout.
+func (s *CertificateService) Template(cert *models.Certificate) *models.CertificateTemplate {
+	var tmpl models.CertificateTemplate
+	if cert.TemplateID != nil && s.db.First(&tmpl, *cert.TemplateID).Error == nil {
+		return &tmpl
+	}
+	if cert.LearningPath != nil {
+		if cert.LearningPath.CertificateTemplateID != nil && s.db.First(&tmpl, *cert.LearningPath.CertificateTemplateID).Error == nil {
+			return &tmpl
+		}
+	} else if cert.Course.CertificateTemplateID != nil && s.db.First(&tmpl, *cert.Course.CertificateTemplateID).Error == nil {
+		return &tmpl
+	}
+	if s.db.Where("tenant_id = ? AND is_default = ?", cert.TenantID, true).First(&tmpl).Error == nil {
+		return &tmpl
+	}
+	return nil
+}
+
+func (s *CertificateService) load(certID uint) (*models.Certificate, error) {
+	var cert models.Certificate
+	err := s.db.Preload("Contact").Preload("Course.Instructor").Preload("LearningPath").First(&cert, certID).Error
+	if err != nil {
+		return nil, fmt.Errorf("certificate not found: %w", err)
+	}
+	return &cert, nil
+}
+
+// Issue renders the certificate and stores the PDF the first time it is
+// called; without storage it is rendered on demand by PDF.
+func (s *CertificateService) Issue(ctx context.Context, certID uint) (*models.Certificate, error) {
+	cert, err := s.load(certID)
+	if err != nil {
+		return nil, err
+	}
+	if cert.StorageKey != "" || s.storage == nil {
+		return cert, nil
+	}
+
+	pdf, err := s.Render(ctx, cert, s.Template(cert))
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("certificates/%d/%s.pdf", cert.TenantID, cert.CertificateNumber)
+	if err := s.storage.Upload(ctx, key, bytes.NewReader(pdf), "application/pdf"); err != nil {
+		return nil, fmt.Errorf("uploading certificate: %w", err)
+	}
+	cert.StorageKey = key
+	s.db.Model(&models.Certificate{}).Where("id = ?", cert.ID).Update("storage_key", key)
+	return cert, nil
+}
+
+// Reissue drops the stored PDF so the certificate is rendered again, e.g.
+// after its template changed.
+func (s *CertificateService) Reissue(ctx context.Context, certID uint) (*models.Certificate, error) {
+	if err := s.db.Model(&models.Certificate{}).Where("id = ?", certID).Update("storage_key", "").Error; err != nil {
+		return nil, err
+	}
+	return s.Issue(ctx, certID)
+}
+
+// PDF returns the certificate document, from storage when available and
+// rendered on the fly otherwise.
+func (s *CertificateService) PDF(ctx context.Context, cert *models.Certificate) ([]byte, error) {
+	if cert.StorageKey != "" && s.storage != nil {
+		reader, err := s.storage.Download(ctx, cert.StorageKey)
+		if err == nil {
+			defer reader.Close()
+			return io.ReadAll(reader)
+		}
+	}
+	full, err := s.load(cert.ID)
+	if err != nil {
+		return nil, err
+	}
+	return s.Render(ctx, full, s.Template(full))
+}
+
+// Preview renders a template with sample data.
+func (s *CertificateService) Preview(ctx context.Context, tmpl *models.CertificateTemplate) ([]byte, error) {
+	cert := &models.Certificate{
+		TenantID:          tmpl.TenantID,
+		CertificateNumber: "CERT-PREVIEW",
+		IssuedAt:          time.Now(),
+		Contact:           models.Contact{FirstName: "Jane", LastName: "Doe"},
+		Course: models.Course{
+			Title:      "Sample Course",
+			Instructor: &models.User{FirstName: "Alex", LastName: "Smith"},
+		},
+	}
+	return s.Render(ctx, cert, tmpl)
+}
+
+// Render draws the certificate with the template (nil = built-in layout).
+func (s *CertificateService) Render(ctx context.Context, cert *models.Certificate, tmpl *models.CertificateTemplate) ([]byte, error) {
+	orientation, size, family := "L", "A4", "Helvetica"
+	fields := defaultCertificateFields
+	if tmpl != nil {
+		if tmpl.Orientation == "P" {
+			orientation = "P"
+		}
+		if tmpl.PageSize == "Letter" {
+			size = "Letter"
+		}
+		if tmpl.FontFamily != "" {
+			family = tmpl.FontFamily
+		}
+		if len(tmpl.Fields) > 0 {
+			var custom []CertificateField
+			if err := json.Unmarshal(tmpl.Fields, &custom); err != nil {
+				return nil, fmt.Errorf("invalid template fields: %w", err)
+			}
+			fields = custom
+		}
+	}
+
+	pdf := fpdf.New(orientation, "mm", size, "")
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddPage()
+	pageW, pageH := pdf.GetPageSize()
+
+	// Text in built-in fonts goes through cp1252; a custom TTF takes UTF-8.
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	builtin := true
+	if tmpl != nil && tmpl.FontKey != "" {
+		font, err := s.asset(ctx, tmpl.FontKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading template font: %w", err)
+		}
+		for _, style := range []string{"", "B", "I", "BI"} {
+			pdf.AddUTF8FontFromBytes(family, style, font)
+		}
+		tr = func(s string) string { return s }
+		builtin = false
+	}
+	if builtin && !isCoreFont(family) {
+		family = "Helvetica"
+	}
+
+	if tmpl != nil && tmpl.BackgroundKey != "" {
+		img, err := s.asset(ctx, tmpl.BackgroundKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading template background: %w", err)
+		}
+		opts := fpdf.ImageOptions{ImageType: imageType(tmpl.BackgroundKey)}
+		pdf.RegisterImageOptionsReader("background", opts, bytes.NewReader(img))
+		pdf.ImageOptions("background", 0, 0, pageW, pageH, false, opts, 0, "")
+	}
+
+	for i, field := range fields {
+		width := field.Width
+		if width <= 0 {
+			width = pageW - field.X
+		}
+
+		switch field.Type {
+		case models.CertFieldQRCode:
+			img, err := qrCode(s.VerifyURL(cert.CertificateNumber))
+			if err != nil {
+				return nil, err
+			}
+			name := fmt.Sprintf("qr-%d", i)
+			opts := fpdf.ImageOptions{ImageType: "PNG"}
+			pdf.RegisterImageOptionsReader(name, opts, bytes.NewReader(img))
+			height := field.Height
+			if height <= 0 {
+				height = width
+			}
+			pdf.ImageOptions(name, field.X, field.Y, width, height, false, opts, 0, s.VerifyURL(cert.CertificateNumber))
+			continue
+		case models.CertFieldSignature:
+			if tmpl == nil || tmpl.SignatureKey == "" {
+				continue
+			}
+			img, err := s.asset(ctx, tmpl.SignatureKey)
+			if err != nil {
+				return nil, fmt.Errorf("loading template signature: %w", err)
+			}
+			name := fmt.Sprintf("signature-%d", i)
+			opts := fpdf.ImageOptions{ImageType: imageType(tmpl.SignatureKey)}
+			pdf.RegisterImageOptionsReader(name, opts, bytes.NewReader(img))
+			pdf.ImageOptions(name, field.X, field.Y, width, field.Height, false, opts, 0, "")
+			continue
+		}
+
+		text := certificateFieldText(field, cert)
+		if text == "" {
+			continue
+		}
+		fontSize := field.FontSize
+		if fontSize <= 0 {
+			fontSize = 12
+		}
+		align := field.Align
+		if align == "" {
+			align = "L"
+		}
+		r, g, b := hexColor(field.Color)
+		pdf.SetTextColor(r, g, b)
+		pdf.SetFont(family, field.Style, fontSize)
+		pdf.SetXY(field.X, field.Y)
+		pdf.CellFormat(width, fontSize*0.45, tr(text), "", 0, align, false, 0, "")
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("rendering certificate: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// certificateFieldText returns what a text field prints: the static text
+// for CertFieldText, otherwise the label followed by the value.
+func certificateFieldText(field CertificateField, cert *models.Certificate) string {
+	var value string
+	switch field.Type {
+	case models.CertFieldText:
+		return field.Text
+	case models.CertFieldStudentName:
+		value = strings.TrimSpace(cert.Contact.FirstName + " " + cert.Contact.LastName)
+		if value == "" {
+			value = cert.Contact.Email
+		}
+	case models.CertFieldCourseTitle:
+		value = cert.Title()
+	case models.CertFieldDate:
+		layout := field.Format
+		if layout == "" {
+			layout = "2 January 2006"
+		}
+		value = cert.IssuedAt.Format(layout)
+	case models.CertFieldInstructor:
+		if cert.Course.Instructor != nil {
+			value = strings.TrimSpace(cert.Course.Instructor.FirstName + " " + cert.Course.Instructor.LastName)
+		}
+	case models.CertFieldNumber:
+		value = cert.CertificateNumber
+	}
+	if value == "" {
+		return ""
+	}
+	return field.Text + value
+}
+
+// asset reads a template image or font. They are uploaded through the
+// media library, so they live in the public bucket.
+func (s *CertificateService) asset(ctx context.Context, key string) ([]byte, error) {
+	if s.assets == nil {
+		return nil, fmt.Errorf("storage not configured")
+	}
+	reader, err := s.assets.Download(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func qrCode(content string) ([]byte, error) {
+	code, err := qr.Encode(content, qr.M, qr.Auto)
+	if err != nil {
+		return nil, fmt.Errorf("encoding QR code: %w", err)
+	}
+	code, err = barcode.Scale(code, 300, 300)
+	if err != nil {
+		return nil, fmt.Errorf("scaling QR code: %w", err)
+	}
+	// fpdf only reads 8-bit PNGs; the barcode image is 16-bit gray
+	gray := image.NewGray(code.Bounds())
+	draw.Draw(gray, gray.Bounds(), code, code.Bounds().Min, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, gray); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func imageType(key string) string {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".png":
+		return "PNG"
+	case ".gif":
+		return "GIF"
+	}
+	return "JPG"
+}
+
+func isCoreFont(family string) bool {
+	switch strings.ToLower(family) {
+	case "helvetica", "arial", "times", "courier":
+		return true
+	}
+	return false
+}
+
+// hexColor parses #rrggbb, defaulting to near-black.
+func hexColor(hex string) (int, int, int) {
+	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) != 6 {
+		return 26, 26, 46
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 26, 26, 46
+	}
+	return int(v >> 16 & 0xff), int(v >> 8 & 0xff), int(v & 0xff)
+}
+
+// CertificateEmailData builds the template data for the certificate email.
+func CertificateEmailData(cert *models.Certificate, appName, verifyURL string) map[string]interface{} {
+	return map[string]interface{}{
+		"AppName":           appName,
+		"Year":              time.Now().Year(),
+		"FirstName":         cert.Contact.FirstName,
+		"CourseTitle":       cert.Title(),
+		"CertificateNumber": cert.CertificateNumber,
+		"ActionURL":         verifyURL,
+	}
+}
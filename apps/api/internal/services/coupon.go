@@ -0,0 +1,519 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"gritcms/apps/api/internal/models"
+)
+
+// CouponError explains why a coupon code cannot be used. Handlers return the
+// message to the customer as-is.
+type CouponError struct {
+	Code   string
+	Reason string
+}
+
+func (e *CouponError) Error() string { return e.Reason }
+
+// DiscountQuote is the result of applying coupon codes and automatic
+// discounts to a cart.
+type DiscountQuote struct {
+	Subtotal  float64                `json:"subtotal"`
+	Discount  float64                `json:"discount"`
+	Total     float64                `json:"total"`
+	TrialDays int                    `json:"trial_days"` // extra trial days from free_trial coupons
+	Discounts []models.OrderDiscount `json:"discounts"`
+	// CouponID is the first code-based coupon, kept on Order.CouponID.
+	CouponID *uint `json:"coupon_id"`
+}
+
+// CouponService validates coupons, prices carts and generates bulk codes.
+type CouponService struct {
+	db *gorm.DB
+}
+
+func NewCouponService(db *gorm.DB) *CouponService {
+	return &CouponService{db: db}
+}
+
+type discountCandidate struct {
+	coupon    models.Coupon
+	automatic bool
+	amount    float64
+	trialDays int
+}
+
+// Quote applies the given codes plus any automatic discounts to the cart
+// items (UnitPrice/Total in minor units of currency). contactID may be 0 for
+// anonymous shoppers, in which case per-customer rules are not checked.
+// Fixed coupon amounts and minimum order amounts are in the tenant base
+// currency and converted to the cart currency.
+//
+// Stacking rules: several codes can only be combined when all of them are
+// stackable. Automatic discounts are tried highest Priority first and join
+// the selection when it is empty or when both they and everything already
+// selected are stackable. Codes entered by the customer take precedence.
+func (s *CouponService) Quote(tenantID, contactID uint, currency string, items []models.OrderItem, codes []string) (*DiscountQuote, error) {
+	var subtotal float64
+	for _, item := range items {
+		subtotal += item.Total
+	}
+	quote := &DiscountQuote{Subtotal: subtotal, Total: subtotal}
+
+	var selected []discountCandidate
+	seen := map[uint]bool{}
+	for _, raw := range codes {
+		code := strings.ToUpper(strings.TrimSpace(raw))
+		if code == "" {
+			continue
+		}
+		var coupon models.Coupon
+		if err := s.db.Where("code = ? AND tenant_id = ? AND status = ?", code, tenantID, models.CouponStatusActive).First(&coupon).Error; err != nil {
+			return nil, &CouponError{Code: code, Reason: "Invalid coupon code"}
+		}
+		if seen[coupon.ID] {
+			continue
+		}
+		cand, err := s.evaluate(coupon, contactID, currency, items, subtotal)
+		if err != nil {
+			return nil, err
+		}
+		seen[coupon.ID] = true
+		selected = append(selected, *cand)
+	}
+	if len(selected) > 1 {
+		for _, cand := range selected {
+			if !cand.coupon.Stackable {
+				return nil, &CouponError{Code: cand.coupon.Code, Reason: fmt.Sprintf("Coupon %s cannot be combined with other coupons", cand.coupon.Code)}
+			}
+		}
+	}
+
+	var automatic []models.Coupon
+	s.db.Where("tenant_id = ? AND automatic = ? AND status = ?", tenantID, true, models.CouponStatusActive).
+		Order("priority DESC, id ASC").Find(&automatic)
+	for _, coupon := range automatic {
+		if seen[coupon.ID] {
+			continue
+		}
+		if len(selected) > 0 && (!coupon.Stackable || !allStackable(selected)) {
+			continue
+		}
+		cand, err := s.evaluate(coupon, contactID, currency, items, subtotal)
+		if err != nil {
+			continue
+		}
+		cand.automatic = true
+		seen[coupon.ID] = true
+		selected = append(selected, *cand)
+	}
+
+	// Apply in order, never discounting below zero.
+	remaining := subtotal
+	for _, cand := range selected {
+		amount := cand.amount
+		if amount > remaining {
+			amount = remaining
+		}
+		amount = roundMoney(amount)
+		remaining = roundMoney(remaining - amount)
+
+		quote.Discount += amount
+		quote.TrialDays += cand.trialDays
+		quote.Discounts = append(quote.Discounts, models.OrderDiscount{
+			TenantID:  tenantID,
+			CouponID:  cand.coupon.ID,
+			ContactID: contactID,
+			Code:      cand.coupon.Code,
+			Name:      cand.coupon.Name,
+			Type:      cand.coupon.Type,
+			Automatic: cand.automatic,
+			Amount:    amount,
+			TrialDays: cand.trialDays,
+		})
+		if !cand.automatic && quote.CouponID == nil {
+			id := cand.coupon.ID
+			quote.CouponID = &id
+		}
+	}
+	quote.Discount = roundMoney(quote.Discount)
+	quote.Total = roundMoney(subtotal - quote.Discount)
+	return quote, nil
+}
+
+// fromBase converts a coupon amount from the base currency to the cart
+// currency. Without a rate the amount is used as-is.
+func (s *CouponService) fromBase(tenantID uint, amount float64, currency string) float64 {
+	if amount == 0 || currency == "" {
+		return amount
+	}
+	fx := NewCurrencyService(s.db)
+	converted, err := fx.Convert(tenantID, amount, fx.BaseCurrency(tenantID), currency)
+	if err != nil {
+		return amount
+	}
+	return converted
+}
+
+func allStackable(cands []discountCandidate) bool {
+	for _, c := range cands {
+		if !c.coupon.Stackable {
+			return false
+		}
+	}
+	return true
+}
+
+// Check validates a coupon's availability rules (dates, usage limits, minimum
+// order, first purchase) without looking at the cart contents.
+func (s *CouponService) Check(coupon models.Coupon, contactID uint, currency string, subtotal float64) error {
+	fail := func(reason string) error { return &CouponError{Code: coupon.Code, Reason: reason} }
+
+	if coupon.Status != models.CouponStatusActive {
+		return fail("Invalid coupon code")
+	}
+	now := time.Now()
+	if coupon.ValidFrom != nil && now.Before(*coupon.ValidFrom) {
+		return fail("Coupon is not yet valid")
+	}
+	if coupon.ValidUntil != nil && now.After(*coupon.ValidUntil) {
+		return fail("Coupon has expired")
+	}
+	if coupon.MaxUses > 0 && coupon.UsedCount >= coupon.MaxUses {
+		return fail("Coupon usage limit reached")
+	}
+	if subtotal < s.fromBase(coupon.TenantID, coupon.MinOrderAmount, currency) {
+		return fail("Order total is below the coupon minimum")
+	}
+	if contactID == 0 {
+		return nil
+	}
+
+	if coupon.MaxUsesPerCustomer > 0 {
+		var used int64
+		s.db.Model(&models.OrderDiscount{}).
+			Where("coupon_id = ? AND contact_id = ? AND redeemed_at IS NOT NULL", coupon.ID, contactID).
+			Count(&used)
+		if int(used) >= coupon.MaxUsesPerCustomer {
+			return fail("You have already used this coupon")
+		}
+	}
+	if coupon.FirstPurchaseOnly {
+		var paid int64
+		s.db.Model(&models.Order{}).Where("contact_id = ? AND paid_at IS NOT NULL", contactID).Count(&paid)
+		if paid > 0 {
+			return fail("Coupon is only valid on your first purchase")
+		}
+	}
+	return nil
+}
+
+// evaluate checks a coupon and computes its discount for the cart.
+func (s *CouponService) evaluate(coupon models.Coupon, contactID uint, currency string, items []models.OrderItem, subtotal float64) (*discountCandidate, error) {
+	if err := s.Check(coupon, contactID, currency, subtotal); err != nil {
+		return nil, err
+	}
+	fail := func(reason string) error { return &CouponError{Code: coupon.Code, Reason: reason} }
+
+	eligible := filterItems(items, parseIDs(coupon.ProductIDs))
+	var eligibleTotal float64
+	for _, item := range eligible {
+		eligibleTotal += item.Total
+	}
+
+	cand := &discountCandidate{coupon: coupon}
+	switch coupon.Type {
+	case models.CouponTypePercentage:
+		cand.amount = eligibleTotal * (coupon.Amount / 100)
+	case models.CouponTypeFixed:
+		cand.amount = s.fromBase(coupon.TenantID, coupon.Amount, currency)
+		if cand.amount > eligibleTotal {
+			cand.amount = eligibleTotal
+		}
+	case models.CouponTypeBuyXGetY:
+		cand.amount = buyXGetYDiscount(coupon, items)
+	case models.CouponTypeFreeTrial:
+		if coupon.TrialDays <= 0 || !s.hasSubscriptionPrice(eligible) {
+			return nil, fail("Coupon only applies to subscriptions")
+		}
+		cand.trialDays = coupon.TrialDays
+		return cand, nil
+	default:
+		return nil, fail("Invalid coupon code")
+	}
+
+	if cand.amount <= 0 {
+		return nil, fail("Coupon does not apply to the items in your cart")
+	}
+	return cand, nil
+}
+
+func (s *CouponService) hasSubscriptionPrice(items []models.OrderItem) bool {
+	var priceIDs []uint
+	for _, item := range items {
+		if item.PriceID != nil {
+			priceIDs = append(priceIDs, *item.PriceID)
+		}
+	}
+	if len(priceIDs) == 0 {
+		return false
+	}
+	var count int64
+	s.db.Model(&models.Price{}).Where("id IN ? AND type = ?", priceIDs, models.PriceTypeSubscription).Count(&count)
+	return count > 0
+}
+
+// buyXGetYDiscount discounts the cheapest "get" units: for every BuyQuantity
+// units of ProductIDs bought, GetQuantity units of GetProductIDs are
+// discounted by Amount percent (free when Amount is 0 or 100). When both
+// lists are the same pool, the discounted units come out of the same set.
+func buyXGetYDiscount(coupon models.Coupon, items []models.OrderItem) float64 {
+	if coupon.BuyQuantity <= 0 || coupon.GetQuantity <= 0 {
+		return 0
+	}
+	buyIDs := parseIDs(coupon.ProductIDs)
+	getIDs := parseIDs(coupon.GetProductIDs)
+	samePool := len(getIDs) == 0
+	if samePool {
+		getIDs = buyIDs
+	}
+
+	var unitPrices []float64
+	for _, item := range filterItems(items, getIDs) {
+		for i := 0; i < item.Quantity; i++ {
+			unitPrices = append(unitPrices, item.UnitPrice)
+		}
+	}
+
+	var free int
+	if samePool {
+		free = (len(unitPrices) / (coupon.BuyQuantity + coupon.GetQuantity)) * coupon.GetQuantity
+	} else {
+		var bought int
+		for _, item := range filterItems(items, buyIDs) {
+			bought += item.Quantity
+		}
+		free = (bought / coupon.BuyQuantity) * coupon.GetQuantity
+	}
+	if free > len(unitPrices) {
+		free = len(unitPrices)
+	}
+
+	sort.Float64s(unitPrices)
+	percent := coupon.Amount
+	if percent <= 0 || percent > 100 {
+		percent = 100
+	}
+	var discount float64
+	for _, price := range unitPrices[:free] {
+		discount += price * percent / 100
+	}
+	return discount
+}
+
+// filterItems returns the items whose product is in ids (all items when ids is empty).
+func filterItems(items []models.OrderItem, ids []uint) []models.OrderItem {
+	if len(ids) == 0 {
+		return items
+	}
+	allowed := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		allowed[id] = true
+	}
+	var out []models.OrderItem
+	for _, item := range items {
+		if item.ProductID != nil && allowed[*item.ProductID] {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func parseIDs(raw datatypes.JSON) []uint {
+	var ids []uint
+	if len(raw) > 0 {
+		json.Unmarshal(raw, &ids)
+	}
+	return ids
+}
+
+// ErrCouponExhausted is returned at checkout when a coupon has no uses
+// left once paid uses and the uses held by unpaid checkouts are counted.
+var ErrCouponExhausted = errors.New("coupon has reached its usage limit")
+
+// couponHold is how long an unpaid checkout holds its coupon uses.
+const couponHold = time.Hour
+
+// ReserveCoupons checks, with each coupon locked, that the discounts' coupons
+// still have a use left. Call it in the transaction that writes the
+// discounts to a pending order, which then holds the uses until couponHold
+// passes.
+func ReserveCoupons(tx *gorm.DB, discounts []models.OrderDiscount, now time.Time) error {
+	seen := map[uint]bool{}
+	for _, d := range discounts {
+		if seen[d.CouponID] {
+			continue
+		}
+		seen[d.CouponID] = true
+
+		var coupon models.Coupon
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&coupon, d.CouponID).Error; err != nil {
+			return err
+		}
+		if coupon.MaxUses == 0 {
+			continue
+		}
+		var held int64
+		if err := tx.Model(&models.OrderDiscount{}).
+			Joins("JOIN orders ON orders.id = order_discounts.order_id").
+			Where("order_discounts.coupon_id = ? AND order_discounts.redeemed_at IS NULL AND orders.status = ? AND orders.created_at > ?",
+				coupon.ID, models.OrderStatusPending, now.Add(-couponHold)).
+			Count(&held).Error; err != nil {
+			return err
+		}
+		if coupon.UsedCount+int(held) >= coupon.MaxUses {
+			return ErrCouponExhausted
+		}
+	}
+	return nil
+}
+
+// RedeemDiscounts marks an order's discounts as used and bumps the coupons'
+// UsedCount. Called by fulfillment inside its transaction once the order is
+// paid.
+func RedeemDiscounts(tx *gorm.DB, discounts []models.OrderDiscount, now time.Time) error {
+	for _, d := range discounts {
+		if d.RedeemedAt != nil {
+			continue
+		}
+		if err := tx.Model(&models.OrderDiscount{}).Where("id = ?", d.ID).Update("redeemed_at", now).Error; err != nil {
+			return err
+		}
+		if err := RedeemCoupon(tx, d.CouponID, d.OrderID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RedeemCoupon counts a paid use of a coupon. MaxUses is enforced when the
+// checkout reserves the use (ReserveCoupons); the customer has paid by now,
+// so a payment that arrives after its hold lapsed still counts, and the
+// overrun is logged.
+func RedeemCoupon(tx *gorm.DB, couponID, orderID uint) error {
+	if err := tx.Model(&models.Coupon{}).Where("id = ?", couponID).
+		UpdateColumn("used_count", gorm.Expr("used_count + 1")).Error; err != nil {
+		return err
+	}
+	var coupon models.Coupon
+	if err := tx.Select("id", "code", "max_uses", "used_count").First(&coupon, couponID).Error; err != nil {
+		return err
+	}
+	if coupon.MaxUses > 0 && coupon.UsedCount > coupon.MaxUses {
+		log.Printf("[coupon] Coupon %s used %d times, over its limit of %d, by order %d", coupon.Code, coupon.UsedCount, coupon.MaxUses, orderID)
+	}
+	return nil
+}
+
+// codeAlphabet leaves out characters that are easy to misread (0/O, 1/I/L).
+const codeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// GenerateCodes creates count single-use copies of a coupon with unique
+// random codes (prefix + length random characters). The copies share the
+// template's rules and point back to it via ParentID.
+func (s *CouponService) GenerateCodes(template models.Coupon, count int, prefix string, length int) ([]models.Coupon, error) {
+	if count < 1 || count > 10000 {
+		return nil, fmt.Errorf("count must be between 1 and 10000")
+	}
+	if length < 6 {
+		length = 8
+	}
+	prefix = strings.ToUpper(strings.TrimSpace(prefix))
+	if len(prefix)+length > 50 {
+		return nil, fmt.Errorf("prefix is too long")
+	}
+
+	// Collect unique codes first, checking against existing ones in batches.
+	codes := make(map[string]bool, count)
+	for attempts := 0; len(codes) < count; attempts++ {
+		if attempts > 10 {
+			return nil, fmt.Errorf("could not generate enough unique codes; try a longer code length")
+		}
+		batch := make([]string, 0, count-len(codes))
+		for len(batch) < count-len(codes) {
+			code, err := randomCode(prefix, length)
+			if err != nil {
+				return nil, err
+			}
+			if !codes[code] {
+				batch = append(batch, code)
+			}
+		}
+		var existing []string
+		s.db.Unscoped().Model(&models.Coupon{}).Where("code IN ?", batch).Pluck("code", &existing)
+		taken := make(map[string]bool, len(existing))
+		for _, code := range existing {
+			taken[code] = true
+		}
+		for _, code := range batch {
+			if !taken[code] {
+				codes[code] = true
+			}
+		}
+	}
+
+	parentID := template.ID
+	coupons := make([]models.Coupon, 0, count)
+	for code := range codes {
+		c := template
+		c.ID = 0
+		c.Code = code
+		c.MaxUses = 1
+		c.MaxUsesPerCustomer = 1
+		c.UsedCount = 0
+		c.Automatic = false
+		c.ParentID = &parentID
+		c.CreatedAt = time.Time{}
+		c.UpdatedAt = time.Time{}
+		coupons = append(coupons, c)
+	}
+	sort.Slice(coupons, func(i, j int) bool { return coupons[i].Code < coupons[j].Code })
+
+	if err := s.db.CreateInBatches(&coupons, 500).Error; err != nil {
+		return nil, err
+	}
+	return coupons, nil
+}
+
+func randomCode(prefix string, length int) (string, error) {
+	var b strings.Builder
+	b.WriteString(prefix)
+	max := big.NewInt(int64(len(codeAlphabet)))
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(codeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// GenerateAutomaticCode returns an internal code for a code-less automatic
+// discount, since Coupon.Code is unique and required.
+func GenerateAutomaticCode() string {
+	code, _ := randomCode("AUTO-", 10)
+	return code
+}
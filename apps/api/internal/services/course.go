@@ -0,0 +1,295 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/events"
+	"gritcms/apps/api/internal/models"
+)
+
+// Course errors.
+var (
+	ErrQuizNotPassed = errors.New("pass this lesson's quiz before completing it")
+	ErrNotEnrolled   = errors.New("not enrolled in this course")
+)
+
+// maxHeartbeatGap caps the time credited between two heartbeats, so a
+// paused or abandoned tab doesn't accumulate time.
+const maxHeartbeatGap = 60 * time.Second
+
+// Heartbeat is a periodic progress report from the lesson player.
+type Heartbeat struct {
+	ActiveSeconds   int  `json:"active_seconds"`   // time engaged since the previous beat
+	PositionSeconds *int `json:"position_seconds"` // video or audio position
+	DurationSeconds int  `json:"duration_seconds"` // media length, if the lesson doesn't know it
+}
+
+// CourseService tracks students' progress through courses: lesson
+// completion, the gates on completing a lesson or course, and certificates.
+type CourseService struct {
+	db *gorm.DB
+}
+
+// NewCourseService creates a course service.
+func NewCourseService(db *gorm.DB) *CourseService {
+	return &CourseService{db: db}
+}
+
+// StudentEnrollment finds the active or completed enrollment of the student
+// with this email in a course. Enrollments granted by a membership return
+// ErrMembershipRequired once the membership lapses.
+func (s *CourseService) StudentEnrollment(email string, courseID uint) (*models.CourseEnrollment, error) {
+	var contact models.Contact
+	if err := s.db.Where("email = ? AND tenant_id = ?", email, 1).First(&contact).Error; err != nil {
+		return nil, ErrNotEnrolled
+	}
+	var enrollment models.CourseEnrollment
+	if err := s.db.Where("contact_id = ? AND course_id = ? AND status IN ?", contact.ID, courseID,
+		[]string{models.EnrollStatusActive, models.EnrollStatusCompleted}).First(&enrollment).Error; err != nil {
+		return nil, ErrNotEnrolled
+	}
+	if err := NewAccessService(s.db).CheckEnrollment(&enrollment, time.Now()); err != nil {
+		return nil, err
+	}
+	return &enrollment, nil
+}
+
+// requiredQuizzesPassed reports whether the enrollment has a passing attempt
+// on every required quiz of the given lessons.
+func (s *CourseService) requiredQuizzesPassed(enrollmentID uint, lessonIDs []uint) bool {
+	if len(lessonIDs) == 0 {
+		return true
+	}
+	var missing int64
+	s.db.Model(&models.Quiz{}).
+		Where("lesson_id IN ? AND required = ?", lessonIDs, true).
+		Where("NOT EXISTS (SELECT 1 FROM quiz_attempts qa WHERE qa.quiz_id = quizzes.id AND qa.enrollment_id = ? AND qa.passed = ?)", enrollmentID, true).
+		Count(&missing)
+	return missing == 0
+}
+
+// CompleteLesson marks a lesson complete for an enrollment and recalculates
+// course progress. With enforce set, the lesson must have dripped and any
+// required quiz or assignment must be passed (admins skip the checks).
+func (s *CourseService) CompleteLesson(enrollmentID, lessonID uint, enforce bool) (*models.LessonProgress, error) {
+	if enforce {
+		var enrollment models.CourseEnrollment
+		if err := s.db.First(&enrollment, enrollmentID).Error; err != nil {
+			return nil, err
+		}
+		if err := s.CheckUnlocked(&enrollment, lessonID, time.Now()); err != nil {
+			return nil, err
+		}
+		if !s.requiredQuizzesPassed(enrollmentID, []uint{lessonID}) {
+			return nil, ErrQuizNotPassed
+		}
+		if !s.requiredAssignmentsPassed(enrollmentID, []uint{lessonID}) {
+			return nil, ErrAssignmentNotPassed
+		}
+	}
+
+	now := time.Now()
+	var progress models.LessonProgress
+	result := s.db.Where("enrollment_id = ? AND lesson_id = ?", enrollmentID, lessonID).First(&progress)
+
+	if result.Error == gorm.ErrRecordNotFound {
+		progress = models.LessonProgress{
+			TenantID:     1,
+			EnrollmentID: enrollmentID,
+			LessonID:     lessonID,
+			Status:       models.ProgressCompleted,
+			StartedAt:    &now,
+			CompletedAt:  &now,
+		}
+		if err := s.db.Create(&progress).Error; err != nil {
+			return nil, err
+		}
+	} else {
+		if progress.Status == models.ProgressCompleted {
+			return &progress, nil
+		}
+		progress.Status = models.ProgressCompleted
+		progress.CompletedAt = &now
+		if err := s.db.Save(&progress).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	events.Emit(events.CourseLessonCompleted, progress)
+	s.RecalculateProgress(enrollmentID)
+	return &progress, nil
+}
+
+// RecordHeartbeat records a player heartbeat: time spent, the resume
+// position and the furthest share of the video watched. Time spent and the
+// furthest point watched both advance by no more than the wall-clock time
+// since the previous beat, so skipping ahead isn't watching. Once the
+// course's AutoCompletePercent of the video is watched the lesson is
+// completed.
+func (s *CourseService) RecordHeartbeat(enrollment *models.CourseEnrollment, lessonID uint, beat Heartbeat, now time.Time) (*models.LessonProgress, error) {
+	if err := s.CheckUnlocked(enrollment, lessonID, now); err != nil {
+		return nil, err
+	}
+
+	var lesson models.Lesson
+	if err := s.db.Preload("MediaAsset").First(&lesson, lessonID).Error; err != nil {
+		return nil, ErrLessonNotFound
+	}
+	duration := lesson.DurationMinutes * 60
+	if lesson.MediaAsset != nil && lesson.MediaAsset.DurationSeconds > 0 {
+		duration = lesson.MediaAsset.DurationSeconds
+	} else if duration == 0 {
+		duration = beat.DurationSeconds
+	}
+
+	var progress models.LessonProgress
+	result := s.db.Where("enrollment_id = ? AND lesson_id = ?", enrollment.ID, lessonID).First(&progress)
+	isNew := result.Error == gorm.ErrRecordNotFound
+	if result.Error != nil && !isNew {
+		return nil, result.Error
+	}
+	if isNew {
+		progress = models.LessonProgress{
+			TenantID:     enrollment.TenantID,
+			EnrollmentID: enrollment.ID,
+			LessonID:     lessonID,
+			Status:       models.ProgressInProgress,
+			StartedAt:    &now,
+		}
+	}
+
+	// Time: what the player reports (or the distance played), capped by the
+	// real time since the previous beat
+	claimed := beat.ActiveSeconds
+	if claimed <= 0 && beat.PositionSeconds != nil {
+		claimed = *beat.PositionSeconds - progress.LastPositionSeconds
+	}
+	elapsed := maxHeartbeatGap
+	if !isNew && now.Sub(progress.UpdatedAt) < elapsed {
+		elapsed = now.Sub(progress.UpdatedAt)
+	}
+	if credit := min(claimed, int(elapsed.Seconds())); credit > 0 {
+		progress.TimeSpentSeconds += credit
+	}
+
+	if beat.PositionSeconds != nil {
+		position := max(*beat.PositionSeconds, 0)
+		progress.LastPositionSeconds = position
+		if duration > 0 {
+			watched := int(progress.VideoPercent / 100 * float64(duration))
+			reached := min(position, watched+int(elapsed.Seconds()))
+			percent := math.Min(float64(reached)/float64(duration)*100, 100)
+			if percent > progress.VideoPercent {
+				progress.VideoPercent = math.Round(percent*100) / 100
+			}
+		}
+	}
+	if progress.Status == models.ProgressNotStarted || progress.Status == "" {
+		progress.Status = models.ProgressInProgress
+		progress.StartedAt = &now
+	}
+
+	if isNew {
+		if err := s.db.Create(&progress).Error; err != nil {
+			return nil, err
+		}
+	} else if err := s.db.Model(&progress).Updates(map[string]interface{}{
+		"status":                progress.Status,
+		"started_at":            progress.StartedAt,
+		"time_spent_seconds":    progress.TimeSpentSeconds,
+		"last_position_seconds": progress.LastPositionSeconds,
+		"video_percent":         progress.VideoPercent,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	if progress.Status != models.ProgressCompleted && progress.VideoPercent > 0 {
+		var course models.Course
+		s.db.Select("id", "auto_complete_percent").First(&course, enrollment.CourseID)
+		if course.AutoCompletePercent > 0 && progress.VideoPercent >= float64(course.AutoCompletePercent) {
+			// A required quiz still has to be passed first
+			if completed, err := s.CompleteLesson(enrollment.ID, lessonID, true); err == nil {
+				return completed, nil
+			}
+		}
+	}
+	return &progress, nil
+}
+
+// RecalculateProgress updates the enrollment's progress percentage. Once
+// every lesson is complete and every required quiz and assignment in the
+// course is passed, the enrollment is completed and a certificate issued.
+// Progress in learning paths containing the course is refreshed too.
+func (s *CourseService) RecalculateProgress(enrollmentID uint) {
+	var enrollment models.CourseEnrollment
+	if err := s.db.Preload("Course.Modules.Lessons").First(&enrollment, enrollmentID).Error; err != nil {
+		return
+	}
+
+	var lessonIDs []uint
+	for _, mod := range enrollment.Course.Modules {
+		for _, l := range mod.Lessons {
+			lessonIDs = append(lessonIDs, l.ID)
+		}
+	}
+	if len(lessonIDs) == 0 {
+		return
+	}
+
+	var completedCount int64
+	s.db.Model(&models.LessonProgress{}).
+		Where("enrollment_id = ? AND lesson_id IN ? AND status = ?", enrollmentID, lessonIDs, models.ProgressCompleted).
+		Count(&completedCount)
+
+	enrollment.ProgressPercentage = float64(completedCount) / float64(len(lessonIDs)) * 100
+
+	if int(completedCount) >= len(lessonIDs) && enrollment.Status != models.EnrollStatusCompleted &&
+		s.requiredQuizzesPassed(enrollmentID, lessonIDs) && s.requiredAssignmentsPassed(enrollmentID, lessonIDs) {
+		enrollment.Status = models.EnrollStatusCompleted
+		now := time.Now()
+		enrollment.CompletedAt = &now
+
+		// Issue the certificate first; course.completed listeners render and email it
+		s.generateCertificate(enrollment)
+		events.Emit(events.CourseCompleted, enrollment)
+	}
+
+	s.db.Model(&enrollment).Updates(map[string]interface{}{
+		"progress_percentage": enrollment.ProgressPercentage,
+		"status":              enrollment.Status,
+		"completed_at":        enrollment.CompletedAt,
+	})
+	s.UpdatePathProgress(enrollment)
+}
+
+func (s *CourseService) generateCertificate(enrollment models.CourseEnrollment) {
+	// Check if certificate already exists
+	var existing models.Certificate
+	if err := s.db.Where("enrollment_id = ? AND learning_path_id IS NULL", enrollment.ID).First(&existing).Error; err == nil {
+		return
+	}
+
+	cert := models.Certificate{
+		TenantID:          enrollment.TenantID,
+		CourseID:          enrollment.CourseID,
+		EnrollmentID:      enrollment.ID,
+		ContactID:         enrollment.ContactID,
+		CertificateNumber: certificateNumber(),
+		IssuedAt:          time.Now(),
+		Template:          "default",
+		TemplateID:        enrollment.Course.CertificateTemplateID,
+	}
+	s.db.Create(&cert)
+}
+
+func certificateNumber() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("CERT-%X", b)
+}
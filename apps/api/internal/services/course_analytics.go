@@ -0,0 +1,167 @@
+package services
+
+import (
+	"math"
+	"sort"
+
+	"gritcms/apps/api/internal/models"
+)
+
+// LessonEngagement summarizes how students engage with one lesson.
+type LessonEngagement struct {
+	LessonID          uint    `json:"lesson_id"`
+	ModuleID          uint    `json:"module_id"`
+	Title             string  `json:"title"`
+	Type              string  `json:"type"`
+	Started           int64   `json:"started"`
+	Completed         int64   `json:"completed"`
+	CompletionRate    float64 `json:"completion_rate"`
+	DropOff           int64   `json:"drop_off"`      // stopped here without finishing the course
+	DropOffRate       float64 `json:"drop_off_rate"` // share of those who started the lesson
+	MedianTimeSeconds int     `json:"median_time_seconds"`
+	AvgVideoPercent   float64 `json:"avg_video_percent"`
+}
+
+// FunnelStep is one stage of the course completion funnel.
+type FunnelStep struct {
+	Step  string  `json:"step"`
+	Count int64   `json:"count"`
+	Rate  float64 `json:"rate"` // share of enrollments
+}
+
+// CourseEngagement is the per-lesson engagement and completion funnel for a
+// course.
+type CourseEngagement struct {
+	Lessons []LessonEngagement `json:"lessons"`
+	Funnel  []FunnelStep       `json:"funnel"`
+}
+
+// Engagement builds lesson-level analytics for a course, or one of its
+// cohorts: how many students started and completed each lesson, where
+// students who haven't finished stopped, the median time spent and how much
+// of the video was watched.
+func (s *CourseService) Engagement(courseID uint, cohortID *uint) (*CourseEngagement, error) {
+	course, err := s.LoadOutline(courseID)
+	if err != nil {
+		return nil, err
+	}
+
+	var enrollments []models.CourseEnrollment
+	query := s.db.Select("id", "status", "progress_percentage").Where("course_id = ?", courseID)
+	if cohortID != nil {
+		query = query.Where("cohort_id = ?", *cohortID)
+	}
+	query.Find(&enrollments)
+	finished := make(map[uint]bool, len(enrollments))
+	ids := make([]uint, 0, len(enrollments))
+	for _, e := range enrollments {
+		ids = append(ids, e.ID)
+		finished[e.ID] = e.Status == models.EnrollStatusCompleted
+	}
+
+	var progresses []models.LessonProgress
+	if len(ids) > 0 {
+		s.db.Select("enrollment_id", "lesson_id", "status", "time_spent_seconds", "video_percent").
+			Where("enrollment_id IN ?", ids).Find(&progresses)
+	}
+
+	// Position of each lesson in the outline, to find the furthest one reached
+	order := make(map[uint]int)
+	for _, mod := range course.Modules {
+		for _, lesson := range mod.Lessons {
+			order[lesson.ID] = len(order)
+		}
+	}
+
+	byLesson := make(map[uint][]models.LessonProgress)
+	furthest := make(map[uint]uint) // enrollment -> lesson
+	for _, p := range progresses {
+		pos, ok := order[p.LessonID]
+		if !ok {
+			continue
+		}
+		byLesson[p.LessonID] = append(byLesson[p.LessonID], p)
+		if last, seen := furthest[p.EnrollmentID]; !seen || pos > order[last] {
+			furthest[p.EnrollmentID] = p.LessonID
+		}
+	}
+	dropOffs := make(map[uint]int64)
+	for enrollmentID, lessonID := range furthest {
+		if !finished[enrollmentID] {
+			dropOffs[lessonID]++
+		}
+	}
+
+	result := &CourseEngagement{Lessons: []LessonEngagement{}}
+	for _, mod := range course.Modules {
+		for _, lesson := range mod.Lessons {
+			row := LessonEngagement{
+				LessonID: lesson.ID,
+				ModuleID: mod.ID,
+				Title:    lesson.Title,
+				Type:     lesson.Type,
+				DropOff:  dropOffs[lesson.ID],
+			}
+			times := make([]int, 0, len(byLesson[lesson.ID]))
+			var videoTotal float64
+			for _, p := range byLesson[lesson.ID] {
+				if p.Status == models.ProgressNotStarted {
+					continue
+				}
+				row.Started++
+				if p.Status == models.ProgressCompleted {
+					row.Completed++
+				}
+				times = append(times, p.TimeSpentSeconds)
+				videoTotal += p.VideoPercent
+			}
+			row.CompletionRate = percentOf(row.Completed, row.Started)
+			row.DropOffRate = percentOf(row.DropOff, row.Started)
+			row.MedianTimeSeconds = median(times)
+			if row.Started > 0 {
+				row.AvgVideoPercent = math.Round(videoTotal/float64(row.Started)*100) / 100
+			}
+			result.Lessons = append(result.Lessons, row)
+		}
+	}
+
+	var started, halfway, completed int64
+	for _, e := range enrollments {
+		if _, ok := furthest[e.ID]; ok || e.ProgressPercentage > 0 {
+			started++
+		}
+		if e.ProgressPercentage >= 50 {
+			halfway++
+		}
+		if e.Status == models.EnrollStatusCompleted {
+			completed++
+		}
+	}
+	total := int64(len(enrollments))
+	result.Funnel = []FunnelStep{
+		{Step: "enrolled", Count: total, Rate: percentOf(total, total)},
+		{Step: "started", Count: started, Rate: percentOf(started, total)},
+		{Step: "halfway", Count: halfway, Rate: percentOf(halfway, total)},
+		{Step: "completed", Count: completed, Rate: percentOf(completed, total)},
+	}
+	return result, nil
+}
+
+func percentOf(num, denom int64) float64 {
+	if denom == 0 {
+		return 0
+	}
+	return math.Round(float64(num)/float64(denom)*10000) / 100
+}
+
+func median(values []int) int {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Ints(values)
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"gritcms/apps/api/internal/models"
+)
+
+// Cohort errors.
+var (
+	ErrCohortNotFound = errors.New("cohort not found for this course")
+	ErrCohortClosed   = errors.New("enrollment for this cohort is closed")
+	ErrCohortFull     = errors.New("this cohort is full")
+	ErrNoOpenCohort   = errors.New("no cohort of this course is open for enrollment")
+)
+
+// CohortOpen reports whether the cohort is inside its enrollment window.
+// Without an explicit close date enrollment stays open until the cohort ends.
+func CohortOpen(cohort *models.CourseCohort, now time.Time) bool {
+	if cohort.EnrollOpensAt != nil && now.Before(*cohort.EnrollOpensAt) {
+		return false
+	}
+	if cohort.EnrollClosesAt != nil {
+		return now.Before(*cohort.EnrollClosesAt)
+	}
+	return cohort.EndsAt == nil || now.Before(*cohort.EndsAt)
+}
+
+// cohortSeatHold is how long a pending checkout holds its cohort seat.
+const cohortSeatHold = time.Hour
+
+// CohortSeatsTaken counts the cohort's active and completed enrollments,
+// plus the seats held by recent checkouts that haven't been paid yet.
+func CohortSeatsTaken(db *gorm.DB, cohortID uint) int64 {
+	var taken, held int64
+	db.Model(&models.CourseEnrollment{}).
+		Where("cohort_id = ? AND status IN ?", cohortID, []string{models.EnrollStatusActive, models.EnrollStatusCompleted}).
+		Count(&taken)
+	db.Model(&models.OrderItem{}).
+		Joins("JOIN orders ON orders.id = order_items.order_id AND orders.deleted_at IS NULL").
+		Where("order_items.cohort_id = ? AND orders.status = ? AND orders.created_at > ?",
+			cohortID, models.OrderStatusPending, time.Now().Add(-cohortSeatHold)).
+		Distinct("orders.id").Count(&held)
+	return taken + held
+}
+
+func cohortFull(db *gorm.DB, cohort *models.CourseCohort) bool {
+	return cohort.SeatLimit > 0 && CohortSeatsTaken(db, cohort.ID) >= int64(cohort.SeatLimit)
+}
+
+// SelectCohort picks the cohort a new enrollment joins. A requested cohort
+// must belong to the course, be open and have a free seat; otherwise
+// cohort-based courses get the next open cohort with seats and evergreen
+// courses none.
+//
+// The cohorts are locked FOR UPDATE, so inside a transaction the seat count
+// holds until the enrollment or order taking the seat is committed.
+func SelectCohort(db *gorm.DB, course *models.Course, cohortID *uint, now time.Time) (*models.CourseCohort, error) {
+	locked := db.Clauses(clause.Locking{Strength: "UPDATE"})
+	if cohortID != nil {
+		var cohort models.CourseCohort
+		if err := locked.Where("id = ? AND course_id = ?", *cohortID, course.ID).First(&cohort).Error; err != nil {
+			return nil, ErrCohortNotFound
+		}
+		if !CohortOpen(&cohort, now) {
+			return nil, ErrCohortClosed
+		}
+		if cohortFull(db, &cohort) {
+			return nil, ErrCohortFull
+		}
+		return &cohort, nil
+	}
+	if !course.CohortBased {
+		return nil, nil
+	}
+
+	var cohorts []models.CourseCohort
+	locked.Where("course_id = ?", course.ID).Order("starts_at ASC").Find(&cohorts)
+	for i := range cohorts {
+		if CohortOpen(&cohorts[i], now) && !cohortFull(db, &cohorts[i]) {
+			return &cohorts[i], nil
+		}
+	}
+	return nil, ErrNoOpenCohort
+}
+
+// HoldCohortSeat checks, with the cohort locked, that a checkout can still
+// take a seat in it. Call it in the transaction that creates the pending
+// order, which then holds the seat.
+func HoldCohortSeat(tx *gorm.DB, cohortID uint, now time.Time) error {
+	var cohort models.CourseCohort
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&cohort, cohortID).Error; err != nil {
+		return ErrCohortNotFound
+	}
+	if !CohortOpen(&cohort, now) {
+		return ErrCohortClosed
+	}
+	if cohortFull(tx, &cohort) {
+		return ErrCohortFull
+	}
+	return nil
+}
+
+// JoinCohort places the enrollment in the cohort and adds the student to
+// the cohort's community space, leaving the space of the cohort they were
+// moved out of.
+func JoinCohort(db *gorm.DB, enrollment *models.CourseEnrollment, cohort *models.CourseCohort) error {
+	if cohort == nil {
+		return nil
+	}
+	if enrollment.CohortID == nil || *enrollment.CohortID != cohort.ID {
+		if enrollment.CohortID != nil {
+			if err := leaveCohortSpace(db, enrollment, *enrollment.CohortID, cohort.SpaceID); err != nil {
+				return err
+			}
+		}
+		if err := db.Model(enrollment).Update("cohort_id", cohort.ID).Error; err != nil {
+			return err
+		}
+		enrollment.CohortID = &cohort.ID
+	}
+	if cohort.SpaceID == nil {
+		return nil
+	}
+
+	var member models.CommunityMember
+	err := db.Unscoped().Where("space_id = ? AND contact_id = ?", *cohort.SpaceID, enrollment.ContactID).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return db.Create(&models.CommunityMember{
+			TenantID:  enrollment.TenantID,
+			ContactID: enrollment.ContactID,
+			SpaceID:   *cohort.SpaceID,
+			Role:      models.MemberRoleMember,
+			JoinedAt:  time.Now(),
+		}).Error
+	}
+	if err != nil {
+		return err
+	}
+	if member.DeletedAt.Valid {
+		return db.Unscoped().Model(&member).Update("deleted_at", nil).Error
+	}
+	return nil
+}
+
+// leaveCohortSpace removes the student from a previous cohort's space,
+// unless the new cohort shares it.
+func leaveCohortSpace(db *gorm.DB, enrollment *models.CourseEnrollment, oldCohortID uint, newSpaceID *uint) error {
+	var old models.CourseCohort
+	if err := db.Select("id", "space_id").First(&old, oldCohortID).Error; err != nil || old.SpaceID == nil {
+		return nil
+	}
+	if newSpaceID != nil && *newSpaceID == *old.SpaceID {
+		return nil
+	}
+	return db.Where("space_id = ? AND contact_id = ? AND role = ?", *old.SpaceID, enrollment.ContactID, models.MemberRoleMember).
+		Delete(&models.CommunityMember{}).Error
+}
+
+// fulfillCohort places a purchase enrollment in the cohort chosen at
+// checkout, or the next open one for cohort-based courses. Seats were
+// checked at checkout, so a paid student is never turned away here.
+func fulfillCohort(tx *gorm.DB, enrollment *models.CourseEnrollment, cohortID *uint, now time.Time) error {
+	if cohortID != nil {
+		var cohort models.CourseCohort
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND course_id = ?", *cohortID, enrollment.CourseID).First(&cohort).Error; err == nil {
+			return JoinCohort(tx, enrollment, &cohort)
+		}
+	}
+	if enrollment.CohortID != nil {
+		return nil
+	}
+
+	var course models.Course
+	if err := tx.First(&course, enrollment.CourseID).Error; err != nil || !course.CohortBased {
+		return nil
+	}
+	cohort, err := SelectCohort(tx, &course, nil, now)
+	if err != nil {
+		log.Printf("[fulfillment] No open cohort for course %d, enrollment %d left unassigned", course.ID, enrollment.ID)
+		return nil
+	}
+	return JoinCohort(tx, enrollment, cohort)
+}
+
+// CohortStats summarizes a cohort's enrollment and progress.
+type CohortStats struct {
+	Cohort         models.CourseCohort `json:"cohort"`
+	Enrolled       int64               `json:"enrolled"`
+	Completed      int64               `json:"completed"`
+	CompletionRate float64             `json:"completion_rate"`
+	AvgProgress    float64             `json:"avg_progress"`
+	SeatsLeft      *int64              `json:"seats_left"` // nil = unlimited
+	Engagement     *CourseEngagement   `json:"engagement,omitempty"`
+}
+
+// CohortStats reports enrollment, completion and seat usage per cohort of a
+// course, in start order.
+func (s *CourseService) CohortStats(courseID uint) []CohortStats {
+	var cohorts []models.CourseCohort
+	s.db.Where("course_id = ?", courseID).Order("starts_at ASC").Find(&cohorts)
+
+	stats := make([]CohortStats, 0, len(cohorts))
+	for _, cohort := range cohorts {
+		stats = append(stats, s.cohortStats(cohort))
+	}
+	return stats
+}
+
+func (s *CourseService) cohortStats(cohort models.CourseCohort) CohortStats {
+	row := CohortStats{Cohort: cohort}
+	s.db.Model(&models.CourseEnrollment{}).Where("cohort_id = ?", cohort.ID).Count(&row.Enrolled)
+	s.db.Model(&models.CourseEnrollment{}).Where("cohort_id = ? AND status = ?", cohort.ID, models.EnrollStatusCompleted).Count(&row.Completed)
+	s.db.Model(&models.CourseEnrollment{}).Where("cohort_id = ?", cohort.ID).
+		Select("COALESCE(AVG(progress_percentage), 0)").Row().Scan(&row.AvgProgress)
+	row.AvgProgress = math.Round(row.AvgProgress*100) / 100
+	row.CompletionRate = percentOf(row.Completed, row.Enrolled)
+
+	row.Cohort.SeatsTaken = CohortSeatsTaken(s.db, cohort.ID)
+	if cohort.SeatLimit > 0 {
+		left := max(int64(cohort.SeatLimit)-row.Cohort.SeatsTaken, 0)
+		row.SeatsLeft = &left
+	}
+	return row
+}
+
+// CohortAnalytics reports a single cohort's stats with lesson engagement
+// and the completion funnel for its students.
+func (s *CourseService) CohortAnalytics(courseID, cohortID uint) (*CohortStats, error) {
+	var cohort models.CourseCohort
+	if err := s.db.Where("id = ? AND course_id = ?", cohortID, courseID).First(&cohort).Error; err != nil {
+		return nil, ErrCohortNotFound
+	}
+	row := s.cohortStats(cohort)
+	engagement, err := s.Engagement(courseID, &cohortID)
+	if err != nil {
+		return nil, err
+	}
+	row.Engagement = engagement
+	return &row, nil
+}
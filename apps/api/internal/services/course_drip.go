@@ -0,0 +1,316 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/models"
+)
+
+// Drip errors.
+var (
+	ErrLessonLocked   = errors.New("this lesson hasn't unlocked yet")
+	ErrLessonNotFound = errors.New("lesson not found in this course")
+)
+
+// dripNoticeLookback caps how far back the unlock email reaches, so a
+// stalled queue or a newly dripped course doesn't mail out old lessons.
+const dripNoticeLookback = 48 * time.Hour
+
+// LoadOutline loads a course with its modules and lessons in order.
+func (s *CourseService) LoadOutline(courseID uint) (*models.Course, error) {
+	var course models.Course
+	err := s.db.Preload("Modules", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sort_order ASC, id ASC")
+	}).Preload("Modules.Lessons", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sort_order ASC, id ASC")
+	}).First(&course, courseID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &course, nil
+}
+
+// dripStart is the time lesson delays are counted from: the cohort's start
+// date, or enrollment for evergreen courses.
+func (s *CourseService) dripStart(enrollment *models.CourseEnrollment) time.Time {
+	if enrollment.CohortID == nil {
+		return enrollment.EnrolledAt
+	}
+	cohort := enrollment.Cohort
+	if cohort == nil || cohort.ID != *enrollment.CohortID {
+		cohort = &models.CourseCohort{}
+		if err := s.db.Select("id", "starts_at").First(cohort, *enrollment.CohortID).Error; err != nil {
+			return enrollment.EnrolledAt
+		}
+		enrollment.Cohort = cohort
+	}
+	return cohort.StartsAt
+}
+
+// UnlockTimes works out when each lesson of the course unlocks for the
+// enrollment; the course must be loaded with LoadOutline. A nil time means
+// the lesson is waiting on the previous module, or a prerequisite course of
+// the student's learning path, being completed.
+func (s *CourseService) UnlockTimes(enrollment *models.CourseEnrollment, course *models.Course) map[uint]*time.Time {
+	unlocks := make(map[uint]*time.Time)
+	if len(s.MissingPrerequisites(enrollment.ContactID, enrollment.CourseID)) > 0 {
+		for _, mod := range course.Modules {
+			for _, lesson := range mod.Lessons {
+				unlocks[lesson.ID] = nil
+			}
+		}
+		return unlocks
+	}
+	var progresses []models.LessonProgress
+	s.db.Where("enrollment_id = ? AND status = ?", enrollment.ID, models.ProgressCompleted).Find(&progresses)
+	return s.unlockTimes(enrollment, course, completedAt(progresses))
+}
+
+// completedAt maps lessons to when they were completed.
+func completedAt(progresses []models.LessonProgress) map[uint]time.Time {
+	completed := make(map[uint]time.Time, len(progresses))
+	for _, p := range progresses {
+		if p.CompletedAt != nil {
+			completed[p.LessonID] = *p.CompletedAt
+		}
+	}
+	return completed
+}
+
+// unlockTimes is UnlockTimes with the enrollment's completed lessons
+// already loaded.
+func (s *CourseService) unlockTimes(enrollment *models.CourseEnrollment, course *models.Course, completed map[uint]time.Time) map[uint]*time.Time {
+	unlocks := make(map[uint]*time.Time)
+	start := s.dripStart(enrollment)
+
+	var previousDone *time.Time // when the previous module was completed
+	for i, mod := range course.Modules {
+		for _, lesson := range mod.Lessons {
+			at := start
+			switch lesson.DripType {
+			case models.LessonDripDate:
+				if lesson.DripDate != nil {
+					at = *lesson.DripDate
+				}
+			case models.LessonDripPreviousModule:
+				if i > 0 && !lesson.IsFreePreview {
+					if previousDone == nil {
+						unlocks[lesson.ID] = nil
+						continue
+					}
+					at = *previousDone
+				}
+			default:
+				at = start.AddDate(0, 0, lesson.DripDelayDays)
+			}
+			if at.Before(start) {
+				at = start
+			}
+			// Free previews are open from enrollment, even before a cohort starts
+			if lesson.IsFreePreview && enrollment.EnrolledAt.Before(at) {
+				at = enrollment.EnrolledAt
+			}
+			unlocks[lesson.ID] = &at
+		}
+		previousDone = moduleCompletedAt(mod, completed, start)
+	}
+	return unlocks
+}
+
+// moduleCompletedAt returns when the last lesson of the module was
+// completed, or nil if some lesson is still open. An empty module counts as
+// complete from the start.
+func moduleCompletedAt(mod models.CourseModule, completed map[uint]time.Time, start time.Time) *time.Time {
+	last := start
+	for _, lesson := range mod.Lessons {
+		at, ok := completed[lesson.ID]
+		if !ok {
+			return nil
+		}
+		if at.After(last) {
+			last = at
+		}
+	}
+	return &last
+}
+
+func locked(unlock *time.Time, now time.Time) bool {
+	return unlock == nil || unlock.After(now)
+}
+
+// ApplyDrip marks the course's locked lessons for the student API, with
+// their unlock date, and strips their content.
+func (s *CourseService) ApplyDrip(enrollment *models.CourseEnrollment, course *models.Course, now time.Time) {
+	unlocks := s.UnlockTimes(enrollment, course)
+	for i := range course.Modules {
+		for j := range course.Modules[i].Lessons {
+			lesson := &course.Modules[i].Lessons[j]
+			unlock, ok := unlocks[lesson.ID]
+			if !ok || !locked(unlock, now) {
+				continue
+			}
+			lockLesson(lesson, unlock)
+		}
+	}
+}
+
+// LockCourse locks every lesson of the course for the student API, e.g.
+// when the membership that granted it has lapsed.
+func LockCourse(course *models.Course) {
+	for i := range course.Modules {
+		for j := range course.Modules[i].Lessons {
+			lockLesson(&course.Modules[i].Lessons[j], nil)
+		}
+	}
+}
+
+func lockLesson(lesson *models.Lesson, unlock *time.Time) {
+	lesson.Locked = true
+	lesson.UnlocksAt = unlock
+	lesson.Content = nil
+	lesson.VideoURL = ""
+	lesson.Quizzes = nil
+	lesson.Assignment = nil
+}
+
+// CheckUnlocked returns ErrLessonLocked if the lesson hasn't dripped for the
+// enrollment yet, or ErrLessonNotFound if it isn't part of the course.
+func (s *CourseService) CheckUnlocked(enrollment *models.CourseEnrollment, lessonID uint, now time.Time) error {
+	course, err := s.LoadOutline(enrollment.CourseID)
+	if err != nil {
+		return err
+	}
+	unlock, ok := s.UnlockTimes(enrollment, course)[lessonID]
+	if !ok {
+		return ErrLessonNotFound
+	}
+	if locked(unlock, now) {
+		return ErrLessonLocked
+	}
+	return nil
+}
+
+// UnlockNotice lists the lessons that have unlocked for an enrollment since
+// the student was last emailed.
+type UnlockNotice struct {
+	Enrollment models.CourseEnrollment
+	Course     *models.Course
+	Lessons    []models.Lesson
+}
+
+// DueUnlockNotices finds active enrollments with lessons that unlocked after
+// enrollment and since the last unlock email. Only enrollments with
+// something unlocking in the lookback window are loaded: a cohort start, a
+// dated lesson, a delayed lesson falling due, or a completion that may open
+// the next module.
+func (s *CourseService) DueUnlockNotices(now time.Time) ([]UnlockNotice, error) {
+	floor := now.Add(-dripNoticeLookback)
+	var ids []uint
+	err := s.db.Raw(`
+		SELECT e.id FROM course_enrollments e
+		JOIN courses c ON c.id = e.course_id AND c.deleted_at IS NULL
+		LEFT JOIN course_cohorts co ON co.id = e.cohort_id
+		WHERE e.status = @active AND c.status = @published AND (
+			COALESCE(co.starts_at, e.enrolled_at) BETWEEN @floor AND @now
+			OR EXISTS (
+				SELECT 1 FROM lessons l
+				JOIN course_modules m ON m.id = l.module_id AND m.deleted_at IS NULL
+				WHERE m.course_id = e.course_id AND l.deleted_at IS NULL AND (
+					(l.drip_type = @date AND l.drip_date BETWEEN @floor AND @now)
+					OR (l.drip_type NOT IN (@date, @previous) AND l.drip_delay_days > 0
+						AND COALESCE(co.starts_at, e.enrolled_at) + l.drip_delay_days * INTERVAL '1 day' BETWEEN @floor AND @now)
+					OR (l.drip_type = @previous AND EXISTS (
+						SELECT 1 FROM lesson_progresses p
+						WHERE p.enrollment_id = e.id AND p.status = @completed AND p.completed_at BETWEEN @floor AND @now))
+				)
+			)
+		)
+		ORDER BY e.course_id, e.id
+	`, map[string]interface{}{
+		"active":    models.EnrollStatusActive,
+		"published": models.CourseStatusPublished,
+		"date":      models.LessonDripDate,
+		"previous":  models.LessonDripPreviousModule,
+		"completed": models.ProgressCompleted,
+		"floor":     floor,
+		"now":       now,
+	}).Scan(&ids).Error
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	var enrollments []models.CourseEnrollment
+	if err := s.db.Preload("Contact").Preload("Cohort").Where("id IN ?", ids).
+		Order("course_id, id").Find(&enrollments).Error; err != nil {
+		return nil, err
+	}
+	var progresses []models.LessonProgress
+	s.db.Where("enrollment_id IN ? AND status = ?", ids, models.ProgressCompleted).Find(&progresses)
+	completed := make(map[uint][]models.LessonProgress, len(ids))
+	for _, p := range progresses {
+		completed[p.EnrollmentID] = append(completed[p.EnrollmentID], p)
+	}
+
+	courses := make(map[uint]*models.Course)
+	var notices []UnlockNotice
+	for _, enrollment := range enrollments {
+		course, ok := courses[enrollment.CourseID]
+		if !ok {
+			course, _ = s.LoadOutline(enrollment.CourseID)
+			courses[enrollment.CourseID] = course
+		}
+		if course == nil || len(s.MissingPrerequisites(enrollment.ContactID, enrollment.CourseID)) > 0 {
+			continue
+		}
+
+		// From enrollment, so a cohort's first lessons are announced when it starts
+		since := enrollment.EnrolledAt
+		if enrollment.DripNotifiedAt != nil && enrollment.DripNotifiedAt.After(since) {
+			since = *enrollment.DripNotifiedAt
+		}
+		if since.Before(floor) {
+			since = floor
+		}
+
+		unlocks := s.unlockTimes(&enrollment, course, completedAt(completed[enrollment.ID]))
+		notice := UnlockNotice{Enrollment: enrollment, Course: course}
+		for _, mod := range course.Modules {
+			for _, lesson := range mod.Lessons {
+				at := unlocks[lesson.ID]
+				if at != nil && at.After(since) && !at.After(now) {
+					notice.Lessons = append(notice.Lessons, lesson)
+				}
+			}
+		}
+		if len(notice.Lessons) > 0 {
+			notices = append(notices, notice)
+		}
+	}
+	return notices, nil
+}
+
+// MarkUnlockNotified records that the enrollment was emailed about lessons
+// unlocked up to at.
+func (s *CourseService) MarkUnlockNotified(enrollmentID uint, at time.Time) {
+	s.db.Model(&models.CourseEnrollment{}).Where("id = ?", enrollmentID).Update("drip_notified_at", at)
+}
+
+// UnlockEmailData builds the template data for a lesson unlock email.
+func UnlockEmailData(notice UnlockNotice, appName, webURL string) map[string]interface{} {
+	titles := make([]string, 0, len(notice.Lessons))
+	for _, lesson := range notice.Lessons {
+		titles = append(titles, lesson.Title)
+	}
+	return map[string]interface{}{
+		"AppName":     appName,
+		"Year":        time.Now().Year(),
+		"FirstName":   notice.Enrollment.Contact.FirstName,
+		"CourseTitle": notice.Course.Title,
+		"Lessons":     titles,
+		"ActionURL":   strings.TrimRight(webURL, "/") + "/learn/" + notice.Course.Slug,
+	}
+}
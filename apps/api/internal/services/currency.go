@@ -0,0 +1,370 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"gritcms/apps/api/internal/models"
+)
+
+// Setting keys for currency configuration.
+const (
+	SettingDefaultCurrency     = "default_currency"     // tenant base currency for reporting
+	SettingSupportedCurrencies = "supported_currencies" // comma-separated display currencies
+)
+
+// ErrNoExchangeRate is returned when a currency has no rate to the base currency.
+var ErrNoExchangeRate = errors.New("no exchange rate for currency")
+
+// CurrencyService resolves prices in a display currency and converts
+// amounts to the tenant's base currency using the exchange-rate table.
+type CurrencyService struct {
+	db *gorm.DB
+}
+
+func NewCurrencyService(db *gorm.DB) *CurrencyService {
+	return &CurrencyService{db: db}
+}
+
+// BaseCurrency returns the tenant's reporting currency (default USD).
+func (s *CurrencyService) BaseCurrency(tenantID uint) string {
+	var setting models.Setting
+	if err := s.db.Where("tenant_id = ? AND key = ?", tenantID, SettingDefaultCurrency).First(&setting).Error; err == nil {
+		if cur := normalizeCurrency(setting.Value); cur != "" {
+			return cur
+		}
+	}
+	return "USD"
+}
+
+// SupportedCurrencies lists the currencies shoppers may pick: the base
+// currency, any configured in supported_currencies, and every currency
+// with an exchange rate.
+func (s *CurrencyService) SupportedCurrencies(tenantID uint) []string {
+	base := s.BaseCurrency(tenantID)
+	out := []string{base}
+	seen := map[string]bool{base: true}
+	add := func(cur string) {
+		cur = normalizeCurrency(cur)
+		if cur != "" && !seen[cur] {
+			seen[cur] = true
+			out = append(out, cur)
+		}
+	}
+
+	var setting models.Setting
+	if err := s.db.Where("tenant_id = ? AND key = ?", tenantID, SettingSupportedCurrencies).First(&setting).Error; err == nil {
+		for _, cur := range strings.Split(setting.Value, ",") {
+			add(cur)
+		}
+	}
+	var rated []string
+	s.db.Model(&models.ExchangeRate{}).Where("tenant_id = ? AND base_currency = ?", tenantID, base).
+		Order("currency ASC").Pluck("currency", &rated)
+	for _, cur := range rated {
+		add(cur)
+	}
+	return out
+}
+
+// IsSupported reports whether a currency can be shown to shoppers.
+func (s *CurrencyService) IsSupported(tenantID uint, currency string) bool {
+	currency = normalizeCurrency(currency)
+	for _, cur := range s.SupportedCurrencies(tenantID) {
+		if cur == currency {
+			return true
+		}
+	}
+	return false
+}
+
+// Rate returns the value of one unit of currency in the base currency.
+func (s *CurrencyService) Rate(tenantID uint, currency string) (float64, error) {
+	currency = normalizeCurrency(currency)
+	base := s.BaseCurrency(tenantID)
+	if currency == "" || currency == base {
+		return 1, nil
+	}
+	var rate models.ExchangeRate
+	if err := s.db.Where("tenant_id = ? AND currency = ? AND base_currency = ?", tenantID, currency, base).First(&rate).Error; err != nil || rate.Rate <= 0 {
+		return 0, fmt.Errorf("%w %s", ErrNoExchangeRate, currency)
+	}
+	return rate.Rate, nil
+}
+
+// Convert converts an amount in minor units between two currencies via the
+// base currency, rounded to a whole minor unit.
+func (s *CurrencyService) Convert(tenantID uint, amount float64, from, to string) (float64, error) {
+	from, to = normalizeCurrency(from), normalizeCurrency(to)
+	if from == to {
+		return amount, nil
+	}
+	fromRate, err := s.Rate(tenantID, from)
+	if err != nil {
+		return 0, err
+	}
+	toRate, err := s.Rate(tenantID, to)
+	if err != nil {
+		return 0, err
+	}
+	return math.Round(amount * fromRate / toRate), nil
+}
+
+// PriceIn returns a price's amount in the requested currency: an explicit
+// amount from Price.Amounts, the price's own amount when the currency
+// matches, or an FX conversion. When none is possible the price's own
+// amount and currency are returned.
+func (s *CurrencyService) PriceIn(tenantID uint, price models.Price, currency string) (float64, string) {
+	own := normalizeCurrency(price.Currency)
+	if own == "" {
+		own = s.BaseCurrency(tenantID)
+	}
+	currency = normalizeCurrency(currency)
+	if currency == "" || currency == own {
+		return price.Amount, own
+	}
+
+	if len(price.Amounts) > 0 {
+		var amounts map[string]float64
+		if json.Unmarshal(price.Amounts, &amounts) == nil {
+			for cur, amount := range amounts {
+				if normalizeCurrency(cur) == currency && amount > 0 {
+					return amount, currency
+				}
+			}
+		}
+	}
+
+	if converted, err := s.Convert(tenantID, price.Amount, own, currency); err == nil {
+		return converted, currency
+	}
+	return price.Amount, own
+}
+
+// BaseRevenueSQL sums order totals in the base currency. Orders without a
+// captured rate are left out rather than added in their own currency; see
+// UnconvertedOrders.
+const BaseRevenueSQL = "COALESCE(SUM(CASE WHEN exchange_rate > 0 THEN base_total ELSE 0 END), 0)"
+
+// UnconvertedOrders counts paid orders left out of BaseRevenueSQL totals
+// because their currency has no exchange rate yet.
+func (s *CurrencyService) UnconvertedOrders(tenantID uint) int64 {
+	var count int64
+	s.db.Model(&models.Order{}).
+		Where("tenant_id = ? AND paid_at IS NOT NULL AND status IN ? AND exchange_rate = 0", tenantID,
+			[]string{models.OrderStatusPaid, models.OrderStatusPartiallyRefunded}).
+		Count(&count)
+	return count
+}
+
+// MRR returns monthly recurring revenue from active subscriptions in the
+// base currency, converting the amount each subscription was sold at (its
+// price, for older rows) with the current exchange rate. Past-due
+// subscriptions and currencies without a rate are left out.
+func (s *CurrencyService) MRR(tenantID uint) float64 {
+	base := s.BaseCurrency(tenantID)
+	const (
+		amount   = "CASE WHEN subscriptions.amount > 0 THEN subscriptions.amount ELSE prices.amount END"
+		currency = "UPPER(CASE WHEN subscriptions.amount > 0 THEN subscriptions.currency ELSE prices.currency END)"
+		interval = "CASE WHEN subscriptions.amount > 0 THEN subscriptions.interval ELSE prices.interval END"
+	)
+	var mrr float64
+	s.db.Model(&models.Subscription{}).
+		Where("subscriptions.tenant_id = ? AND subscriptions.status = ?", tenantID, models.SubscriptionActive).
+		Joins("JOIN prices ON prices.id = subscriptions.price_id").
+		Joins("LEFT JOIN exchange_rates er ON er.tenant_id = ? AND er.base_currency = ? AND er.currency = "+currency, tenantID, base).
+		Select("COALESCE(SUM(CASE "+interval+" WHEN 'year' THEN "+amount+" / 12 WHEN 'week' THEN "+amount+" * 52 / 12 WHEN 'day' THEN "+amount+" * 365 / 12 ELSE "+amount+" END"+
+			" * CASE WHEN "+currency+" IN (?, '') THEN 1 ELSE er.rate END), 0)", base).
+		Scan(&mrr)
+	return math.Round(mrr)
+}
+
+// captureOrderRate sets the base currency, exchange rate and base total on
+// an order being paid. An order in a currency without a rate is left
+// uncaptured and picked up by BackfillOrderRates once a rate exists.
+func captureOrderRate(tx *gorm.DB, order *models.Order) {
+	svc := NewCurrencyService(tx)
+	rate, err := svc.Rate(order.TenantID, order.Currency)
+	if err != nil {
+		return
+	}
+	order.BaseCurrency = svc.BaseCurrency(order.TenantID)
+	order.ExchangeRate = rate
+	order.BaseTotal = roundMoney(order.Total * rate)
+}
+
+// BackfillOrderRates captures current rates on paid orders that have none
+// yet, or whose captured base currency is no longer the tenant's base.
+// Returns the number of orders updated.
+func (s *CurrencyService) BackfillOrderRates(tenantID uint) int64 {
+	base := s.BaseCurrency(tenantID)
+	stale := s.db.Model(&models.Order{}).
+		Where("tenant_id = ? AND paid_at IS NOT NULL AND (exchange_rate = 0 OR base_currency IS NULL OR base_currency <> ?)", tenantID, base)
+
+	var updated int64
+	res := stale.Session(&gorm.Session{}).Where("(UPPER(currency) = ? OR currency = '' OR currency IS NULL)", base).
+		Updates(map[string]interface{}{"base_currency": base, "exchange_rate": 1, "base_total": gorm.Expr("total")})
+	updated += res.RowsAffected
+
+	var rates []models.ExchangeRate
+	s.db.Where("tenant_id = ? AND base_currency = ?", tenantID, base).Find(&rates)
+	for _, r := range rates {
+		res := stale.Session(&gorm.Session{}).Where("UPPER(currency) = ?", r.Currency).
+			Updates(map[string]interface{}{"base_currency": base, "exchange_rate": r.Rate, "base_total": gorm.Expr("ROUND(total * ?, 2)", r.Rate)})
+		updated += res.RowsAffected
+	}
+	return updated
+}
+
+// ErrBaseCurrencyLocked is returned when the base currency is changed after
+// orders have been paid in it.
+var ErrBaseCurrencyLocked = errors.New("the base currency cannot be changed once orders have been paid")
+
+// SetBaseCurrency changes the tenant's base currency. Exchange rates are
+// re-based onto the new currency, which needs a rate against the old one when
+// any rates exist. The change is refused once orders have been paid, since
+// their captured base totals are in the old currency.
+func (s *CurrencyService) SetBaseCurrency(tenantID uint, currency string) error {
+	currency = normalizeCurrency(currency)
+	if currency == "" {
+		return fmt.Errorf("base_currency must be a 3-letter ISO code")
+	}
+	old := s.BaseCurrency(tenantID)
+	if currency == old {
+		return nil
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var paid int64
+		if err := tx.Model(&models.Order{}).Where("tenant_id = ? AND paid_at IS NOT NULL", tenantID).Count(&paid).Error; err != nil {
+			return err
+		}
+		if paid > 0 {
+			return ErrBaseCurrencyLocked
+		}
+
+		var rates []models.ExchangeRate
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("tenant_id = ? AND base_currency = ?", tenantID, old).Find(&rates).Error; err != nil {
+			return err
+		}
+		if len(rates) > 0 {
+			// pivot is the value of one unit of the new base in the old base
+			var pivot float64
+			for _, r := range rates {
+				if r.Currency == currency {
+					pivot = r.Rate
+				}
+			}
+			if pivot <= 0 {
+				return fmt.Errorf("%w %s: set its rate against %s before making it the base currency", ErrNoExchangeRate, currency, old)
+			}
+			for _, r := range rates {
+				updates := map[string]interface{}{"base_currency": currency, "rate": r.Rate / pivot}
+				if r.Currency == currency {
+					updates["currency"], updates["rate"] = old, 1/pivot
+				}
+				if err := tx.Model(&models.ExchangeRate{}).Where("id = ?", r.ID).Updates(updates).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		setting := models.Setting{TenantID: tenantID, Group: "payments", Key: SettingDefaultCurrency, Value: currency, Type: "string"}
+		return tx.Where("tenant_id = ? AND key = ?", tenantID, SettingDefaultCurrency).
+			Assign(map[string]interface{}{"value": currency}).
+			FirstOrCreate(&setting).Error
+	})
+}
+
+// SetRate creates or updates the rate for a currency against the base currency.
+func (s *CurrencyService) SetRate(tenantID uint, currency string, rate float64, source string) (*models.ExchangeRate, error) {
+	currency = normalizeCurrency(currency)
+	if currency == "" {
+		return nil, fmt.Errorf("currency must be a 3-letter ISO code")
+	}
+	if rate <= 0 {
+		return nil, fmt.Errorf("rate for %s must be greater than zero", currency)
+	}
+	base := s.BaseCurrency(tenantID)
+	if currency == base {
+		return nil, fmt.Errorf("%s is the base currency", currency)
+	}
+	row := models.ExchangeRate{TenantID: tenantID, BaseCurrency: base, Currency: currency, Rate: rate, Source: source}
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tenant_id"}, {Name: "currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"base_currency", "rate", "source", "updated_at"}),
+	}).Create(&row).Error
+	if err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+// ImportRates reads "currency,rate" rows (an optional header row is skipped)
+// and stores them. Rates are the value of one unit of the currency in the
+// base currency. Returns the number of rates imported.
+func (s *CurrencyService) ImportRates(tenantID uint, r io.Reader) (int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return 0, fmt.Errorf("reading CSV: %w", err)
+	}
+
+	imported := 0
+	for i, rec := range records {
+		if len(rec) < 2 {
+			continue
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(rec[1]), 64)
+		if err != nil {
+			if i == 0 {
+				continue // header
+			}
+			return imported, fmt.Errorf("line %d: invalid rate %q", i+1, rec[1])
+		}
+		if _, err := s.SetRate(tenantID, rec[0], rate, "import"); err != nil {
+			return imported, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+// countryCurrencies maps ISO country codes to their currency, for picking a
+// display currency from the visitor's country.
+var countryCurrencies = map[string]string{
+	"US": "USD", "CA": "CAD", "MX": "MXN", "BR": "BRL", "AR": "ARS",
+	"GB": "GBP", "IE": "EUR", "FR": "EUR", "DE": "EUR", "ES": "EUR", "IT": "EUR",
+	"NL": "EUR", "BE": "EUR", "PT": "EUR", "AT": "EUR", "FI": "EUR", "GR": "EUR",
+	"CH": "CHF", "SE": "SEK", "NO": "NOK", "DK": "DKK", "PL": "PLN",
+	"KE": "KES", "UG": "UGX", "TZ": "TZS", "RW": "RWF", "ET": "ETB",
+	"NG": "NGN", "GH": "GHS", "ZA": "ZAR", "EG": "EGP", "MA": "MAD",
+	"IN": "INR", "PK": "PKR", "CN": "CNY", "JP": "JPY", "SG": "SGD",
+	"AE": "AED", "SA": "SAR", "AU": "AUD", "NZ": "NZD",
+}
+
+// CurrencyForCountry returns the currency used in a country, or "".
+func CurrencyForCountry(country string) string {
+	return countryCurrencies[strings.ToUpper(strings.TrimSpace(country))]
+}
+
+func normalizeCurrency(cur string) string {
+	cur = strings.ToUpper(strings.TrimSpace(cur))
+	if len(cur) != 3 {
+		return ""
+	}
+	return cur
+}
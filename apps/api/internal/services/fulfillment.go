@@ -1,152 +1,479 @@
 package services
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"gritcms/apps/api/internal/events"
 	"gritcms/apps/api/internal/models"
 )
 
-// FulfillOrder handles post-payment fulfillment for a paid order:
-// - auto-enrolls contacts in courses linked to purchased products
-// - grants access to digital products
-// - grants membership access
-// - records service purchases
-// - emits PurchaseCompleted event
-func FulfillOrder(db *gorm.DB, order *models.Order) {
-	for _, item := range order.Items {
-		var product models.Product
-		if err := db.First(&product, item.ProductID).Error; err != nil {
-			log.Printf("[fulfillment] Product %v not found for order %d: %v", item.ProductID, order.ID, err)
-			continue
-		}
-
-		switch product.Type {
-
-		case models.ProductTypeCourse:
-			// Auto-enroll contact in any courses linked to this product
-			var courses []models.Course
-			db.Where("product_id = ?", product.ID).Find(&courses)
-			for _, course := range courses {
-				enrollment := models.CourseEnrollment{
-					TenantID:  1,
-					ContactID: order.ContactID,
-					CourseID:  course.ID,
-					Status:    "active",
-					Source:    "purchase",
-				}
-				if err := db.FirstOrCreate(&enrollment, models.CourseEnrollment{
-					ContactID: order.ContactID,
-					CourseID:  course.ID,
-				}).Error; err != nil {
-					log.Printf("[fulfillment] Failed to enroll contact %d in course %d: %v", order.ContactID, course.ID, err)
-				} else {
-					log.Printf("[fulfillment] Contact %d enrolled in course %d", order.ContactID, course.ID)
-				}
-			}
+// PaymentConfirmation carries what a payment provider knows about a
+// successful payment when it hands the order over for fulfillment.
+type PaymentConfirmation struct {
+	Provider    string    // stripe, paypal, mpesa, manual
+	PaymentID   string    // provider payment/session ID, if not already on the order
+	Reference   string    // capture ID / receipt number used for refunds
+	Fingerprint string    // card fingerprint, for affiliate self-referral checks
+	PaidAt      time.Time // zero = now
+}
 
-		case models.ProductTypeDigital:
-			// Grant access to the digital product
-			access := models.ContactProduct{
-				TenantID:  1,
-				ContactID: order.ContactID,
-				ProductID: product.ID,
-				OrderID:   order.ID,
-				Status:    "active",
-			}
-			if err := db.FirstOrCreate(&access, models.ContactProduct{
-				ContactID: order.ContactID,
-				ProductID: product.ID,
-			}).Error; err != nil {
-				log.Printf("[fulfillment] Failed to grant digital access to contact %d for product %d: %v", order.ContactID, product.ID, err)
-			} else {
-				log.Printf("[fulfillment] Contact %d granted access to digital product %d", order.ContactID, product.ID)
-			}
+// OrderFulfiller is the single entry point every payment provider, webhook
+// and admin action uses once an order has been paid.
+type OrderFulfiller interface {
+	// FulfillPaidOrder marks the order paid and grants what was bought.
+	// It is idempotent: calling it again for a fulfilled order is a no-op
+	// and reports fulfilled=false.
+	FulfillPaidOrder(orderID uint, confirmation PaymentConfirmation) (order *models.Order, fulfilled bool, err error)
+}
+
+// FulfillmentService is the default OrderFulfiller. The order status change,
+// enrollments, access grants and coupon usage are written in one transaction,
+// and every completed step is recorded so a retry never grants twice.
+type FulfillmentService struct {
+	db *gorm.DB
+}
+
+func NewFulfillmentService(db *gorm.DB) *FulfillmentService {
+	return &FulfillmentService{db: db}
+}
+
+var _ OrderFulfiller = (*FulfillmentService)(nil)
+
+// ErrOrderNotPayable is returned when an order can no longer be fulfilled
+// (e.g. it has already been refunded).
+var ErrOrderNotPayable = errors.New("order cannot be fulfilled in its current status")
+
+// FulfillPaidOrder implements OrderFulfiller.
+func (s *FulfillmentService) FulfillPaidOrder(orderID uint, confirmation PaymentConfirmation) (*models.Order, bool, error) {
+	var order models.Order
+	var enrollments []models.CourseEnrollment
+	var conversions []models.FunnelConversion
+	var renewed *models.Subscription
+	fulfilled := false
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Lock the order so concurrent webhooks/confirmations serialise here
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&order, orderID).Error; err != nil {
+			return err
+		}
+
+		if order.FulfilledAt != nil {
+			return nil
+		}
+		if order.Status == models.OrderStatusRefunded || order.Status == models.OrderStatusPartiallyRefunded {
+			return ErrOrderNotPayable
+		}
+
+		now := time.Now()
+		paidAt := confirmation.PaidAt
+		if paidAt.IsZero() {
+			paidAt = now
+		}
+		if order.PaidAt == nil {
+			order.PaidAt = &paidAt
+		}
+		order.Status = models.OrderStatusPaid
+		if confirmation.Provider != "" && order.PaymentProvider == "" {
+			order.PaymentProvider = confirmation.Provider
+		}
+		if confirmation.PaymentID != "" && order.PaymentID == "" {
+			order.PaymentID = confirmation.PaymentID
+		}
+		if confirmation.Reference != "" {
+			order.PaymentReference = confirmation.Reference
+		}
+		if confirmation.Fingerprint != "" {
+			order.PaymentFingerprint = confirmation.Fingerprint
+		}
 
-		case models.ProductTypeMembership:
-			// Grant or extend membership access
-			now := time.Now()
-			var expiresAt *time.Time
+		steps := newStepRecorder(tx, &order)
+		if err := steps.load(); err != nil {
+			return err
+		}
 
-			// Check if product has a duration defined (e.g. 30 days, 365 days)
-			if product.MembershipDays > 0 {
-				exp := now.AddDate(0, 0, product.MembershipDays)
-				expiresAt = &exp
+		for _, item := range order.Items {
+			created, err := s.fulfillItem(tx, steps, &order, item, now)
+			if err != nil {
+				return err
 			}
+			enrollments = append(enrollments, created...)
+		}
 
-			var existing models.ContactProduct
-			err := db.Where("contact_id = ? AND product_id = ?", order.ContactID, product.ID).First(&existing).Error
-			if err == gorm.ErrRecordNotFound {
-				// New membership
-				membership := models.ContactProduct{
-					TenantID:   1,
-					ContactID:  order.ContactID,
-					ProductID:  product.ID,
-					OrderID:    order.ID,
-					Status:     "active",
-					StartedAt:  &now,
-					ExpiresAt:  expiresAt,
-				}
-				if err := db.Create(&membership).Error; err != nil {
-					log.Printf("[fulfillment] Failed to create membership for contact %d, product %d: %v", order.ContactID, product.ID, err)
-				} else {
-					log.Printf("[fulfillment] Contact %d granted membership product %d", order.ContactID, product.ID)
-				}
-			} else if err == nil {
-				// Extend existing membership
-				updates := map[string]interface{}{"status": "active", "order_id": order.ID}
-				if expiresAt != nil {
-					// If already has an expiry in the future, extend from that; otherwise extend from now
-					base := now
-					if existing.ExpiresAt != nil && existing.ExpiresAt.After(now) {
-						base = *existing.ExpiresAt
-					}
-					exp := base.AddDate(0, 0, product.MembershipDays)
-					updates["expires_at"] = exp
-				}
-				if err := db.Model(&existing).Updates(updates).Error; err != nil {
-					log.Printf("[fulfillment] Failed to extend membership for contact %d, product %d: %v", order.ContactID, product.ID, err)
-				} else {
-					log.Printf("[fulfillment] Contact %d membership extended for product %d", order.ContactID, product.ID)
-				}
+		// Renewal orders move their subscription on a period
+		if order.SubscriptionID != nil {
+			if err := steps.run("subscription:renewal", func() error {
+				var err error
+				renewed, err = renewSubscription(tx, &order)
+				return err
+			}); err != nil {
+				return err
 			}
+		}
 
-		case models.ProductTypeService:
-			// Record that the contact purchased this service
-			access := models.ContactProduct{
-				TenantID:  1,
-				ContactID: order.ContactID,
-				ProductID: product.ID,
-				OrderID:   order.ID,
-				Status:    "active",
+		// Coupon usage only counts once the order is paid
+		var discounts []models.OrderDiscount
+		if err := tx.Where("order_id = ?", order.ID).Find(&discounts).Error; err != nil {
+			return err
+		}
+		if len(discounts) > 0 {
+			if err := steps.run("coupon:usage", func() error {
+				return RedeemDiscounts(tx, discounts, now)
+			}); err != nil {
+				return err
 			}
-			if err := db.FirstOrCreate(&access, models.ContactProduct{
-				ContactID: order.ContactID,
-				ProductID: product.ID,
-			}).Error; err != nil {
-				log.Printf("[fulfillment] Failed to record service purchase for contact %d, product %d: %v", order.ContactID, product.ID, err)
-			} else {
-				log.Printf("[fulfillment] Contact %d service purchase recorded for product %d", order.ContactID, product.ID)
+		} else if order.CouponID != nil {
+			// Orders created before per-order discounts were recorded
+			if err := steps.run("coupon:usage", func() error {
+				return RedeemCoupon(tx, *order.CouponID, order.ID)
+			}); err != nil {
+				return err
 			}
+		}
+
+		// Revenue is reported in the tenant base currency at the rate when paid
+		captureOrderRate(tx, &order)
+
+		// Abandoned checkouts paid later count as recovered revenue
+		if err := markCheckoutRecovered(tx, &order, now); err != nil {
+			return err
+		}
 
-		case models.ProductTypePhysical:
-			// Physical products require shipping — just log for now.
-			// You can extend this to trigger a fulfillment/shipping workflow.
-			log.Printf("[fulfillment] Physical product %d (order %d) — shipping fulfillment required for contact %d", product.ID, order.ID, order.ContactID)
+		// Funnel checkouts and one-click offers convert their step
+		if order.FunnelStepID != nil {
+			if err := steps.run("funnel:conversion", func() error {
+				var err error
+				conversions, err = recordFunnelConversions(tx, &order, now)
+				return err
+			}); err != nil {
+				return err
+			}
+		}
 
-		default:
-			log.Printf("[fulfillment] Unknown product type '%s' for product %d, skipping", product.Type, product.ID)
+		order.FulfilledAt = &now
+		if err := tx.Model(&order).Updates(map[string]interface{}{
+			"status":              order.Status,
+			"paid_at":             order.PaidAt,
+			"payment_provider":    order.PaymentProvider,
+			"payment_id":          order.PaymentID,
+			"payment_reference":   order.PaymentReference,
+			"payment_fingerprint": order.PaymentFingerprint,
+			"base_currency":       order.BaseCurrency,
+			"exchange_rate":       order.ExchangeRate,
+			"base_total":          order.BaseTotal,
+			"fulfilled_at":        order.FulfilledAt,
+		}).Error; err != nil {
+			return err
 		}
+
+		fulfilled = true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if !fulfilled {
+		return &order, false, nil
 	}
 
+	for _, e := range enrollments {
+		events.Emit(events.CourseEnrolled, e)
+	}
+	for _, conv := range conversions {
+		events.Emit(events.FunnelConverted, map[string]interface{}{
+			"funnel_id": conv.FunnelID, "step_id": conv.StepID, "type": conv.Type, "order_id": order.ID,
+		})
+	}
+	var subs []models.Subscription
+	s.db.Where("order_id = ?", order.ID).Find(&subs)
+	for _, sub := range subs {
+		events.Emit(events.SubscriptionCreated, sub)
+	}
+	if renewed != nil {
+		events.Emit(events.SubscriptionRenewed, map[string]interface{}{
+			"subscription_id": renewed.ID,
+			"contact_id":      renewed.ContactID,
+			"amount":          order.Total,
+			"currency":        order.Currency,
+			"period_start":    renewed.CurrentPeriodStart,
+			"order_id":        order.ID,
+		})
+	}
 	events.Emit(events.PurchaseCompleted, map[string]interface{}{
 		"order_id":   order.ID,
 		"contact_id": order.ContactID,
 		"total":      order.Total,
+		"currency":   order.Currency,
+		"base_total": order.BaseTotal,
 	})
 
 	log.Printf("[fulfillment] Order %d fulfilled (contact=%d, total=%.2f)", order.ID, order.ContactID, order.Total)
-}
\ No newline at end of file
+	return &order, true, nil
+}
+
+// fulfillItem grants whatever a single order item entitles the contact to:
+// - course enrollments (direct course items and course-type products,
+//   including bundles and learning paths), in the cohort chosen at checkout
+// - learning path enrollments for paths sold with the product
+// - digital product and service access
+// - membership access (granted or extended by MembershipDays)
+// - a subscription, for subscription prices bought at checkout
+// Physical products are only logged. Newly created enrollments are returned
+// so CourseEnrolled can be emitted after commit.
+func (s *FulfillmentService) fulfillItem(tx *gorm.DB, steps *stepRecorder, order *models.Order, item models.OrderItem, now time.Time) ([]models.CourseEnrollment, error) {
+	var enrollments []models.CourseEnrollment
+	enroll := func(courseID uint) error {
+		return steps.run(fmt.Sprintf("item:%d:course:%d", item.ID, courseID), func() error {
+			e, created, err := enrollContact(tx, order.TenantID, order.ContactID, courseID, now)
+			if err != nil {
+				return fmt.Errorf("enrolling contact %d in course %d: %w", order.ContactID, courseID, err)
+			}
+			if err := fulfillCohort(tx, &e, item.CohortID, now); err != nil {
+				return fmt.Errorf("placing enrollment %d in a cohort: %w", e.ID, err)
+			}
+			if created {
+				enrollments = append(enrollments, e)
+			}
+			return nil
+		})
+	}
+
+	// Direct course purchase
+	if item.CourseID != nil {
+		if err := enroll(*item.CourseID); err != nil {
+			return nil, err
+		}
+	}
+	if item.ProductID == nil {
+		return enrollments, nil
+	}
+
+	var product models.Product
+	if err := tx.First(&product, *item.ProductID).Error; err != nil {
+		log.Printf("[fulfillment] Product %d not found for order %d: %v", *item.ProductID, order.ID, err)
+		return enrollments, nil
+	}
+
+	if item.PriceID != nil && order.SubscriptionID == nil {
+		err := steps.run(fmt.Sprintf("item:%d:subscription", item.ID), func() error {
+			return startSubscription(tx, order, item, now)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch product.Type {
+	case models.ProductTypeCourse:
+		err := steps.run(fmt.Sprintf("item:%d:paths:%d", item.ID, product.ID), func() error {
+			return enrollProductPaths(tx, order, product.ID, now)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, courseID := range ProductCourseIDs(tx, product.ID) {
+			if item.CourseID != nil && *item.CourseID == courseID {
+				continue
+			}
+			if err := enroll(courseID); err != nil {
+				return nil, err
+			}
+		}
+
+	case models.ProductTypeDigital, models.ProductTypeService:
+		err := steps.run(fmt.Sprintf("item:%d:access:%d", item.ID, product.ID), func() error {
+			return grantProductAccess(tx, order, product.ID, now, nil, false)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+	case models.ProductTypeMembership:
+		err := steps.run(fmt.Sprintf("item:%d:membership:%d", item.ID, product.ID), func() error {
+			return grantProductAccess(tx, order, product.ID, now, &product, true)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+	case models.ProductTypePhysical:
+		// Physical products require shipping — just log for now.
+		log.Printf("[fulfillment] Physical product %d (order %d) — shipping fulfillment required for contact %d", product.ID, order.ID, order.ContactID)
+
+	default:
+		log.Printf("[fulfillment] Unknown product type '%s' for product %d, skipping", product.Type, product.ID)
+	}
+
+	return enrollments, nil
+}
+
+// enrollContact creates an active purchase enrollment, or reactivates one
+// that was revoked/expired. created reports whether a new row was inserted.
+func enrollContact(tx *gorm.DB, tenantID, contactID, courseID uint, now time.Time) (models.CourseEnrollment, bool, error) {
+	var enrollment models.CourseEnrollment
+	err := tx.Where("contact_id = ? AND course_id = ?", contactID, courseID).First(&enrollment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		enrollment = models.CourseEnrollment{
+			TenantID:   tenantID,
+			ContactID:  contactID,
+			CourseID:   courseID,
+			Status:     models.EnrollStatusActive,
+			EnrolledAt: now,
+			Source:     "purchase",
+		}
+		if err := tx.Create(&enrollment).Error; err != nil {
+			return enrollment, false, err
+		}
+		return enrollment, true, nil
+	}
+	if err != nil {
+		return enrollment, false, err
+	}
+
+	if enrollment.Status == models.EnrollStatusRevoked || enrollment.Status == models.EnrollStatusExpired || enrollment.Status == models.EnrollStatusSuspended {
+		err = tx.Model(&enrollment).Updates(map[string]interface{}{
+			"status": models.EnrollStatusActive,
+			"source": "purchase",
+		}).Error
+	}
+	return enrollment, false, err
+}
+
+// grantProductAccess creates or reactivates a ContactProduct for the order.
+// Memberships get an expiry from MembershipDays, extended from the current
+// expiry when the membership is still running.
+func grantProductAccess(tx *gorm.DB, order *models.Order, productID uint, now time.Time, product *models.Product, membership bool) error {
+	var expiresAt *time.Time
+	if membership && product != nil && product.MembershipDays > 0 {
+		exp := now.AddDate(0, 0, product.MembershipDays)
+		expiresAt = &exp
+	}
+
+	var existing models.ContactProduct
+	err := tx.Where("contact_id = ? AND product_id = ?", order.ContactID, productID).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return tx.Create(&models.ContactProduct{
+			TenantID:  order.TenantID,
+			ContactID: order.ContactID,
+			ProductID: productID,
+			OrderID:   order.ID,
+			Status:    models.ContactProductStatusActive,
+			Source:    "purchase",
+			StartedAt: &now,
+			ExpiresAt: expiresAt,
+		}).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{"status": models.ContactProductStatusActive, "order_id": order.ID}
+	if expiresAt != nil {
+		// If already has an expiry in the future, extend from that; otherwise extend from now
+		base := now
+		if existing.Status == models.ContactProductStatusActive && existing.ExpiresAt != nil && existing.ExpiresAt.After(now) {
+			base = *existing.ExpiresAt
+		}
+		updates["expires_at"] = base.AddDate(0, 0, product.MembershipDays)
+	}
+	return tx.Model(&existing).Updates(updates).Error
+}
+
+// startSubscription creates the subscription bought with a subscription
+// price. The checkout payment covers the first billing period, which starts
+// once the trial ends; the trial is the price's TrialDays plus any days added
+// by free_trial coupons on the order.
+func startSubscription(tx *gorm.DB, order *models.Order, item models.OrderItem, now time.Time) error {
+	var price models.Price
+	if err := tx.First(&price, *item.PriceID).Error; err != nil || price.Type != models.PriceTypeSubscription {
+		return nil
+	}
+
+	var extension int
+	if err := tx.Model(&models.OrderDiscount{}).Where("order_id = ?", order.ID).
+		Select("COALESCE(SUM(trial_days), 0)").Scan(&extension).Error; err != nil {
+		return err
+	}
+
+	orderID := order.ID
+	sub := models.Subscription{
+		TenantID:           order.TenantID,
+		ContactID:          order.ContactID,
+		ProductID:          price.ProductID,
+		PriceID:            price.ID,
+		Status:             models.SubscriptionActive,
+		PaymentProvider:    order.PaymentProvider,
+		OrderID:            &orderID,
+		Amount:             item.UnitPrice,
+		Currency:           order.Currency,
+		Interval:           price.Interval,
+		CurrentPeriodStart: now,
+	}
+	if days := price.TrialDays + extension; days > 0 {
+		trialEnd := now.AddDate(0, 0, days)
+		sub.TrialEndsAt = &trialEnd
+		sub.CurrentPeriodStart = trialEnd
+	}
+	sub.CurrentPeriodEnd = AddBillingInterval(sub.CurrentPeriodStart, price.Interval)
+	return tx.Create(&sub).Error
+}
+
+// AddBillingInterval returns t moved on by one Price.Interval (month when unset).
+func AddBillingInterval(t time.Time, interval string) time.Time {
+	switch interval {
+	case "year":
+		return t.AddDate(1, 0, 0)
+	case "week":
+		return t.AddDate(0, 0, 7)
+	case "day":
+		return t.AddDate(0, 0, 1)
+	}
+	return t.AddDate(0, 1, 0)
+}
+
+// stepRecorder records the fulfillment steps run for an order. The rows are
+// written in the same transaction that sets FulfilledAt, so they roll back
+// with it: a retry starts over, and idempotency comes from the FulfilledAt
+// check. They are an audit trail of what fulfillment applied, and keep a
+// step from running twice within one fulfillment.
+type stepRecorder struct {
+	tx    *gorm.DB
+	order *models.Order
+	done  map[string]bool
+}
+
+func newStepRecorder(tx *gorm.DB, order *models.Order) *stepRecorder {
+	return &stepRecorder{tx: tx, order: order, done: map[string]bool{}}
+}
+
+func (r *stepRecorder) load() error {
+	var steps []models.OrderFulfillmentStep
+	if err := r.tx.Where("order_id = ?", r.order.ID).Find(&steps).Error; err != nil {
+		return err
+	}
+	for _, st := range steps {
+		r.done[st.Step] = true
+	}
+	return nil
+}
+
+// run executes fn unless the step was already recorded, then records it.
+func (r *stepRecorder) run(step string, fn func() error) error {
+	if r.done[step] {
+		return nil
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	r.done[step] = true
+	return r.tx.Create(&models.OrderFulfillmentStep{
+		TenantID: r.order.TenantID,
+		OrderID:  r.order.ID,
+		Step:     step,
+	}).Error
+}
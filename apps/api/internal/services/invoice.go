@@ -0,0 +1,301 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/storage"
+)
+
+// Setting keys used for the seller block on invoices.
+const (
+	SettingBusinessName    = "business_name"
+	SettingBusinessTaxID   = "business_tax_id"
+	SettingBusinessAddress = "business_address"
+	SettingInvoicePrefix   = "invoice_prefix"
+)
+
+// InvoiceService issues sequential, per-tenant invoices for paid orders and
+// renders them as PDFs kept in the private bucket; they are only served
+// through the authenticated invoice downloads.
+type InvoiceService struct {
+	db      *gorm.DB
+	storage *storage.Storage
+}
+
+func NewInvoiceService(db *gorm.DB, store *storage.Storage) *InvoiceService {
+	s := &InvoiceService{db: db}
+	if store != nil {
+		s.storage = store.Private()
+	}
+	return s
+}
+
+// IssueInvoice returns the invoice for an order, allocating the next invoice
+// number and rendering the PDF the first time it is called.
+func (s *InvoiceService) IssueInvoice(ctx context.Context, orderID uint) (*models.Invoice, error) {
+	var order models.Order
+	if err := s.db.Preload("Contact").Preload("Items.Product").Preload("Items.Course").First(&order, orderID).Error; err != nil {
+		return nil, fmt.Errorf("order not found: %w", err)
+	}
+	if order.PaidAt == nil {
+		return nil, fmt.Errorf("order %d has not been paid", order.ID)
+	}
+
+	var invoice models.Invoice
+	err := s.db.Where("order_id = ?", order.ID).First(&invoice).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		created, cerr := s.createInvoice(&order)
+		if cerr != nil {
+			// Another worker may have issued it concurrently.
+			if ferr := s.db.Where("order_id = ?", order.ID).First(&invoice).Error; ferr != nil {
+				return nil, cerr
+			}
+		} else {
+			invoice = *created
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if invoice.StorageKey == "" && s.storage != nil {
+		pdf, err := s.RenderPDF(&invoice, &order)
+		if err != nil {
+			return nil, err
+		}
+		key := fmt.Sprintf("invoices/%d/%s.pdf", invoice.TenantID, invoice.InvoiceNumber)
+		if err := s.storage.Upload(ctx, key, bytes.NewReader(pdf), "application/pdf"); err != nil {
+			return nil, fmt.Errorf("uploading invoice: %w", err)
+		}
+		invoice.StorageKey = key
+		s.db.Model(&invoice).Update("storage_key", key)
+	}
+
+	return &invoice, nil
+}
+
+// PDF returns the invoice document, from storage when available and
+// rendered on the fly otherwise.
+func (s *InvoiceService) PDF(ctx context.Context, invoice *models.Invoice) ([]byte, error) {
+	if invoice.StorageKey != "" && s.storage != nil {
+		reader, err := s.storage.Download(ctx, invoice.StorageKey)
+		if err == nil {
+			defer reader.Close()
+			return io.ReadAll(reader)
+		}
+	}
+
+	var order models.Order
+	if err := s.db.Preload("Contact").Preload("Items.Product").Preload("Items.Course").First(&order, invoice.OrderID).Error; err != nil {
+		return nil, err
+	}
+	return s.RenderPDF(invoice, &order)
+}
+
+// createInvoice allocates the next number for the tenant and stores the
+// invoice with a snapshot of the seller details.
+func (s *InvoiceService) createInvoice(order *models.Order) (*models.Invoice, error) {
+	tenantID := order.TenantID
+	if tenantID == 0 {
+		tenantID = 1
+	}
+	seller := s.settings(tenantID, SettingBusinessName, SettingBusinessTaxID, SettingBusinessAddress, SettingInvoicePrefix, "site_name")
+	if seller[SettingBusinessName] == "" {
+		seller[SettingBusinessName] = seller["site_name"]
+	}
+	prefix := seller[SettingInvoicePrefix]
+	if prefix == "" {
+		prefix = "INV-"
+	}
+
+	var invoice models.Invoice
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		seq := models.InvoiceSequence{TenantID: tenantID}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&seq).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("tenant_id = ?", tenantID).First(&seq).Error; err != nil {
+			return err
+		}
+		next := seq.LastNumber + 1
+		if err := tx.Model(&models.InvoiceSequence{}).Where("tenant_id = ?", tenantID).Update("last_number", next).Error; err != nil {
+			return err
+		}
+
+		invoice = models.Invoice{
+			TenantID:       tenantID,
+			OrderID:        order.ID,
+			ContactID:      order.ContactID,
+			InvoiceNumber:  fmt.Sprintf("%s%06d", prefix, next),
+			Sequence:       next,
+			Currency:       order.Currency,
+			Subtotal:       order.Subtotal,
+			DiscountAmount: order.DiscountAmount,
+			TaxAmount:      order.TaxAmount,
+			Total:          order.Total,
+			SellerName:     seller[SettingBusinessName],
+			SellerTaxID:    seller[SettingBusinessTaxID],
+			SellerAddress:  seller[SettingBusinessAddress],
+			IssuedAt:       *order.PaidAt,
+		}
+		return tx.Create(&invoice).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// RenderPDF draws the invoice document.
+func (s *InvoiceService) RenderPDF(invoice *models.Invoice, order *models.Order) ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetMargins(20, 20, 20)
+	pdf.AddPage()
+
+	// Header — seller on the left, invoice meta on the right
+	pdf.SetFont("Helvetica", "B", 20)
+	pdf.CellFormat(100, 10, tr(invoice.SellerName), "", 0, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(70, 10, "INVOICE", "", 1, "R", false, 0, "")
+
+	pdf.SetFont("Helvetica", "", 10)
+	y := pdf.GetY()
+	pdf.MultiCell(100, 5, tr(invoice.SellerAddress), "", "L", false)
+	if invoice.SellerTaxID != "" {
+		pdf.CellFormat(100, 5, tr("Tax ID: "+invoice.SellerTaxID), "", 1, "L", false, 0, "")
+	}
+	leftY := pdf.GetY()
+
+	pdf.SetXY(120, y)
+	pdf.CellFormat(70, 5, "Invoice no: "+invoice.InvoiceNumber, "", 2, "R", false, 0, "")
+	pdf.CellFormat(70, 5, "Date: "+invoice.IssuedAt.Format("2 Jan 2006"), "", 2, "R", false, 0, "")
+	pdf.CellFormat(70, 5, "Order: "+order.OrderNumber, "", 2, "R", false, 0, "")
+	if pdf.GetY() > leftY {
+		leftY = pdf.GetY()
+	}
+	pdf.SetXY(20, leftY+8)
+
+	// Bill to
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.CellFormat(0, 5, "Bill to", "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	if order.Contact != nil {
+		name := strings.TrimSpace(order.Contact.FirstName + " " + order.Contact.LastName)
+		if name != "" {
+			pdf.CellFormat(0, 5, tr(name), "", 1, "L", false, 0, "")
+		}
+		pdf.CellFormat(0, 5, tr(order.Contact.Email), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(8)
+
+	// Line items
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.SetFillColor(240, 240, 245)
+	pdf.CellFormat(95, 8, "Description", "B", 0, "L", true, 0, "")
+	pdf.CellFormat(15, 8, "Qty", "B", 0, "C", true, 0, "")
+	pdf.CellFormat(30, 8, "Unit price", "B", 0, "R", true, 0, "")
+	pdf.CellFormat(30, 8, "Amount", "B", 1, "R", true, 0, "")
+
+	pdf.SetFont("Helvetica", "", 10)
+	for _, item := range order.Items {
+		pdf.CellFormat(95, 7, tr(orderItemName(item)), "", 0, "L", false, 0, "")
+		pdf.CellFormat(15, 7, fmt.Sprintf("%d", item.Quantity), "", 0, "C", false, 0, "")
+		pdf.CellFormat(30, 7, formatMoney(item.UnitPrice, invoice.Currency), "", 0, "R", false, 0, "")
+		pdf.CellFormat(30, 7, formatMoney(item.Total, invoice.Currency), "", 1, "R", false, 0, "")
+	}
+	pdf.Ln(2)
+
+	// Totals
+	totalRow := func(label string, amount float64, bold bool) {
+		style := ""
+		if bold {
+			style = "B"
+		}
+		pdf.SetFont("Helvetica", style, 10)
+		pdf.CellFormat(140, 7, label, "", 0, "R", false, 0, "")
+		pdf.CellFormat(30, 7, formatMoney(amount, invoice.Currency), "", 1, "R", false, 0, "")
+	}
+	totalRow("Subtotal", invoice.Subtotal, false)
+	if invoice.DiscountAmount > 0 {
+		totalRow("Discount", -invoice.DiscountAmount, false)
+	}
+	if invoice.TaxAmount > 0 {
+		totalRow("Tax", invoice.TaxAmount, false)
+	}
+	totalRow("Total", invoice.Total, true)
+
+	pdf.Ln(10)
+	pdf.SetFont("Helvetica", "I", 9)
+	pdf.SetTextColor(120, 120, 130)
+	paid := "Paid"
+	if order.PaymentProvider != "" {
+		paid += " via " + order.PaymentProvider
+	}
+	pdf.CellFormat(0, 5, paid+" on "+invoice.IssuedAt.Format("2 Jan 2006")+". Thank you for your business.", "", 1, "L", false, 0, "")
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("rendering invoice: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// settings loads the given setting keys for a tenant.
+func (s *InvoiceService) settings(tenantID uint, keys ...string) map[string]string {
+	var rows []models.Setting
+	s.db.Where("tenant_id = ? AND key IN ?", tenantID, keys).Find(&rows)
+	out := make(map[string]string, len(keys))
+	for _, r := range rows {
+		out[r.Key] = r.Value
+	}
+	return out
+}
+
+func orderItemName(item models.OrderItem) string {
+	if item.Course != nil {
+		return item.Course.Title
+	}
+	if item.Product != nil {
+		return item.Product.Name
+	}
+	return "Item"
+}
+
+// formatMoney renders an amount stored in minor units (cents).
+func formatMoney(amount float64, currency string) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%s %.2f", sign, strings.ToUpper(currency), amount/100)
+}
+
+// InvoiceEmailData builds the template data for the invoice email.
+func InvoiceEmailData(invoice *models.Invoice, order *models.Order, appName, webURL string) map[string]interface{} {
+	data := map[string]interface{}{
+		"AppName":       appName,
+		"Year":          time.Now().Year(),
+		"InvoiceNumber": invoice.InvoiceNumber,
+		"OrderNumber":   order.OrderNumber,
+		"Total":         formatMoney(invoice.Total, invoice.Currency),
+	}
+	if order.Contact != nil {
+		data["FirstName"] = order.Contact.FirstName
+	}
+	if webURL != "" {
+		data["ActionURL"] = strings.TrimRight(webURL, "/") + fmt.Sprintf("/purchases/%d", order.ID)
+	}
+	return data
+}
@@ -0,0 +1,366 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/events"
+	"gritcms/apps/api/internal/models"
+)
+
+// Learning path errors.
+var (
+	ErrPathNotFound         = errors.New("learning path not found")
+	ErrPathRequiresPurchase = errors.New("this learning path requires payment")
+)
+
+// ProductCourseIDs returns every course a product grants: courses linked by
+// their ProductID, courses bundled through ProductCourse, and the courses of
+// learning paths sold with the product.
+func ProductCourseIDs(db *gorm.DB, productID uint) []uint {
+	var direct, bundled, pathCourses []uint
+	db.Model(&models.Course{}).Where("product_id = ?", productID).Pluck("id", &direct)
+	db.Model(&models.ProductCourse{}).Where("product_id = ?", productID).Pluck("course_id", &bundled)
+	db.Model(&models.LearningPathCourse{}).
+		Joins("JOIN learning_paths ON learning_paths.id = learning_path_courses.path_id AND learning_paths.deleted_at IS NULL").
+		Where("learning_paths.product_id = ?", productID).
+		Order("learning_path_courses.position ASC").
+		Pluck("learning_path_courses.course_id", &pathCourses)
+
+	seen := make(map[uint]bool)
+	var ids []uint
+	for _, list := range [][]uint{direct, bundled, pathCourses} {
+		for _, id := range list {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// EnrollPath enrolls the contact in a learning path, or reactivates a
+// revoked path enrollment. created reports whether a new row was inserted.
+// Course enrollments are the caller's concern.
+func EnrollPath(db *gorm.DB, tenantID, contactID, pathID uint, source string, now time.Time) (models.LearningPathEnrollment, bool, error) {
+	var enrollment models.LearningPathEnrollment
+	err := db.Where("path_id = ? AND contact_id = ?", pathID, contactID).First(&enrollment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		enrollment = models.LearningPathEnrollment{
+			TenantID:   tenantID,
+			PathID:     pathID,
+			ContactID:  contactID,
+			Status:     models.EnrollStatusActive,
+			Source:     source,
+			EnrolledAt: now,
+		}
+		return enrollment, true, db.Create(&enrollment).Error
+	}
+	if err != nil {
+		return enrollment, false, err
+	}
+	if enrollment.Status == models.EnrollStatusRevoked || enrollment.Status == models.EnrollStatusExpired || enrollment.Status == models.EnrollStatusSuspended {
+		enrollment.Status = models.EnrollStatusActive
+		enrollment.Source = source
+		err = db.Model(&enrollment).Updates(map[string]interface{}{"status": enrollment.Status, "source": source}).Error
+	}
+	return enrollment, false, err
+}
+
+// enrollProductPaths enrolls the order's contact in the learning paths sold
+// with the product. Their courses are enrolled through ProductCourseIDs.
+func enrollProductPaths(tx *gorm.DB, order *models.Order, productID uint, now time.Time) error {
+	var pathIDs []uint
+	tx.Model(&models.LearningPath{}).Where("product_id = ?", productID).Pluck("id", &pathIDs)
+	for _, pathID := range pathIDs {
+		if _, _, err := EnrollPath(tx, order.TenantID, order.ContactID, pathID, "purchase", now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadPath loads a learning path with its courses in order.
+func (s *CourseService) LoadPath(pathID uint) (*models.LearningPath, error) {
+	var path models.LearningPath
+	err := s.db.Preload("Courses", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position ASC, id ASC")
+	}).Preload("Courses.Course").First(&path, pathID).Error
+	if err != nil {
+		return nil, ErrPathNotFound
+	}
+	return &path, nil
+}
+
+// Prerequisites maps each course of the path to the course that must be
+// completed before it, if any. The path's courses must be loaded in order.
+func Prerequisites(path *models.LearningPath) map[uint]*uint {
+	prereqs := make(map[uint]*uint, len(path.Courses))
+	for i, pc := range path.Courses {
+		switch {
+		case pc.PrerequisiteCourseID != nil:
+			prereqs[pc.CourseID] = pc.PrerequisiteCourseID
+		case path.Sequential && i > 0:
+			prev := path.Courses[i-1].CourseID
+			prereqs[pc.CourseID] = &prev
+		default:
+			prereqs[pc.CourseID] = nil
+		}
+	}
+	return prereqs
+}
+
+// completedCourses returns which of the courses the contact has completed.
+func (s *CourseService) completedCourses(contactID uint, courseIDs []uint) map[uint]bool {
+	done := make(map[uint]bool)
+	if len(courseIDs) == 0 {
+		return done
+	}
+	var ids []uint
+	s.db.Model(&models.CourseEnrollment{}).
+		Where("contact_id = ? AND course_id IN ? AND status = ?", contactID, courseIDs, models.EnrollStatusCompleted).
+		Pluck("course_id", &ids)
+	for _, id := range ids {
+		done[id] = true
+	}
+	return done
+}
+
+// MissingPrerequisites lists the prerequisite courses the contact hasn't
+// completed yet for a course, across the learning paths they are enrolled
+// in. Prerequisites only apply to students taking the course as part of a
+// path.
+func (s *CourseService) MissingPrerequisites(contactID, courseID uint) []models.Course {
+	var pathIDs []uint
+	s.db.Model(&models.LearningPathEnrollment{}).
+		Joins("JOIN learning_path_courses ON learning_path_courses.path_id = learning_path_enrollments.path_id").
+		Where("learning_path_enrollments.contact_id = ? AND learning_path_courses.course_id = ? AND learning_path_enrollments.status IN ?",
+			contactID, courseID, []string{models.EnrollStatusActive, models.EnrollStatusCompleted}).
+		Pluck("learning_path_enrollments.path_id", &pathIDs)
+
+	var required []uint
+	for _, pathID := range pathIDs {
+		path, err := s.LoadPath(pathID)
+		if err != nil {
+			continue
+		}
+		if prereq := Prerequisites(path)[courseID]; prereq != nil {
+			required = append(required, *prereq)
+		}
+	}
+	if len(required) == 0 {
+		return nil
+	}
+
+	done := s.completedCourses(contactID, required)
+	var missingIDs []uint
+	for _, id := range required {
+		if !done[id] {
+			missingIDs = append(missingIDs, id)
+		}
+	}
+	if len(missingIDs) == 0 {
+		return nil
+	}
+	var missing []models.Course
+	s.db.Select("id", "title", "slug").Where("id IN ?", missingIDs).Find(&missing)
+	return missing
+}
+
+// PathCourseProgress is one course of a path as seen by an enrolled student.
+type PathCourseProgress struct {
+	CourseID             uint       `json:"course_id"`
+	Title                string     `json:"title"`
+	Slug                 string     `json:"slug"`
+	Thumbnail            string     `json:"thumbnail"`
+	Position             int        `json:"position"`
+	PrerequisiteCourseID *uint      `json:"prerequisite_course_id"`
+	Locked               bool       `json:"locked"`
+	Enrolled             bool       `json:"enrolled"`
+	Status               string     `json:"status"`
+	ProgressPercentage   float64    `json:"progress_percentage"`
+	CompletedAt          *time.Time `json:"completed_at"`
+}
+
+// PathProgress is a student's progress through a learning path.
+type PathProgress struct {
+	Enrollment         models.LearningPathEnrollment `json:"enrollment"`
+	Courses            []PathCourseProgress          `json:"courses"`
+	CompletedCourses   int                           `json:"completed_courses"`
+	ProgressPercentage float64                       `json:"progress_percentage"`
+}
+
+// PathProgress works out the student's progress through the path: the
+// average of their course progress, with completed courses counting fully.
+func (s *CourseService) PathProgress(enrollment *models.LearningPathEnrollment, path *models.LearningPath) *PathProgress {
+	courseIDs := make([]uint, 0, len(path.Courses))
+	for _, pc := range path.Courses {
+		courseIDs = append(courseIDs, pc.CourseID)
+	}
+	var courseEnrollments []models.CourseEnrollment
+	if len(courseIDs) > 0 {
+		s.db.Where("contact_id = ? AND course_id IN ?", enrollment.ContactID, courseIDs).Find(&courseEnrollments)
+	}
+	byCourse := make(map[uint]models.CourseEnrollment, len(courseEnrollments))
+	for _, e := range courseEnrollments {
+		byCourse[e.CourseID] = e
+	}
+
+	prereqs := Prerequisites(path)
+	result := &PathProgress{Enrollment: *enrollment, Courses: make([]PathCourseProgress, 0, len(path.Courses))}
+	var total float64
+	for _, pc := range path.Courses {
+		row := PathCourseProgress{
+			CourseID:             pc.CourseID,
+			Title:                pc.Course.Title,
+			Slug:                 pc.Course.Slug,
+			Thumbnail:            pc.Course.Thumbnail,
+			Position:             pc.Position,
+			PrerequisiteCourseID: prereqs[pc.CourseID],
+		}
+		if e, ok := byCourse[pc.CourseID]; ok {
+			row.Enrolled = true
+			row.Status = e.Status
+			row.ProgressPercentage = e.ProgressPercentage
+			row.CompletedAt = e.CompletedAt
+			if e.Status == models.EnrollStatusCompleted {
+				row.ProgressPercentage = 100
+				result.CompletedCourses++
+			}
+		}
+		if prereq := prereqs[pc.CourseID]; prereq != nil {
+			row.Locked = byCourse[*prereq].Status != models.EnrollStatusCompleted
+		}
+		total += row.ProgressPercentage
+		result.Courses = append(result.Courses, row)
+	}
+	if len(path.Courses) > 0 {
+		result.ProgressPercentage = math.Round(total/float64(len(path.Courses))*100) / 100
+	}
+	return result
+}
+
+// UpdatePathProgress refreshes the contact's progress in every learning path
+// containing the course. Once all of a path's courses are completed the path
+// enrollment is completed and a path certificate issued.
+func (s *CourseService) UpdatePathProgress(courseEnrollment models.CourseEnrollment) {
+	var enrollments []models.LearningPathEnrollment
+	s.db.Joins("JOIN learning_path_courses ON learning_path_courses.path_id = learning_path_enrollments.path_id").
+		Where("learning_path_enrollments.contact_id = ? AND learning_path_courses.course_id = ? AND learning_path_enrollments.status = ?",
+			courseEnrollment.ContactID, courseEnrollment.CourseID, models.EnrollStatusActive).
+		Find(&enrollments)
+
+	for i := range enrollments {
+		enrollment := &enrollments[i]
+		path, err := s.LoadPath(enrollment.PathID)
+		if err != nil || len(path.Courses) == 0 {
+			continue
+		}
+		progress := s.PathProgress(enrollment, path)
+		updates := map[string]interface{}{"progress_percentage": progress.ProgressPercentage}
+
+		completed := progress.CompletedCourses == len(path.Courses)
+		if completed {
+			now := time.Now()
+			cert := s.generatePathCertificate(enrollment, path, courseEnrollment)
+			enrollment.Status = models.EnrollStatusCompleted
+			enrollment.CompletedAt = &now
+			enrollment.CertificateID = cert
+			updates["status"] = enrollment.Status
+			updates["completed_at"] = enrollment.CompletedAt
+			updates["certificate_id"] = cert
+		}
+		enrollment.ProgressPercentage = progress.ProgressPercentage
+		s.db.Model(enrollment).Updates(updates)
+		if completed {
+			events.Emit(events.LearningPathCompleted, *enrollment)
+		}
+	}
+}
+
+// generatePathCertificate issues the path certificate against the course
+// enrollment that completed the path, returning its ID.
+func (s *CourseService) generatePathCertificate(enrollment *models.LearningPathEnrollment, path *models.LearningPath, last models.CourseEnrollment) *uint {
+	var existing models.Certificate
+	if err := s.db.Where("learning_path_id = ? AND contact_id = ?", path.ID, enrollment.ContactID).First(&existing).Error; err == nil {
+		return &existing.ID
+	}
+	cert := models.Certificate{
+		TenantID:          enrollment.TenantID,
+		CourseID:          last.CourseID,
+		EnrollmentID:      last.ID,
+		ContactID:         enrollment.ContactID,
+		CertificateNumber: certificateNumber(),
+		IssuedAt:          time.Now(),
+		Template:          "default",
+		TemplateID:        path.CertificateTemplateID,
+		LearningPathID:    &path.ID,
+	}
+	if err := s.db.Create(&cert).Error; err != nil {
+		log.Printf("[courses] Failed to issue certificate for path %d, contact %d: %v", path.ID, enrollment.ContactID, err)
+		return nil
+	}
+	return &cert.ID
+}
+
+// EnrollInPath enrolls the contact in a learning path and each of its
+// courses they aren't enrolled in yet; the path must be loaded with
+// LoadPath. Courses already completed count towards the path straight away.
+// Newly created course enrollments are returned so CourseEnrolled can be
+// emitted.
+func EnrollInPath(db *gorm.DB, contactID uint, path *models.LearningPath, source string, now time.Time) (*models.LearningPathEnrollment, []models.CourseEnrollment, error) {
+	var pathEnrollment models.LearningPathEnrollment
+	var created []models.CourseEnrollment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		pathEnrollment, _, err = EnrollPath(tx, path.TenantID, contactID, path.ID, source, now)
+		if err != nil {
+			return err
+		}
+		for _, pc := range path.Courses {
+			var existing int64
+			tx.Model(&models.CourseEnrollment{}).Where("contact_id = ? AND course_id = ?", contactID, pc.CourseID).Count(&existing)
+			if existing > 0 {
+				continue
+			}
+			enrollment := models.CourseEnrollment{
+				TenantID:   path.TenantID,
+				ContactID:  contactID,
+				CourseID:   pc.CourseID,
+				Status:     models.EnrollStatusActive,
+				EnrolledAt: now,
+				Source:     source,
+			}
+			if err := tx.Create(&enrollment).Error; err != nil {
+				return err
+			}
+			if err := fulfillCohort(tx, &enrollment, nil, now); err != nil {
+				return err
+			}
+			created = append(created, enrollment)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Progress is credited against the most recently completed course, which
+	// also carries the path certificate if that finishes the path
+	courseIDs := make([]uint, 0, len(path.Courses))
+	for _, pc := range path.Courses {
+		courseIDs = append(courseIDs, pc.CourseID)
+	}
+	var latest models.CourseEnrollment
+	if len(courseIDs) > 0 && db.Where("contact_id = ? AND course_id IN ?", contactID, courseIDs).
+		Order("completed_at DESC NULLS LAST, id DESC").First(&latest).Error == nil {
+		NewCourseService(db).UpdatePathProgress(latest)
+		db.First(&pathEnrollment, pathEnrollment.ID)
+	}
+	return &pathEnrollment, created, nil
+}
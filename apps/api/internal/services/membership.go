@@ -0,0 +1,236 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/models"
+)
+
+// ErrMembershipRequired is returned when access depends on a membership the
+// contact doesn't hold, or that has lapsed.
+var ErrMembershipRequired = errors.New("an active membership is required")
+
+// AccessService answers whether a contact's memberships entitle them to a
+// course, space, guide or product. It is the single place student endpoints
+// ask.
+type AccessService struct {
+	db *gorm.DB
+}
+
+func NewAccessService(db *gorm.DB) *AccessService {
+	return &AccessService{db: db}
+}
+
+// subscriptionActive reports whether a subscription still grants access:
+// past due, paused and cancelled subscriptions don't, and one set to cancel
+// at period end lapses when the period ends.
+func subscriptionActive(sub models.Subscription, now time.Time) bool {
+	if sub.Status != models.SubscriptionActive {
+		return false
+	}
+	return !sub.CancelAtPeriodEnd || sub.CurrentPeriodEnd.IsZero() || now.Before(sub.CurrentPeriodEnd)
+}
+
+// ActiveMemberships returns the IDs of the membership products the contact
+// currently holds. A product sold by subscription follows its latest
+// subscription; otherwise the purchase grant counts until it expires after
+// MembershipDays.
+func (s *AccessService) ActiveMemberships(contactID uint, now time.Time) []uint {
+	var subs []models.Subscription
+	s.db.Where("contact_id = ?", contactID).Order("created_at ASC").Find(&subs)
+	latest := make(map[uint]models.Subscription, len(subs))
+	for _, sub := range subs {
+		latest[sub.ProductID] = sub
+	}
+
+	var grants []models.ContactProduct
+	s.db.Joins("JOIN products ON products.id = contact_products.product_id").
+		Where("contact_products.contact_id = ? AND products.type = ?", contactID, models.ProductTypeMembership).
+		Find(&grants)
+
+	var active []uint
+	for productID, sub := range latest {
+		if subscriptionActive(sub, now) {
+			active = append(active, productID)
+		}
+	}
+	for _, grant := range grants {
+		if _, bySubscription := latest[grant.ProductID]; bySubscription {
+			continue
+		}
+		if grant.Status == models.ContactProductStatusActive && (grant.ExpiresAt == nil || now.Before(*grant.ExpiresAt)) {
+			active = append(active, grant.ProductID)
+		}
+	}
+	return active
+}
+
+// HasAccess reports whether one of the contact's active memberships is
+// entitled to the resource.
+func (s *AccessService) HasAccess(contactID uint, resourceType string, resourceID uint, now time.Time) bool {
+	memberships := s.ActiveMemberships(contactID, now)
+	if len(memberships) == 0 {
+		return false
+	}
+	var count int64
+	s.db.Model(&models.MembershipEntitlement{}).
+		Where("resource_type = ? AND resource_id = ? AND membership_product_id IN ?", resourceType, resourceID, memberships).
+		Count(&count)
+	return count > 0
+}
+
+// Entitled returns the IDs of the resources of a type the contact's active
+// memberships unlock.
+func (s *AccessService) Entitled(contactID uint, resourceType string, now time.Time) []uint {
+	memberships := s.ActiveMemberships(contactID, now)
+	if len(memberships) == 0 {
+		return nil
+	}
+	var ids []uint
+	s.db.Model(&models.MembershipEntitlement{}).
+		Where("resource_type = ? AND membership_product_id IN ?", resourceType, memberships).
+		Distinct().Pluck("resource_id", &ids)
+	return ids
+}
+
+// CheckEnrollment returns ErrMembershipRequired when the enrollment came
+// from a membership that is no longer active. Purchased and manual
+// enrollments are unaffected.
+func (s *AccessService) CheckEnrollment(enrollment *models.CourseEnrollment, now time.Time) error {
+	if enrollment.Source != "membership" {
+		return nil
+	}
+	if !s.HasAccess(enrollment.ContactID, models.EntitlementCourse, enrollment.CourseID, now) {
+		return ErrMembershipRequired
+	}
+	return nil
+}
+
+// CanAccessSpace reports whether the contact may read and post in a space:
+// public spaces are open, and private or paid spaces are open to entitled
+// members. A membership row only counts while whatever granted it is live:
+// a purchased seat in a paid space lapses with the grant of its product and
+// a cohort seat with the enrollment in the cohort. Admins and moderators of
+// the space, and members added by hand, keep access.
+func (s *AccessService) CanAccessSpace(contactID uint, space *models.Space, now time.Time) bool {
+	if space.Type == models.SpaceTypePublic {
+		return true
+	}
+	if s.HasAccess(contactID, models.EntitlementSpace, space.ID, now) {
+		return true
+	}
+	var member models.CommunityMember
+	if err := s.db.Where("space_id = ? AND contact_id = ?", space.ID, contactID).First(&member).Error; err != nil {
+		return false
+	}
+	if member.Role != models.MemberRoleMember {
+		return true
+	}
+	if space.ProductID != nil {
+		if s.CanAccessProduct(contactID, *space.ProductID, now) {
+			return true
+		}
+		// Only a lapsed grant that was still running when they joined shows
+		// the seat was bought; members added by hand have none
+		var lapsed int64
+		s.db.Model(&models.ContactProduct{}).
+			Where("contact_id = ? AND product_id = ? AND (expires_at IS NULL OR expires_at > ?)", contactID, *space.ProductID, member.JoinedAt).
+			Count(&lapsed)
+		return lapsed == 0
+	}
+
+	var cohortIDs []uint
+	s.db.Model(&models.CourseCohort{}).Where("space_id = ?", space.ID).Pluck("id", &cohortIDs)
+	if len(cohortIDs) == 0 {
+		return true
+	}
+	var enrollments []models.CourseEnrollment
+	s.db.Where("contact_id = ? AND cohort_id IN ? AND status IN ?", contactID, cohortIDs,
+		[]string{models.EnrollStatusActive, models.EnrollStatusCompleted}).Find(&enrollments)
+	for i := range enrollments {
+		if s.CheckEnrollment(&enrollments[i], now) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessProduct reports whether the contact may download a product's
+// files: they bought it and the grant hasn't expired, or one of their
+// memberships unlocks it.
+func (s *AccessService) CanAccessProduct(contactID, productID uint, now time.Time) bool {
+	var grants int64
+	s.db.Model(&models.ContactProduct{}).
+		Where("contact_id = ? AND product_id = ? AND status = ? AND (expires_at IS NULL OR expires_at > ?)",
+			contactID, productID, models.ContactProductStatusActive, now).
+		Count(&grants)
+	if grants > 0 {
+		return true
+	}
+	return s.HasAccess(contactID, models.EntitlementProduct, productID, now)
+}
+
+// ExpireMemberships marks purchase grants whose MembershipDays have lapsed
+// as expired, returning how many were updated. Access is checked against
+// the expiry date regardless; this keeps statuses accurate for reporting.
+func (s *AccessService) ExpireMemberships(now time.Time) (int64, error) {
+	res := s.db.Model(&models.ContactProduct{}).
+		Where("status = ? AND expires_at IS NOT NULL AND expires_at <= ?", models.ContactProductStatusActive, now).
+		Update("status", models.ContactProductStatusExpired)
+	return res.RowsAffected, res.Error
+}
+
+// MembershipAccess is an active membership with what it unlocks.
+type MembershipAccess struct {
+	Product  models.Product        `json:"product"`
+	Courses  []models.Course       `json:"courses"`
+	Spaces   []models.Space        `json:"spaces"`
+	Guides   []models.PremiumGuide `json:"guides"`
+	Products []models.Product      `json:"products"`
+}
+
+// Memberships lists the contact's active memberships with the courses,
+// spaces, guides and products each one unlocks.
+func (s *AccessService) Memberships(contactID uint, now time.Time) []MembershipAccess {
+	ids := s.ActiveMemberships(contactID, now)
+	result := make([]MembershipAccess, 0, len(ids))
+	if len(ids) == 0 {
+		return result
+	}
+
+	var products []models.Product
+	s.db.Where("id IN ?", ids).Order("name ASC").Find(&products)
+	for _, product := range products {
+		var entitlements []models.MembershipEntitlement
+		s.db.Where("membership_product_id = ?", product.ID).Find(&entitlements)
+		byType := make(map[string][]uint)
+		for _, e := range entitlements {
+			byType[e.ResourceType] = append(byType[e.ResourceType], e.ResourceID)
+		}
+
+		access := MembershipAccess{
+			Product:  product,
+			Courses:  []models.Course{},
+			Spaces:   []models.Space{},
+			Guides:   []models.PremiumGuide{},
+			Products: []models.Product{},
+		}
+		if ids := byType[models.EntitlementCourse]; len(ids) > 0 {
+			s.db.Where("id IN ? AND status = ?", ids, models.CourseStatusPublished).Order("title ASC").Find(&access.Courses)
+		}
+		if ids := byType[models.EntitlementSpace]; len(ids) > 0 {
+			s.db.Where("id IN ?", ids).Order("sort_order ASC").Find(&access.Spaces)
+		}
+		if ids := byType[models.EntitlementGuide]; len(ids) > 0 {
+			s.db.Where("id IN ? AND status = ?", ids, models.GuideStatusPublished).Order("sort_order ASC").Find(&access.Guides)
+		}
+		if ids := byType[models.EntitlementProduct]; len(ids) > 0 {
+			s.db.Where("id IN ?", ids).Order("name ASC").Find(&access.Products)
+		}
+		result = append(result, access)
+	}
+	return result
+}
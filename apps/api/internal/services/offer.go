@@ -0,0 +1,430 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/payments"
+)
+
+var (
+	// ErrOfferUnavailable is returned when a funnel offer doesn't apply to the order.
+	ErrOfferUnavailable = errors.New("this offer is not available for the order")
+	// ErrOfferTaken is returned when the customer already bought the offer.
+	ErrOfferTaken = errors.New("this offer has already been purchased")
+	// ErrOneClickUnavailable is returned when the original payment can't be
+	// charged again; the frontend should fall back to a normal checkout.
+	ErrOneClickUnavailable = errors.New("one-click payment is not available for this order")
+)
+
+// OfferPaymentError is returned when the saved card was declined or needs
+// the customer to authenticate.
+type OfferPaymentError struct {
+	Reason string
+}
+
+func (e *OfferPaymentError) Error() string {
+	if e.Reason == "" {
+		return "payment for the offer failed"
+	}
+	return "payment for the offer failed: " + e.Reason
+}
+
+// OfferService prices order bumps at checkout and sells funnel upsell and
+// downsell steps one-click, charging the payment method saved at checkout.
+type OfferService struct {
+	db        *gorm.DB
+	providers *payments.Registry
+	fulfiller OrderFulfiller
+}
+
+func NewOfferService(db *gorm.DB, providers *payments.Registry) *OfferService {
+	return &OfferService{db: db, providers: providers, fulfiller: NewFulfillmentService(db)}
+}
+
+// BumpsFor returns the active order bumps offered with a product and price.
+func (s *OfferService) BumpsFor(tenantID, productID, priceID uint) []models.OrderBump {
+	var bumps []models.OrderBump
+	s.db.Preload("OfferProduct").Preload("OfferPrice").
+		Where("tenant_id = ? AND product_id = ? AND active = ?", tenantID, productID, true).
+		Where("price_id IS NULL OR price_id = ?", priceID).
+		Order("sort_order ASC, id ASC").Find(&bumps)
+	return bumps
+}
+
+// BumpAmount returns what a bump costs in the given currency.
+func (s *OfferService) BumpAmount(tenantID uint, bump models.OrderBump, currency string) float64 {
+	return s.offerAmount(tenantID, bump.Amount, bump.OfferPrice, currency)
+}
+
+// BumpItems turns the bumps the customer ticked into order items priced in
+// the order currency. Unknown or inactive bump IDs are an error.
+func (s *OfferService) BumpItems(tenantID, productID, priceID uint, bumpIDs []uint, currency string) ([]models.OrderItem, error) {
+	if len(bumpIDs) == 0 {
+		return nil, nil
+	}
+	offered := make(map[uint]models.OrderBump)
+	for _, b := range s.BumpsFor(tenantID, productID, priceID) {
+		offered[b.ID] = b
+	}
+
+	var items []models.OrderItem
+	seen := make(map[uint]bool)
+	for _, id := range bumpIDs {
+		bump, ok := offered[id]
+		if !ok {
+			return nil, fmt.Errorf("order bump %d is not available for this product", id)
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		amount := s.BumpAmount(tenantID, bump, currency)
+		bumpID, offerProductID, offerPriceID := bump.ID, bump.OfferProductID, bump.OfferPriceID
+		items = append(items, models.OrderItem{
+			TenantID:  tenantID,
+			ProductID: &offerProductID,
+			PriceID:   &offerPriceID,
+			BumpID:    &bumpID,
+			Quantity:  1,
+			UnitPrice: amount,
+			Total:     amount,
+		})
+	}
+	return items, nil
+}
+
+// offerAmount resolves a special offer price (in the offer price's own
+// currency, 0 = the regular price) into the order currency.
+func (s *OfferService) offerAmount(tenantID uint, special float64, price *models.Price, currency string) float64 {
+	fx := NewCurrencyService(s.db)
+	if price == nil {
+		return special
+	}
+	if special <= 0 {
+		amount, _ := fx.PriceIn(tenantID, *price, currency)
+		return amount
+	}
+	if converted, err := fx.Convert(tenantID, special, price.Currency, currency); err == nil {
+		return converted
+	}
+	return special
+}
+
+// funnelSteps returns the steps of the funnel a step belongs to, in order.
+func (s *OfferService) funnelSteps(stepID uint) []models.FunnelStep {
+	var step models.FunnelStep
+	if err := s.db.First(&step, stepID).Error; err != nil {
+		return nil
+	}
+	var steps []models.FunnelStep
+	s.db.Where("funnel_id = ?", step.FunnelID).Order("sort_order ASC, id ASC").Find(&steps)
+	return steps
+}
+
+func isOfferStep(step models.FunnelStep) bool {
+	return (step.Type == models.FunnelStepTypeUpsell || step.Type == models.FunnelStepTypeDownsell) && step.ProductID != nil
+}
+
+// WantsSavedCard reports whether a checkout on this funnel step is followed
+// by one-click offers, so the payment method should be kept on file.
+func (s *OfferService) WantsSavedCard(stepID *uint) bool {
+	if stepID == nil {
+		return false
+	}
+	after := false
+	for _, step := range s.funnelSteps(*stepID) {
+		if step.ID == *stepID {
+			after = true
+			continue
+		}
+		if after && isOfferStep(step) {
+			return true
+		}
+	}
+	return false
+}
+
+// RootOrder returns the checkout order an upsell order hangs off (or the order itself).
+func (s *OfferService) RootOrder(order *models.Order) *models.Order {
+	if order.ParentOrderID == nil {
+		return order
+	}
+	var root models.Order
+	if err := s.db.First(&root, *order.ParentOrderID).Error; err != nil {
+		return order
+	}
+	return &root
+}
+
+// NextOffer returns the next upsell or downsell step to show after the
+// customer handled the step `afterStepID` (the checkout step or a previous
+// offer). Accepting an upsell skips the downsells that directly follow it;
+// declining shows them. Offers already bought are skipped. Returns nil when
+// the funnel has no more offers.
+func (s *OfferService) NextOffer(root *models.Order, afterStepID uint, accepted bool) *models.FunnelStep {
+	if root.FunnelStepID == nil {
+		return nil
+	}
+	var bought []uint
+	s.db.Model(&models.Order{}).Where("parent_order_id = ? AND status IN ?", root.ID,
+		[]string{models.OrderStatusPaid, models.OrderStatusPending}).Pluck("funnel_step_id", &bought)
+	taken := make(map[uint]bool, len(bought))
+	for _, id := range bought {
+		taken[id] = true
+	}
+
+	steps := s.funnelSteps(*root.FunnelStepID)
+	pos := -1
+	for i, step := range steps {
+		if step.ID == afterStepID {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		return nil
+	}
+
+	skipDownsells := accepted
+	for _, step := range steps[pos+1:] {
+		if step.Type == models.FunnelStepTypeThankyou {
+			return nil
+		}
+		if skipDownsells && step.Type == models.FunnelStepTypeDownsell {
+			continue
+		}
+		skipDownsells = false
+		if isOfferStep(step) && !taken[step.ID] {
+			s.db.Preload("Product").Preload("Price").First(&step, step.ID)
+			return &step
+		}
+	}
+	return nil
+}
+
+// OfferPrice returns the price of an offer step and its amount in the
+// order currency.
+func (s *OfferService) OfferPrice(root *models.Order, step *models.FunnelStep) (*models.Price, float64, error) {
+	var price models.Price
+	q := s.db.Where("product_id = ?", *step.ProductID)
+	if step.PriceID != nil {
+		q = q.Where("id = ?", *step.PriceID)
+	}
+	if err := q.Order("sort_order ASC").First(&price).Error; err != nil {
+		return nil, 0, ErrOfferUnavailable
+	}
+	return &price, s.offerAmount(root.TenantID, step.OfferAmount, &price, root.Currency), nil
+}
+
+// AcceptOffer buys an upsell/downsell step for the customer of a paid
+// checkout order, charging the payment method saved at checkout. The
+// follow-on order is fulfilled straight away when the charge succeeds, or
+// by the provider webhook when it is still processing.
+func (s *OfferService) AcceptOffer(ctx context.Context, root *models.Order, stepID uint) (*models.Order, error) {
+	if root.Status != models.OrderStatusPaid || root.FunnelStepID == nil || root.PaymentID == "" {
+		return nil, ErrOfferUnavailable
+	}
+
+	var step models.FunnelStep
+	if err := s.db.Preload("Product").First(&step, stepID).Error; err != nil || !isOfferStep(step) {
+		return nil, ErrOfferUnavailable
+	}
+	inFunnel := false
+	for _, st := range s.funnelSteps(*root.FunnelStepID) {
+		if st.ID == step.ID {
+			inFunnel = true
+		}
+	}
+	if !inFunnel {
+		return nil, ErrOfferUnavailable
+	}
+
+	provider, err := s.providers.Provider(root.TenantID, root.PaymentProvider)
+	if err != nil {
+		return nil, ErrOneClickUnavailable
+	}
+	charger, ok := provider.(payments.OffSessionCharger)
+	if !ok {
+		return nil, ErrOneClickUnavailable
+	}
+	method, err := charger.SavedPaymentMethod(ctx, root.PaymentID)
+	if err != nil {
+		return nil, ErrOneClickUnavailable
+	}
+
+	price, amount, err := s.OfferPrice(root, &step)
+	if err != nil {
+		return nil, err
+	}
+	if amount <= 0 {
+		return nil, ErrOfferUnavailable
+	}
+
+	rootID, stepRef, productID, priceID := root.ID, step.ID, *step.ProductID, price.ID
+	order := models.Order{
+		TenantID:           root.TenantID,
+		ContactID:          root.ContactID,
+		OrderNumber:        fmt.Sprintf("%s-U%d", root.OrderNumber, step.ID),
+		Status:             models.OrderStatusPending,
+		Subtotal:           amount,
+		Total:              amount,
+		Currency:           root.Currency,
+		PaymentProvider:    root.PaymentProvider,
+		FunnelStepID:       &stepRef,
+		ParentOrderID:      &rootID,
+		AffiliateAccountID: root.AffiliateAccountID,
+		AffiliateLinkID:    root.AffiliateLinkID,
+		IPAddress:          root.IPAddress,
+		PaymentFingerprint: root.PaymentFingerprint, // same saved card
+		Items: []models.OrderItem{{
+			TenantID:  root.TenantID,
+			ProductID: &productID,
+			PriceID:   &priceID,
+			Quantity:  1,
+			UnitPrice: amount,
+			Total:     amount,
+		}},
+	}
+	// Lock the checkout order so two clicks on the same offer serialise here
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.Order{}, root.ID).Error; err != nil {
+			return err
+		}
+		var existing int64
+		if err := tx.Model(&models.Order{}).Where("parent_order_id = ? AND funnel_step_id = ? AND status IN ?", root.ID, step.ID,
+			[]string{models.OrderStatusPaid, models.OrderStatusPending}).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return ErrOfferTaken
+		}
+		// A declined attempt left a failed order with this number; retries get a fresh one
+		var attempts int64
+		if err := tx.Unscoped().Model(&models.Order{}).Where("parent_order_id = ? AND funnel_step_id = ?", root.ID, step.ID).
+			Count(&attempts).Error; err != nil {
+			return err
+		}
+		if attempts > 0 {
+			order.OrderNumber = fmt.Sprintf("%s-%d", order.OrderNumber, attempts+1)
+		}
+		return tx.Create(&order).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var email, name string
+	var contact models.Contact
+	if s.db.First(&contact, root.ContactID).Error == nil {
+		email = contact.Email
+		name = strings.TrimSpace(contact.FirstName + " " + contact.LastName)
+	}
+	description := ""
+	if step.Product != nil {
+		description = step.Product.Name
+	}
+
+	res, err := charger.ChargeSaved(ctx, payments.PaymentRequest{
+		OrderID:       order.ID,
+		OrderNumber:   order.OrderNumber,
+		Amount:        math.Round(amount),
+		Currency:      order.Currency,
+		Description:   description,
+		CustomerEmail: email,
+		CustomerName:  name,
+		Metadata: map[string]string{
+			"contact_id":      fmt.Sprintf("%d", order.ContactID),
+			"parent_order_id": fmt.Sprintf("%d", root.ID),
+			"funnel_step_id":  fmt.Sprintf("%d", step.ID),
+		},
+	}, *method)
+	if err != nil {
+		s.failOrder(&order, err.Error())
+		return &order, err
+	}
+	if res.PaymentID != "" {
+		order.PaymentID = res.PaymentID
+		s.db.Model(&order).Update("payment_id", res.PaymentID)
+	}
+
+	switch res.Status {
+	case payments.StatusSucceeded:
+		paid, _, err := s.fulfiller.FulfillPaidOrder(order.ID, PaymentConfirmation{
+			Provider:  order.PaymentProvider,
+			PaymentID: res.PaymentID,
+			Reference: res.Reference,
+		})
+		if err != nil {
+			return &order, err
+		}
+		return paid, nil
+	case payments.StatusFailed:
+		s.failOrder(&order, res.FailureReason)
+		return &order, &OfferPaymentError{Reason: res.FailureReason}
+	}
+	return &order, nil // processing — the webhook fulfills it
+}
+
+func (s *OfferService) failOrder(order *models.Order, reason string) {
+	order.Status = models.OrderStatusFailed
+	s.db.Model(order).Update("status", order.Status)
+	log.Printf("[offers] Upsell order %d payment failed (%s: %s) %s", order.ID, order.PaymentProvider, order.PaymentID, reason)
+}
+
+// recordFunnelConversions records what a paid funnel order converted: the
+// checkout/offer step itself and every order bump on it. Values are in the
+// base currency when the order's rate has been captured.
+func recordFunnelConversions(tx *gorm.DB, order *models.Order, now time.Time) ([]models.FunnelConversion, error) {
+	var step models.FunnelStep
+	if err := tx.First(&step, *order.FunnelStepID).Error; err != nil {
+		return nil, nil // step deleted since checkout
+	}
+	rate := order.ExchangeRate
+	if rate == 0 {
+		rate = 1
+	}
+	contactID := order.ContactID
+	orderID := order.ID
+
+	convType := "purchase"
+	if step.Type == models.FunnelStepTypeUpsell || step.Type == models.FunnelStepTypeDownsell {
+		convType = step.Type
+	}
+
+	var bumpTotal float64
+	var conversions []models.FunnelConversion
+	for _, item := range order.Items {
+		if item.BumpID == nil {
+			continue
+		}
+		bumpTotal += item.Total
+		conversions = append(conversions, models.FunnelConversion{
+			TenantID: order.TenantID, FunnelID: step.FunnelID, StepID: step.ID, ContactID: &contactID, OrderID: &orderID,
+			Type: "bump", Value: int64(math.Round(item.Total * rate)), ConvertedAt: now,
+		})
+	}
+	main := order.Total - bumpTotal
+	if main < 0 {
+		main = 0
+	}
+	conversions = append([]models.FunnelConversion{{
+		TenantID: order.TenantID, FunnelID: step.FunnelID, StepID: step.ID, ContactID: &contactID, OrderID: &orderID,
+		Type: convType, Value: int64(math.Round(main * rate)), ConvertedAt: now,
+	}}, conversions...)
+
+	if err := tx.Create(&conversions).Error; err != nil {
+		return nil, err
+	}
+	return conversions, nil
+}
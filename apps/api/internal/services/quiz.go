@@ -0,0 +1,396 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"gritcms/apps/api/internal/models"
+)
+
+// Quiz errors.
+var (
+	ErrMaxAttempts    = errors.New("maximum attempts reached")
+	ErrAttemptExpired = errors.New("the time limit for this attempt has passed")
+	ErrNoOpenAttempt  = errors.New("start the quiz before submitting")
+	ErrExternalQuiz   = errors.New("this quiz is scored by the lesson's content")
+)
+
+// quizSubmitGrace allows for network latency on timed attempts.
+const quizSubmitGrace = 30 * time.Second
+
+// QuizAnswer is one answer submitted by a student.
+type QuizAnswer struct {
+	QuestionID uint   `json:"question_id"`
+	Answer     string `json:"answer"`
+}
+
+// gradedAnswer is stored on the attempt.
+type gradedAnswer struct {
+	QuestionID uint   `json:"question_id"`
+	Answer     string `json:"answer"`
+	Correct    bool   `json:"correct"`
+	Points     int    `json:"points"`
+}
+
+// StudentQuestion is a question without its answer or explanation.
+type StudentQuestion struct {
+	ID       uint           `json:"id"`
+	Question string         `json:"question"`
+	Type     string         `json:"type"`
+	Options  datatypes.JSON `json:"options"`
+	Points   int            `json:"points"`
+}
+
+// StudentQuiz is a quiz as served to a student, in the order of their
+// current attempt.
+type StudentQuiz struct {
+	ID               uint                `json:"id"`
+	LessonID         uint                `json:"lesson_id"`
+	Title            string              `json:"title"`
+	Description      string              `json:"description"`
+	PassingScore     int                 `json:"passing_score"`
+	MaxAttempts      int                 `json:"max_attempts"`
+	TimeLimitMinutes int                 `json:"time_limit_minutes"`
+	AttemptsUsed     int64               `json:"attempts_used"`
+	Passed           bool                `json:"passed"`
+	BestScore        float64             `json:"best_score"`
+	Attempt          *models.QuizAttempt `json:"attempt"` // open attempt, if any
+	Questions        []StudentQuestion   `json:"questions"`
+}
+
+// QuestionResult is the outcome of one question. CorrectAnswer and
+// Explanation are only set when the quiz shows correct answers.
+type QuestionResult struct {
+	QuestionID    uint   `json:"question_id"`
+	Correct       bool   `json:"correct"`
+	Points        int    `json:"points"`
+	CorrectAnswer string `json:"correct_answer,omitempty"`
+	Explanation   string `json:"explanation,omitempty"`
+}
+
+// QuizResult is a graded attempt.
+type QuizResult struct {
+	Attempt      *models.QuizAttempt `json:"attempt"`
+	Score        float64             `json:"score"`
+	Passed       bool                `json:"passed"`
+	TotalPoints  int                 `json:"total_points"`
+	EarnedPoints int                 `json:"earned_points"`
+	Questions    []QuestionResult    `json:"questions,omitempty"`
+}
+
+// QuizService serves quizzes to students and grades their attempts.
+type QuizService struct {
+	db *gorm.DB
+}
+
+// NewQuizService creates a quiz service.
+func NewQuizService(db *gorm.DB) *QuizService {
+	return &QuizService{db: db}
+}
+
+// grade scores answers against the quiz's questions by Points. Every
+// question type is matched case-insensitively on the trimmed answer.
+func grade(quiz *models.Quiz, answers []QuizAnswer) (QuizResult, []gradedAnswer) {
+	byQuestion := map[uint]string{}
+	for _, a := range answers {
+		byQuestion[a.QuestionID] = a.Answer
+	}
+
+	var result QuizResult
+	graded := make([]gradedAnswer, 0, len(quiz.Questions))
+	for _, q := range quiz.Questions {
+		result.TotalPoints += q.Points
+		answer, answered := byQuestion[q.ID]
+		correct := answered && strings.EqualFold(strings.TrimSpace(answer), strings.TrimSpace(q.CorrectAnswer))
+		points := 0
+		if correct {
+			points = q.Points
+			result.EarnedPoints += q.Points
+		}
+		graded = append(graded, gradedAnswer{QuestionID: q.ID, Answer: answer, Correct: correct, Points: points})
+
+		qr := QuestionResult{QuestionID: q.ID, Correct: correct, Points: points}
+		if quiz.ShowCorrectAnswers {
+			qr.CorrectAnswer = q.CorrectAnswer
+			qr.Explanation = q.Explanation
+		}
+		result.Questions = append(result.Questions, qr)
+	}
+
+	if result.TotalPoints > 0 {
+		result.Score = float64(result.EarnedPoints) / float64(result.TotalPoints) * 100
+	}
+	result.Passed = result.Score >= float64(quiz.PassingScore)
+	if !quiz.ShowCorrectAnswers {
+		result.Questions = nil
+	}
+	return result, graded
+}
+
+// openAttempt returns the enrollment's unfinished attempt at the quiz.
+func (s *QuizService) openAttempt(quizID, enrollmentID uint) *models.QuizAttempt {
+	var attempt models.QuizAttempt
+	if err := s.db.Where("quiz_id = ? AND enrollment_id = ? AND completed_at IS NULL", quizID, enrollmentID).
+		Order("started_at DESC").First(&attempt).Error; err != nil {
+		return nil
+	}
+	return &attempt
+}
+
+// expired reports whether a timed attempt is past its limit.
+func expired(attempt *models.QuizAttempt, now time.Time) bool {
+	return attempt.ExpiresAt != nil && now.After(attempt.ExpiresAt.Add(quizSubmitGrace))
+}
+
+// closeExpired records an unsubmitted timed-out attempt as a failed one.
+func (s *QuizService) closeExpired(attempt *models.QuizAttempt) {
+	s.db.Model(attempt).Updates(map[string]interface{}{
+		"completed_at": attempt.ExpiresAt,
+		"score":        0,
+		"passed":       false,
+		"timed_out":    true,
+	})
+}
+
+// ForStudent returns the quiz without answers, shuffled for the student's
+// open attempt when the quiz asks for it.
+func (s *QuizService) ForStudent(quiz *models.Quiz, enrollmentID uint) StudentQuiz {
+	out := StudentQuiz{
+		ID:               quiz.ID,
+		LessonID:         quiz.LessonID,
+		Title:            quiz.Title,
+		Description:      quiz.Description,
+		PassingScore:     quiz.PassingScore,
+		MaxAttempts:      quiz.MaxAttempts,
+		TimeLimitMinutes: quiz.TimeLimitMinutes,
+	}
+	s.db.Model(&models.QuizAttempt{}).Where("quiz_id = ? AND enrollment_id = ?", quiz.ID, enrollmentID).Count(&out.AttemptsUsed)
+	s.db.Model(&models.QuizAttempt{}).Where("quiz_id = ? AND enrollment_id = ? AND completed_at IS NOT NULL", quiz.ID, enrollmentID).
+		Select("COALESCE(MAX(score), 0)").Scan(&out.BestScore)
+	out.Passed = out.BestScore >= float64(quiz.PassingScore) && out.AttemptsUsed > 0
+
+	seed := int64(quiz.ID)
+	if attempt := s.openAttempt(quiz.ID, enrollmentID); attempt != nil {
+		if expired(attempt, time.Now()) {
+			s.closeExpired(attempt)
+		} else {
+			out.Attempt = attempt
+			seed = attempt.Seed
+		}
+	}
+
+	questions := append([]models.QuizQuestion(nil), quiz.Questions...)
+	rng := rand.New(rand.NewSource(seed))
+	if quiz.ShuffleQuestions {
+		rng.Shuffle(len(questions), func(i, j int) { questions[i], questions[j] = questions[j], questions[i] })
+	}
+	for _, q := range questions {
+		options := q.Options
+		if quiz.ShuffleOptions {
+			if shuffled, err := shuffleOptions(q.Options, rng); err == nil {
+				options = shuffled
+			}
+		}
+		out.Questions = append(out.Questions, StudentQuestion{
+			ID:       q.ID,
+			Question: q.Question,
+			Type:     q.Type,
+			Options:  options,
+			Points:   q.Points,
+		})
+	}
+	return out
+}
+
+func shuffleOptions(raw datatypes.JSON, rng *rand.Rand) (datatypes.JSON, error) {
+	if len(raw) == 0 {
+		return raw, nil
+	}
+	var options []json.RawMessage
+	if err := json.Unmarshal(raw, &options); err != nil {
+		return nil, err
+	}
+	rng.Shuffle(len(options), func(i, j int) { options[i], options[j] = options[j], options[i] })
+	return json.Marshal(options)
+}
+
+// lockAttempts runs fn with the enrollment's row locked and a service bound
+// to the transaction, so attempts are counted, opened and graded one request
+// at a time. fn's result is returned after the transaction commits; only
+// database errors roll it back, so an expired attempt closed on the way to
+// ErrMaxAttempts or ErrAttemptExpired stays closed.
+func (s *QuizService) lockAttempts(enrollmentID uint, fn func(tx *QuizService) error) error {
+	var result error
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").
+			First(&models.CourseEnrollment{}, enrollmentID).Error; err != nil {
+			return err
+		}
+		result = fn(&QuizService{db: tx})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return result
+}
+
+// Start opens an attempt, or returns the one already in progress. Timed
+// quizzes get an expiry; every started attempt counts towards MaxAttempts.
+func (s *QuizService) Start(quiz *models.Quiz, enrollmentID uint, now time.Time) (*models.QuizAttempt, error) {
+	if quiz.External {
+		return nil, ErrExternalQuiz
+	}
+	var attempt *models.QuizAttempt
+	err := s.lockAttempts(enrollmentID, func(tx *QuizService) error {
+		var err error
+		attempt, err = tx.start(quiz, enrollmentID, now)
+		return err
+	})
+	return attempt, err
+}
+
+// start opens an attempt with the enrollment locked.
+func (s *QuizService) start(quiz *models.Quiz, enrollmentID uint, now time.Time) (*models.QuizAttempt, error) {
+	if attempt := s.openAttempt(quiz.ID, enrollmentID); attempt != nil {
+		if !expired(attempt, now) {
+			return attempt, nil
+		}
+		s.closeExpired(attempt)
+	}
+
+	if quiz.MaxAttempts > 0 {
+		var used int64
+		s.db.Model(&models.QuizAttempt{}).Where("quiz_id = ? AND enrollment_id = ?", quiz.ID, enrollmentID).Count(&used)
+		if int(used) >= quiz.MaxAttempts {
+			return nil, ErrMaxAttempts
+		}
+	}
+
+	attempt := models.QuizAttempt{
+		TenantID:     quiz.TenantID,
+		QuizID:       quiz.ID,
+		EnrollmentID: enrollmentID,
+		Seed:         now.UnixNano(),
+		StartedAt:    now,
+	}
+	if quiz.TimeLimitMinutes > 0 {
+		expires := now.Add(time.Duration(quiz.TimeLimitMinutes) * time.Minute)
+		attempt.ExpiresAt = &expires
+	}
+	if err := s.db.Create(&attempt).Error; err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// Submit grades the student's answers on their open attempt. Untimed quizzes
+// may be submitted without starting first.
+func (s *QuizService) Submit(quiz *models.Quiz, enrollmentID uint, answers []QuizAnswer, now time.Time) (*QuizResult, error) {
+	if quiz.External {
+		return nil, ErrExternalQuiz
+	}
+	var result QuizResult
+	err := s.lockAttempts(enrollmentID, func(tx *QuizService) error {
+		attempt := tx.openAttempt(quiz.ID, enrollmentID)
+		if attempt == nil {
+			if quiz.TimeLimitMinutes > 0 {
+				return ErrNoOpenAttempt
+			}
+			var err error
+			if attempt, err = tx.start(quiz, enrollmentID, now); err != nil {
+				return err
+			}
+		}
+		if expired(attempt, now) {
+			tx.closeExpired(attempt)
+			return ErrAttemptExpired
+		}
+
+		var graded []gradedAnswer
+		result, graded = grade(quiz, answers)
+		answersJSON, _ := json.Marshal(graded)
+		attempt.Answers = answersJSON
+		attempt.Score = result.Score
+		attempt.Passed = result.Passed
+		attempt.CompletedAt = &now
+		if err := tx.db.Model(attempt).Updates(map[string]interface{}{
+			"answers":      attempt.Answers,
+			"score":        attempt.Score,
+			"passed":       attempt.Passed,
+			"completed_at": now,
+		}).Error; err != nil {
+			return err
+		}
+		result.Attempt = attempt
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Passing the lesson's last required quiz (and assignment) completes the lesson
+	if result.Passed {
+		courses := NewCourseService(s.db)
+		lessonIDs := []uint{quiz.LessonID}
+		if courses.requiredQuizzesPassed(enrollmentID, lessonIDs) && courses.requiredAssignmentsPassed(enrollmentID, lessonIDs) {
+			courses.CompleteLesson(enrollmentID, quiz.LessonID, false)
+		}
+	}
+	return &result, nil
+}
+
+// StudentAttempts lists an enrollment's attempts at a quiz. Stored answers
+// are only returned when the quiz shows correct answers.
+func (s *QuizService) StudentAttempts(quiz *models.Quiz, enrollmentID uint) []models.QuizAttempt {
+	var attempts []models.QuizAttempt
+	s.db.Where("quiz_id = ? AND enrollment_id = ?", quiz.ID, enrollmentID).Order("started_at DESC").Find(&attempts)
+	if !quiz.ShowCorrectAnswers {
+		for i := range attempts {
+			attempts[i].Answers = nil
+		}
+	}
+	return attempts
+}
+
+// Record grades answers into a new completed attempt, for attempts entered
+// on a student's behalf. The time limit doesn't apply; MaxAttempts does.
+func (s *QuizService) Record(quiz *models.Quiz, enrollmentID uint, answers []QuizAnswer, now time.Time) (*QuizResult, error) {
+	if quiz.External {
+		return nil, ErrExternalQuiz
+	}
+	result, graded := grade(quiz, answers)
+	answersJSON, _ := json.Marshal(graded)
+	attempt := models.QuizAttempt{
+		TenantID:     quiz.TenantID,
+		QuizID:       quiz.ID,
+		EnrollmentID: enrollmentID,
+		Answers:      answersJSON,
+		Score:        result.Score,
+		Passed:       result.Passed,
+		StartedAt:    now,
+		CompletedAt:  &now,
+	}
+	err := s.lockAttempts(enrollmentID, func(tx *QuizService) error {
+		if quiz.MaxAttempts > 0 {
+			var used int64
+			tx.db.Model(&models.QuizAttempt{}).Where("quiz_id = ? AND enrollment_id = ?", quiz.ID, enrollmentID).Count(&used)
+			if int(used) >= quiz.MaxAttempts {
+				return ErrMaxAttempts
+			}
+		}
+		return tx.db.Create(&attempt).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Attempt = &attempt
+	return &result, nil
+}
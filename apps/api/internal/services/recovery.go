@@ -0,0 +1,413 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/events"
+	"gritcms/apps/api/internal/models"
+)
+
+// Setting keys (group "commerce") for abandoned checkout recovery.
+const (
+	SettingAbandonedEnabled     = "abandoned_checkout_enabled"       // "false" turns recovery off
+	SettingAbandonedDelay       = "abandoned_checkout_delay_minutes" // pending this long = abandoned
+	SettingAbandonedEmailHours  = "abandoned_checkout_email_hours"   // e.g. "1,24,72" after abandonment
+	SettingAbandonedCouponID    = "abandoned_checkout_coupon_id"     // template coupon for single-use codes
+	SettingAbandonedCouponEmail = "abandoned_checkout_coupon_email"  // which email carries the code (1-based)
+	SettingAbandonedExpireDays  = "abandoned_checkout_expire_days"   // pending orders expire after this
+)
+
+var (
+	// ErrRecoveryNotFound is returned for an unknown resume token.
+	ErrRecoveryNotFound = errors.New("checkout not found")
+	// ErrCheckoutClosed is returned when resuming a checkout that was paid or expired.
+	ErrCheckoutClosed = errors.New("this checkout is no longer open")
+)
+
+// RecoverySettings controls when checkouts count as abandoned, the recovery
+// email series and when stale orders expire.
+type RecoverySettings struct {
+	Enabled      bool
+	Delay        time.Duration
+	EmailOffsets []time.Duration // after AbandonedAt, one per email
+	CouponID     uint            // 0 = no coupon
+	CouponEmail  int             // 1-based email that carries the coupon
+	ExpireAfter  time.Duration
+}
+
+// CheckoutRecoveryService detects abandoned checkouts, drives the recovery
+// email series and expires pending orders nobody came back for.
+type CheckoutRecoveryService struct {
+	db *gorm.DB
+}
+
+func NewCheckoutRecoveryService(db *gorm.DB) *CheckoutRecoveryService {
+	return &CheckoutRecoveryService{db: db}
+}
+
+// Settings loads the tenant's recovery settings, with defaults of one hour
+// to abandonment, emails 1h/24h/72h later, the coupon in the last email and
+// expiry after 7 days.
+func (s *CheckoutRecoveryService) Settings(tenantID uint) RecoverySettings {
+	cfg := RecoverySettings{
+		Enabled:      true,
+		Delay:        time.Hour,
+		EmailOffsets: []time.Duration{time.Hour, 24 * time.Hour, 72 * time.Hour},
+		ExpireAfter:  7 * 24 * time.Hour,
+	}
+
+	var settings []models.Setting
+	s.db.Where("tenant_id = ? AND key LIKE ?", tenantID, "abandoned_checkout_%").Find(&settings)
+	for _, st := range settings {
+		value := strings.TrimSpace(st.Value)
+		n, _ := strconv.Atoi(value)
+		switch st.Key {
+		case SettingAbandonedEnabled:
+			cfg.Enabled = value != "false" && value != "0"
+		case SettingAbandonedDelay:
+			if n > 0 {
+				cfg.Delay = time.Duration(n) * time.Minute
+			}
+		case SettingAbandonedEmailHours:
+			var offsets []time.Duration
+			for _, part := range strings.Split(value, ",") {
+				if h, err := strconv.ParseFloat(strings.TrimSpace(part), 64); err == nil && h >= 0 {
+					offsets = append(offsets, time.Duration(h*float64(time.Hour)))
+				}
+			}
+			cfg.EmailOffsets = offsets
+		case SettingAbandonedCouponID:
+			if n > 0 {
+				cfg.CouponID = uint(n)
+			}
+		case SettingAbandonedCouponEmail:
+			cfg.CouponEmail = n
+		case SettingAbandonedExpireDays:
+			if n > 0 {
+				cfg.ExpireAfter = time.Duration(n) * 24 * time.Hour
+			}
+		}
+	}
+	if cfg.CouponEmail < 1 || cfg.CouponEmail > len(cfg.EmailOffsets) {
+		cfg.CouponEmail = len(cfg.EmailOffsets)
+	}
+	return cfg
+}
+
+// DetectAbandoned records pending orders older than the abandonment delay
+// and emits checkout.abandoned for each. Orders whose customer has paid for
+// a later order are expired instead, since they already came back.
+func (s *CheckoutRecoveryService) DetectAbandoned(tenantID uint, now time.Time) ([]models.CheckoutRecovery, error) {
+	cfg := s.Settings(tenantID)
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	var orders []models.Order
+	err := s.db.Preload("Contact").
+		Where("tenant_id = ? AND status = ? AND created_at <= ? AND created_at > ? AND subscription_id IS NULL",
+			tenantID, models.OrderStatusPending, now.Add(-cfg.Delay), now.Add(-cfg.ExpireAfter)).
+		Where("id NOT IN (?)", s.db.Model(&models.CheckoutRecovery{}).Select("order_id")).
+		Order("created_at ASC").Limit(500).Find(&orders).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var recoveries []models.CheckoutRecovery
+	for _, order := range orders {
+		if order.Contact == nil || order.Contact.Email == "" {
+			continue
+		}
+
+		var later int64
+		s.db.Model(&models.Order{}).
+			Where("contact_id = ? AND status = ? AND created_at > ?", order.ContactID, models.OrderStatusPaid, order.CreatedAt).
+			Count(&later)
+		if later > 0 {
+			s.db.Model(&models.Order{}).Where("id = ? AND status = ?", order.ID, models.OrderStatusPending).
+				Update("status", models.OrderStatusExpired)
+			continue
+		}
+
+		token, err := recoveryToken()
+		if err != nil {
+			return recoveries, err
+		}
+		rec := models.CheckoutRecovery{
+			TenantID:    tenantID,
+			OrderID:     order.ID,
+			ContactID:   order.ContactID,
+			Email:       order.Contact.Email,
+			Token:       token,
+			AbandonedAt: now,
+		}
+		if err := s.db.Create(&rec).Error; err != nil {
+			continue // already recorded by a concurrent run
+		}
+		recoveries = append(recoveries, rec)
+
+		events.Emit(events.CheckoutAbandoned, map[string]interface{}{
+			"recovery_id":  rec.ID,
+			"order_id":     order.ID,
+			"order_number": order.OrderNumber,
+			"contact_id":   order.ContactID,
+			"email":        rec.Email,
+			"total":        order.Total,
+			"currency":     order.Currency,
+		})
+	}
+	return recoveries, nil
+}
+
+// RecoveryEmail is a recovery email that is due to be sent.
+type RecoveryEmail struct {
+	Recovery models.CheckoutRecovery
+	Order    models.Order
+	Step     int  // 1-based position in the series
+	Last     bool // final email in the series
+}
+
+// DueEmails returns the next recovery email for every open recovery whose
+// send time has passed. When several emails are overdue (e.g. the worker was
+// down) only the latest is sent. The coupon is issued here for the email
+// that carries it.
+func (s *CheckoutRecoveryService) DueEmails(tenantID uint, now time.Time) ([]RecoveryEmail, error) {
+	cfg := s.Settings(tenantID)
+	if !cfg.Enabled || len(cfg.EmailOffsets) == 0 {
+		return nil, nil
+	}
+
+	var recoveries []models.CheckoutRecovery
+	err := s.db.Preload("Order.Items.Product").Preload("Order.Contact").
+		Where("tenant_id = ? AND recovered_at IS NULL AND expired_at IS NULL AND emails_sent < ?", tenantID, len(cfg.EmailOffsets)).
+		Order("abandoned_at ASC").Limit(500).Find(&recoveries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var due []RecoveryEmail
+	for _, rec := range recoveries {
+		if rec.Order == nil || rec.Order.Status != models.OrderStatusPending {
+			continue
+		}
+		step := 0
+		for i := rec.EmailsSent; i < len(cfg.EmailOffsets); i++ {
+			if !rec.AbandonedAt.Add(cfg.EmailOffsets[i]).After(now) {
+				step = i + 1
+			}
+		}
+		if step == 0 {
+			continue
+		}
+
+		if cfg.CouponID > 0 && step >= cfg.CouponEmail && rec.CouponID == nil {
+			if err := s.issueCoupon(&rec, cfg.CouponID); err != nil {
+				return due, fmt.Errorf("issuing recovery coupon for order %d: %w", rec.OrderID, err)
+			}
+		}
+		due = append(due, RecoveryEmail{Recovery: rec, Order: *rec.Order, Step: step, Last: step == len(cfg.EmailOffsets)})
+	}
+	return due, nil
+}
+
+// issueCoupon generates a single-use code from the template coupon.
+func (s *CheckoutRecoveryService) issueCoupon(rec *models.CheckoutRecovery, templateID uint) error {
+	var template models.Coupon
+	if err := s.db.Where("id = ? AND status = ?", templateID, models.CouponStatusActive).First(&template).Error; err != nil {
+		return nil // template removed or disabled: send without a coupon
+	}
+	codes, err := NewCouponService(s.db).GenerateCodes(template, 1, "BACK", 8)
+	if err != nil {
+		return err
+	}
+	rec.CouponID = &codes[0].ID
+	rec.CouponCode = codes[0].Code
+	return s.db.Model(rec).Updates(map[string]interface{}{"coupon_id": rec.CouponID, "coupon_code": rec.CouponCode}).Error
+}
+
+// MarkEmailSent records that a recovery email went out.
+func (s *CheckoutRecoveryService) MarkEmailSent(rec models.CheckoutRecovery, step int, now time.Time) error {
+	return s.db.Model(&models.CheckoutRecovery{}).Where("id = ?", rec.ID).
+		Updates(map[string]interface{}{"emails_sent": step, "last_email_at": now}).Error
+}
+
+// ExpireStale marks pending orders older than the expiry window as expired
+// and disables unused recovery coupons. Returns the number of orders expired.
+func (s *CheckoutRecoveryService) ExpireStale(tenantID uint, now time.Time) (int64, error) {
+	cfg := s.Settings(tenantID)
+	cutoff := now.Add(-cfg.ExpireAfter)
+
+	stale := s.db.Model(&models.Order{}).Select("id").
+		Where("tenant_id = ? AND status = ? AND created_at <= ?", tenantID, models.OrderStatusPending, cutoff)
+
+	var couponIDs []uint
+	s.db.Model(&models.CheckoutRecovery{}).
+		Where("order_id IN (?) AND coupon_id IS NOT NULL", stale).Pluck("coupon_id", &couponIDs)
+	if len(couponIDs) > 0 {
+		s.db.Model(&models.Coupon{}).Where("id IN ? AND used_count = 0", couponIDs).
+			Update("status", models.CouponStatusExpired)
+	}
+	s.db.Model(&models.CheckoutRecovery{}).
+		Where("order_id IN (?) AND expired_at IS NULL AND recovered_at IS NULL", stale).
+		Update("expired_at", now)
+
+	res := s.db.Model(&models.Order{}).
+		Where("tenant_id = ? AND status = ? AND created_at <= ?", tenantID, models.OrderStatusPending, cutoff).
+		Update("status", models.OrderStatusExpired)
+	return res.RowsAffected, res.Error
+}
+
+// Resume loads an open checkout by its resume token and applies the
+// recovery coupon to it when one was issued, re-pricing the order.
+func (s *CheckoutRecoveryService) Resume(token string) (*models.CheckoutRecovery, *models.Order, error) {
+	var rec models.CheckoutRecovery
+	if token == "" || s.db.Where("token = ?", token).First(&rec).Error != nil {
+		return nil, nil, ErrRecoveryNotFound
+	}
+
+	var order models.Order
+	if err := s.db.Preload("Items.Product").Preload("Contact").Preload("Discounts").First(&order, rec.OrderID).Error; err != nil {
+		return nil, nil, ErrRecoveryNotFound
+	}
+	if order.Status != models.OrderStatusPending || rec.ExpiredAt != nil {
+		return &rec, &order, ErrCheckoutClosed
+	}
+
+	if rec.CouponCode == "" {
+		return &rec, &order, nil
+	}
+	codes := []string{rec.CouponCode}
+	for _, d := range order.Discounts {
+		if d.Code == rec.CouponCode {
+			return &rec, &order, nil
+		}
+		if !d.Automatic && d.Code != "" {
+			codes = append(codes, d.Code)
+		}
+	}
+
+	quote, err := NewCouponService(s.db).Quote(order.TenantID, order.ContactID, order.Currency, order.Items, codes)
+	if err != nil {
+		// The recovery code can't combine with what was entered; keep the order as-is
+		return &rec, &order, nil
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("order_id = ?", order.ID).Delete(&models.OrderDiscount{}).Error; err != nil {
+			return err
+		}
+		if err := ReserveCoupons(tx, quote.Discounts, time.Now()); err != nil {
+			return err
+		}
+		for i := range quote.Discounts {
+			quote.Discounts[i].OrderID = order.ID
+		}
+		if len(quote.Discounts) > 0 {
+			if err := tx.Create(&quote.Discounts).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&order).Updates(map[string]interface{}{
+			"discount_amount": quote.Discount,
+			"total":           quote.Total,
+			"coupon_id":       quote.CouponID,
+		}).Error
+	})
+	if errors.Is(err, ErrCouponExhausted) {
+		// Someone else took the last use; keep the order as-is
+		return &rec, &order, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	order.DiscountAmount = quote.Discount
+	order.Total = quote.Total
+	order.CouponID = quote.CouponID
+	order.Discounts = quote.Discounts
+	return &rec, &order, nil
+}
+
+// markCheckoutRecovered records that an abandoned checkout was paid, for
+// the recovered revenue report.
+func markCheckoutRecovered(tx *gorm.DB, order *models.Order, now time.Time) error {
+	amount := order.BaseTotal
+	if order.ExchangeRate == 0 {
+		amount = order.Total
+	}
+	return tx.Model(&models.CheckoutRecovery{}).
+		Where("order_id = ? AND recovered_at IS NULL", order.ID).
+		Updates(map[string]interface{}{"recovered_at": now, "recovered_amount": amount, "expired_at": nil}).Error
+}
+
+// RecoveryReport summarises abandoned checkouts detected in a period.
+type RecoveryReport struct {
+	Abandoned        int64   `json:"abandoned"`
+	Emailed          int64   `json:"emailed"`
+	Recovered        int64   `json:"recovered"`
+	RecoveredRevenue float64 `json:"recovered_revenue"`
+	Expired          int64   `json:"expired"`
+	RecoveryRate     float64 `json:"recovery_rate"` // percent of abandoned
+	CouponsIssued    int64   `json:"coupons_issued"`
+	Currency         string  `json:"currency"`
+}
+
+// Report returns recovery metrics for checkouts abandoned since from.
+// Recovered revenue is in the tenant base currency.
+func (s *CheckoutRecoveryService) Report(tenantID uint, from time.Time) RecoveryReport {
+	scope := func() *gorm.DB {
+		return s.db.Model(&models.CheckoutRecovery{}).Where("tenant_id = ? AND abandoned_at >= ?", tenantID, from)
+	}
+
+	report := RecoveryReport{Currency: NewCurrencyService(s.db).BaseCurrency(tenantID)}
+	scope().Count(&report.Abandoned)
+	scope().Where("emails_sent > 0").Count(&report.Emailed)
+	scope().Where("recovered_at IS NOT NULL").Count(&report.Recovered)
+	scope().Where("recovered_at IS NOT NULL").Select("COALESCE(SUM(recovered_amount), 0)").Scan(&report.RecoveredRevenue)
+	scope().Where("expired_at IS NOT NULL").Count(&report.Expired)
+	scope().Where("coupon_id IS NOT NULL").Count(&report.CouponsIssued)
+	if report.Abandoned > 0 {
+		report.RecoveryRate = roundMoney(float64(report.Recovered) / float64(report.Abandoned) * 100)
+	}
+	return report
+}
+
+// RecoveryEmailData builds the template data for a recovery email.
+func RecoveryEmailData(email RecoveryEmail, appName, webURL string) map[string]interface{} {
+	order := email.Order
+	var items []string
+	for _, item := range order.Items {
+		if item.Product != nil {
+			items = append(items, item.Product.Name)
+		}
+	}
+	data := map[string]interface{}{
+		"AppName":     appName,
+		"Year":        time.Now().Year(),
+		"OrderNumber": order.OrderNumber,
+		"Items":       strings.Join(items, ", "),
+		"Total":       formatMoney(order.Total, order.Currency),
+		"Step":        email.Step,
+		"Last":        email.Last,
+		"CouponCode":  email.Recovery.CouponCode,
+		"ActionURL":   strings.TrimRight(webURL, "/") + "/checkout/resume?token=" + email.Recovery.Token,
+	}
+	if order.Contact != nil {
+		data["FirstName"] = order.Contact.FirstName
+	}
+	return data
+}
+
+func recoveryToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
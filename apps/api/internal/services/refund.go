@@ -0,0 +1,390 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"gritcms/apps/api/internal/config"
+	"gritcms/apps/api/internal/events"
+	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/payments"
+)
+
+// RefundItem selects an order item to refund. Amount 0 refunds whatever is
+// left of the item's (discounted) share of the order.
+type RefundItem struct {
+	OrderItemID uint    `json:"order_item_id"`
+	Amount      float64 `json:"amount"`
+}
+
+// RefundRequest describes a refund against a paid order. With no items and
+// Amount 0 the full remaining balance is refunded.
+type RefundRequest struct {
+	Amount float64      `json:"amount"`
+	Items  []RefundItem `json:"items"`
+	Reason string       `json:"reason"`
+}
+
+// RefundService issues refunds through the order's payment provider, records
+// them in the refund ledger and reverses what the purchase granted.
+type RefundService struct {
+	db        *gorm.DB
+	cfg       *config.Config
+	providers *payments.Registry
+}
+
+func NewRefundService(db *gorm.DB, cfg *config.Config, providers *payments.Registry) *RefundService {
+	return &RefundService{db: db, cfg: cfg, providers: providers}
+}
+
+// RefundOrder refunds all or part of an order. Most provider refunds take
+// effect immediately; M-Pesa reversals stay pending until the provider's
+// webhook arrives (see CompleteProviderRefund). The order row stays locked
+// from the balance check until the ledger is written, so concurrent refunds
+// can't both spend the same balance.
+func (s *RefundService) RefundOrder(order *models.Order, req RefundRequest) ([]models.Refund, error) {
+	var lines []models.Refund
+	var providerRefundID, status string
+	var providerErr error
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var locked models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, order.ID).Error; err != nil {
+			return err
+		}
+		order.Status, order.RefundedAmount = locked.Status, locked.RefundedAmount
+
+		var err error
+		lines, err = s.refundLines(tx, order, req)
+		if err != nil {
+			return err
+		}
+		total := refundTotal(lines)
+		providerRefundID, status, providerErr = s.refundWithProvider(order, total, req.Reason)
+		if providerErr != nil {
+			return providerErr
+		}
+
+		now := time.Now()
+		for i := range lines {
+			lines[i].TenantID = order.TenantID
+			lines[i].OrderID = order.ID
+			lines[i].Currency = order.Currency
+			lines[i].Reason = req.Reason
+			lines[i].Status = status
+			lines[i].PaymentProvider = order.PaymentProvider
+			lines[i].ProviderRefundID = providerRefundID
+			if status == models.RefundStatusSucceeded {
+				lines[i].ProcessedAt = &now
+			}
+		}
+		// The ledger and the order totals are written together, so a refund
+		// is never half-recorded.
+		if err := tx.Create(&lines).Error; err != nil {
+			return err
+		}
+		if status == models.RefundStatusSucceeded {
+			return settleOrder(tx, order, lines)
+		}
+		return nil
+	})
+	if providerErr != nil {
+		log.Printf("[refund] Provider refund failed for order %d: %v", order.ID, providerErr)
+		s.db.Create(&models.Refund{
+			TenantID:        order.TenantID,
+			OrderID:         order.ID,
+			Amount:          refundTotal(lines),
+			Currency:        order.Currency,
+			Reason:          req.Reason,
+			Status:          models.RefundStatusFailed,
+			PaymentProvider: order.PaymentProvider,
+			FailureReason:   providerErr.Error(),
+		})
+		return nil, providerErr
+	}
+	if err != nil {
+		if status == "" {
+			return nil, err
+		}
+		// The money has already moved; make sure someone sees it
+		log.Printf("[refund] Failed to record refund for order %d (provider ref %s): %v", order.ID, providerRefundID, err)
+		return nil, fmt.Errorf("the refund was issued (provider reference %q) but could not be recorded: %w", providerRefundID, err)
+	}
+
+	if status == models.RefundStatusSucceeded {
+		s.applyRefund(order, lines)
+	}
+
+	return lines, nil
+}
+
+// refundLines checks a refund request against what is left to refund and
+// builds its ledger lines: one per item, or a single order-level line. The
+// caller holds the order's row lock.
+func (s *RefundService) refundLines(tx *gorm.DB, order *models.Order, req RefundRequest) ([]models.Refund, error) {
+	if order.Status != models.OrderStatusPaid && order.Status != models.OrderStatusPartiallyRefunded {
+		return nil, fmt.Errorf("order is not in a refundable status")
+	}
+	if len(order.Items) == 0 {
+		tx.Where("order_id = ?", order.ID).Find(&order.Items)
+	}
+
+	var pending int64
+	tx.Model(&models.Refund{}).Where("order_id = ? AND status = ?", order.ID, models.RefundStatusPending).Count(&pending)
+	if pending > 0 {
+		return nil, fmt.Errorf("a refund for this order is still pending")
+	}
+
+	remaining := roundMoney(order.Total - order.RefundedAmount)
+	if remaining <= 0 {
+		return nil, fmt.Errorf("order has already been fully refunded")
+	}
+
+	var lines []models.Refund
+	if len(req.Items) > 0 {
+		for _, ri := range req.Items {
+			item, ok := findOrderItem(order.Items, ri.OrderItemID)
+			if !ok {
+				return nil, fmt.Errorf("order item %d does not belong to this order", ri.OrderItemID)
+			}
+			left := roundMoney(s.itemShare(order, item) - s.itemRefunded(item.ID))
+			amount := ri.Amount
+			if amount == 0 {
+				amount = left
+			}
+			if amount <= 0 || amount > left+0.001 {
+				return nil, fmt.Errorf("refund amount for item %d must be between 0 and %.2f", item.ID, left)
+			}
+			itemID := item.ID
+			lines = append(lines, models.Refund{OrderItemID: &itemID, Amount: roundMoney(amount)})
+		}
+	} else {
+		amount := req.Amount
+		if amount == 0 {
+			amount = remaining
+		}
+		if amount <= 0 {
+			return nil, fmt.Errorf("refund amount must be greater than zero")
+		}
+		lines = append(lines, models.Refund{Amount: roundMoney(amount)})
+	}
+
+	if total := refundTotal(lines); total > remaining+0.001 {
+		return nil, fmt.Errorf("refund amount exceeds the refundable balance of %.2f", remaining)
+	}
+	return lines, nil
+}
+
+// CompleteProviderRefund settles refunds that were accepted asynchronously
+// (M-Pesa reversals). The result must be for the order whose signed
+// callback URL it arrived on. On success the order is updated and access
+// revoked.
+func (s *RefundService) CompleteProviderRefund(orderID uint, providerRefundID string, succeeded bool, failureReason string) error {
+	var lines []models.Refund
+	s.db.Where("order_id = ? AND provider_refund_id = ? AND status = ?", orderID, providerRefundID, models.RefundStatusPending).Find(&lines)
+	if len(lines) == 0 {
+		return fmt.Errorf("no pending refund of order %d for provider reference %s", orderID, providerRefundID)
+	}
+
+	now := time.Now()
+	if !succeeded {
+		s.db.Model(&models.Refund{}).Where("order_id = ? AND provider_refund_id = ? AND status = ?", orderID, providerRefundID, models.RefundStatusPending).
+			Updates(map[string]interface{}{"status": models.RefundStatusFailed, "failure_reason": failureReason, "processed_at": now})
+		return nil
+	}
+
+	var order models.Order
+	if err := s.db.Preload("Items").First(&order, lines[0].OrderID).Error; err != nil {
+		return err
+	}
+	settled := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Refund{}).Where("order_id = ? AND provider_refund_id = ? AND status = ?", orderID, providerRefundID, models.RefundStatusPending).
+			Updates(map[string]interface{}{"status": models.RefundStatusSucceeded, "processed_at": now})
+		if res.Error != nil || res.RowsAffected == 0 {
+			// Already settled by a duplicate delivery
+			return res.Error
+		}
+		settled = true
+		return settleOrder(tx, &order, lines)
+	})
+	if err != nil || !settled {
+		return err
+	}
+	s.applyRefund(&order, lines)
+	return nil
+}
+
+// refundWithProvider sends the refund to the payment provider and returns the
+// provider's reference and the resulting ledger status.
+func (s *RefundService) refundWithProvider(order *models.Order, amount float64, reason string) (string, string, error) {
+	name := order.PaymentProvider
+	if name == "" && strings.HasPrefix(order.PaymentID, "pi_") {
+		name = payments.ProviderStripe
+	}
+	if name == "" || name == "manual" {
+		// Manual / offline orders — nothing to call, just record the refund.
+		return "", models.RefundStatusSucceeded, nil
+	}
+
+	provider, err := s.providers.Provider(order.TenantID, name)
+	if err != nil {
+		return "", "", err
+	}
+
+	result, err := provider.Refund(context.Background(), payments.RefundRequest{
+		OrderID:   order.ID,
+		PaymentID: order.PaymentID,
+		Reference: order.PaymentReference,
+		Amount:    amount,
+		Currency:  order.Currency,
+		Reason:    reason,
+		// Only used by providers that settle refunds asynchronously (M-Pesa reversals)
+		CallbackURL: strings.TrimRight(s.cfg.AppURL, "/") + "/api/webhooks/" + name,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	if result.Status == payments.StatusPending {
+		return result.RefundID, models.RefundStatusPending, nil
+	}
+	return result.RefundID, models.RefundStatusSucceeded, nil
+}
+
+// settleOrder adds succeeded refund lines to the order's refunded amount and
+// moves it to refunded or partially refunded. The amount is added in the
+// database under the order's row lock, and order is refreshed from the
+// result.
+func settleOrder(tx *gorm.DB, order *models.Order, lines []models.Refund) error {
+	var locked models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "total", "refunded_amount").First(&locked, order.ID).Error; err != nil {
+		return err
+	}
+	amount := refundTotal(lines)
+	status := models.OrderStatusPartiallyRefunded
+	if roundMoney(locked.RefundedAmount+amount) >= locked.Total-0.001 {
+		status = models.OrderStatusRefunded
+	}
+	if err := tx.Model(&locked).Updates(map[string]interface{}{
+		"refunded_amount": gorm.Expr("refunded_amount + ?", amount),
+		"status":          status,
+	}).Error; err != nil {
+		return err
+	}
+	order.RefundedAmount = roundMoney(locked.RefundedAmount + amount)
+	order.Status = status
+	return nil
+}
+
+func refundTotal(lines []models.Refund) float64 {
+	var amount float64
+	for _, l := range lines {
+		amount += l.Amount
+	}
+	return roundMoney(amount)
+}
+
+// applyRefund follows up a settled refund: it revokes access for fully
+// refunded items, reverses affiliate commissions and emits PurchaseRefunded.
+func (s *RefundService) applyRefund(order *models.Order, lines []models.Refund) {
+	amount := refundTotal(lines)
+	fullyRefunded := order.Status == models.OrderStatusRefunded
+
+	for _, item := range order.Items {
+		if fullyRefunded || s.itemRefunded(item.ID) >= s.itemShare(order, item)-0.001 {
+			s.revokeItemAccess(order, item)
+		}
+	}
+
+	if order.Total > 0 {
+		NewAffiliateService(s.db).ReverseOrderCommissions(order.ID, order.RefundedAmount/order.Total, fullyRefunded)
+	}
+
+	events.Emit(events.PurchaseRefunded, map[string]interface{}{
+		"order_id":        order.ID,
+		"contact_id":      order.ContactID,
+		"total":           order.Total,
+		"amount":          amount,
+		"refunded_amount": order.RefundedAmount,
+		"partial":         !fullyRefunded,
+	})
+
+	log.Printf("[refund] Order %d refunded %.2f (total refunded %.2f, status %s)", order.ID, amount, order.RefundedAmount, order.Status)
+}
+
+// revokeItemAccess removes what fulfillment granted for a single order item:
+// product access, course and learning path enrollments and paid community
+// space membership.
+func (s *RefundService) revokeItemAccess(order *models.Order, item models.OrderItem) {
+	var courseIDs []uint
+	if item.CourseID != nil {
+		courseIDs = append(courseIDs, *item.CourseID)
+	}
+
+	if item.ProductID != nil {
+		productID := *item.ProductID
+
+		// Digital downloads, memberships and services — only the grant that came from this order
+		s.db.Model(&models.ContactProduct{}).
+			Where("contact_id = ? AND product_id = ? AND order_id = ?", order.ContactID, productID, order.ID).
+			Update("status", models.ContactProductStatusRevoked)
+
+		courseIDs = append(courseIDs, ProductCourseIDs(s.db, productID)...)
+
+		var pathIDs []uint
+		s.db.Model(&models.LearningPath{}).Where("product_id = ?", productID).Pluck("id", &pathIDs)
+		if len(pathIDs) > 0 {
+			s.db.Model(&models.LearningPathEnrollment{}).
+				Where("contact_id = ? AND path_id IN ? AND source = ? AND status = ?", order.ContactID, pathIDs, "purchase", models.EnrollStatusActive).
+				Update("status", models.EnrollStatusRevoked)
+		}
+
+		var spaceIDs []uint
+		s.db.Model(&models.Space{}).Where("product_id = ?", productID).Pluck("id", &spaceIDs)
+		if len(spaceIDs) > 0 {
+			s.db.Where("contact_id = ? AND space_id IN ? AND role = ?", order.ContactID, spaceIDs, "member").
+				Delete(&models.CommunityMember{})
+		}
+	}
+
+	if len(courseIDs) > 0 {
+		s.db.Model(&models.CourseEnrollment{}).
+			Where("contact_id = ? AND course_id IN ? AND source = ?", order.ContactID, courseIDs, "purchase").
+			Update("status", models.EnrollStatusRevoked)
+	}
+}
+
+// itemShare is the part of the order total attributable to an item, after
+// the order-level discount is spread proportionally.
+func (s *RefundService) itemShare(order *models.Order, item models.OrderItem) float64 {
+	if order.Subtotal <= 0 {
+		return 0
+	}
+	return roundMoney(item.Total * order.Total / order.Subtotal)
+}
+
+func (s *RefundService) itemRefunded(itemID uint) float64 {
+	var sum float64
+	s.db.Model(&models.Refund{}).Where("order_item_id = ? AND status = ?", itemID, models.RefundStatusSucceeded).
+		Select("COALESCE(SUM(amount), 0)").Scan(&sum)
+	return sum
+}
+
+func findOrderItem(items []models.OrderItem, id uint) (models.OrderItem, bool) {
+	for _, it := range items {
+		if it.ID == id {
+			return it, true
+		}
+	}
+	return models.OrderItem{}, false
+}
+
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
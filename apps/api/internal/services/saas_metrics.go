@@ -0,0 +1,481 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"gritcms/apps/api/internal/models"
+)
+
+// SaaSMetricsService snapshots subscription revenue once a day and derives
+// MRR movements, churn, ARPU, trial conversion, LTV and cohort retention
+// from the snapshots. All amounts are MRR in the tenant base currency.
+type SaaSMetricsService struct {
+	db *gorm.DB
+}
+
+func NewSaaSMetricsService(db *gorm.DB) *SaaSMetricsService {
+	return &SaaSMetricsService{db: db}
+}
+
+// subscriptionSpan is when a subscription paid: from the end of its trial
+// until it was cancelled (or its paid period ran out).
+type subscriptionSpan struct {
+	contactID  uint
+	startedAt  time.Time
+	trialEnd   time.Time
+	endedAt    *time.Time
+	monthlyMRR float64
+}
+
+func (sp subscriptionSpan) payingAt(t time.Time) bool {
+	return !sp.trialEnd.After(t) && (sp.endedAt == nil || sp.endedAt.After(t))
+}
+
+// spans loads every subscription with its monthly amount in the base currency.
+func (s *SaaSMetricsService) spans(tenantID uint) []subscriptionSpan {
+	var subs []models.Subscription
+	s.db.Preload("Price").Where("tenant_id = ?", tenantID).Find(&subs)
+
+	fx := NewCurrencyService(s.db)
+	base := fx.BaseCurrency(tenantID)
+	rates := map[string]float64{}
+	rate := func(cur string) float64 {
+		cur = normalizeCurrency(cur)
+		if cur == "" || cur == base {
+			return 1
+		}
+		if r, ok := rates[cur]; ok {
+			return r
+		}
+		// Without a rate the amount can't be added to base-currency totals
+		r, err := fx.Rate(tenantID, cur)
+		if err != nil {
+			r = 0
+		}
+		rates[cur] = r
+		return r
+	}
+
+	spans := make([]subscriptionSpan, 0, len(subs))
+	for _, sub := range subs {
+		// Subscriptions carry the amount they were sold at; older rows fall
+		// back to their price
+		amount, currency, interval := sub.Amount, sub.Currency, sub.Interval
+		if amount == 0 {
+			if sub.Price == nil || sub.Price.Type == models.PriceTypeOneTime {
+				continue
+			}
+			amount, currency, interval = sub.Price.Amount, sub.Price.Currency, sub.Price.Interval
+		}
+		monthly := amount
+		switch interval {
+		case "year":
+			monthly = monthly / 12
+		case "week":
+			monthly = monthly * 52 / 12
+		case "day":
+			monthly = monthly * 365 / 12
+		}
+
+		span := subscriptionSpan{
+			contactID:  sub.ContactID,
+			startedAt:  sub.CreatedAt,
+			trialEnd:   sub.CreatedAt,
+			monthlyMRR: monthly * rate(currency),
+		}
+		if sub.TrialEndsAt != nil {
+			span.trialEnd = *sub.TrialEndsAt
+		} else if sub.Price != nil {
+			span.trialEnd = sub.CreatedAt.AddDate(0, 0, sub.Price.TrialDays)
+		}
+		switch {
+		case sub.CancelAtPeriodEnd && !sub.CurrentPeriodEnd.IsZero():
+			end := sub.CurrentPeriodEnd
+			span.endedAt = &end
+		case sub.CancelledAt != nil:
+			span.endedAt = sub.CancelledAt
+		case sub.Status == models.SubscriptionCancelled || sub.Status == models.SubscriptionPaused ||
+			sub.Status == models.SubscriptionPastDue:
+			// A past-due subscription stopped paying when its renewal failed
+			end := sub.UpdatedAt
+			span.endedAt = &end
+		}
+		spans = append(spans, span)
+	}
+	return spans
+}
+
+// customerMRR sums each contact's MRR at time t.
+func customerMRR(spans []subscriptionSpan, t time.Time) map[uint]float64 {
+	out := make(map[uint]float64)
+	for _, sp := range spans {
+		if sp.payingAt(t) {
+			out[sp.contactID] += sp.monthlyMRR
+		}
+	}
+	for id, v := range out {
+		out[id] = math.Round(v)
+	}
+	return out
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// SnapshotDay computes and stores the metrics for one UTC day. It can be
+// re-run for the same day; the previous day's stored snapshot is used as the
+// baseline for movements when there is one.
+func (s *SaaSMetricsService) SnapshotDay(tenantID uint, day time.Time) (*models.MetricSnapshot, error) {
+	return s.snapshotDay(tenantID, day, s.spans(tenantID))
+}
+
+func (s *SaaSMetricsService) snapshotDay(tenantID uint, day time.Time, spans []subscriptionSpan) (*models.MetricSnapshot, error) {
+	day = startOfDay(day)
+	end := day.AddDate(0, 0, 1)
+	prevDay := day.AddDate(0, 0, -1)
+
+	current := customerMRR(spans, end.Add(-time.Nanosecond))
+
+	previous := map[uint]float64{}
+	var prevSnap int64
+	s.db.Model(&models.MetricSnapshot{}).Where("tenant_id = ? AND date = ?", tenantID, prevDay).Count(&prevSnap)
+	if prevSnap > 0 {
+		var rows []models.CustomerMRRSnapshot
+		s.db.Where("tenant_id = ? AND date = ?", tenantID, prevDay).Find(&rows)
+		for _, r := range rows {
+			previous[r.ContactID] = r.MRR
+		}
+	} else {
+		previous = customerMRR(spans, day.Add(-time.Nanosecond))
+	}
+
+	snap := models.MetricSnapshot{
+		TenantID: tenantID,
+		Date:     day,
+		Currency: NewCurrencyService(s.db).BaseCurrency(tenantID),
+	}
+
+	// Customers who start paying again after a gap are reactivations
+	var returning []uint
+	for id, mrr := range current {
+		if mrr > 0 && previous[id] == 0 {
+			returning = append(returning, id)
+		}
+	}
+	paidBefore := map[uint]bool{}
+	if len(returning) > 0 {
+		var ids []uint
+		s.db.Model(&models.CustomerMRRSnapshot{}).Distinct("contact_id").
+			Where("tenant_id = ? AND date < ? AND contact_id IN ?", tenantID, prevDay, returning).Pluck("contact_id", &ids)
+		for _, id := range ids {
+			paidBefore[id] = true
+		}
+		if prevSnap == 0 {
+			// No stored history: fall back to the subscriptions themselves
+			for _, sp := range spans {
+				if sp.endedAt != nil && !sp.endedAt.After(day) && sp.trialEnd.Before(*sp.endedAt) {
+					paidBefore[sp.contactID] = true
+				}
+			}
+		}
+	}
+
+	for id, mrr := range current {
+		prev := previous[id]
+		switch {
+		case prev == 0 && paidBefore[id]:
+			snap.ReactivatedMRR += mrr
+			snap.ReactivatedCustomers++
+		case prev == 0:
+			snap.NewMRR += mrr
+			snap.NewCustomers++
+		case mrr > prev:
+			snap.ExpansionMRR += mrr - prev
+		case mrr < prev:
+			snap.ContractionMRR += prev - mrr
+		}
+		snap.MRR += mrr
+	}
+	for id, prev := range previous {
+		if current[id] == 0 && prev > 0 {
+			snap.ChurnedMRR += prev
+			snap.ChurnedCustomers++
+		}
+	}
+	snap.ActiveCustomers = int64(len(current))
+
+	for _, sp := range spans {
+		if !sp.trialEnd.After(sp.startedAt) {
+			continue
+		}
+		if !sp.startedAt.Before(day) && sp.startedAt.Before(end) {
+			snap.TrialsStarted++
+		}
+		if !sp.trialEnd.Before(day) && sp.trialEnd.Before(end) {
+			snap.TrialsEnded++
+			if sp.endedAt == nil || sp.endedAt.After(sp.trialEnd) {
+				snap.TrialsConverted++
+			}
+		}
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("tenant_id = ? AND date = ?", tenantID, day).Delete(&models.CustomerMRRSnapshot{}).Error; err != nil {
+			return err
+		}
+		rows := make([]models.CustomerMRRSnapshot, 0, len(current))
+		for id, mrr := range current {
+			rows = append(rows, models.CustomerMRRSnapshot{TenantID: tenantID, Date: day, ContactID: id, MRR: mrr})
+		}
+		if len(rows) > 0 {
+			if err := tx.CreateInBatches(&rows, 500).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "tenant_id"}, {Name: "date"}},
+			DoUpdates: clause.AssignmentColumns([]string{"currency", "mrr", "active_customers",
+				"new_mrr", "expansion_mrr", "contraction_mrr", "churned_mrr", "reactivated_mrr",
+				"new_customers", "churned_customers", "reactivated_customers",
+				"trials_started", "trials_ended", "trials_converted", "updated_at"}),
+		}).Create(&snap).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// Rebuild snapshots every day from `from` to `to` in order, so each day's
+// movements are measured against the one before.
+func (s *SaaSMetricsService) Rebuild(tenantID uint, from, to time.Time) (int, error) {
+	spans := s.spans(tenantID)
+	count := 0
+	for day := startOfDay(from); !day.After(startOfDay(to)); day = day.AddDate(0, 0, 1) {
+		if _, err := s.snapshotDay(tenantID, day, spans); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// CatchUp snapshots every day since the last stored snapshot up to and
+// including yesterday (backfilling `backfillDays` on the first run), then
+// refreshes the cohort table. This is what the nightly job runs.
+func (s *SaaSMetricsService) CatchUp(tenantID uint, now time.Time, backfillDays int) (int, error) {
+	yesterday := startOfDay(now).AddDate(0, 0, -1)
+	from := yesterday.AddDate(0, 0, -backfillDays+1)
+
+	var last models.MetricSnapshot
+	if err := s.db.Where("tenant_id = ?", tenantID).Order("date DESC").First(&last).Error; err == nil {
+		from = startOfDay(last.Date).AddDate(0, 0, 1)
+	}
+	count, err := s.Rebuild(tenantID, from, yesterday)
+	if err != nil {
+		return count, err
+	}
+	return count, s.RebuildCohorts(tenantID)
+}
+
+// RebuildCohorts recomputes the monthly cohort retention table from the
+// customer snapshots: a customer is retained in a month if they paid on any
+// day of it.
+func (s *SaaSMetricsService) RebuildCohorts(tenantID uint) error {
+	type cell struct {
+		Cohort    time.Time
+		Month     time.Time
+		Customers int64
+		MRR       float64
+	}
+	var cells []cell
+	err := s.db.Raw(`
+		WITH firsts AS (
+			SELECT contact_id, date_trunc('month', MIN(date)) AS cohort
+			FROM customer_mrr_snapshots WHERE tenant_id = ? GROUP BY contact_id
+		), monthly AS (
+			SELECT contact_id, date_trunc('month', date) AS month, MAX(mrr) AS mrr
+			FROM customer_mrr_snapshots WHERE tenant_id = ? GROUP BY contact_id, date_trunc('month', date)
+		)
+		SELECT f.cohort, m.month, COUNT(*) AS customers, COALESCE(SUM(m.mrr), 0) AS mrr
+		FROM firsts f JOIN monthly m ON m.contact_id = f.contact_id
+		GROUP BY f.cohort, m.month
+	`, tenantID, tenantID).Scan(&cells).Error
+	if err != nil {
+		return err
+	}
+
+	sizes := map[time.Time]int64{}
+	for _, c := range cells {
+		if c.Cohort.Equal(c.Month) {
+			sizes[c.Cohort] = c.Customers
+		}
+	}
+	rows := make([]models.CohortRetention, 0, len(cells))
+	now := time.Now()
+	for _, c := range cells {
+		size := sizes[c.Cohort]
+		if size == 0 {
+			continue
+		}
+		offset := (c.Month.Year()-c.Cohort.Year())*12 + int(c.Month.Month()-c.Cohort.Month())
+		rows = append(rows, models.CohortRetention{
+			TenantID:    tenantID,
+			Cohort:      c.Cohort,
+			MonthOffset: offset,
+			CohortSize:  size,
+			Customers:   c.Customers,
+			Retention:   roundMoney(float64(c.Customers) / float64(size) * 100),
+			MRR:         math.Round(c.MRR),
+			UpdatedAt:   now,
+		})
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("tenant_id = ?", tenantID).Delete(&models.CohortRetention{}).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(&rows, 500).Error
+	})
+}
+
+// SaaSReport summarises subscription metrics over a period.
+type SaaSReport struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Currency string    `json:"currency"`
+
+	MRR             float64 `json:"mrr"`
+	ARR             float64 `json:"arr"`
+	StartingMRR     float64 `json:"starting_mrr"`
+	ActiveCustomers int64   `json:"active_customers"`
+	ARPU            float64 `json:"arpu"`
+
+	NewMRR         float64 `json:"new_mrr"`
+	ExpansionMRR   float64 `json:"expansion_mrr"`
+	ContractionMRR float64 `json:"contraction_mrr"`
+	ChurnedMRR     float64 `json:"churned_mrr"`
+	ReactivatedMRR float64 `json:"reactivated_mrr"`
+	NetNewMRR      float64 `json:"net_new_mrr"`
+
+	NewCustomers         int64 `json:"new_customers"`
+	ChurnedCustomers     int64 `json:"churned_customers"`
+	ReactivatedCustomers int64 `json:"reactivated_customers"`
+
+	LogoChurnRate       float64  `json:"logo_churn_rate"`     // percent of starting customers lost
+	GrossRevenueChurn   float64  `json:"gross_revenue_churn"` // (churned + contraction) / starting MRR, percent
+	NetRevenueChurn     float64  `json:"net_revenue_churn"`   // gross minus expansion and reactivation, percent
+	MonthlyLogoChurn    float64  `json:"monthly_logo_churn"`  // churn normalised to 30 days, percent
+	TrialsStarted       int64    `json:"trials_started"`
+	TrialsEnded         int64    `json:"trials_ended"`
+	TrialsConverted     int64    `json:"trials_converted"`
+	TrialConversionRate float64  `json:"trial_conversion_rate"` // percent of ended trials
+	LTV                 *float64 `json:"ltv"`                   // ARPU / monthly churn; nil without churn
+
+	Daily []models.MetricSnapshot `json:"daily"`
+}
+
+// Report aggregates the stored daily snapshots between from and to (inclusive).
+func (s *SaaSMetricsService) Report(tenantID uint, from, to time.Time) SaaSReport {
+	from, to = startOfDay(from), startOfDay(to)
+	report := SaaSReport{From: from, To: to, Currency: NewCurrencyService(s.db).BaseCurrency(tenantID)}
+
+	s.db.Where("tenant_id = ? AND date >= ? AND date <= ?", tenantID, from, to).Order("date ASC").Find(&report.Daily)
+	if len(report.Daily) == 0 {
+		return report
+	}
+
+	var start models.MetricSnapshot
+	startCustomers := int64(0)
+	if err := s.db.Where("tenant_id = ? AND date = ?", tenantID, from.AddDate(0, 0, -1)).First(&start).Error; err == nil {
+		report.StartingMRR = start.MRR
+		startCustomers = start.ActiveCustomers
+	} else {
+		first := report.Daily[0]
+		report.StartingMRR = first.MRR - first.NewMRR - first.ExpansionMRR - first.ReactivatedMRR + first.ContractionMRR + first.ChurnedMRR
+		startCustomers = first.ActiveCustomers - first.NewCustomers - first.ReactivatedCustomers + first.ChurnedCustomers
+	}
+
+	for _, d := range report.Daily {
+		report.NewMRR += d.NewMRR
+		report.ExpansionMRR += d.ExpansionMRR
+		report.ContractionMRR += d.ContractionMRR
+		report.ChurnedMRR += d.ChurnedMRR
+		report.ReactivatedMRR += d.ReactivatedMRR
+		report.NewCustomers += d.NewCustomers
+		report.ChurnedCustomers += d.ChurnedCustomers
+		report.ReactivatedCustomers += d.ReactivatedCustomers
+		report.TrialsStarted += d.TrialsStarted
+		report.TrialsEnded += d.TrialsEnded
+		report.TrialsConverted += d.TrialsConverted
+	}
+	last := report.Daily[len(report.Daily)-1]
+	report.MRR = last.MRR
+	report.ARR = last.MRR * 12
+	report.ActiveCustomers = last.ActiveCustomers
+	report.NetNewMRR = report.NewMRR + report.ExpansionMRR + report.ReactivatedMRR - report.ContractionMRR - report.ChurnedMRR
+
+	percent := func(n, d float64) float64 {
+		if d == 0 {
+			return 0
+		}
+		return roundMoney(n / d * 100)
+	}
+	if report.ActiveCustomers > 0 {
+		report.ARPU = math.Round(report.MRR / float64(report.ActiveCustomers))
+	}
+	report.LogoChurnRate = percent(float64(report.ChurnedCustomers), float64(startCustomers))
+	report.GrossRevenueChurn = percent(report.ChurnedMRR+report.ContractionMRR, report.StartingMRR)
+	report.NetRevenueChurn = percent(report.ChurnedMRR+report.ContractionMRR-report.ExpansionMRR-report.ReactivatedMRR, report.StartingMRR)
+	report.TrialConversionRate = percent(float64(report.TrialsConverted), float64(report.TrialsEnded))
+
+	days := float64(len(report.Daily))
+	report.MonthlyLogoChurn = roundMoney(report.LogoChurnRate * 30 / days)
+	if report.MonthlyLogoChurn > 0 {
+		ltv := math.Round(report.ARPU / (report.MonthlyLogoChurn / 100))
+		report.LTV = &ltv
+	}
+	return report
+}
+
+// CohortTable returns the stored cohort retention cells for the last
+// `months` cohorts, grouped by cohort month in ascending order.
+func (s *SaaSMetricsService) CohortTable(tenantID uint, months int) []map[string]interface{} {
+	since := startOfDay(time.Now()).AddDate(0, -months+1, 0)
+	since = time.Date(since.Year(), since.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	var cells []models.CohortRetention
+	s.db.Where("tenant_id = ? AND cohort >= ?", tenantID, since).Order("cohort ASC, month_offset ASC").Find(&cells)
+
+	byCohort := map[time.Time][]models.CohortRetention{}
+	var cohorts []time.Time
+	for _, c := range cells {
+		key := startOfDay(c.Cohort)
+		if _, ok := byCohort[key]; !ok {
+			cohorts = append(cohorts, key)
+		}
+		byCohort[key] = append(byCohort[key], c)
+	}
+	sort.Slice(cohorts, func(i, j int) bool { return cohorts[i].Before(cohorts[j]) })
+
+	table := make([]map[string]interface{}, 0, len(cohorts))
+	for _, cohort := range cohorts {
+		row := byCohort[cohort]
+		table = append(table, map[string]interface{}{
+			"cohort":      cohort.Format("2006-01"),
+			"cohort_size": row[0].CohortSize,
+			"months":      row,
+		})
+	}
+	return table
+}
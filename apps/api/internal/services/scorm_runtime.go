@@ -0,0 +1,621 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/models"
+)
+
+// ErrInvalidStatement is returned for xAPI statements missing a verb or
+// object.
+var ErrInvalidStatement = errors.New("statements need a verb and an object")
+
+// maxScormValue caps a stored cmi value; SCORM 2004 allows 64k of
+// suspend data.
+const maxScormValue = 64000
+
+// xapiStatePrefix keys xAPI state documents within LessonProgress.ScormData.
+const xapiStatePrefix = "xapi.state."
+
+// ScormLaunch tells the student's player how to start a package lesson.
+type ScormLaunch struct {
+	Version   string            `json:"version"`
+	URL       string            `json:"url"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	CMI       map[string]string `json:"cmi,omitempty"` // initial data for the player's SCORM API
+}
+
+// runtimeResult is what a SCO or xAPI statement reported about the attempt.
+type runtimeResult struct {
+	Completed      bool
+	Passed         *bool
+	Score          *float64 // percent
+	SessionSeconds int
+}
+
+// PackageLesson loads a lesson that launches package content, with its
+// package.
+func (s *ScormService) PackageLesson(lessonID uint) (*models.Lesson, *models.ScormPackage, error) {
+	var lesson models.Lesson
+	if err := s.db.First(&lesson, lessonID).Error; err != nil {
+		return nil, nil, ErrLessonNotFound
+	}
+	if lesson.ScormPackageID == nil {
+		return nil, nil, ErrNotPackageLesson
+	}
+	var pkg models.ScormPackage
+	if err := s.db.First(&pkg, *lesson.ScormPackageID).Error; err != nil {
+		return nil, nil, ErrNotPackageLesson
+	}
+	if pkg.Status != models.ScormReady {
+		return nil, nil, ErrPackageNotReady
+	}
+	return &lesson, &pkg, nil
+}
+
+// Launch returns signed URLs for a package lesson. SCORM lessons get the
+// cmi data to initialise the player's runtime API with, resuming from the
+// student's last session; xAPI lessons get the launch parameters pointing
+// at the statement endpoint.
+func (s *ScormService) Launch(enrollment *models.CourseEnrollment, lesson *models.Lesson, pkg *models.ScormPackage, user *models.User, apiURL, secret string, now time.Time) (*ScormLaunch, error) {
+	expires := now.Add(ScormSessionTTL)
+	base := strings.TrimRight(apiURL, "/")
+	token := signContentToken(secret, fmt.Sprintf("scorm.%d", pkg.ID), expires)
+	launch := &ScormLaunch{
+		Version:   pkg.Version,
+		URL:       fmt.Sprintf("%s/api/scorm/%s/%s", base, token, lesson.ScormLaunch),
+		ExpiresAt: expires,
+	}
+
+	if pkg.Version == models.ScormVersionXAPI {
+		xapiToken := signContentToken(secret, fmt.Sprintf("xapi.%d.%d", enrollment.ID, lesson.ID), expires)
+		actor, _ := json.Marshal(map[string]interface{}{
+			"objectType": "Agent",
+			"name":       strings.TrimSpace(user.FirstName + " " + user.LastName),
+			"mbox":       "mailto:" + user.Email,
+		})
+		params := url.Values{}
+		params.Set("endpoint", fmt.Sprintf("%s/api/xapi/%s/", base, xapiToken))
+		params.Set("auth", "Basic "+base64.StdEncoding.EncodeToString([]byte("student:"+xapiToken)))
+		params.Set("actor", string(actor))
+		params.Set("activity_id", lesson.ScormItemID)
+		params.Set("registration", registrationID(enrollment.ID, lesson.ID))
+		sep := "?"
+		if strings.Contains(launch.URL, "?") {
+			sep = "&"
+		}
+		launch.URL += sep + params.Encode()
+		return launch, nil
+	}
+
+	data := map[string]string{}
+	seconds := 0
+	var progress models.LessonProgress
+	if s.db.Where("enrollment_id = ? AND lesson_id = ?", enrollment.ID, lesson.ID).First(&progress).Error == nil {
+		data = scormData(&progress)
+		seconds = progress.TimeSpentSeconds
+	}
+	cmi := make(map[string]string, len(data)+8)
+	for k, v := range data {
+		if !strings.HasPrefix(k, xapiStatePrefix) {
+			cmi[k] = v
+		}
+	}
+	var mastery *int
+	var quiz models.Quiz
+	if s.db.Where("lesson_id = ? AND external = ? AND required = ?", lesson.ID, true, true).First(&quiz).Error == nil {
+		mastery = &quiz.PassingScore
+	}
+	resume := data["cmi.suspend_data"] != "" || data["cmi.core.exit"] == "suspend" || data["cmi.exit"] == "suspend"
+
+	if pkg.Version == models.ScormVersion2004 {
+		cmi["cmi.learner_id"] = strconv.FormatUint(uint64(enrollment.ContactID), 10)
+		cmi["cmi.learner_name"] = strings.TrimSpace(user.LastName + ", " + user.FirstName)
+		cmi["cmi.credit"] = "credit"
+		cmi["cmi.mode"] = "normal"
+		cmi["cmi.total_time"] = fmt.Sprintf("PT%dS", seconds)
+		cmi["cmi.entry"] = "ab-initio"
+		if resume {
+			cmi["cmi.entry"] = "resume"
+		}
+		if mastery != nil {
+			cmi["cmi.scaled_passing_score"] = strconv.FormatFloat(float64(*mastery)/100, 'f', -1, 64)
+		}
+		delete(cmi, "cmi.exit")
+	} else {
+		cmi["cmi.core.student_id"] = strconv.FormatUint(uint64(enrollment.ContactID), 10)
+		cmi["cmi.core.student_name"] = strings.TrimSpace(user.LastName + ", " + user.FirstName)
+		cmi["cmi.core.credit"] = "credit"
+		cmi["cmi.core.lesson_mode"] = "normal"
+		cmi["cmi.core.total_time"] = fmt.Sprintf("%04d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+		cmi["cmi.core.entry"] = "ab-initio"
+		if resume {
+			cmi["cmi.core.entry"] = "resume"
+		}
+		if _, ok := cmi["cmi.core.lesson_status"]; !ok {
+			cmi["cmi.core.lesson_status"] = "not attempted"
+		}
+		if mastery != nil {
+			cmi["cmi.student_data.mastery_score"] = strconv.Itoa(*mastery)
+		}
+		delete(cmi, "cmi.core.exit")
+	}
+	launch.CMI = cmi
+	return launch, nil
+}
+
+// registrationID derives a stable xAPI registration UUID for a student's
+// attempt at a lesson.
+func registrationID(enrollmentID, lessonID uint) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("registration.%d.%d", enrollmentID, lessonID)))
+	return formatUUID(sum[:16])
+}
+
+// Commit stores the cmi data a SCO set through LMSCommit/Commit and records
+// its results: completion completes the lesson, and a score or pass/fail
+// is recorded as a QuizAttempt. Session time is credited on finish, when
+// the SCO reports its final cmi.session_time.
+func (s *ScormService) Commit(enrollment *models.CourseEnrollment, lesson *models.Lesson, pkg *models.ScormPackage, cmi map[string]string, finish bool, now time.Time) (*models.LessonProgress, error) {
+	if err := NewCourseService(s.db).CheckUnlocked(enrollment, lesson.ID, now); err != nil {
+		return nil, err
+	}
+	progress, isNew, err := s.progress(enrollment, lesson.ID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	data := scormData(progress)
+	for k, v := range cmi {
+		if !strings.HasPrefix(k, "cmi.") || readOnlyCMI[k] {
+			continue
+		}
+		if len(v) > maxScormValue {
+			v = v[:maxScormValue]
+		}
+		data[k] = v
+	}
+	result := scormResult(pkg.Version, data)
+	if finish {
+		if pkg.Version == models.ScormVersion2004 {
+			result.SessionSeconds = durationSeconds(cmi["cmi.session_time"])
+		} else {
+			result.SessionSeconds = durationSeconds(cmi["cmi.core.session_time"])
+		}
+	}
+	return s.apply(enrollment, lesson, progress, isNew, data, result, now)
+}
+
+// readOnlyCMI are elements the LMS supplies at launch; they aren't stored
+// back. Session time is credited, not kept.
+var readOnlyCMI = map[string]bool{
+	"cmi.core.student_id": true, "cmi.core.student_name": true, "cmi.core.credit": true,
+	"cmi.core.lesson_mode": true, "cmi.core.total_time": true, "cmi.core.entry": true,
+	"cmi.core.session_time": true, "cmi.student_data.mastery_score": true,
+	"cmi.learner_id": true, "cmi.learner_name": true, "cmi.credit": true, "cmi.mode": true,
+	"cmi.total_time": true, "cmi.entry": true, "cmi.session_time": true, "cmi.scaled_passing_score": true,
+}
+
+// scormResult reads completion, success and score from cmi data.
+func scormResult(version string, data map[string]string) runtimeResult {
+	var r runtimeResult
+	pass := func(v bool) { r.Passed = &v }
+	if version == models.ScormVersion2004 {
+		r.Completed = data["cmi.completion_status"] == "completed"
+		switch data["cmi.success_status"] {
+		case "passed":
+			pass(true)
+		case "failed":
+			pass(false)
+		}
+		if scaled, err := strconv.ParseFloat(data["cmi.score.scaled"], 64); err == nil {
+			score := scaled * 100
+			r.Score = &score
+		} else {
+			r.Score = rawScore(data["cmi.score.raw"], data["cmi.score.min"], data["cmi.score.max"])
+		}
+	} else {
+		switch data["cmi.core.lesson_status"] {
+		case "completed":
+			r.Completed = true
+		case "passed":
+			r.Completed = true
+			pass(true)
+		case "failed":
+			pass(false)
+		}
+		r.Score = rawScore(data["cmi.core.score.raw"], data["cmi.core.score.min"], data["cmi.core.score.max"])
+	}
+	if r.Score != nil {
+		score := math.Round(math.Max(0, math.Min(*r.Score, 100))*100) / 100
+		r.Score = &score
+	}
+	return r
+}
+
+// rawScore scales a raw score to a percentage of its range, which defaults
+// to 0-100.
+func rawScore(raw, minimum, maximum string) *float64 {
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return nil
+	}
+	lo, hi := 0.0, 100.0
+	if m, err := strconv.ParseFloat(minimum, 64); err == nil {
+		lo = m
+	}
+	if m, err := strconv.ParseFloat(maximum, 64); err == nil && m > lo {
+		hi = m
+	}
+	score := (v - lo) / (hi - lo) * 100
+	return &score
+}
+
+var isoDuration = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:([\d.]+)S)?)?$`)
+
+// durationSeconds parses a SCORM 1.2 timespan (HHHH:MM:SS.SS) or an ISO
+// 8601 duration (SCORM 2004, xAPI).
+func durationSeconds(v string) int {
+	v = strings.TrimSpace(v)
+	if m := isoDuration.FindStringSubmatch(v); m != nil {
+		units := []float64{365 * 86400, 30 * 86400, 7 * 86400, 86400, 3600, 60, 1}
+		total := 0.0
+		for i, unit := range units {
+			if n, err := strconv.ParseFloat(m[i+1], 64); err == nil {
+				total += n * unit
+			}
+		}
+		return int(total)
+	}
+	parts := strings.Split(v, ":")
+	if len(parts) != 3 {
+		return 0
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	sec, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0
+	}
+	return h*3600 + m*60 + int(sec)
+}
+
+func scormData(progress *models.LessonProgress) map[string]string {
+	data := map[string]string{}
+	if len(progress.ScormData) > 0 {
+		_ = json.Unmarshal(progress.ScormData, &data)
+	}
+	return data
+}
+
+// progress loads the student's progress on a lesson, or a new unsaved one.
+func (s *ScormService) progress(enrollment *models.CourseEnrollment, lessonID uint, now time.Time) (*models.LessonProgress, bool, error) {
+	var progress models.LessonProgress
+	result := s.db.Where("enrollment_id = ? AND lesson_id = ?", enrollment.ID, lessonID).First(&progress)
+	if result.Error == gorm.ErrRecordNotFound {
+		return &models.LessonProgress{
+			TenantID:     enrollment.TenantID,
+			EnrollmentID: enrollment.ID,
+			LessonID:     lessonID,
+			Status:       models.ProgressInProgress,
+			StartedAt:    &now,
+		}, true, nil
+	}
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	return &progress, false, nil
+}
+
+// apply saves the runtime data and session time on the progress, records
+// any score as a QuizAttempt and completes the lesson when the content
+// reports completion. A required external quiz must be passed first.
+func (s *ScormService) apply(enrollment *models.CourseEnrollment, lesson *models.Lesson, progress *models.LessonProgress, isNew bool, data map[string]string, r runtimeResult, now time.Time) (*models.LessonProgress, error) {
+	progress.ScormData, _ = json.Marshal(data)
+	if r.SessionSeconds > 0 {
+		progress.TimeSpentSeconds += r.SessionSeconds
+	}
+	if progress.Status == models.ProgressNotStarted || progress.Status == "" {
+		progress.Status = models.ProgressInProgress
+		progress.StartedAt = &now
+	}
+	if isNew {
+		if err := s.db.Create(progress).Error; err != nil {
+			return nil, err
+		}
+	} else if err := s.db.Model(progress).Updates(map[string]interface{}{
+		"status":             progress.Status,
+		"started_at":         progress.StartedAt,
+		"time_spent_seconds": progress.TimeSpentSeconds,
+		"scorm_data":         progress.ScormData,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	if r.Score != nil || r.Passed != nil {
+		if err := s.recordAttempt(enrollment, lesson, r, now); err != nil {
+			return nil, err
+		}
+	}
+	if r.Completed && progress.Status != models.ProgressCompleted {
+		completed, err := NewCourseService(s.db).CompleteLesson(enrollment.ID, lesson.ID, true)
+		if err == nil {
+			return completed, nil
+		}
+		if !errors.Is(err, ErrQuizNotPassed) && !errors.Is(err, ErrAssignmentNotPassed) {
+			return nil, err
+		}
+	}
+	return progress, nil
+}
+
+// recordAttempt records the content's score on the lesson's external quiz,
+// creating an optional one for content imported without a mastery score.
+// Repeated commits update the open attempt; a pass or fail closes it.
+func (s *ScormService) recordAttempt(enrollment *models.CourseEnrollment, lesson *models.Lesson, r runtimeResult, now time.Time) error {
+	var quiz models.Quiz
+	err := s.db.Where("lesson_id = ? AND external = ?", lesson.ID, true).First(&quiz).Error
+	if err == gorm.ErrRecordNotFound {
+		quiz = models.Quiz{
+			TenantID:     lesson.TenantID,
+			LessonID:     lesson.ID,
+			Title:        lesson.Title,
+			PassingScore: 70,
+			External:     true,
+		}
+		if err := s.db.Create(&quiz).Error; err != nil {
+			return err
+		}
+		// Required defaults to true on create
+		if err := s.db.Model(&quiz).Update("required", false).Error; err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	score := 0.0
+	if r.Score != nil {
+		score = *r.Score
+	}
+	passed := r.Score != nil && score >= float64(quiz.PassingScore)
+	if r.Passed != nil {
+		passed = *r.Passed
+	}
+
+	var attempt models.QuizAttempt
+	err = s.db.Where("quiz_id = ? AND enrollment_id = ? AND completed_at IS NULL", quiz.ID, enrollment.ID).
+		Order("id DESC").First(&attempt).Error
+	if err == gorm.ErrRecordNotFound {
+		var last models.QuizAttempt
+		if s.db.Where("quiz_id = ? AND enrollment_id = ?", quiz.ID, enrollment.ID).Order("id DESC").First(&last).Error == nil &&
+			last.Score == score && last.Passed == passed {
+			return nil // the content re-committed the same result
+		}
+		attempt = models.QuizAttempt{
+			TenantID:     quiz.TenantID,
+			QuizID:       quiz.ID,
+			EnrollmentID: enrollment.ID,
+			StartedAt:    now,
+		}
+	} else if err != nil {
+		return err
+	}
+	attempt.Score = score
+	attempt.Passed = passed
+	if r.Passed != nil {
+		attempt.CompletedAt = &now
+	}
+	return s.db.Save(&attempt).Error
+}
+
+// --- xAPI ---
+
+type xapiStatement struct {
+	ID   string `json:"id"`
+	Verb struct {
+		ID string `json:"id"`
+	} `json:"verb"`
+	Object struct {
+		ID string `json:"id"`
+	} `json:"object"`
+	Result *struct {
+		Score *struct {
+			Scaled *float64 `json:"scaled"`
+			Raw    *float64 `json:"raw"`
+			Min    *float64 `json:"min"`
+			Max    *float64 `json:"max"`
+		} `json:"score"`
+		Success    *bool  `json:"success"`
+		Completion *bool  `json:"completion"`
+		Duration   string `json:"duration"`
+	} `json:"result"`
+	Timestamp *time.Time `json:"timestamp"`
+}
+
+// RecordStatements stores xAPI statements (one, or an array) from a
+// lesson's content and returns their ids. Statements about the lesson's
+// activity feed the same progress, quiz attempt and completion as SCORM
+// runtime data. Statements already stored are skipped.
+func (s *ScormService) RecordStatements(enrollment *models.CourseEnrollment, lesson *models.Lesson, body []byte, statementID string, now time.Time) ([]string, error) {
+	var raws []json.RawMessage
+	if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(body, &raws); err != nil {
+			return nil, ErrInvalidStatement
+		}
+	} else {
+		raws = []json.RawMessage{body}
+	}
+	if err := NewCourseService(s.db).CheckUnlocked(enrollment, lesson.ID, now); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(raws))
+	for _, raw := range raws {
+		var fields map[string]json.RawMessage
+		var st xapiStatement
+		if json.Unmarshal(raw, &fields) != nil || json.Unmarshal(raw, &st) != nil || st.Verb.ID == "" || st.Object.ID == "" {
+			return nil, ErrInvalidStatement
+		}
+		if st.ID == "" {
+			st.ID = statementID
+		}
+		if st.ID == "" {
+			st.ID = newUUID()
+		}
+		ids = append(ids, st.ID)
+		var existing int64
+		s.db.Model(&models.XAPIStatement{}).Where("statement_id = ?", st.ID).Count(&existing)
+		if existing > 0 {
+			continue
+		}
+
+		timestamp := now
+		if st.Timestamp != nil {
+			timestamp = *st.Timestamp
+		}
+		fields["id"], _ = json.Marshal(st.ID)
+		fields["stored"], _ = json.Marshal(now)
+		stored, _ := json.Marshal(fields)
+		record := models.XAPIStatement{
+			TenantID:     enrollment.TenantID,
+			StatementID:  st.ID,
+			EnrollmentID: enrollment.ID,
+			LessonID:     lesson.ID,
+			Verb:         st.Verb.ID,
+			ObjectID:     st.Object.ID,
+			Statement:    datatypes.JSON(stored),
+			Timestamp:    timestamp,
+		}
+		if err := s.db.Create(&record).Error; err != nil {
+			return nil, err
+		}
+
+		if lesson.ScormItemID != "" && st.Object.ID != lesson.ScormItemID {
+			continue
+		}
+		r := statementResult(&st)
+		if !r.Completed && r.Passed == nil && r.Score == nil && r.SessionSeconds == 0 {
+			continue
+		}
+		progress, isNew, err := s.progress(enrollment, lesson.ID, now)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.apply(enrollment, lesson, progress, isNew, scormData(progress), r, now); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// statementResult reads a statement's verb and result. Durations are
+// credited from the statements that end a session.
+func statementResult(st *xapiStatement) runtimeResult {
+	var r runtimeResult
+	pass := func(v bool) { r.Passed = &v }
+	verb := st.Verb.ID[strings.LastIndex(st.Verb.ID, "/")+1:]
+	switch verb {
+	case "completed":
+		r.Completed = true
+	case "passed":
+		r.Completed = true
+		pass(true)
+	case "failed":
+		pass(false)
+	}
+	if st.Result == nil {
+		return r
+	}
+	if st.Result.Completion != nil && *st.Result.Completion {
+		r.Completed = true
+	}
+	if st.Result.Success != nil {
+		pass(*st.Result.Success)
+	}
+	if sc := st.Result.Score; sc != nil {
+		if sc.Scaled != nil {
+			score := *sc.Scaled * 100
+			r.Score = &score
+		} else if sc.Raw != nil {
+			raw, lo, hi := *sc.Raw, 0.0, 100.0
+			if sc.Min != nil {
+				lo = *sc.Min
+			}
+			if sc.Max != nil && *sc.Max > lo {
+				hi = *sc.Max
+			}
+			score := (raw - lo) / (hi - lo) * 100
+			r.Score = &score
+		}
+		if r.Score != nil {
+			score := math.Round(math.Max(0, math.Min(*r.Score, 100))*100) / 100
+			r.Score = &score
+		}
+	}
+	if verb == "terminated" || verb == "suspended" || verb == "exited" {
+		r.SessionSeconds = durationSeconds(st.Result.Duration)
+	}
+	return r
+}
+
+// State returns an xAPI state document the content saved for the lesson.
+func (s *ScormService) State(enrollmentID, lessonID uint, stateID string) (string, bool) {
+	var progress models.LessonProgress
+	if s.db.Where("enrollment_id = ? AND lesson_id = ?", enrollmentID, lessonID).First(&progress).Error != nil {
+		return "", false
+	}
+	value, ok := scormData(&progress)[xapiStatePrefix+stateID]
+	return value, ok
+}
+
+// SaveState stores (or with a nil value, deletes) an xAPI state document,
+// which content uses to bookmark and resume.
+func (s *ScormService) SaveState(enrollment *models.CourseEnrollment, lessonID uint, stateID string, value *string, now time.Time) error {
+	progress, isNew, err := s.progress(enrollment, lessonID, now)
+	if err != nil {
+		return err
+	}
+	data := scormData(progress)
+	if value == nil {
+		delete(data, xapiStatePrefix+stateID)
+	} else {
+		v := *value
+		if len(v) > maxScormValue {
+			v = v[:maxScormValue]
+		}
+		data[xapiStatePrefix+stateID] = v
+	}
+	progress.ScormData, _ = json.Marshal(data)
+	if isNew {
+		return s.db.Create(progress).Error
+	}
+	return s.db.Model(progress).Update("scorm_data", progress.ScormData).Error
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return formatUUID(b)
+}
+
+// formatUUID formats 16 bytes as a version 4 UUID.
+func formatUUID(b []byte) string {
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"gritcms/apps/api/internal/events"
+	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/payments"
+)
+
+// SubscriptionService renews subscriptions when their billing period ends by
+// charging the payment method saved at checkout. Each renewal is an order
+// linked to the subscription; fulfilling it moves the subscription on to its
+// next period and emits SubscriptionRenewed.
+type SubscriptionService struct {
+	db        *gorm.DB
+	providers *payments.Registry
+	fulfiller OrderFulfiller
+}
+
+func NewSubscriptionService(db *gorm.DB, providers *payments.Registry) *SubscriptionService {
+	return &SubscriptionService{db: db, providers: providers, fulfiller: NewFulfillmentService(db)}
+}
+
+// RenewDue renews every active subscription we bill whose period has ended,
+// and cancels the ones set to cancel at period end. Returns how many renewal
+// charges were started. See billedHere for the subscriptions left alone.
+func (s *SubscriptionService) RenewDue(ctx context.Context, now time.Time) (int, error) {
+	var due []models.Subscription
+	if err := s.db.Where("status = ? AND current_period_end <= ?", models.SubscriptionActive, now).
+		Where("order_id IS NOT NULL AND COALESCE(provider_subscription_id, '') = ''").
+		Order("current_period_end ASC").Limit(500).Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	renewed := 0
+	for i := range due {
+		sub := &due[i]
+		if sub.CancelAtPeriodEnd {
+			if err := s.cancel(sub, sub.CurrentPeriodEnd); err != nil {
+				log.Printf("[subscriptions] Failed to cancel subscription %d: %v", sub.ID, err)
+			}
+			continue
+		}
+		started, err := s.Renew(ctx, sub)
+		if err != nil {
+			log.Printf("[subscriptions] Renewal of subscription %d failed: %v", sub.ID, err)
+			continue
+		}
+		if started {
+			renewed++
+		}
+	}
+	return renewed, nil
+}
+
+// billedHere reports whether renewals of the subscription are charged by
+// RenewDue. Subscriptions the provider bills (ProviderSubscriptionID set) are
+// renewed by the provider, and ones with no checkout order (created by an
+// admin, or before renewals were charged here) have no saved payment method;
+// both are left as they are rather than marked past due.
+func billedHere(sub *models.Subscription) bool {
+	return sub.ProviderSubscriptionID == "" && sub.OrderID != nil
+}
+
+// Renew charges the next period of a subscription to the payment method
+// saved by its checkout order. A declined charge, or a checkout payment that
+// can't be charged again, moves the subscription to past_due. started is
+// false when a charge for this period already exists or the subscription
+// isn't billedHere.
+func (s *SubscriptionService) Renew(ctx context.Context, sub *models.Subscription) (bool, error) {
+	if !billedHere(sub) {
+		return false, nil
+	}
+
+	// One renewal order per period; a charge still processing is finished by the webhook
+	orderNumber := fmt.Sprintf("SUB%d-%s", sub.ID, sub.CurrentPeriodEnd.UTC().Format("20060102"))
+	var existing int64
+	s.db.Unscoped().Model(&models.Order{}).Where("order_number = ?", orderNumber).Count(&existing)
+	if existing > 0 {
+		return false, nil
+	}
+
+	var origin models.Order
+	if s.db.First(&origin, *sub.OrderID).Error != nil || origin.PaymentID == "" {
+		return false, s.pastDue(sub, "no checkout payment to charge again")
+	}
+	provider, err := s.providers.Provider(origin.TenantID, origin.PaymentProvider)
+	if err != nil {
+		return false, s.pastDue(sub, err.Error())
+	}
+	charger, ok := provider.(payments.OffSessionCharger)
+	if !ok {
+		return false, s.pastDue(sub, provider.Name()+" can't charge a saved payment method")
+	}
+	method, err := charger.SavedPaymentMethod(ctx, origin.PaymentID)
+	if err != nil {
+		return false, s.pastDue(sub, err.Error())
+	}
+
+	amount, currency := sub.Amount, sub.Currency
+	var product models.Product
+	s.db.First(&product, sub.ProductID)
+	if amount <= 0 {
+		var price models.Price
+		if err := s.db.First(&price, sub.PriceID).Error; err != nil {
+			return false, s.pastDue(sub, "price no longer exists")
+		}
+		amount, currency = price.Amount, price.Currency
+	}
+
+	subID, productID, priceID := sub.ID, sub.ProductID, sub.PriceID
+	order := models.Order{
+		TenantID:           sub.TenantID,
+		ContactID:          sub.ContactID,
+		OrderNumber:        orderNumber,
+		Status:             models.OrderStatusPending,
+		Subtotal:           amount,
+		Total:              amount,
+		Currency:           currency,
+		PaymentProvider:    origin.PaymentProvider,
+		SubscriptionID:     &subID,
+		PaymentFingerprint: origin.PaymentFingerprint, // same saved card
+		Items: []models.OrderItem{{
+			TenantID:  sub.TenantID,
+			ProductID: &productID,
+			PriceID:   &priceID,
+			Quantity:  1,
+			UnitPrice: amount,
+			Total:     amount,
+		}},
+	}
+	if err := s.db.Create(&order).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return false, nil // another run started this period's charge
+		}
+		return false, err
+	}
+
+	var email, name string
+	var contact models.Contact
+	if s.db.First(&contact, sub.ContactID).Error == nil {
+		email = contact.Email
+		name = strings.TrimSpace(contact.FirstName + " " + contact.LastName)
+	}
+
+	res, err := charger.ChargeSaved(ctx, payments.PaymentRequest{
+		OrderID:       order.ID,
+		OrderNumber:   order.OrderNumber,
+		Amount:        math.Round(amount),
+		Currency:      order.Currency,
+		Description:   product.Name,
+		CustomerEmail: email,
+		CustomerName:  name,
+		Metadata: map[string]string{
+			"contact_id":      fmt.Sprintf("%d", sub.ContactID),
+			"subscription_id": fmt.Sprintf("%d", sub.ID),
+		},
+	}, *method)
+	if err != nil {
+		s.failOrder(&order, err.Error())
+		return true, s.pastDue(sub, err.Error())
+	}
+	if res.PaymentID != "" {
+		order.PaymentID = res.PaymentID
+		s.db.Model(&order).Update("payment_id", res.PaymentID)
+	}
+
+	switch res.Status {
+	case payments.StatusSucceeded:
+		_, _, err := s.fulfiller.FulfillPaidOrder(order.ID, PaymentConfirmation{
+			Provider:    order.PaymentProvider,
+			PaymentID:   res.PaymentID,
+			Reference:   res.Reference,
+			Fingerprint: res.Fingerprint,
+		})
+		return true, err
+	case payments.StatusFailed:
+		s.failOrder(&order, res.FailureReason)
+		return true, s.pastDue(sub, res.FailureReason)
+	}
+	return true, nil // processing — the webhook fulfills it
+}
+
+// RenewalFailed moves the subscription of a renewal order whose payment
+// failed to past_due. Orders that aren't renewals are ignored.
+func (s *SubscriptionService) RenewalFailed(order *models.Order, reason string) error {
+	if order.SubscriptionID == nil {
+		return nil
+	}
+	var sub models.Subscription
+	if err := s.db.First(&sub, *order.SubscriptionID).Error; err != nil {
+		return err
+	}
+	return s.pastDue(&sub, reason)
+}
+
+func (s *SubscriptionService) pastDue(sub *models.Subscription, reason string) error {
+	res := s.db.Model(&models.Subscription{}).Where("id = ? AND status = ?", sub.ID, models.SubscriptionActive).
+		Update("status", models.SubscriptionPastDue)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error
+	}
+	sub.Status = models.SubscriptionPastDue
+	log.Printf("[subscriptions] Subscription %d is past due: %s", sub.ID, reason)
+	events.Emit(events.SubscriptionPastDue, map[string]interface{}{
+		"subscription_id": sub.ID,
+		"contact_id":      sub.ContactID,
+		"product_id":      sub.ProductID,
+		"reason":          reason,
+	})
+	return nil
+}
+
+func (s *SubscriptionService) cancel(sub *models.Subscription, at time.Time) error {
+	res := s.db.Model(&models.Subscription{}).Where("id = ? AND status = ?", sub.ID, models.SubscriptionActive).
+		Updates(map[string]interface{}{"status": models.SubscriptionCancelled, "cancelled_at": at})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error
+	}
+	events.Emit(events.SubscriptionCancelled, map[string]interface{}{
+		"subscription_id": sub.ID,
+		"contact_id":      sub.ContactID,
+		"product_id":      sub.ProductID,
+	})
+	return nil
+}
+
+func (s *SubscriptionService) failOrder(order *models.Order, reason string) {
+	order.Status = models.OrderStatusFailed
+	s.db.Model(order).Update("status", order.Status)
+	log.Printf("[subscriptions] Renewal order %d payment failed (%s: %s) %s", order.ID, order.PaymentProvider, order.PaymentID, reason)
+}
+
+// renewSubscription moves a subscription on to the period its renewal order
+// paid for. Called by fulfillment inside its transaction.
+func renewSubscription(tx *gorm.DB, order *models.Order) (*models.Subscription, error) {
+	var sub models.Subscription
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&sub, *order.SubscriptionID).Error; err != nil {
+		return nil, err
+	}
+	interval := sub.Interval
+	if interval == "" {
+		var price models.Price
+		tx.First(&price, sub.PriceID)
+		interval = price.Interval
+	}
+
+	sub.CurrentPeriodStart = sub.CurrentPeriodEnd
+	sub.CurrentPeriodEnd = AddBillingInterval(sub.CurrentPeriodStart, interval)
+	sub.Status = models.SubscriptionActive
+	err := tx.Model(&sub).Updates(map[string]interface{}{
+		"current_period_start": sub.CurrentPeriodStart,
+		"current_period_end":   sub.CurrentPeriodEnd,
+		"status":               sub.Status,
+	}).Error
+	return &sub, err
+}
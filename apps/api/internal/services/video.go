@@ -0,0 +1,365 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"gritcms/apps/api/internal/models"
+	"gritcms/apps/api/internal/storage"
+)
+
+// Video errors.
+var (
+	ErrVideoNotReady       = errors.New("video is still processing")
+	ErrInvalidVideoToken   = errors.New("invalid or expired playback token")
+	ErrInvalidPlaylistPath = errors.New("invalid playlist path")
+)
+
+// PlaybackTTL is how long playback tokens and signed segment URLs last.
+const PlaybackTTL = 2 * time.Hour
+
+// hlsRendition is one quality level of a transcoded video.
+type hlsRendition struct {
+	Height  int
+	Bitrate int // video kbit/s
+}
+
+var hlsRenditions = []hlsRendition{
+	{Height: 360, Bitrate: 800},
+	{Height: 720, Bitrate: 2800},
+	{Height: 1080, Bitrate: 5000},
+}
+
+// hlsAudioBitrate is the AAC bitrate of every rendition, in kbit/s.
+const hlsAudioBitrate = 128
+
+// VideoService transcodes uploaded videos to HLS and serves them through
+// short-lived signed URLs.
+type VideoService struct {
+	db      *gorm.DB
+	storage *storage.Storage
+	private *storage.Storage // HLS renditions, only reachable through signed playlists
+}
+
+// NewVideoService creates a video service.
+func NewVideoService(db *gorm.DB, store *storage.Storage) *VideoService {
+	s := &VideoService{db: db, storage: store}
+	if store != nil {
+		s.private = store.Private()
+	}
+	return s
+}
+
+// IsVideo reports whether an asset is a video that can be transcoded.
+func IsVideo(asset *models.MediaAsset) bool {
+	return strings.HasPrefix(asset.MimeType, "video/")
+}
+
+// MarkPending flags a video asset as waiting for the transcode job.
+func (s *VideoService) MarkPending(assetID uint) {
+	s.db.Model(&models.MediaAsset{}).Where("id = ?", assetID).Updates(map[string]interface{}{
+		"transcode_status": models.TranscodePending,
+		"transcode_error":  "",
+	})
+}
+
+// Transcode downloads the original upload, renders the HLS renditions no
+// taller than the source with ffmpeg, and uploads them next to a master
+// playlist under media/hls/{id} in the private bucket.
+func (s *VideoService) Transcode(ctx context.Context, assetID uint, ffmpegPath, ffprobePath string) error {
+	if s.storage == nil {
+		return fmt.Errorf("storage not configured")
+	}
+	var asset models.MediaAsset
+	if err := s.db.First(&asset, assetID).Error; err != nil {
+		return fmt.Errorf("media asset not found: %w", err)
+	}
+	if !IsVideo(&asset) {
+		return fmt.Errorf("media asset %d is not a video", asset.ID)
+	}
+
+	s.db.Model(&asset).Update("transcode_status", models.TranscodeProcessing)
+	err := s.transcode(ctx, &asset, ffmpegPath, ffprobePath)
+	if err != nil {
+		msg := err.Error()
+		if len(msg) > 500 {
+			msg = msg[:500]
+		}
+		s.db.Model(&asset).Updates(map[string]interface{}{
+			"transcode_status": models.TranscodeFailed,
+			"transcode_error":  msg,
+		})
+		return err
+	}
+	return nil
+}
+
+func (s *VideoService) transcode(ctx context.Context, asset *models.MediaAsset, ffmpegPath, ffprobePath string) error {
+	dir, err := os.MkdirTemp("", "transcode-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source"+path.Ext(asset.Path))
+	if err := s.download(ctx, asset.Path, source); err != nil {
+		return fmt.Errorf("downloading source: %w", err)
+	}
+
+	width, height, duration, err := probeVideo(ctx, ffprobePath, source)
+	if err != nil {
+		return err
+	}
+
+	out := filepath.Join(dir, "hls")
+	var master strings.Builder
+	master.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for i, r := range hlsRenditions {
+		// Never upscale, but always produce the lowest rendition
+		if r.Height > height && i > 0 {
+			break
+		}
+		h := r.Height
+		if h > height {
+			h = height - height%2
+		}
+		w := width * h / height
+		w -= w % 2
+
+		name := fmt.Sprintf("%dp", r.Height)
+		if err := os.MkdirAll(filepath.Join(out, name), 0o755); err != nil {
+			return err
+		}
+		cmd := exec.CommandContext(ctx, ffmpegPath, "-y", "-i", source,
+			"-vf", fmt.Sprintf("scale=%d:%d", w, h),
+			"-c:v", "libx264", "-preset", "veryfast", "-profile:v", "main", "-crf", "21",
+			"-maxrate", fmt.Sprintf("%dk", r.Bitrate), "-bufsize", fmt.Sprintf("%dk", 2*r.Bitrate),
+			"-g", "48", "-keyint_min", "48", "-sc_threshold", "0",
+			"-c:a", "aac", "-b:a", fmt.Sprintf("%dk", hlsAudioBitrate), "-ac", "2",
+			"-hls_time", "6", "-hls_playlist_type", "vod",
+			"-hls_segment_filename", filepath.Join(out, name, "segment_%04d.ts"),
+			filepath.Join(out, name, "index.m3u8"),
+		)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("ffmpeg %s: %w: %s", name, err, lastLines(output, 3))
+		}
+		fmt.Fprintf(&master, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\n%s/index.m3u8\n",
+			(r.Bitrate+hlsAudioBitrate)*1000, w, h, name)
+	}
+	if err := os.WriteFile(filepath.Join(out, "master.m3u8"), []byte(master.String()), 0o644); err != nil {
+		return err
+	}
+
+	// Drop renditions of an earlier transcode that this one may not replace
+	prefix := fmt.Sprintf("media/hls/%d", asset.ID)
+	if err := s.private.DeletePrefix(ctx, prefix+"/"); err != nil {
+		return err
+	}
+	err = filepath.Walk(out, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(out, file)
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return s.private.Upload(ctx, prefix+"/"+filepath.ToSlash(rel), f, hlsContentType(file))
+	})
+	if err != nil {
+		return fmt.Errorf("uploading renditions: %w", err)
+	}
+
+	return s.db.Model(asset).Updates(map[string]interface{}{
+		"transcode_status": models.TranscodeReady,
+		"transcode_error":  "",
+		"hls_path":         prefix,
+		"width":            width,
+		"height":           height,
+		"duration_seconds": duration,
+	}).Error
+}
+
+func (s *VideoService) download(ctx context.Context, key, dest string) error {
+	reader, err := s.storage.Download(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, reader)
+	return err
+}
+
+// probeVideo reads the source dimensions and duration with ffprobe.
+func probeVideo(ctx context.Context, ffprobePath, file string) (width, height, duration int, err error) {
+	cmd := exec.CommandContext(ctx, ffprobePath, "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=duration", "-of", "json", file)
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("ffprobe: %w", err)
+	}
+	var probe struct {
+		Streams []struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return 0, 0, 0, fmt.Errorf("reading ffprobe output: %w", err)
+	}
+	if len(probe.Streams) == 0 || probe.Streams[0].Height == 0 {
+		return 0, 0, 0, fmt.Errorf("no video stream found")
+	}
+	seconds, _ := strconv.ParseFloat(probe.Format.Duration, 64)
+	return probe.Streams[0].Width, probe.Streams[0].Height, int(seconds + 0.5), nil
+}
+
+func hlsContentType(file string) string {
+	if strings.HasSuffix(file, ".m3u8") {
+		return "application/vnd.apple.mpegurl"
+	}
+	return "video/mp2t"
+}
+
+func lastLines(output []byte, n int) string {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, " | ")
+}
+
+// PlaybackToken signs access to an asset's playlists until expires.
+func PlaybackToken(secret string, assetID uint, expires time.Time) string {
+	payload := fmt.Sprintf("%d.%d", assetID, expires.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ParsePlaybackToken returns the asset a playback token grants, if it is
+// authentic and unexpired.
+func ParsePlaybackToken(secret, token string, now time.Time) (uint, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, ErrInvalidVideoToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, ErrInvalidVideoToken
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return 0, ErrInvalidVideoToken
+	}
+	idPart, expPart, _ := strings.Cut(string(payload), ".")
+	id, err1 := strconv.ParseUint(idPart, 10, 64)
+	exp, err2 := strconv.ParseInt(expPart, 10, 64)
+	if err1 != nil || err2 != nil || now.Unix() > exp {
+		return 0, ErrInvalidVideoToken
+	}
+	return uint(id), nil
+}
+
+// Playback describes how a student's player should load a lesson video.
+type Playback struct {
+	Type      string    `json:"type"` // hls, or file while the video is still transcoding
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Duration  int       `json:"duration_seconds"`
+}
+
+// Playback returns a signed way to play the asset. Transcoded videos are
+// served as HLS through the API's playlist endpoint (apiURL); others fall
+// back to a signed URL of the original upload.
+func (s *VideoService) Playback(ctx context.Context, asset *models.MediaAsset, apiURL, secret string, now time.Time) (*Playback, error) {
+	if s.storage == nil {
+		return nil, fmt.Errorf("storage not configured")
+	}
+	expires := now.Add(PlaybackTTL)
+	if asset.TranscodeStatus == models.TranscodeReady && asset.HLSPath != "" {
+		token := PlaybackToken(secret, asset.ID, expires)
+		return &Playback{
+			Type:      "hls",
+			URL:       fmt.Sprintf("%s/api/video/%s/master.m3u8", strings.TrimRight(apiURL, "/"), token),
+			ExpiresAt: expires,
+			Duration:  asset.DurationSeconds,
+		}, nil
+	}
+	if asset.TranscodeStatus == models.TranscodePending || asset.TranscodeStatus == models.TranscodeProcessing {
+		return nil, ErrVideoNotReady
+	}
+	url, err := s.storage.GetSignedURL(ctx, asset.Path, PlaybackTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &Playback{Type: "file", URL: url, ExpiresAt: expires, Duration: asset.DurationSeconds}, nil
+}
+
+// Playlist loads one of the asset's HLS playlists and rewrites its segment
+// lines to signed storage URLs. Nested playlists stay relative, so players
+// fetch them through the same token path.
+func (s *VideoService) Playlist(ctx context.Context, asset *models.MediaAsset, file string) ([]byte, error) {
+	if s.storage == nil || asset.HLSPath == "" {
+		return nil, ErrVideoNotReady
+	}
+	file = strings.TrimPrefix(path.Clean("/"+file), "/")
+	if !strings.HasSuffix(file, ".m3u8") || strings.Contains(file, "..") {
+		return nil, ErrInvalidPlaylistPath
+	}
+
+	reader, err := s.private.Download(ctx, asset.HLSPath+"/"+file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	dir := path.Dir(file)
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") && !strings.HasSuffix(line, ".m3u8") {
+			signed, err := s.private.GetSignedURL(ctx, asset.HLSPath+"/"+path.Join(dir, line), PlaybackTTL)
+			if err != nil {
+				return nil, err
+			}
+			line = signed
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
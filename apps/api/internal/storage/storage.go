@@ -12,6 +12,7 @@ import (
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 
 	"gritcms/apps/api/internal/config"
 )
@@ -54,20 +55,15 @@ func New(cfg config.StorageConfig) (*Storage, error) {
 		o.UsePathStyle = true // Required for MinIO
 	})
 
-	// Verify bucket exists with a quick head request
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	_, err = client.HeadBucket(ctx, &s3.HeadBucketInput{
-		Bucket: aws.String(cfg.Bucket),
-	})
-	if err != nil {
-		// Try to create the bucket
-		_, createErr := client.CreateBucket(ctx, &s3.CreateBucketInput{
-			Bucket: aws.String(cfg.Bucket),
-		})
-		if createErr != nil {
-			return nil, fmt.Errorf("bucket %q not accessible and cannot be created: %w", cfg.Bucket, err)
+	if err := ensureBucket(ctx, client, cfg.Bucket); err != nil {
+		return nil, err
+	}
+	if cfg.PrivateBucket != "" {
+		if err := ensureBucket(ctx, client, cfg.PrivateBucket); err != nil {
+			return nil, err
 		}
 	}
 
@@ -95,6 +91,33 @@ func New(cfg config.StorageConfig) (*Storage, error) {
 	}, nil
 }
 
+// ensureBucket creates the bucket if a quick head request can't find it.
+func ensureBucket(ctx context.Context, client *s3.Client, bucket string) error {
+	_, err := client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		_, createErr := client.CreateBucket(ctx, &s3.CreateBucketInput{
+			Bucket: aws.String(bucket),
+		})
+		if createErr != nil {
+			return fmt.Errorf("bucket %q not accessible and cannot be created: %w", bucket, err)
+		}
+	}
+	return nil
+}
+
+// Private returns a Storage on the private bucket, whose files are only
+// reachable through GetSignedURL or Download. GetURL links on it don't
+// resolve.
+func (s *Storage) Private() *Storage {
+	private := *s
+	if s.cfg.PrivateBucket != "" {
+		private.bucket = s.cfg.PrivateBucket
+	}
+	return &private
+}
+
 // Upload stores a file in the bucket at the given key.
 func (s *Storage) Upload(ctx context.Context, key string, reader io.Reader, contentType string) error {
 	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
@@ -133,14 +156,84 @@ func (s *Storage) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+// DeletePrefix removes every file whose key starts with prefix.
+func (s *Storage) DeletePrefix(ctx context.Context, prefix string) error {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("listing %q: %w", prefix, err)
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+		objects := make([]types.ObjectIdentifier, 0, len(page.Contents))
+		for _, obj := range page.Contents {
+			objects = append(objects, types.ObjectIdentifier{Key: obj.Key})
+		}
+		_, err = s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("deleting %q: %w", prefix, err)
+		}
+	}
+	return nil
+}
+
+// MovePrivate moves every file under prefix from the public bucket to the
+// private one, for files stored publicly before they were kept private.
+// Returns how many files were moved.
+func (s *Storage) MovePrivate(ctx context.Context, prefix string) (int, error) {
+	private := s.cfg.PrivateBucket
+	if private == "" || private == s.bucket {
+		return 0, nil
+	}
+	moved := 0
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return moved, fmt.Errorf("listing %q: %w", prefix, err)
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if err := s.MoveToPrivate(ctx, key, key); err != nil {
+				return moved, err
+			}
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+// MoveToPrivate moves the file at key to privateKey in the private bucket.
+func (s *Storage) MoveToPrivate(ctx context.Context, key, privateKey string) error {
+	private := s.Private().bucket
+	if private == s.bucket && key == privateKey {
+		return nil
+	}
+	_, err := s.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(private),
+		Key:        aws.String(privateKey),
+		CopySource: aws.String(s.bucket + "/" + escapeKey(key)),
+	})
+	if err != nil {
+		return fmt.Errorf("copying %q: %w", key, err)
+	}
+	return s.Delete(ctx, key)
+}
+
 // GetURL returns the public URL for a stored file.
 func (s *Storage) GetURL(key string) string {
-	// Encode each path segment individually to preserve forward slashes
-	segments := strings.Split(key, "/")
-	for i, seg := range segments {
-		segments[i] = url.PathEscape(seg)
-	}
-	encodedKey := strings.Join(segments, "/")
+	encodedKey := escapeKey(key)
 
 	// Use public URL if configured (e.g. R2 dev URL)
 	if s.cfg.PublicURL != "" {
@@ -152,6 +245,15 @@ func (s *Storage) GetURL(key string) string {
 	return fmt.Sprintf("%s/%s/%s", endpoint, s.bucket, encodedKey)
 }
 
+// escapeKey encodes each path segment individually to preserve forward slashes.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
 // GetSignedURL returns a pre-signed URL valid for the given duration.
 func (s *Storage) GetSignedURL(ctx context.Context, key string, duration time.Duration) (string, error) {
 	presigner := s3.NewPresignClient(s.client)